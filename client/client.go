@@ -0,0 +1,247 @@
+// Package client is a Go client for the server-to-server invite API under
+// /api/v1/, which is enabled by setting API_TOKEN on the deployment.
+//
+//	c := client.New("https://invite.example.com", os.Getenv("INVITE_API_TOKEN"))
+//	inv, err := c.CreateInvite(ctx, client.InviteRequest{Username: "octocat"})
+//
+// Requests that fail with a network error, 429, or a 5xx status are retried
+// with exponential backoff. CreateInvite sends an Idempotency-Key, so a
+// retried invitation is never sent twice.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls the invite API of one deployment.
+type Client struct {
+	BaseURL    string       // Base URL of the deployment, without /api/v1
+	Token      string       // The deployment's API_TOKEN
+	HTTPClient *http.Client // Defaults to a client with a 30s timeout
+	MaxRetries int          // Retries after the first attempt; 0 disables them
+	Backoff    time.Duration
+}
+
+// New returns a Client for the deployment at baseURL with the defaults: 3
+// retries, starting 500ms apart.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		MaxRetries: 3,
+		Backoff:    500 * time.Millisecond,
+	}
+}
+
+// InviteRequest describes an invitation. Exactly one of Username and Email
+// must be set.
+type InviteRequest struct {
+	Username string   `json:"username,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Role     string   `json:"role,omitempty"` // "member" (the default) or "admin"
+	Teams    []string `json:"teams,omitempty"`
+	Campaign string   `json:"campaign,omitempty"`
+}
+
+// Statuses of an Invite.
+const (
+	StatusInvited = "invited" // Sent, not yet accepted
+	StatusJoined  = "joined"  // Accepted; only known for invitations by username
+
+	// StatusPendingApproval is the status of invitations granting a role
+	// under dual control, until an admin approves them. Their ID is that of
+	// the approval.
+	StatusPendingApproval = "pending_approval"
+)
+
+// Invite is an invitation created through the API.
+type Invite struct {
+	ID        string    `json:"id"`
+	Org       string    `json:"org,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Teams     []string  `json:"teams,omitempty"`
+	Status    string    `json:"status"`
+	AcceptURL string    `json:"accept_url,omitempty"` // Where the user accepts the invitation, for invitations by username
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Error is an error response of the API. Code is one of the deployment's
+// error codes, such as "not_found" or "invite_rate_limited".
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("invite api: %d %s", e.Status, e.Code)
+	}
+	return fmt.Sprintf("invite api: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried later.
+func (e *Error) Temporary() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// IsNotFound reports whether err is a 404 from the API.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// CreateInvite sends an invitation. Retries reuse one Idempotency-Key, so
+// the server sends the invitation at most once.
+func (c *Client) CreateInvite(ctx context.Context, req InviteRequest) (*Invite, error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var inv Invite
+	if err := c.do(ctx, http.MethodPost, "/api/v1/invites", b, newIdempotencyKey(), &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// GetInvite returns the invitation with id and its current status.
+func (c *Client) GetInvite(ctx context.Context, id string) (*Invite, error) {
+	var inv Invite
+	if err := c.do(ctx, http.MethodGet, "/api/v1/invites/"+url.PathEscape(id), nil, "", &inv); err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// ListInvites returns up to limit of the most recent invitations, newest
+// first. A limit of 0 uses the server's default.
+func (c *Client) ListInvites(ctx context.Context, limit int) ([]Invite, error) {
+	path := "/api/v1/invites"
+	if limit > 0 {
+		path += "?limit=" + strconv.Itoa(limit)
+	}
+	var list struct {
+		Invites []Invite `json:"invites"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, nil, "", &list); err != nil {
+		return nil, err
+	}
+	return list.Invites, nil
+}
+
+// do sends a request, retrying transient failures, and decodes the JSON
+// response into out.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, idempotencyKey string, out any) error {
+	backoff := c.Backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.attempt(ctx, method, path, body, idempotencyKey, out)
+		if err == nil || attempt >= c.MaxRetries || ctx.Err() != nil || !retryable(err, idempotencyKey != "") {
+			return err
+		}
+		wait := backoff
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends one request. It returns the server's Retry-After, if any.
+func (c *Client) attempt(ctx context.Context, method, path string, body []byte, idempotencyKey string, out any) (time.Duration, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, r)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode >= 300 {
+		e := &Error{Status: resp.StatusCode}
+		var eb struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(b, &eb) == nil {
+			e.Code, e.Message = eb.Error.Code, eb.Error.Message
+		}
+		secs, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return time.Duration(secs) * time.Second, e
+	}
+	if out == nil {
+		return 0, nil
+	}
+	return 0, json.Unmarshal(b, out)
+}
+
+// codeInFlight is the code of the 409 answering a request whose
+// Idempotency-Key is held by an attempt still in flight, as opposed to
+// conflicts such as already_member, which are final.
+const codeInFlight = "conflict"
+
+// retryable reports whether a failed request should be retried: API errors
+// that are Temporary, network errors, and, for requests with an
+// Idempotency-Key, the 409 of a retry that arrived while the first attempt
+// was still in flight, which gets the first attempt's response once it is
+// done.
+func retryable(err error, idempotent bool) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Temporary() || idempotent && e.Status == http.StatusConflict && e.Code == codeInFlight
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// newIdempotencyKey returns a random Idempotency-Key.
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,136 @@
+// Command invitectl is the operator's tool for an auto-invite deployment.
+//
+//	invitectl validate-config [-online] [-json]
+//	invitectl config export [-format env|tfvars]
+//
+// validate-config checks the configuration in the environment, as the
+// deployment would read it, and prints a report. It exits with status 1 when
+// any check fails, so it can gate a deploy. With -online the provider
+// credentials are verified against the provider's API.
+//
+// config export prints the settings the enabled features need, as a dotenv
+// file or as Terraform variables, with placeholders for secrets and for
+// required settings that are missing.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"auto-invite/invite"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "validate-config":
+		os.Exit(validateConfig(os.Args[2:]))
+	case "config":
+		if len(os.Args) < 3 || os.Args[2] != "export" {
+			usage()
+		}
+		os.Exit(exportConfig(os.Args[3:]))
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: invitectl validate-config [-online] [-json]")
+	fmt.Fprintln(os.Stderr, "       invitectl config export [-format env|tfvars]")
+	os.Exit(2)
+}
+
+// validateConfig runs the validate-config command and returns the exit
+// status.
+func validateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	online := fs.Bool("online", false, "verify the provider credentials against its API")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	report := invite.ValidateConfig(context.Background(), *online)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		for _, c := range report.Checks {
+			if c.Detail != "" {
+				fmt.Printf("%-8s %s: %s\n", c.Status, c.Name, c.Detail)
+			} else {
+				fmt.Printf("%-8s %s\n", c.Status, c.Name)
+			}
+		}
+	}
+	if !report.Valid {
+		fmt.Fprintln(os.Stderr, "The configuration has errors.")
+		return 1
+	}
+	return 0
+}
+
+// exportConfig runs the config export command and returns the exit status.
+func exportConfig(args []string) int {
+	fs := flag.NewFlagSet("config export", flag.ExitOnError)
+	format := fs.String("format", "env", "output format: env or tfvars")
+	fs.Parse(args)
+
+	var line func(invite.ConfigSetting) string
+	switch *format {
+	case "env":
+		line = envLine
+	case "tfvars":
+		line = tfvarsLine
+	default:
+		fmt.Fprintf(os.Stderr, "unknown format %q; expected env or tfvars\n", *format)
+		return 2
+	}
+	settings, err := invite.ExportConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	feature := ""
+	for _, s := range settings {
+		if s.Feature != feature {
+			if feature != "" {
+				fmt.Println()
+			}
+			feature = s.Feature
+			fmt.Printf("# %s\n", feature)
+		}
+		fmt.Println(line(s))
+	}
+	return 0
+}
+
+// envLine formats s as a dotenv line, quoting values that need it.
+func envLine(s invite.ConfigSetting) string {
+	value := s.Value
+	if strings.ContainsAny(value, " \t\"'#$\\\n") {
+		value = strconv.Quote(value)
+	}
+	return s.Name + "=" + value
+}
+
+// tfvarsLine formats s as a Terraform variable assignment, named after
+// the setting in lower case. Secrets are marked so that their variables can
+// be declared sensitive.
+func tfvarsLine(s invite.ConfigSetting) string {
+	value := strconv.Quote(s.Value)
+	// Escape Terraform's template sequences.
+	value = strings.NewReplacer("${", "$${", "%{", "%%{").Replace(value)
+	line := strings.ToLower(s.Name) + " = " + value
+	if s.Secret {
+		line += " # sensitive"
+	}
+	return line
+}
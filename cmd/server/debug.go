@@ -0,0 +1,49 @@
+package main
+
+import (
+	"expvar"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+// serveDebug serves pprof and runtime stats on a separate address, set with
+// DEBUG_ADDR (for example 127.0.0.1:6060), so they are never reachable
+// through the public port. Nothing is served when it is unset.
+func serveDebug(addr string) {
+	if addr == "" {
+		return
+	}
+	start := time.Now()
+	expvar.Publish("runtime", expvar.Func(func() any {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return map[string]any{
+			"goroutines":     runtime.NumGoroutine(),
+			"heap_alloc":     m.HeapAlloc,
+			"heap_objects":   m.HeapObjects,
+			"sys":            m.Sys,
+			"num_gc":         m.NumGC,
+			"pause_total_ns": m.PauseTotalNs,
+			"uptime_seconds": int(time.Since(start).Seconds()),
+		}
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		log.Printf("Serving debug endpoints on %s", addr)
+		srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		if err := srv.ListenAndServe(); err != nil {
+			log.Printf("Debug server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,133 @@
+// Command server runs the invite flow as a standalone HTTP server, for
+// Cloud Run, Kubernetes, and other container platforms. It listens on $PORT
+// (8080 by default).
+//
+// /healthz is the liveness probe and /readyz the readiness probe. With
+// STORE_DEGRADE_MODE, both flag a store that is down, and /readyz fails
+// while it is down in fail-closed mode. On SIGTERM
+// the server fails readiness, waits SHUTDOWN_DELAY for the load balancer to
+// stop sending traffic, and then drains open requests for up to
+// SHUTDOWN_TIMEOUT. Long-lived event streams are closed shortly after
+// draining starts.
+//
+// Background workers, WORKER_CONCURRENCY of them, drain the invite queue and
+// send notifications off the request path. They stop after the requests
+// have drained.
+//
+// With DEBUG_ADDR set, pprof and runtime stats are served on that address.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"auto-invite/invite"
+)
+
+// streamGrace is how long open requests may run after draining starts
+// before their contexts are cancelled.
+const streamGrace = 5 * time.Second
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	// Load the configuration now, so that a bad one fails the rollout
+	// instead of the first request.
+	invite.Init()
+
+	serveDebug(os.Getenv("DEBUG_ADDR"))
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workersDone := make(chan struct{})
+	go func() {
+		invite.RunWorkers(workerCtx)
+		close(workersDone)
+	}()
+
+	var draining atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		// A store that is down does not call for a restart.
+		status, _ := invite.Readiness(r.Context())
+		w.Write([]byte(status + "\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			http.Error(w, "draining", http.StatusServiceUnavailable)
+			return
+		}
+		status, ready := invite.Readiness(r.Context())
+		if !ready {
+			http.Error(w, status, http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(status + "\n"))
+	})
+	mux.HandleFunc("/", invite.Handler)
+
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Addr:              ":" + port,
+		Handler:           mux,
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("SERVER_READ_TIMEOUT", 30*time.Second),
+		// No write timeout by default, as it would cut off event streams.
+		WriteTimeout: envDuration("SERVER_WRITE_TIMEOUT", 0),
+		IdleTimeout:  envDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+		BaseContext:  func(net.Listener) context.Context { return baseCtx },
+	}
+	if keepAlives, err := strconv.ParseBool(os.Getenv("SERVER_KEEPALIVES")); err == nil {
+		srv.SetKeepAlivesEnabled(keepAlives)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		log.Printf("Listening on :%s", port)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	sig := <-stop
+	log.Printf("Received %s, shutting down", sig)
+	draining.Store(true)
+	time.Sleep(envDuration("SHUTDOWN_DELAY", 5*time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+	// Requests still open after the grace period, such as event streams,
+	// have their contexts cancelled so they wind down.
+	time.AfterFunc(streamGrace, cancelRequests)
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown did not finish cleanly: %v", err)
+	}
+	stopWorkers()
+	<-workersDone
+	log.Print("Server stopped")
+}
+
+// envDuration reads a duration environment variable such as "5s", returning
+// def when it is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("FATAL: %s must be a duration such as 5s: %v", name, err)
+	}
+	return d
+}
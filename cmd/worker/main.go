@@ -0,0 +1,82 @@
+//go:build js && wasm
+
+// Command worker runs the invite flow on Cloudflare Workers, compiled to
+// WebAssembly. worker/worker.mjs receives fetch events and passes each
+// request to the goFetch function registered here; outbound requests use the
+// Workers fetch API through net/http's js/wasm transport, so nothing needs
+// system calls.
+//
+// State lives in the in-memory store, which is per isolate.
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"syscall/js"
+
+	"auto-invite/invite"
+)
+
+func main() {
+	js.Global().Set("goFetch", js.FuncOf(goFetch))
+	select {}
+}
+
+// goFetch is called from JavaScript as goFetch(method, url, headers, body),
+// where headers is an array of [name, value] pairs and body a Uint8Array
+// (or null). It returns a Promise of {status, headers, body}.
+func goFetch(this js.Value, args []js.Value) any {
+	method, url, headers, body := args[0].String(), args[1].String(), args[2], args[3]
+	var reqBody []byte
+	if !body.IsNull() && !body.IsUndefined() {
+		reqBody = make([]byte, body.Get("length").Int())
+		js.CopyBytesToGo(reqBody, body)
+	}
+
+	var handler js.Func
+	handler = js.FuncOf(func(this js.Value, p []js.Value) any {
+		resolve, reject := p[0], p[1]
+		// The handler may block on outbound fetches, which need the event
+		// loop, so it must run outside the callback.
+		go func() {
+			defer handler.Release()
+			req, err := http.NewRequest(method, url, bytes.NewReader(reqBody))
+			if err != nil {
+				reject.Invoke(err.Error())
+				return
+			}
+			for i := 0; i < headers.Length(); i++ {
+				pair := headers.Index(i)
+				req.Header.Add(pair.Index(0).String(), pair.Index(1).String())
+			}
+			req.RemoteAddr = req.Header.Get("CF-Connecting-IP")
+
+			rec := httptest.NewRecorder()
+			invite.Handler(rec, req)
+			resolve.Invoke(toJSResponse(rec.Result()))
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(handler)
+}
+
+// toJSResponse converts a response into the object worker.mjs expects.
+func toJSResponse(resp *http.Response) js.Value {
+	b, _ := io.ReadAll(resp.Body)
+	body := js.Global().Get("Uint8Array").New(len(b))
+	js.CopyBytesToJS(body, b)
+
+	headers := js.Global().Get("Array").New()
+	for name, values := range resp.Header {
+		for _, v := range values {
+			headers.Call("push", js.ValueOf([]any{name, v}))
+		}
+	}
+	out := js.Global().Get("Object").New()
+	out.Set("status", resp.StatusCode)
+	out.Set("headers", headers)
+	out.Set("body", body)
+	return out
+}
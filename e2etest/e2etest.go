@@ -0,0 +1,414 @@
+// Package e2etest runs the invite flow end to end against a fake GitHub, for
+// regression tests of the gates, providers, and integrations.
+//
+// Each Harness runs the handler in a child process of the test binary, so
+// that every test gets a fresh configuration from its own environment, as a
+// deployment would. The test binary's TestMain must hand over to Main:
+//
+//	func TestMain(m *testing.M) { e2etest.Main(m) }
+//
+//	func TestInvite(t *testing.T) {
+//		h := e2etest.Start(t, e2etest.Env{"WAITLIST_WHEN_FULL": "true"})
+//		h.GitHub.AddUser(e2etest.User{Login: "octocat"})
+//		res := h.SignIn(t, "octocat", nil)
+//		if got := res.Location.Query().Get("error_code"); got != "" {
+//			t.Fatalf("sign-in failed: %s", got)
+//		}
+//		h.WaitForLog(t, "Successfully invited user octocat")
+//	}
+//
+// Notifications sent to webhook URLs pointing at Harness.Webhooks are
+// recorded, and the handler's JSON logs can be searched with Logs and
+// WaitForLog.
+package e2etest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auto-invite/invite"
+)
+
+// serveEnv is set in the environment of the child process that serves the
+// handler.
+const serveEnv = "E2ETEST_SERVE"
+
+// Settings of every harness. Tests may override them with Env.
+const (
+	Org          = "acme"
+	SuccessURL   = "https://site.test/welcome"
+	ErrorURL     = "https://site.test/error"
+	AdminToken   = "e2e-admin-token-0123456789abcdef"
+	APIToken     = "e2e-api-token-0123456789abcdef"
+	SigningKey   = "e2e-signing-key-0123456789abcdef0123"
+	clientID     = "e2e-client-id"
+	clientSecret = "e2e-client-secret"
+	adminPAT     = "e2e-pat-0123456789"
+)
+
+// Env holds settings for the handler. An empty value unsets a setting, and
+// WebhooksURL in a value is replaced with the URL of Harness.Webhooks.
+type Env map[string]string
+
+// WebhooksURL stands for the URL of Harness.Webhooks in Env values, such as
+// WebhooksURL + "/slack".
+const WebhooksURL = "{webhooks}"
+
+// Main runs the tests, or serves the handler when the test binary runs as a
+// harness's child process. Call it from TestMain.
+func Main(m *testing.M) {
+	if os.Getenv(serveEnv) == "1" {
+		serve()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// serve runs the handler on a local port, which it reports on stdout, until
+// stdin is closed.
+func serve() {
+	invite.Init()
+	ctx, cancel := context.WithCancel(context.Background())
+	go invite.RunWorkers(ctx)
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.HandlerFunc(invite.Handler)}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("listening on %s\n", ln.Addr())
+	go func() {
+		io.Copy(io.Discard, os.Stdin)
+		cancel()
+		srv.Close()
+	}()
+	srv.Serve(ln)
+	os.Exit(0)
+}
+
+// Harness is a running handler, its fake GitHub, and the recorder of its
+// notifications.
+type Harness struct {
+	URL      string // Base URL of the handler
+	GitHub   *GitHub
+	Webhooks *Webhooks
+	Client   *http.Client // Does not follow redirects
+
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu   sync.Mutex
+	logs []LogEntry
+	cond *sync.Cond
+}
+
+// LogEntry is a line of the handler's log.
+type LogEntry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Attrs   map[string]any
+}
+
+// Start starts a handler configured from env on top of the harness
+// defaults, with org Org on the fake GitHub. It is stopped when the test
+// ends.
+func Start(t testing.TB, env Env) *Harness {
+	t.Helper()
+	h := &Harness{
+		GitHub:   NewGitHub(),
+		Webhooks: NewWebhooks(),
+		Client: &http.Client{
+			Timeout:       30 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+	h.cond = sync.NewCond(&h.mu)
+	t.Cleanup(h.close)
+	h.GitHub.AddOrg(Org, 0)
+
+	settings := Env{
+		"GITHUB_URL":           h.GitHub.URL,
+		"GITHUB_CLIENT_ID":     clientID,
+		"GITHUB_CLIENT_SECRET": clientSecret,
+		"GITHUB_ORG_NAME":      Org,
+		"GITHUB_PAT":           adminPAT,
+		"SUCCESS_REDIRECT_URL": SuccessURL,
+		"ERROR_REDIRECT_URL":   ErrorURL,
+		"ADMIN_TOKEN":          AdminToken,
+		"API_TOKEN":            APIToken,
+		"SIGNING_KEY":          SigningKey,
+		"LOG_FORMAT":           "json",
+		"LOG_LEVEL":            "debug",
+	}
+	for k, v := range env {
+		settings[k] = v
+	}
+	h.cmd = exec.Command(os.Args[0], "-test.run=^$")
+	h.cmd.Env = []string{serveEnv + "=1"}
+	for _, kv := range os.Environ() {
+		// Only the harness's settings reach the handler.
+		if name, _, _ := strings.Cut(kv, "="); name == "PATH" || name == "HOME" || name == "TMPDIR" {
+			h.cmd.Env = append(h.cmd.Env, kv)
+		}
+	}
+	for k, v := range settings {
+		if v != "" {
+			h.cmd.Env = append(h.cmd.Env, k+"="+strings.ReplaceAll(v, WebhooksURL, h.Webhooks.URL))
+		}
+	}
+	stdout, err := h.cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := h.cmd.StderrPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.stdin, err = h.cmd.StdinPipe(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go h.readLogs(stderr)
+
+	addr := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if a, ok := strings.CutPrefix(scanner.Text(), "listening on "); ok {
+				addr <- a
+			}
+		}
+		close(addr)
+	}()
+	select {
+	case a, ok := <-addr:
+		if !ok {
+			t.Fatalf("e2etest: the handler exited before listening:\n%s", h.logText())
+		}
+		h.URL = "http://" + a
+	case <-time.After(30 * time.Second):
+		t.Fatal("e2etest: the handler did not start")
+	}
+	h.GitHub.CallbackURL = h.URL + "/github/callback"
+	return h
+}
+
+// close stops the handler and the fakes.
+func (h *Harness) close() {
+	if h.stdin != nil {
+		h.stdin.Close()
+	}
+	if h.cmd != nil && h.cmd.Process != nil {
+		done := make(chan struct{})
+		go func() { h.cmd.Wait(); close(done) }()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			h.cmd.Process.Kill()
+		}
+	}
+	h.GitHub.Close()
+	h.Webhooks.Close()
+}
+
+// readLogs collects the handler's JSON log lines.
+func (h *Harness) readLogs(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		entry := LogEntry{Attrs: map[string]any{}}
+		var fields map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			entry.Message = scanner.Text()
+		} else {
+			for k, v := range fields {
+				switch s, _ := v.(string); k {
+				case "time":
+					entry.Time, _ = time.Parse(time.RFC3339Nano, s)
+				case "level":
+					entry.Level = s
+				case "msg":
+					entry.Message = s
+				default:
+					entry.Attrs[k] = v
+				}
+			}
+		}
+		h.mu.Lock()
+		h.logs = append(h.logs, entry)
+		h.cond.Broadcast()
+		h.mu.Unlock()
+	}
+}
+
+// Logs returns the handler's log so far.
+func (h *Harness) Logs() []LogEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]LogEntry(nil), h.logs...)
+}
+
+// logText returns the log as text, for failure messages.
+func (h *Harness) logText() string {
+	var b strings.Builder
+	for _, e := range h.Logs() {
+		fmt.Fprintf(&b, "%s %s\n", e.Level, e.Message)
+	}
+	return b.String()
+}
+
+// WaitForLog waits up to 10 seconds for a log line containing substr, and
+// returns it. Work done off the request path, such as notifications, logs
+// after the response.
+func (h *Harness) WaitForLog(t testing.TB, substr string) LogEntry {
+	t.Helper()
+	entry, ok := h.waitForLog(substr, 10*time.Second)
+	if !ok {
+		t.Fatalf("e2etest: no log line contains %q; the log is:\n%s", substr, h.logText())
+	}
+	return entry
+}
+
+func (h *Harness) waitForLog(substr string, timeout time.Duration) (LogEntry, bool) {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		h.mu.Lock()
+		h.cond.Broadcast()
+		h.mu.Unlock()
+	})
+	defer timer.Stop()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for seen := 0; ; {
+		for ; seen < len(h.logs); seen++ {
+			if strings.Contains(h.logs[seen].Message, substr) {
+				return h.logs[seen], true
+			}
+		}
+		if time.Now().After(deadline) {
+			return LogEntry{}, false
+		}
+		h.cond.Wait()
+	}
+}
+
+// Result is where a browser-less sign-in ended: a redirect off the handler
+// and the fake GitHub, such as to SuccessURL or ErrorURL, or a page the
+// handler served itself.
+type Result struct {
+	Status   int
+	Location *url.URL // Nil unless the flow ended in a redirect
+	Body     string   // The page, unless the flow ended in a redirect
+	Hops     []string // Every URL visited
+}
+
+// ErrorCode returns the error_code the flow ended with, or "".
+func (r *Result) ErrorCode() string {
+	if r.Location == nil {
+		return ""
+	}
+	return r.Location.Query().Get("error_code")
+}
+
+// SignIn signs in as login from /login with query, follows the flow through
+// the fake GitHub's authorization page, and returns where it ended. The
+// fake GitHub must know login unless query has deny=1, which cancels the
+// authorization instead.
+func (h *Harness) SignIn(t testing.TB, login string, query url.Values) *Result {
+	t.Helper()
+	deny := query.Get("deny") == "1"
+	q := url.Values{}
+	for k, v := range query {
+		if k != "deny" {
+			q[k] = v
+		}
+	}
+	next := h.URL + "/login"
+	if len(q) > 0 {
+		next += "?" + q.Encode()
+	}
+	res := &Result{}
+	for range 10 {
+		res.Hops = append(res.Hops, next)
+		resp, err := h.Client.Get(next)
+		if err != nil {
+			t.Fatalf("e2etest: GET %s: %v", next, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		res.Status = resp.StatusCode
+		loc, err := resp.Location()
+		if err != nil {
+			res.Body = string(body)
+			return res
+		}
+		switch {
+		case strings.HasPrefix(loc.String(), h.GitHub.URL+"/login/oauth/authorize"):
+			aq := loc.Query()
+			if deny {
+				aq.Set("deny", "1")
+			} else {
+				aq.Set("login", login)
+			}
+			loc.RawQuery = aq.Encode()
+		case !strings.HasPrefix(loc.String(), h.URL) && !strings.HasPrefix(loc.String(), h.GitHub.URL):
+			res.Location = loc
+			return res
+		}
+		next = loc.String()
+	}
+	t.Fatalf("e2etest: too many redirects: %v", res.Hops)
+	return nil
+}
+
+// Admin calls the admin API with AdminToken and decodes the JSON response
+// into out, if not nil. It returns the status code.
+func (h *Harness) Admin(t testing.TB, method, path string, body io.Reader, out any) int {
+	t.Helper()
+	return h.call(t, method, path, AdminToken, body, out)
+}
+
+// API calls the /api/v1/ API with APIToken, like Admin.
+func (h *Harness) API(t testing.TB, method, path string, body io.Reader, out any) int {
+	t.Helper()
+	return h.call(t, method, path, APIToken, body, out)
+}
+
+func (h *Harness) call(t testing.TB, method, path, token string, body io.Reader, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, h.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		t.Fatalf("e2etest: %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("e2etest: %s %s: decoding the response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
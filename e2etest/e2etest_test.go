@@ -0,0 +1,201 @@
+package e2etest
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) { Main(m) }
+
+func TestInvite(t *testing.T) {
+	h := Start(t, nil)
+	h.GitHub.AddUser(User{Login: "octocat"})
+	res := h.SignIn(t, "octocat", nil)
+	if code := res.ErrorCode(); code != "" {
+		t.Fatalf("sign-in failed: %s", code)
+	}
+	if res.Location == nil || !strings.HasPrefix(res.Location.String(), SuccessURL) {
+		t.Fatalf("sign-in ended at %v, want %s", res.Location, SuccessURL)
+	}
+	h.WaitForLog(t, "Successfully invited user octocat")
+	if m, ok := h.GitHub.Membership(Org, "octocat"); !ok || m.State != "pending" {
+		t.Fatalf("membership = %+v, %v; want a pending one", m, ok)
+	}
+}
+
+func TestGateDenied(t *testing.T) {
+	h := Start(t, Env{"MIN_CONTRIBUTIONS": "10"})
+	h.GitHub.AddUser(User{Login: "newbie", Contributions: 2})
+	res := h.SignIn(t, "newbie", nil)
+	if res.Location == nil || !strings.HasPrefix(res.Location.String(), ErrorURL) || res.ErrorCode() == "" {
+		t.Fatalf("sign-in ended at %v, want %s with an error code", res.Location, ErrorURL)
+	}
+	if _, ok := h.GitHub.Membership(Org, "newbie"); ok {
+		t.Fatal("a user below MIN_CONTRIBUTIONS was invited")
+	}
+}
+
+func TestLinkMaxUses(t *testing.T) {
+	h := Start(t, nil)
+	var link struct{ Token string }
+	if status := h.Admin(t, http.MethodPost, "/admin/links", strings.NewReader(`{"max_uses": 1}`), &link); status != http.StatusCreated {
+		t.Fatalf("minting a link: %d", status)
+	}
+	h.GitHub.AddUser(User{Login: "first"})
+	h.GitHub.AddUser(User{Login: "second"})
+	if code := h.SignIn(t, "first", url.Values{"t": {link.Token}}).ErrorCode(); code != "" {
+		t.Fatalf("first use: %s", code)
+	}
+	if code := h.SignIn(t, "second", url.Values{"t": {link.Token}}).ErrorCode(); code != "link_exhausted" {
+		t.Fatalf("second use: error_code = %q, want link_exhausted", code)
+	}
+	if _, ok := h.GitHub.Membership(Org, "second"); ok {
+		t.Fatal("an exhausted link invited its user")
+	}
+}
+
+func TestApproval(t *testing.T) {
+	h := Start(t, Env{"APPROVAL_QUEUE": "all"})
+	h.GitHub.AddUser(User{Login: "octocat"})
+	h.SignIn(t, "octocat", nil)
+	if _, ok := h.GitHub.Membership(Org, "octocat"); ok {
+		t.Fatal("invited before approval")
+	}
+	var list struct {
+		Approvals []struct {
+			ID       string
+			Username string
+			Status   string
+		}
+	}
+	h.Admin(t, http.MethodGet, "/admin/approvals", nil, &list)
+	if len(list.Approvals) != 1 || list.Approvals[0].Username != "octocat" || list.Approvals[0].Status != "pending" {
+		t.Fatalf("approvals = %+v, want one pending for octocat", list.Approvals)
+	}
+	path := "/admin/approvals/" + list.Approvals[0].ID
+	if status := h.Admin(t, http.MethodPost, path, strings.NewReader(`{"decision": "approve"}`), nil); status != http.StatusOK {
+		t.Fatalf("approving: %d", status)
+	}
+	h.WaitForLog(t, "for octocat: approved")
+	if _, ok := h.GitHub.Membership(Org, "octocat"); !ok {
+		t.Fatal("not invited after approval")
+	}
+}
+
+func TestAPIInvite(t *testing.T) {
+	h := Start(t, nil)
+	h.GitHub.AddUser(User{Login: "octocat"})
+	var inv struct {
+		ID        string
+		Username  string
+		Status    string
+		AcceptURL string `json:"accept_url"`
+	}
+	if status := h.API(t, http.MethodPost, "/api/v1/invites", strings.NewReader(`{"username": "octocat"}`), &inv); status != http.StatusCreated {
+		t.Fatalf("creating the invite: %d", status)
+	}
+	if inv.ID == "" || inv.Username != "octocat" || inv.AcceptURL == "" {
+		t.Fatalf("invite = %+v", inv)
+	}
+	if _, ok := h.GitHub.Membership(Org, "octocat"); !ok {
+		t.Fatal("the API invite did not reach GitHub")
+	}
+	if status := h.API(t, http.MethodGet, "/api/v1/invites/"+inv.ID, nil, &inv); status != http.StatusOK || inv.Username != "octocat" {
+		t.Fatalf("getting the invite: %d %+v", status, inv)
+	}
+}
+
+// TestTokenTypes checks that a signed token of one kind is not taken as
+// another: the checklist token of the success page must not work as an
+// invite link or a resend button.
+func TestTokenTypes(t *testing.T) {
+	h := Start(t, Env{"ONBOARDING_CHECKLIST": "true", "STATUS_PAGE": "true"})
+	h.GitHub.AddUser(User{Login: "octocat"})
+	res := h.SignIn(t, "octocat", nil)
+	token := res.Location.Query().Get("checklist_token")
+	if token == "" {
+		t.Fatalf("the success page got no checklist_token: %v", res.Location)
+	}
+	h.GitHub.AddUser(User{Login: "mallory"})
+	if code := h.SignIn(t, "mallory", url.Values{"t": {token}}).ErrorCode(); code != "invalid_link" {
+		t.Fatalf("checklist token as a link: error_code = %q, want invalid_link", code)
+	}
+	resp, err := h.Client.PostForm(h.URL+"/status/resend", url.Values{"token": {token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "This page has expired") {
+		t.Fatalf("the checklist token was taken as a resend token:\n%s", body)
+	}
+}
+
+// TestForwardedFor checks that clients cannot pick their own address with
+// X-Forwarded-For to get around DENY_IPS.
+func TestForwardedFor(t *testing.T) {
+	get := func(t *testing.T, h *Harness, fwd string) int {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, h.URL+"/login", nil)
+		req.Header.Set("X-Forwarded-For", fwd)
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("untrusted peer", func(t *testing.T) {
+		h := Start(t, Env{"DENY_IPS": "127.0.0.1"})
+		if status := get(t, h, "203.0.113.7"); status != http.StatusForbidden {
+			t.Fatalf("a denied peer with a forged X-Forwarded-For got %d, want 403", status)
+		}
+	})
+	t.Run("trusted proxy", func(t *testing.T) {
+		h := Start(t, Env{"DENY_IPS": "198.51.100.9", "TRUSTED_PROXIES": "127.0.0.1"})
+		if status := get(t, h, "203.0.113.7, 198.51.100.9"); status != http.StatusForbidden {
+			t.Fatalf("a denied client behind a trusted proxy got %d, want 403", status)
+		}
+		if status := get(t, h, "198.51.100.9, 203.0.113.7"); status == http.StatusForbidden {
+			t.Fatal("the client-supplied leftmost X-Forwarded-For entry was believed")
+		}
+	})
+}
+
+func TestDualControlAPI(t *testing.T) {
+	h := Start(t, Env{"DUAL_CONTROL_ROLES": "admin"})
+	h.GitHub.AddUser(User{Login: "octocat"})
+	var inv struct{ ID, Status string }
+	if status := h.API(t, http.MethodPost, "/api/v1/invites", strings.NewReader(`{"username": "octocat", "role": "admin"}`), &inv); status != http.StatusAccepted || inv.Status != "pending_approval" {
+		t.Fatalf("an admin invite through the API: %d %+v, want 202 pending_approval", status, inv)
+	}
+	if _, ok := h.GitHub.Membership(Org, "octocat"); ok {
+		t.Fatal("an admin invite through the API skipped dual control")
+	}
+	if status := h.API(t, http.MethodPost, "/api/v1/invites", strings.NewReader(`{"email": "a@example.com", "role": "admin"}`), nil); status != http.StatusForbidden {
+		t.Fatalf("an admin invite by email through the API: %d, want 403", status)
+	}
+	if status := h.Admin(t, http.MethodPost, "/admin/bulk", strings.NewReader(`{"entries": ["octocat"], "role": "admin"}`), nil); status != http.StatusForbidden {
+		t.Fatalf("a bulk admin invite: %d, want 403", status)
+	}
+}
+
+// TestApprovalLinks checks that the one-click links of approval
+// notifications point at PUBLIC_URL, whatever Host the requester sends.
+func TestApprovalLinks(t *testing.T) {
+	h := Start(t, Env{"APPROVAL_QUEUE": "all", "PUBLIC_URL": "https://invite.test", "APPROVAL_SLACK_WEBHOOK": WebhooksURL + "/slack"})
+	h.GitHub.AddUser(User{Login: "octocat"})
+	h.SignIn(t, "octocat", nil)
+	d, ok := h.Webhooks.Wait("/slack", 10*time.Second)
+	if !ok {
+		t.Fatal("no approval notification was sent")
+	}
+	if !strings.Contains(string(d.Body), "https://invite.test/admin/decide?token=") || strings.Contains(string(d.Body), h.URL) {
+		t.Fatalf("the approval links do not point at PUBLIC_URL: %s", d.Body)
+	}
+}
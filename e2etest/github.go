@@ -0,0 +1,496 @@
+package e2etest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is an account of the fake GitHub.
+type User struct {
+	Login         string
+	ID            int64 // Assigned by AddUser when zero
+	Email         string
+	Type          string // "User" (the default) or "Bot"
+	CreatedAt     time.Time
+	Suspended     bool
+	Contributions int // Contributions over the last year, for the GraphQL API
+}
+
+// Membership is a user's membership of an org of the fake GitHub.
+type Membership struct {
+	Login string
+	Role  string // "member" or "admin"
+	State string // "pending" until Accept, then "active"
+	Teams []string
+}
+
+// Invitation is an org invitation sent through the invitations API, by email
+// or by user ID.
+type Invitation struct {
+	Org       string
+	Email     string
+	InviteeID int64
+	Role      string
+	TeamIDs   []int64
+}
+
+// org is an org of the fake GitHub.
+type org struct {
+	seats       int // 0 for unlimited
+	members     map[string]*Membership
+	teams       map[string]int64
+	invitations []Invitation
+}
+
+// GitHub is a fake of the parts of GitHub the invite flow uses: the OAuth
+// web flow, the REST API under /api/v3/, and the GraphQL API at
+// /api/graphql, as GitHub Enterprise Server serves them. Its zero value is
+// not usable; create it with NewGitHub.
+type GitHub struct {
+	*httptest.Server
+
+	// CallbackURL is where the authorize page sends users back to when the
+	// authorization request names no redirect_uri.
+	CallbackURL string
+
+	mu       sync.Mutex
+	users    map[string]*User
+	orgs     map[string]*org
+	codes    map[string]string // OAuth code to login
+	requests []string          // "METHOD /path" of every request
+	nextID   int64
+}
+
+// NewGitHub starts a fake GitHub. Close it when done.
+func NewGitHub() *GitHub {
+	g := &GitHub{
+		users:  map[string]*User{},
+		orgs:   map[string]*org{},
+		codes:  map[string]string{},
+		nextID: 1000,
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	return g
+}
+
+// AddUser adds an account, replacing any with the same login.
+func (g *GitHub) AddUser(u User) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if u.ID == 0 {
+		g.nextID++
+		u.ID = g.nextID
+	}
+	if u.Type == "" {
+		u.Type = "User"
+	}
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now().AddDate(-2, 0, 0)
+	}
+	g.users[strings.ToLower(u.Login)] = &u
+}
+
+// AddOrg adds an org with seats seats, 0 for unlimited, and the given team
+// slugs.
+func (g *GitHub) AddOrg(name string, seats int, teams ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o := &org{seats: seats, members: map[string]*Membership{}, teams: map[string]int64{}}
+	for _, slug := range teams {
+		g.nextID++
+		o.teams[slug] = g.nextID
+	}
+	g.orgs[strings.ToLower(name)] = o
+}
+
+// AddMember makes login an active member of org.
+func (g *GitHub) AddMember(orgName, login, role string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o := g.orgs[strings.ToLower(orgName)]; o != nil {
+		o.members[strings.ToLower(login)] = &Membership{Login: login, Role: role, State: "active"}
+	}
+}
+
+// Accept accepts the pending invitation of login to org, as the user would.
+// It reports whether there was one.
+func (g *GitHub) Accept(orgName, login string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	o := g.orgs[strings.ToLower(orgName)]
+	if o == nil {
+		return false
+	}
+	m := o.members[strings.ToLower(login)]
+	if m == nil || m.State != "pending" {
+		return false
+	}
+	m.State = "active"
+	return true
+}
+
+// Membership returns the membership of login in org, if any.
+func (g *GitHub) Membership(orgName, login string) (Membership, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o := g.orgs[strings.ToLower(orgName)]; o != nil {
+		if m := o.members[strings.ToLower(login)]; m != nil {
+			c := *m
+			c.Teams = append([]string(nil), m.Teams...)
+			return c, true
+		}
+	}
+	return Membership{}, false
+}
+
+// Invitations returns the invitations sent to org through the invitations
+// API.
+func (g *GitHub) Invitations(orgName string) []Invitation {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if o := g.orgs[strings.ToLower(orgName)]; o != nil {
+		return append([]Invitation(nil), o.invitations...)
+	}
+	return nil
+}
+
+// Requests returns "METHOD /path" for every request served so far.
+func (g *GitHub) Requests() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.requests...)
+}
+
+// seatsUsed counts the active and pending members of o.
+func (o *org) seatsUsed() int {
+	return len(o.members)
+}
+
+func (g *GitHub) serve(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, r.Method+" "+r.URL.Path)
+	switch path := r.URL.Path; {
+	case path == "/login/oauth/authorize":
+		g.authorize(w, r)
+	case path == "/login/oauth/access_token":
+		g.accessToken(w, r)
+	case path == "/api/graphql":
+		g.graphql(w, r)
+	case strings.HasPrefix(path, "/api/v3/"):
+		conditional(w, r, func(w http.ResponseWriter) {
+			g.rest(w, r, strings.Split(strings.Trim(strings.TrimPrefix(path, "/api/v3/"), "/"), "/"))
+		})
+	default:
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+	}
+}
+
+// conditional serves a GET with an ETag, like GitHub, answering a matching
+// If-None-Match with 304 Not Modified.
+func conditional(w http.ResponseWriter, r *http.Request, serve func(w http.ResponseWriter)) {
+	if r.Method != http.MethodGet {
+		serve(w)
+		return
+	}
+	rec := httptest.NewRecorder()
+	serve(rec)
+	for name, values := range rec.Header() {
+		w.Header()[name] = values
+	}
+	if rec.Code == http.StatusOK {
+		sum := sha256.Sum256(rec.Body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+// authorize is the authorization page. Browsers would pick an account; the
+// harness names it with the login parameter, and deny=1 cancels.
+func (g *GitHub) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := q.Get("redirect_uri")
+	if target == "" {
+		target = g.CallbackURL
+	}
+	u, err := url.Parse(target)
+	if err != nil || target == "" {
+		http.Error(w, "no redirect_uri", http.StatusBadRequest)
+		return
+	}
+	back := u.Query()
+	back.Set("state", q.Get("state"))
+	if q.Get("deny") == "1" {
+		back.Set("error", "access_denied")
+	} else {
+		login := strings.ToLower(q.Get("login"))
+		if g.users[login] == nil {
+			http.Error(w, "unknown login", http.StatusBadRequest)
+			return
+		}
+		g.nextID++
+		code := fmt.Sprintf("code-%d", g.nextID)
+		g.codes[code] = login
+		back.Set("code", code)
+	}
+	u.RawQuery = back.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// accessToken exchanges a code, once, for a token naming the user.
+func (g *GitHub) accessToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	code := r.PostForm.Get("code")
+	login, ok := g.codes[code]
+	if !ok {
+		writeJSON(w, http.StatusOK, map[string]string{"error": "bad_verification_code"})
+		return
+	}
+	delete(g.codes, code)
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": "gho_" + login, "token_type": "bearer", "scope": "read:user"})
+}
+
+// caller returns the user a user token belongs to, or nil for the admin
+// token and unknown tokens.
+func (g *GitHub) caller(r *http.Request) *User {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if login, ok := strings.CutPrefix(token, "gho_"); ok {
+		return g.users[login]
+	}
+	return nil
+}
+
+// rest serves the REST API calls of the invite flow.
+func (g *GitHub) rest(w http.ResponseWriter, r *http.Request, parts []string) {
+	if r.Header.Get("Authorization") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Requires authentication"})
+		return
+	}
+	switch {
+	case len(parts) == 1 && parts[0] == "user":
+		if u := g.caller(r); u != nil {
+			writeJSON(w, http.StatusOK, userJSON(u))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"login": "e2e-admin", "id": 1, "type": "User"})
+	case len(parts) == 4 && parts[0] == "user" && parts[1] == "memberships" && parts[2] == "orgs":
+		// The admin token belongs to an owner of every org.
+		name := parts[3]
+		if u := g.caller(r); u != nil {
+			if o := g.orgs[strings.ToLower(name)]; o != nil && o.members[strings.ToLower(u.Login)] != nil {
+				writeJSON(w, http.StatusOK, membershipJSON(name, o.members[strings.ToLower(u.Login)]))
+				return
+			}
+			notFound(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, membershipJSON(name, &Membership{Login: "e2e-admin", Role: "admin", State: "active"}))
+	case len(parts) == 1 && parts[0] == "rate_limit":
+		reset := time.Now().Add(time.Hour).Unix()
+		limit := map[string]any{"limit": 5000, "remaining": 5000, "reset": reset}
+		writeJSON(w, http.StatusOK, map[string]any{"resources": map[string]any{"core": limit, "graphql": limit}})
+	case len(parts) == 2 && parts[0] == "users":
+		if u := g.users[strings.ToLower(parts[1])]; u != nil {
+			writeJSON(w, http.StatusOK, userJSON(u))
+			return
+		}
+		notFound(w)
+	case len(parts) >= 2 && parts[0] == "orgs":
+		o := g.orgs[strings.ToLower(parts[1])]
+		if o == nil {
+			notFound(w)
+			return
+		}
+		g.orgAPI(w, r, parts[1], o, parts[2:])
+	default:
+		notFound(w)
+	}
+}
+
+// orgAPI serves /orgs/{org}/...
+func (g *GitHub) orgAPI(w http.ResponseWriter, r *http.Request, name string, o *org, parts []string) {
+	switch {
+	case len(parts) == 0:
+		body := map[string]any{"login": name}
+		if o.seats > 0 {
+			body["plan"] = map[string]any{"name": "team", "seats": o.seats, "filled_seats": o.seatsUsed()}
+		}
+		writeJSON(w, http.StatusOK, body)
+	case len(parts) == 1 && parts[0] == "members":
+		var list []map[string]any
+		for _, m := range o.members {
+			if m.State == "active" {
+				if u := g.users[strings.ToLower(m.Login)]; u != nil {
+					list = append(list, userJSON(u))
+				} else {
+					list = append(list, map[string]any{"login": m.Login})
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	case len(parts) == 2 && parts[0] == "members":
+		if m := o.members[strings.ToLower(parts[1])]; m != nil && m.State == "active" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		notFound(w)
+	case len(parts) == 2 && parts[0] == "memberships":
+		login := strings.ToLower(parts[1])
+		switch r.Method {
+		case http.MethodGet:
+			if m := o.members[login]; m != nil {
+				writeJSON(w, http.StatusOK, membershipJSON(name, m))
+				return
+			}
+			notFound(w)
+		case http.MethodPut:
+			g.invite(w, r, name, o, login)
+		default:
+			notFound(w)
+		}
+	case len(parts) == 1 && parts[0] == "invitations" && r.Method == http.MethodPost:
+		var body struct {
+			Email     string  `json:"email"`
+			InviteeID int64   `json:"invitee_id"`
+			Role      string  `json:"role"`
+			TeamIDs   []int64 `json:"team_ids"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if o.seats > 0 && o.seatsUsed()+len(o.invitations) >= o.seats {
+			seatLimit(w)
+			return
+		}
+		o.invitations = append(o.invitations, Invitation{Org: name, Email: body.Email, InviteeID: body.InviteeID, Role: body.Role, TeamIDs: body.TeamIDs})
+		writeJSON(w, http.StatusCreated, map[string]any{"id": len(o.invitations), "email": body.Email, "role": body.Role})
+	case len(parts) == 2 && parts[0] == "teams":
+		if id, ok := o.teams[parts[1]]; ok {
+			writeJSON(w, http.StatusOK, map[string]any{"id": id, "slug": parts[1]})
+			return
+		}
+		notFound(w)
+	case len(parts) == 4 && parts[0] == "teams" && parts[2] == "memberships":
+		slug, login := parts[1], strings.ToLower(parts[3])
+		m := o.members[login]
+		if _, ok := o.teams[slug]; !ok || m == nil {
+			notFound(w)
+			return
+		}
+		if r.Method == http.MethodPut {
+			m.Teams = append(m.Teams, slug)
+		}
+		for _, t := range m.Teams {
+			if t == slug {
+				writeJSON(w, http.StatusOK, map[string]any{"state": m.State, "role": "member"})
+				return
+			}
+		}
+		notFound(w)
+	default:
+		notFound(w)
+	}
+}
+
+// invite serves PUT /orgs/{org}/memberships/{user}: a pending membership for
+// a new user, or a role change for a member.
+func (g *GitHub) invite(w http.ResponseWriter, r *http.Request, name string, o *org, login string) {
+	u := g.users[login]
+	if u == nil {
+		notFound(w)
+		return
+	}
+	var body struct {
+		Role string `json:"role"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Role == "" {
+		body.Role = "member"
+	}
+	m := o.members[login]
+	if m == nil {
+		if o.seats > 0 && o.seatsUsed()+len(o.invitations) >= o.seats {
+			seatLimit(w)
+			return
+		}
+		m = &Membership{Login: u.Login, State: "pending"}
+		o.members[login] = m
+	}
+	m.Role = body.Role
+	writeJSON(w, http.StatusOK, membershipJSON(name, m))
+}
+
+// graphql answers the contributions query of the contributions gate.
+func (g *GitHub) graphql(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Variables map[string]string `json:"variables"`
+	}
+	b, _ := io.ReadAll(r.Body)
+	json.Unmarshal(b, &body)
+	u := g.users[strings.ToLower(body.Variables["login"])]
+	if u == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"user": nil}})
+		return
+	}
+	calendar := map[string]any{"totalContributions": u.Contributions}
+	writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"user": map[string]any{
+		"contributionsCollection": map[string]any{"contributionCalendar": calendar},
+	}}})
+}
+
+func userJSON(u *User) map[string]any {
+	body := map[string]any{
+		"login":      u.Login,
+		"id":         u.ID,
+		"type":       u.Type,
+		"created_at": u.CreatedAt.UTC().Format(time.RFC3339),
+	}
+	if u.Email != "" {
+		body["email"] = u.Email
+	}
+	if u.Suspended {
+		body["suspended_at"] = time.Now().UTC().Format(time.RFC3339)
+	}
+	return body
+}
+
+func membershipJSON(org string, m *Membership) map[string]any {
+	return map[string]any{
+		"state":        m.State,
+		"role":         m.Role,
+		"organization": map[string]any{"login": org},
+		"user":         map[string]any{"login": m.Login},
+	}
+}
+
+func notFound(w http.ResponseWriter) {
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+// seatLimit answers like GitHub does when an org has no seats left.
+func seatLimit(w http.ResponseWriter) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+		"message": "You must purchase at least one more seat to add this user as a member.",
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(5000))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
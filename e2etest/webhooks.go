@@ -0,0 +1,74 @@
+package e2etest
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Delivery is a request received by Webhooks.
+type Delivery struct {
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Webhooks records the notifications the handler sends, for settings such
+// as APPROVAL_SLACK_WEBHOOK and WELCOME_WEBHOOK_URL pointed at its URL. It
+// answers every request with 200.
+type Webhooks struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	deliveries []Delivery
+	cond       *sync.Cond
+}
+
+// NewWebhooks starts a webhook recorder. Close it when done.
+func NewWebhooks() *Webhooks {
+	wh := &Webhooks{}
+	wh.cond = sync.NewCond(&wh.mu)
+	wh.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		wh.mu.Lock()
+		wh.deliveries = append(wh.deliveries, Delivery{Path: r.URL.Path, Header: r.Header.Clone(), Body: body})
+		wh.cond.Broadcast()
+		wh.mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	return wh
+}
+
+// Deliveries returns the requests received so far.
+func (wh *Webhooks) Deliveries() []Delivery {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	return append([]Delivery(nil), wh.deliveries...)
+}
+
+// Wait waits up to timeout for a delivery to path and returns it, or false
+// when none arrived.
+func (wh *Webhooks) Wait(path string, timeout time.Duration) (Delivery, bool) {
+	deadline := time.Now().Add(timeout)
+	timer := time.AfterFunc(timeout, func() {
+		wh.mu.Lock()
+		wh.cond.Broadcast()
+		wh.mu.Unlock()
+	})
+	defer timer.Stop()
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	for seen := 0; ; {
+		for ; seen < len(wh.deliveries); seen++ {
+			if wh.deliveries[seen].Path == path {
+				return wh.deliveries[seen], true
+			}
+		}
+		if time.Now().After(deadline) {
+			return Delivery{}, false
+		}
+		wh.cond.Wait()
+	}
+}
@@ -0,0 +1,15 @@
+// /function.go
+package autoinvite
+
+import (
+	"net/http"
+
+	"auto-invite/invite"
+)
+
+// Invite is the entry point for Google Cloud Functions. Deploy with
+// --entry-point=Invite; the Go buildpack wraps it with the functions
+// framework.
+func Invite(w http.ResponseWriter, r *http.Request) {
+	invite.Handler(w, r)
+}
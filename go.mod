@@ -3,8 +3,13 @@ module auto-invite
 go 1.23.1
 
 require (
-	github.com/google/go-github/v39 v39.2.0 // indirect
+	github.com/aws/aws-lambda-go v1.47.0
+	github.com/google/go-github/v39 v39.2.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/oauth2 v0.30.0
+)
+
+require (
 	github.com/google/go-querystring v1.1.0 // indirect
 	golang.org/x/crypto v0.39.0 // indirect
-	golang.org/x/oauth2 v0.30.0 // indirect
 )
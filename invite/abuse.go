@@ -0,0 +1,224 @@
+package invite
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+	"auto-invite/webhookverify"
+)
+
+// Store keys of the abuse reports.
+const (
+	abuseFlagKey      = "abuse:flag:"
+	abuseFlagIndexKey = "abuse:flags"
+	abuseDeliveryKey  = "abuse:delivery:"
+)
+
+// reasonAbuseReported is the reason of approvals queued because the user was
+// reported.
+const reasonAbuseReported = "abuse_reported"
+
+// Abuse report settings, from ABUSE_REPORT_SECRET and ABUSE_FLAG_TTL.
+var (
+	abuseReportSecret []byte
+	abuseFlagTTL      time.Duration
+)
+
+// loadAbuseReportConfig reads ABUSE_REPORT_SECRET, which signs the reports
+// of the moderation bot as webhookverify does, and ABUSE_FLAG_TTL, how long
+// a report keeps a user flagged.
+func loadAbuseReportConfig() {
+	abuseReportSecret = []byte(getenv("ABUSE_REPORT_SECRET"))
+	if len(abuseReportSecret) == 0 {
+		return
+	}
+	if len(abuseReportSecret) < minSigningKeyLen {
+		log.Fatalf("FATAL: ABUSE_REPORT_SECRET must be at least %d bytes long.", minSigningKeyLen)
+	}
+	abuseFlagTTL = envDuration("ABUSE_FLAG_TTL", 90*24*time.Hour)
+	if abuseFlagTTL <= 0 {
+		log.Fatal("FATAL: ABUSE_FLAG_TTL must be positive.")
+	}
+}
+
+// abuseReport is the body of POST /abuse/report.
+type abuseReport struct {
+	Username  string `json:"username"`
+	Reason    string `json:"reason"`
+	Reporter  string `json:"reporter,omitempty"`  // Who reported, in the moderation tool
+	Reference string `json:"reference,omitempty"` // Link to the report, for the reviewer
+}
+
+// abuseFlag is a reported user, whose invitations wait for an admin's
+// approval until the flag expires or is cleared.
+type abuseFlag struct {
+	Username  string        `json:"username"`
+	Reports   []abuseReport `json:"reports"`
+	FlaggedAt time.Time     `json:"flagged_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// maxAbuseReports bounds the reports kept on a flag; the oldest are dropped.
+const maxAbuseReports = 20
+
+// handleAbuseReport takes an abuse report from the moderation bot and flags
+// the username, so that their future sign-ins go to the approval queue
+// instead of being invited. Reports are signed like the webhooks the flow
+// sends, and each delivery is taken once. The endpoint is disabled unless
+// ABUSE_REPORT_SECRET is set.
+func handleAbuseReport(w http.ResponseWriter, r *http.Request) {
+	if len(abuseReportSecret) == 0 {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, ErrBadRequest.Wrap(err))
+		return
+	}
+	if err := webhookverify.Verify(r.Header, body, webhookverify.DefaultTolerance, abuseReportSecret); err != nil {
+		writeJSONError(w, ErrUnauthorized)
+		return
+	}
+	var report abuseReport
+	if err := json.Unmarshal(body, &report); err != nil || !validGitHubLogin.MatchString(report.Username) {
+		writeJSONError(w, ErrBadRequest.WithMessage("The report must name a GitHub username."))
+		return
+	}
+	ctx := r.Context()
+	// Verify takes only deliveries with a signed ID, so every report is
+	// counted once.
+	delivery := abuseDeliveryKey + r.Header.Get(webhookverify.DeliveryHeader)
+	first, err := dataStore.SetNX(ctx, delivery, nil, 2*webhookverify.DefaultTolerance)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	if !first {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	flag, err := flagAbuse(ctx, report)
+	if err != nil {
+		// The bot retries the delivery, so it is not counted as taken.
+		dataStore.Delete(ctx, delivery)
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	log.Printf("Abuse report for %s from %s: %s", report.Username, cmp.Or(report.Reporter, "the moderation bot"), report.Reason)
+	writeJSON(w, http.StatusAccepted, flag)
+}
+
+// flagAbuse adds report to the flag of its user, flagging them again for
+// ABUSE_FLAG_TTL.
+func flagAbuse(ctx context.Context, report abuseReport) (*abuseFlag, error) {
+	key := strings.ToLower(report.Username)
+	flag, err := loadAbuseFlag(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if flag == nil {
+		flag = &abuseFlag{Username: report.Username, FlaggedAt: now}
+		if _, err := dataStore.Append(ctx, abuseFlagIndexKey, []byte(key)); err != nil {
+			log.Printf("Failed to index the abuse flag of %s: %v", report.Username, err)
+		}
+	}
+	flag.UpdatedAt = now
+	flag.Reports = append(flag.Reports, report)
+	if len(flag.Reports) > maxAbuseReports {
+		flag.Reports = flag.Reports[len(flag.Reports)-maxAbuseReports:]
+	}
+	b, _ := json.Marshal(flag)
+	return flag, dataStore.Set(ctx, abuseFlagKey+key, b, abuseFlagTTL)
+}
+
+// loadAbuseFlag returns the flag of username, or nil when they are not
+// flagged.
+func loadAbuseFlag(ctx context.Context, username string) (*abuseFlag, error) {
+	b, err := dataStore.Get(ctx, abuseFlagKey+strings.ToLower(username))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var flag abuseFlag
+	if err := json.Unmarshal(b, &flag); err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// abuseFlagged reports whether username was reported, so that their
+// invitation goes to the approval queue. A flag that cannot be read does not
+// hold up the invitation.
+func abuseFlagged(ctx context.Context, username string) bool {
+	if len(abuseReportSecret) == 0 {
+		return false
+	}
+	flag, err := loadAbuseFlag(ctx, username)
+	if err != nil {
+		log.Printf("Could not check the abuse reports of %s, continuing: %v", username, err)
+		return false
+	}
+	return flag != nil
+}
+
+// handleListAbuseFlags lists the flagged users, most recently reported
+// first.
+func handleListAbuseFlags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	keys, err := dataStore.Range(ctx, abuseFlagIndexKey, 0, -1)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	flags := []*abuseFlag{}
+	seen := make(map[string]bool)
+	for i := len(keys) - 1; i >= 0; i-- {
+		if seen[string(keys[i])] {
+			continue
+		}
+		seen[string(keys[i])] = true
+		if flag, err := loadAbuseFlag(ctx, string(keys[i])); err == nil && flag != nil {
+			flags = append(flags, flag)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"abuse_flags": flags})
+}
+
+// handleClearAbuseFlag clears the flag of DELETE /admin/abuse-flags/{username},
+// so that the user is invited as anyone else again.
+func handleClearAbuseFlag(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimPrefix(r.URL.Path, "/admin/abuse-flags/")
+	ctx := r.Context()
+	flag, err := loadAbuseFlag(ctx, username)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if flag == nil {
+		writeJSONError(w, ErrNotFound.WithMessage("The user is not flagged."))
+		return
+	}
+	if err := dataStore.Delete(ctx, abuseFlagKey+strings.ToLower(username)); err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	log.Printf("Cleared the abuse flag of %s", flag.Username)
+	recordAudit(ctx, r, adminActor(r), auditAbuseFlagCleared, map[string]any{"username": flag.Username, "reports": len(flag.Reports)})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,172 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"auto-invite/store"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// Actions of ACCEPTED_ACTIONS that take no value.
+const (
+	acceptedWelcome    = "welcome"    // Post a welcome message to WELCOME_WEBHOOK_URL
+	acceptedSlack      = "slack"      // Send the Slack invitation on acceptance instead of with the invite
+	acceptedNewsletter = "newsletter" // Subscribe the user's verified email through NEWSLETTER_WEBHOOK_URL
+)
+
+// acceptanceConfig is the optional set of actions run once an invited user
+// has accepted the invitation, rather than when it is sent, so that
+// invitations that are never accepted have no side effects.
+type acceptanceConfig struct {
+	webhookSecret []byte   // Secret of the org webhook, for /github/webhook
+	teams         []string // Team slugs to add the user to
+	welcome       bool
+	slack         bool
+	newsletter    bool
+	welcomeURL    string
+	newsletterURL string
+}
+
+// acceptance is nil when no post-acceptance actions are configured.
+var acceptance *acceptanceConfig
+
+// loadAcceptanceConfig reads ACCEPTED_ACTIONS, a comma-separated list of
+// team=slug, welcome, slack, and newsletter actions. Acceptances are
+// detected by the org webhook, configured with GITHUB_WEBHOOK_SECRET and the
+// organization event, or else by the maintenance runs.
+func loadAcceptanceConfig() {
+	spec := getenv("ACCEPTED_ACTIONS")
+	secret := getenv("GITHUB_WEBHOOK_SECRET")
+	if spec == "" && secret == "" {
+		return
+	}
+	if _, ok := activeProvider.(githubProvider); !ok {
+		log.Fatal("FATAL: ACCEPTED_ACTIONS and GITHUB_WEBHOOK_SECRET are only supported for GitHub.")
+	}
+	a := &acceptanceConfig{
+		webhookSecret: []byte(secret),
+		welcomeURL:    getenv("WELCOME_WEBHOOK_URL"),
+		newsletterURL: getenv("NEWSLETTER_WEBHOOK_URL"),
+	}
+	for _, action := range strings.Split(spec, ",") {
+		action = strings.TrimSpace(action)
+		switch name, value, _ := strings.Cut(action, "="); {
+		case action == "":
+		case name == "team" && value != "":
+			a.teams = append(a.teams, value)
+		case action == acceptedWelcome && a.welcomeURL != "":
+			a.welcome = true
+		case action == acceptedSlack && slack != nil:
+			a.slack = true
+		case action == acceptedNewsletter && a.newsletterURL != "":
+			a.newsletter = true
+		default:
+			log.Fatalf("FATAL: Invalid ACCEPTED_ACTIONS entry %q; expected team=slug, welcome (with WELCOME_WEBHOOK_URL), slack (with the Slack step), or newsletter (with NEWSLETTER_WEBHOOK_URL).", action)
+		}
+	}
+	acceptance = a
+}
+
+// runAcceptedActions runs the post-acceptance actions for a user who joined.
+// Each action runs even when an earlier one fails.
+func runAcceptedActions(ctx context.Context, ev busEvent) {
+	username, org := ev.Entry.Username, orgOrDefault(ev.Entry.Org)
+	if len(acceptance.teams) > 0 && !capabilitiesOf(ctx, org).Teams {
+		log.Printf("Not adding %s to teams %v after acceptance: GITHUB_PAT cannot manage the teams of %s", username, acceptance.teams, org)
+	} else if len(acceptance.teams) > 0 {
+		client := adminClient()
+		for _, slug := range acceptance.teams {
+			if _, _, err := client.Teams.AddTeamMembershipBySlug(ctx, org, slug, username, nil); err != nil {
+				log.Printf("Failed to add %s to team %s after acceptance: %v", username, slug, err)
+			}
+		}
+	}
+	if acceptance.welcome {
+		text := fmt.Sprintf("Welcome @%s to %s!", username, org)
+		if err := postWebhook(ctx, acceptance.welcomeURL, map[string]string{"text": text}); err != nil {
+			log.Printf("Failed to post the welcome message for %s: %v", username, err)
+		}
+	}
+	if ev.User == nil || ev.User.Email == "" {
+		return
+	}
+	if acceptance.slack {
+		if err := slack.invite(ctx, ev.User); err != nil {
+			log.Printf("Slack invitation for %s failed: %v", username, err)
+		}
+	}
+	if acceptance.newsletter {
+		body := map[string]string{"email": ev.User.Email, "username": username, "org": org}
+		if err := postWebhook(ctx, acceptance.newsletterURL, body); err != nil {
+			log.Printf("Failed to subscribe %s to the newsletter: %v", username, err)
+		}
+	}
+}
+
+// handleGitHubWebhook receives the org webhook. A member_added organization
+// event for an invited user is their acceptance, which publishes
+// MemberJoined. The endpoint is disabled unless GITHUB_WEBHOOK_SECRET is
+// set.
+func handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if acceptance == nil || len(acceptance.webhookSecret) == 0 {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	payload, err := github.ValidatePayload(r, acceptance.webhookSecret)
+	if err != nil {
+		writeJSONError(w, ErrUnauthorized)
+		return
+	}
+	// Other events, such as the ping sent when the webhook is created, are
+	// acknowledged and ignored.
+	if github.WebHookType(r) != "organization" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	event, err := github.ParseWebHook("organization", payload)
+	if err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage("Invalid webhook payload."))
+		return
+	}
+	if e, ok := event.(*github.OrganizationEvent); ok && e.GetAction() == "member_added" {
+		org := knownOrg(e.GetOrganization().GetLogin())
+		username := e.GetMembership().GetUser().GetLogin()
+		if org != "" && username != "" {
+			if err := acceptPendingMember(r.Context(), org, username); err != nil {
+				log.Printf("Failed to record the acceptance of %s: %v", username, err)
+				writeJSONError(w, ErrConfig.Wrap(err))
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// acceptPendingMember publishes MemberJoined for an invited user of org who
+// has joined. Users this deployment did not invite are ignored.
+func acceptPendingMember(ctx context.Context, org, username string) error {
+	key := pendingMemberKey + org + ":" + username
+	b, err := dataStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var m pendingMember
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	return m.joined(ctx)
+}
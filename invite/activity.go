@@ -0,0 +1,133 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Store keys used by the activity feed.
+const (
+	activityKey      = "activity"
+	activityCountKey = "activity:count"
+)
+
+// Activity event types.
+const (
+	activityInviteSent   = "invite_sent"
+	activityInviteFailed = "invite_failed"
+	activityWaitlisted   = "waitlisted"
+	activityInviteResent = "invite_resent"
+	activityLinked       = "account_linked"
+	activityMemberJoined = "member_joined"
+)
+
+// activityPollInterval is how often the event stream checks for new events.
+const activityPollInterval = time.Second
+
+// activityEvent is one entry in the activity feed. The feed doubles as the
+// invite log: it is append-only and records every invitation outcome.
+type activityEvent struct {
+	Type     string            `json:"type"`
+	Provider string            `json:"provider,omitempty"`
+	UserID   string            `json:"user_id,omitempty"`
+	Username string            `json:"username,omitempty"`
+	Code     string            `json:"code,omitempty"` // Error code for failures
+	Campaign string            `json:"campaign,omitempty"`
+	Org      string            `json:"org,omitempty"`   // Org the event is about, when the deployment serves several
+	Links    map[string]string `json:"links,omitempty"` // Linked accounts on other platforms, by platform
+
+	SpamScore   *int     `json:"spam_score,omitempty"`   // Score given by the spam gate
+	SpamSignals []string `json:"spam_signals,omitempty"` // Spam signals that fired
+	Reputation  string   `json:"reputation,omitempty"`   // Decision of the reputation service
+	Gate        string   `json:"gate,omitempty"`         // Account gate that turned the user away
+	ShadowGates []string `json:"shadow_gates,omitempty"` // Gates in shadow mode that would have turned the user away
+	BotScore    *int     `json:"bot_score,omitempty"`    // Bot score of the sign-in form, with BOT_SIGNALS
+	BotSignals  []string `json:"bot_signals,omitempty"`  // Bot signals that fired
+
+	Variants map[string]string `json:"variants,omitempty"` // Experiment variants the user saw, by experiment
+	UTM      map[string]string `json:"utm,omitempty"`      // utm_* parameters of the link the user signed in from
+	Referrer string            `json:"referrer,omitempty"` // Page the user signed in from
+
+	Time time.Time `json:"time"`
+}
+
+// recordActivity appends an event to the activity feed. Failures are only
+// logged so that they never break the invite flow.
+func recordActivity(ctx context.Context, ev activityEvent) {
+	ev.Time = time.Now().UTC()
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	n, err := dataStore.Append(ctx, activityKey, b)
+	if err == nil {
+		err = dataStore.Set(ctx, activityCountKey, []byte(strconv.FormatInt(n, 10)), 0)
+	}
+	if err != nil {
+		log.Printf("Failed to record %s activity: %v", ev.Type, err)
+	}
+}
+
+// handleEvents streams the activity feed as Server-Sent Events. Event IDs are
+// positions in the feed, so clients that reconnect with Last-Event-ID (as
+// EventSource does automatically) resume without gaps, even when the
+// platform cuts long-running responses short.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, ErrConfig.WithMessage("Streaming is not supported by this server."))
+		return
+	}
+	ctx := r.Context()
+
+	next := readCounter(ctx, activityCountKey)
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			next = n + 1
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 2000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(activityPollInterval)
+	defer ticker.Stop()
+	idle := 0
+	for {
+		events, err := dataStore.Range(ctx, activityKey, next, -1)
+		if err != nil {
+			log.Printf("Failed to read activity feed: %v", err)
+			return
+		}
+		for _, b := range events {
+			var ev activityEvent
+			json.Unmarshal(b, &ev)
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", next, ev.Type, b)
+			next++
+		}
+		if len(events) > 0 {
+			idle = 0
+			flusher.Flush()
+		} else if idle++; idle >= 15 {
+			// Keep proxies from closing an idle connection.
+			idle = 0
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
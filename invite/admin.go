@@ -0,0 +1,148 @@
+package invite
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// handleAdmin routes the /admin/ API. All admin endpoints except
+// /admin/decide and the sign-in pages require an admin credential
+// (ADMIN_TOKEN or one from ADMIN_CREDENTIALS) as a bearer token, or a
+// session from signing in with GitHub, with at least the role the route asks
+// for. The API is disabled when neither is configured.
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	// Approve/deny links authenticate with their own signed token, and the
+	// sign-in pages come before any credential.
+	switch r.URL.Path {
+	case "/admin/decide":
+		handleDecide(w, r)
+		return
+	case "/admin/login":
+		handleAdminLogin(w, r)
+		return
+	case "/admin/logout":
+		handleAdminLogout(w, r)
+		return
+	}
+	if len(adminCredentials) == 0 && !adminGitHubLogin {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	cred := adminCredentialFor(r)
+	if cred == nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		writeJSONError(w, ErrUnauthorized)
+		return
+	}
+
+	route := matchAdminRoute(r.URL.Path)
+	if route == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	handler, ok := route[r.Method]
+	if !ok {
+		methodNotAllowed(w, slices.Sorted(maps.Keys(route))...)
+		return
+	}
+	if cred.role < handler.role {
+		writeJSONError(w, ErrForbidden)
+		return
+	}
+	handler.serve(w, withAdmin(r, cred))
+}
+
+// adminHandler is an admin endpoint and the least role allowed to call it.
+type adminHandler struct {
+	role  adminRole
+	serve http.HandlerFunc
+}
+
+// adminRoutes maps each /admin/ path to its handlers by HTTP method. Paths
+// ending in a slash match everything below them.
+var adminRoutes = map[string]map[string]adminHandler{
+	"/admin/links":           {http.MethodPost: {roleOwner, handleMintLink}},
+	"/admin/shortlinks":      {http.MethodPost: {roleOwner, handleCreateShortLink}},
+	"/admin/bulk":            {http.MethodPost: {roleOwner, handleBulkCreate}},
+	"/admin/bulk/":           {http.MethodGet: {roleViewer, handleBulkStatus}},
+	"/admin/events":          {http.MethodGet: {roleViewer, handleEvents}},
+	"/admin/sso-links":       {http.MethodGet: {roleViewer, handleListSSOLinks}},
+	"/admin/sso-links/":      {http.MethodGet: {roleViewer, handleGetSSOLink}, http.MethodDelete: {roleOwner, handleDeleteSSOLink}},
+	"/admin/approvals":       {http.MethodGet: {roleViewer, handleListApprovals}},
+	"/admin/soft-bans":       {http.MethodGet: {roleViewer, handleListSoftBans}, http.MethodPost: {roleOwner, handleAddSoftBan}},
+	"/admin/soft-bans/":      {http.MethodDelete: {roleOwner, handleLiftSoftBan}},
+	"/admin/abuse-flags":     {http.MethodGet: {roleViewer, handleListAbuseFlags}},
+	"/admin/abuse-flags/":    {http.MethodDelete: {roleApprover, handleClearAbuseFlag}},
+	"/admin/approvals/":      {http.MethodPost: {roleApprover, handleDecideApproval}},
+	"/admin/audit":           {http.MethodGet: {roleOwner, handleAuditLog}},
+	"/admin/reload":          {http.MethodPost: {roleOwner, handleReload}},
+	"/admin/config/validate": {http.MethodGet: {roleOwner, handleValidateConfig}},
+	"/admin/flags":           {http.MethodGet: {roleViewer, handleFlags}},
+	"/admin/experiments":     {http.MethodGet: {roleViewer, handleExperiments}},
+	"/admin/gates":           {http.MethodGet: {roleViewer, handleGateStats}},
+	"/admin/flows/":          {http.MethodGet: {roleViewer, handleFlowTrail}},
+	"/admin/team-sync":       {http.MethodGet: {roleViewer, handleTeamSync}, http.MethodPost: {roleOwner, handleTeamSync}},
+	"/admin/alerts":          {http.MethodGet: {roleViewer, handleAlerts}},
+	"/admin/slo":             {http.MethodGet: {roleViewer, handleSLO}},
+	"/admin/log-level":       {http.MethodGet: {roleViewer, handleLogLevel}, http.MethodPut: {roleOwner, handleLogLevel}},
+	"/admin/redirect-checks": {http.MethodGet: {roleViewer, handleRedirectChecks}},
+	"/admin/load-shedding":   {http.MethodGet: {roleViewer, handleLoadShed}, http.MethodPut: {roleOwner, handleLoadShed}},
+}
+
+// matchAdminRoute finds the route for path: an exact match, or else the
+// longest matching subtree.
+func matchAdminRoute(path string) map[string]adminHandler {
+	if route, ok := adminRoutes[path]; ok {
+		return route
+	}
+	var best string
+	for prefix := range adminRoutes {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return nil
+	}
+	return adminRoutes[best]
+}
+
+// adminCredentialFor returns the admin credential the request carries as a
+// bearer token, or else the session of an admin who signed in with GitHub,
+// or nil. The event stream also accepts the token as an access_token query
+// parameter, because browsers' EventSource cannot send headers.
+func adminCredentialFor(r *http.Request) *adminCredential {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && r.URL.Path == "/admin/events" {
+		token, ok = r.URL.Query().Get("access_token"), true
+	}
+	if !ok || token == "" {
+		return adminSessionFor(r)
+	}
+	return findAdminCredential(token)
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// publicBaseURL returns the externally visible base URL of this deployment,
+// from PUBLIC_URL or, failing that, the request itself.
+func publicBaseURL(r *http.Request) string {
+	if publicURL != "" {
+		return strings.TrimSuffix(publicURL, "/")
+	}
+	scheme := "https"
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	} else if r.TLS == nil {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
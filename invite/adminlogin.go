@@ -0,0 +1,191 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+)
+
+// adminSessionCookie holds the signed session of an admin who signed in
+// with GitHub.
+const adminSessionCookie = "admin_session"
+
+// adminSessionTTL is how long an admin session lasts.
+const adminSessionTTL = 8 * time.Hour
+
+// adminSession is the payload of the session cookie.
+type adminSession struct {
+	Name    string    `json:"name"`
+	Role    adminRole `json:"role"`
+	Expires int64     `json:"exp"`
+}
+
+var (
+	adminGitHubLogin bool                 // Let org owners and team maintainers sign in to /admin with GitHub
+	adminGitHubTeams map[string]adminRole // Role granted to maintainers of each team
+)
+
+// loadAdminLoginConfig reads the GitHub admin sign-in settings. Org owners
+// become owners; maintainers of the teams in ADMIN_GITHUB_TEAMS (a
+// comma-separated list of slug:role entries) get the role listed.
+func loadAdminLoginConfig() {
+	adminGitHubLogin = envBool("ADMIN_GITHUB_LOGIN")
+	if !adminGitHubLogin {
+		return
+	}
+	if activeProvider.Name() != "github" {
+		log.Fatal("FATAL: ADMIN_GITHUB_LOGIN requires the github provider.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when ADMIN_GITHUB_LOGIN is set.")
+	}
+	adminGitHubTeams = make(map[string]adminRole)
+	for _, entry := range strings.Split(getenv("ADMIN_GITHUB_TEAMS"), ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		slug, name, _ := strings.Cut(entry, ":")
+		role, ok := adminRoleNames[name]
+		if slug == "" || !ok {
+			log.Fatalf("FATAL: ADMIN_GITHUB_TEAMS: invalid entry %q: want slug:role.", entry)
+		}
+		adminGitHubTeams[slug] = role
+	}
+}
+
+// handleAdminLogin sends an admin to GitHub to sign in. The flow comes back
+// through the regular callback, marked as an admin sign-in in the state, so
+// no extra callback URL has to be registered.
+func handleAdminLogin(w http.ResponseWriter, r *http.Request) {
+	if !adminGitHubLogin {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	state, err := encodeState(r.Context(), flowState{Admin: true})
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	http.Redirect(w, r, oauthConf.AuthCodeURL(state, oauth2.AccessTypeOnline), redirectStatus(r, redirectLogin))
+}
+
+// handleAdminCallback completes a GitHub admin sign-in and sets the session
+// cookie.
+func handleAdminCallback(w http.ResponseWriter, r *http.Request) {
+	if !adminGitHubLogin {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	ctx := r.Context()
+	token, err := oauthConf.Exchange(withAPILogging(ctx), r.FormValue("code"))
+	if err != nil {
+		writeJSONError(w, ErrOAuthExchange.Wrap(redactError(err, r.FormValue("code"))))
+		return
+	}
+	user, _, err := newGitHubClient(oauthConf.Client(withAPILogging(ctx), token)).Users.Get(ctx, "")
+	if err != nil {
+		writeJSONError(w, ErrUserInfo.Wrap(err))
+		return
+	}
+	login := user.GetLogin()
+	role, err := adminRoleFor(ctx, adminClient(), login)
+	if err != nil {
+		log.Printf("Checking the admin role of %s failed: %v", login, err)
+		writeJSONError(w, ErrUserInfo.Wrap(err))
+		return
+	}
+	if role == 0 {
+		log.Printf("Refused admin sign-in for %s", login)
+		writeJSONError(w, ErrForbidden.WithMessage("Only org owners and maintainers of the admin teams can sign in."))
+		return
+	}
+
+	session := adminSession{Name: "github:" + login, Role: role, Expires: time.Now().Add(adminSessionTTL).Unix()}
+	payload, _ := json.Marshal(session)
+	cookie, err := signToken(r.Context(), tokenAdminSession, payload)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     adminSessionCookie,
+		Value:    cookie,
+		Path:     "/admin/",
+		MaxAge:   int(adminSessionTTL.Seconds()),
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Printf("Admin sign-in for %s as %s", login, role)
+	recordAudit(ctx, r, session.Name, auditAdminSignedIn, map[string]any{"role": role.String()})
+	writeJSON(w, http.StatusOK, map[string]string{"actor": session.Name, "role": role.String()})
+}
+
+// handleAdminLogout ends the admin session.
+func handleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: adminSessionCookie, Path: "/admin/", MaxAge: -1})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminRoleFor returns the admin role of a GitHub user, or 0 for none.
+func adminRoleFor(ctx context.Context, client *github.Client, login string) (adminRole, error) {
+	membership, resp, err := client.Organizations.GetOrgMembership(ctx, login, githubOrgName)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return 0, err
+	}
+	if membership.GetState() != "active" {
+		return 0, nil
+	}
+	if membership.GetRole() == "admin" {
+		return roleOwner, nil
+	}
+	var best adminRole
+	for slug, role := range adminGitHubTeams {
+		if role <= best {
+			continue
+		}
+		tm, resp, err := client.Teams.GetTeamMembershipBySlug(ctx, githubOrgName, slug, login)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				continue
+			}
+			return 0, fmt.Errorf("team %s: %w", slug, err)
+		}
+		if tm.GetState() == "active" && tm.GetRole() == "maintainer" {
+			best = role
+		}
+	}
+	return best, nil
+}
+
+// adminSessionFor returns the credential of a signed-in admin's session
+// cookie, or nil.
+func adminSessionFor(r *http.Request) *adminCredential {
+	if !adminGitHubLogin {
+		return nil
+	}
+	cookie, err := r.Cookie(adminSessionCookie)
+	if err != nil {
+		return nil
+	}
+	payload, err := verifyToken(tokenAdminSession, cookie.Value)
+	if err != nil {
+		return nil
+	}
+	var session adminSession
+	if json.Unmarshal(payload, &session) != nil || time.Now().Unix() >= session.Expires {
+		return nil
+	}
+	return &adminCredential{name: session.Name, role: session.Role}
+}
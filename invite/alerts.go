@@ -0,0 +1,440 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of the alerts.
+const (
+	alertCountKey      = "alert:count:"      // Invitations, failures, and rate limits, by window
+	alertLastInviteKey = "alert:last_invite" // When the last invitation was sent
+	alertSinceKey      = "alert:since"       // When the alerts started watching
+	alertActiveKey     = "alert:active:"     // Set while a rule fires, by rule
+)
+
+// Kinds of alert rules.
+const (
+	alertFailureRate = "failure_rate" // Percentage of invitations failing on the provider's side
+	alertFailures    = "failures"     // Invitations failing on the provider's side
+	alertRateLimited = "rate_limited" // Invitations the provider rate limited
+	alertNoInvites   = "no_invites"   // No invitation sent for a while
+)
+
+const (
+	// alertMinSamples is the fewest invitations a failure rate is computed
+	// over.
+	alertMinSamples = 10
+	// alertActiveTTL bounds how long a rule that stopped being checked
+	// stays marked as firing.
+	alertActiveTTL = 30 * 24 * time.Hour
+)
+
+// A notifier delivers alerts to the people who keep the deployment running.
+type notifier interface {
+	Notify(ctx context.Context, subject, text string) error
+}
+
+// webhookNotifier posts alerts to a Slack or Discord incoming webhook, with
+// the text in field.
+type webhookNotifier struct {
+	url, field string
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, subject, text string) error {
+	return postWebhook(ctx, n.url, map[string]string{n.field: subject + "\n" + text})
+}
+
+// emailNotifier emails alerts.
+type emailNotifier struct {
+	to []string
+}
+
+func (n emailNotifier) Notify(ctx context.Context, subject, text string) error {
+	var errs []error
+	for _, to := range n.to {
+		errs = append(errs, mailer.send(to, subject, text+"\n"))
+	}
+	return errors.Join(errs...)
+}
+
+// alertRule is one rule of ALERT_RULES.
+type alertRule struct {
+	Spec          string `json:"rule"`
+	kind          string
+	threshold     int // Percentage for failure_rate, else a count
+	window        time.Duration
+	campaignsOnly bool // no_invites only fires while a campaign is open
+}
+
+// alertConfig is the optional alerting on anomalies.
+type alertConfig struct {
+	rules     []alertRule
+	notifiers []notifier
+	interval  time.Duration // How often the rules are checked
+	repeat    time.Duration // How often a rule that keeps firing is sent again
+}
+
+// alerts is nil unless ALERT_RULES is set.
+var alerts *alertConfig
+
+var alertChecks struct {
+	sync.Mutex
+	checked time.Time
+	running bool
+}
+
+// loadAlertConfig reads ALERT_RULES and where alerts go: ALERT_SLACK_WEBHOOK,
+// ALERT_DISCORD_WEBHOOK, and ALERT_EMAILS. The rules are checked every
+// ALERT_CHECK_INTERVAL and by /cron/maintenance, and a rule that keeps
+// firing is sent again every ALERT_REPEAT.
+func loadAlertConfig() {
+	alerts = nil
+	spec := getenv("ALERT_RULES")
+	if spec == "" {
+		return
+	}
+	rules, err := parseAlertRules(spec)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid ALERT_RULES: %v", err)
+	}
+	a := &alertConfig{
+		rules:    rules,
+		interval: envDuration("ALERT_CHECK_INTERVAL", 5*time.Minute),
+		repeat:   envDuration("ALERT_REPEAT", 6*time.Hour),
+	}
+	if u := getenv("ALERT_SLACK_WEBHOOK"); u != "" {
+		a.notifiers = append(a.notifiers, webhookNotifier{u, "text"})
+	}
+	if u := getenv("ALERT_DISCORD_WEBHOOK"); u != "" {
+		a.notifiers = append(a.notifiers, webhookNotifier{u, "content"})
+	}
+	var emails []string
+	for _, email := range strings.Split(getenv("ALERT_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) > 0 {
+		if mailer == nil {
+			log.Fatal("FATAL: SMTP_HOST must be set to email ALERT_EMAILS.")
+		}
+		a.notifiers = append(a.notifiers, emailNotifier{emails})
+	}
+	switch {
+	case len(a.notifiers) == 0:
+		log.Fatal("FATAL: ALERT_RULES needs ALERT_SLACK_WEBHOOK, ALERT_DISCORD_WEBHOOK, or ALERT_EMAILS.")
+	case a.interval <= 0:
+		log.Fatal("FATAL: ALERT_CHECK_INTERVAL must be positive.")
+	case a.repeat <= 0:
+		log.Fatal("FATAL: ALERT_REPEAT must be positive.")
+	}
+	alerts = a
+}
+
+// parseAlertRules parses ALERT_RULES, a ";"-separated list of rules such as
+//
+//	failure_rate > 20% over 1h; rate_limited > 5 over 15m; no_invites for 6h during campaigns
+//
+// failure_rate, failures, and rate_limited count the invitations that failed
+// on the provider's side, over the last one to two windows. no_invites fires
+// when no invitation was sent for the duration, with "during campaigns" only
+// while a campaign of CAMPAIGNS is open.
+func parseAlertRules(spec string) ([]alertRule, error) {
+	var rules []alertRule
+	for _, entry := range strings.Split(spec, ";") {
+		f := strings.Fields(entry)
+		if len(f) == 0 {
+			continue
+		}
+		rule := alertRule{Spec: strings.Join(f, " "), kind: f[0]}
+		var err error
+		switch {
+		case f[0] == alertNoInvites && len(f) >= 3 && f[1] == "for":
+			rule.window, err = time.ParseDuration(f[2])
+			switch rest := strings.Join(f[3:], " "); rest {
+			case "":
+			case "during campaigns":
+				rule.campaignsOnly = true
+			default:
+				return nil, fmt.Errorf("%q: unexpected %q", rule.Spec, rest)
+			}
+		case (f[0] == alertFailureRate || f[0] == alertFailures || f[0] == alertRateLimited) && len(f) == 5 && f[1] == ">" && f[3] == "over":
+			n, percent := strings.CutSuffix(f[2], "%")
+			if percent != (f[0] == alertFailureRate) {
+				return nil, fmt.Errorf("%q: failure_rate takes a percentage, the others a count", rule.Spec)
+			}
+			if rule.threshold, err = strconv.Atoi(n); err != nil || rule.threshold < 0 || percent && rule.threshold > 100 {
+				return nil, fmt.Errorf("%q: invalid threshold %q", rule.Spec, f[2])
+			}
+			rule.window, err = time.ParseDuration(f[4])
+		default:
+			return nil, fmt.Errorf("%q: expected metric > threshold over window, or no_invites for window", rule.Spec)
+		}
+		if err != nil || rule.window < time.Minute {
+			return nil, fmt.Errorf("%q: the window must be a duration of at least 1m", rule.Spec)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// providerFailure reports whether code is an invitation failing on the
+// provider's side. Users who are turned away, such as existing members, are
+// not failures.
+func providerFailure(code string) bool {
+	switch code {
+	case ErrInvitationFailed.Code, ErrInviteRateLimited.Code, ErrUserInfo.Code:
+		return true
+	}
+	return false
+}
+
+// alertBucketKey is the key of counter in the window of d that t falls in.
+func alertBucketKey(counter string, d time.Duration, t time.Time) string {
+	return alertCountKey + counter + ":" + strconv.FormatInt(int64(d.Seconds()), 10) + ":" + strconv.FormatInt(t.Truncate(d).Unix(), 10)
+}
+
+// countAlertEvent counts an invitation outcome in the windows of the rules.
+func countAlertEvent(ctx context.Context, ev busEvent) {
+	now := time.Now()
+	counters := []string{"invites"}
+	if ev.Type == eventInviteSent {
+		if err := dataStore.Set(ctx, alertLastInviteKey, []byte(strconv.FormatInt(now.Unix(), 10)), 0); err != nil {
+			log.Printf("Could not record the invitation for alerts: %v", err)
+		}
+	} else if providerFailure(ev.Entry.Code) {
+		counters = append(counters, "failures")
+		if ev.Entry.Code == ErrInviteRateLimited.Code {
+			counters = append(counters, "rate_limited")
+		}
+	}
+	var windows []time.Duration
+	for _, rule := range alerts.rules {
+		if rule.kind != alertNoInvites && !slices.Contains(windows, rule.window) {
+			windows = append(windows, rule.window)
+		}
+	}
+	for _, d := range windows {
+		for _, counter := range counters {
+			if _, err := dataStore.Incr(ctx, alertBucketKey(counter, d, now), 2*d); err != nil {
+				log.Printf("Could not count the invitation for alerts: %v", err)
+				return
+			}
+		}
+	}
+}
+
+// alertCount returns counter over the current and the previous window of d.
+func alertCount(ctx context.Context, counter string, d time.Duration) (int, error) {
+	now := time.Now()
+	total := 0
+	for _, t := range []time.Time{now, now.Add(-d)} {
+		b, err := dataStore.Get(ctx, alertBucketKey(counter, d, t))
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n, _ := strconv.Atoi(string(b))
+		total += n
+	}
+	return total, nil
+}
+
+// alertState is the outcome of checking a rule.
+type alertState struct {
+	alertRule
+	Firing bool       `json:"firing"`
+	Value  string     `json:"value"` // What the rule measured
+	Since  *time.Time `json:"since,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// evaluate checks rule now.
+func (rule alertRule) evaluate(ctx context.Context) alertState {
+	st := alertState{alertRule: rule}
+	fail := func(err error) alertState {
+		st.Error = err.Error()
+		return st
+	}
+	switch rule.kind {
+	case alertNoInvites:
+		last, err := alertTime(ctx, alertLastInviteKey)
+		if err != nil {
+			return fail(err)
+		}
+		if last.IsZero() {
+			// Nothing was sent since the alerts started watching.
+			dataStore.SetNX(ctx, alertSinceKey, []byte(strconv.FormatInt(time.Now().Unix(), 10)), 0)
+			if last, err = alertTime(ctx, alertSinceKey); err != nil {
+				return fail(err)
+			}
+		}
+		quiet := time.Since(last).Truncate(time.Minute)
+		st.Value = "no invitation for " + quiet.String()
+		st.Firing = quiet >= rule.window && (!rule.campaignsOnly || campaignOpen(currentRules()))
+	case alertFailureRate:
+		invites, err := alertCount(ctx, "invites", rule.window)
+		if err != nil {
+			return fail(err)
+		}
+		failures, err := alertCount(ctx, "failures", rule.window)
+		if err != nil {
+			return fail(err)
+		}
+		rate := 0
+		if invites > 0 {
+			rate = failures * 100 / invites
+		}
+		st.Value = fmt.Sprintf("%d%% of %d invitations failed", rate, invites)
+		st.Firing = invites >= alertMinSamples && rate > rule.threshold
+	default:
+		n, err := alertCount(ctx, rule.kind, rule.window)
+		if err != nil {
+			return fail(err)
+		}
+		st.Value = fmt.Sprintf("%s: %d", strings.ReplaceAll(rule.kind, "_", " "), n)
+		st.Firing = n > rule.threshold
+	}
+	return st
+}
+
+// alertTime reads a Unix time from the store, or the zero time when key is
+// not set.
+func alertTime(ctx context.Context, key string) (time.Time, error) {
+	b, err := dataStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	n, _ := strconv.ParseInt(string(b), 10, 64)
+	return time.Unix(n, 0), nil
+}
+
+// campaignOpen reports whether any campaign of rs, or of its orgs, takes
+// sign-ins.
+func campaignOpen(rs *ruleSet) bool {
+	for _, c := range rs.campaigns {
+		if c.Expires.IsZero() || time.Now().Before(c.Expires) {
+			return true
+		}
+	}
+	for _, o := range rs.orgs {
+		if campaignOpen(o) {
+			return true
+		}
+	}
+	return false
+}
+
+// activeAlert is the record of a rule that is firing.
+type activeAlert struct {
+	Since    time.Time `json:"since"`
+	Notified time.Time `json:"notified"`
+}
+
+// checkAlerts checks every rule and notifies when one starts firing, keeps
+// firing for ALERT_REPEAT, or stops.
+func checkAlerts(ctx context.Context) []alertState {
+	states := make([]alertState, 0, len(alerts.rules))
+	for _, rule := range alerts.rules {
+		st := rule.evaluate(ctx)
+		if st.Error == "" {
+			st.Since = alerts.track(ctx, st)
+		} else {
+			log.Printf("Checking the alert %q failed: %v", rule.Spec, st.Error)
+		}
+		states = append(states, st)
+	}
+	return states
+}
+
+// track records the transitions of st's rule and sends the notifications.
+// It returns since when the rule is firing.
+func (a *alertConfig) track(ctx context.Context, st alertState) *time.Time {
+	key := alertActiveKey + st.Spec
+	now := time.Now().UTC()
+	var active *activeAlert
+	if b, err := dataStore.Get(ctx, key); err == nil {
+		active = &activeAlert{}
+		json.Unmarshal(b, active)
+	}
+	switch {
+	case st.Firing && active == nil:
+		b, _ := json.Marshal(activeAlert{Since: now, Notified: now})
+		// Of several instances noticing at once, one notifies.
+		if ok, err := dataStore.SetNX(ctx, key, b, alertActiveTTL); err != nil || !ok {
+			return nil
+		}
+		a.notify(ctx, "Alert: "+st.Spec, st.Value)
+		return &now
+	case st.Firing && now.Sub(active.Notified) >= a.repeat:
+		active.Notified = now
+		b, _ := json.Marshal(active)
+		dataStore.Set(ctx, key, b, alertActiveTTL)
+		a.notify(ctx, "Still alerting: "+st.Spec, fmt.Sprintf("%s, since %s", st.Value, active.Since.Format(time.RFC3339)))
+	case !st.Firing && active != nil:
+		if err := dataStore.Delete(ctx, key); err == nil {
+			a.notify(ctx, "Resolved: "+st.Spec, st.Value)
+		}
+		return nil
+	}
+	if active == nil {
+		return nil
+	}
+	return &active.Since
+}
+
+// notify sends an alert to every notifier. Failures are only logged.
+func (a *alertConfig) notify(ctx context.Context, subject, text string) {
+	log.Printf("WARNING: %s: %s", subject, text)
+	for _, n := range a.notifiers {
+		if err := n.Notify(ctx, subject, text); err != nil {
+			log.Printf("Sending the alert %q failed: %v", subject, err)
+		}
+	}
+}
+
+// maybeCheckAlerts starts a check of the alert rules once the last one is
+// ALERT_CHECK_INTERVAL old. Requests do not wait for it.
+func maybeCheckAlerts() {
+	if alerts == nil {
+		return
+	}
+	alertChecks.Lock()
+	defer alertChecks.Unlock()
+	if alertChecks.running || time.Since(alertChecks.checked) < alerts.interval {
+		return
+	}
+	alertChecks.checked, alertChecks.running = time.Now(), true
+	go func() {
+		checkAlerts(context.Background())
+		alertChecks.Lock()
+		alertChecks.running = false
+		alertChecks.Unlock()
+	}()
+}
+
+// handleAlerts checks the alert rules and shows whether each is firing.
+func handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if alerts == nil {
+		writeJSON(w, http.StatusOK, map[string]any{"alerts": []alertState{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"alerts": checkAlerts(r.Context())})
+}
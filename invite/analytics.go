@@ -0,0 +1,237 @@
+package invite
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Analytics services that funnel events can be sent to.
+const (
+	analyticsPostHog = "posthog"
+	analyticsGA4     = "ga4"
+)
+
+// Funnel events, as the analytics service sees them.
+const (
+	funnelLoginStarted   = "login_started"
+	funnelOAuthCompleted = "oauth_completed"
+	funnelGateFailed     = "gate_failed"
+	funnelInviteFailed   = "invite_failed"
+	funnelInvited        = "invited"
+	funnelAccepted       = "accepted"
+)
+
+// gateCodes are the error codes of sign-ins the gates turned away, which
+// the funnel tells apart from invitations that failed.
+var gateCodes = map[string]bool{
+	ErrAccountSuspended.Code: true, ErrRequirementsNotMet.Code: true, ErrSuspectedSpam.Code: true,
+	ErrRejected.Code: true, ErrPendingReview.Code: true, ErrBotAccount.Code: true,
+}
+
+// analyticsConfig is the optional analytics sink. Funnel events are sent
+// with IDs hashed with a salt, so the service can follow a user through
+// the funnel without learning who they are.
+type analyticsConfig struct {
+	sink     string
+	endpoint string
+	key      string // PostHog project API key, or GA4 API secret
+	streamID string // GA4 measurement ID
+	salt     []byte
+	events   map[string]bool // Funnel events to send
+	timeout  time.Duration
+}
+
+// analytics is nil when no sink is configured.
+var analytics *analyticsConfig
+
+// loadAnalyticsConfig reads the analytics sink settings. ANALYTICS_SINK
+// picks posthog, with POSTHOG_API_KEY and optionally POSTHOG_HOST, or ga4,
+// with GA4_MEASUREMENT_ID and GA4_API_SECRET. ANALYTICS_EVENTS limits the
+// funnel events sent, and ANALYTICS_SALT, by default SIGNING_KEY, salts
+// the IDs.
+func loadAnalyticsConfig() {
+	analytics = nil
+	sink := getenv("ANALYTICS_SINK")
+	if sink == "" {
+		return
+	}
+	a := &analyticsConfig{
+		sink:    sink,
+		salt:    []byte(getenv("ANALYTICS_SALT")),
+		timeout: envDuration("ANALYTICS_TIMEOUT", 3*time.Second),
+		events:  make(map[string]bool),
+	}
+	switch sink {
+	case analyticsPostHog:
+		a.key = getenv("POSTHOG_API_KEY")
+		if a.key == "" {
+			log.Fatal("FATAL: POSTHOG_API_KEY must be set when ANALYTICS_SINK is posthog.")
+		}
+		host := strings.TrimSuffix(getenv("POSTHOG_HOST"), "/")
+		if host == "" {
+			host = "https://us.i.posthog.com"
+		}
+		a.endpoint = host + "/capture/"
+	case analyticsGA4:
+		a.streamID, a.key = getenv("GA4_MEASUREMENT_ID"), getenv("GA4_API_SECRET")
+		if a.streamID == "" || a.key == "" {
+			log.Fatal("FATAL: GA4_MEASUREMENT_ID and GA4_API_SECRET must be set when ANALYTICS_SINK is ga4.")
+		}
+		a.endpoint = "https://www.google-analytics.com/mp/collect?" + url.Values{"measurement_id": {a.streamID}, "api_secret": {a.key}}.Encode()
+	default:
+		log.Fatalf("FATAL: ANALYTICS_SINK must be posthog or ga4, not %q.", sink)
+	}
+	if len(a.salt) == 0 {
+		if len(signingKey) == 0 {
+			log.Fatal("FATAL: ANALYTICS_SALT or SIGNING_KEY or SIGNING_KMS_KEY must be set when ANALYTICS_SINK is set.")
+		}
+		a.salt = signingKey
+	}
+	all := []string{funnelLoginStarted, funnelOAuthCompleted, funnelGateFailed, funnelInviteFailed, funnelInvited, funnelAccepted}
+	names := all
+	if list := getenv("ANALYTICS_EVENTS"); list != "" {
+		names = nil
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if !slices.Contains(all, name) {
+				log.Fatalf("FATAL: ANALYTICS_EVENTS entries must be among %s, not %q.", strings.Join(all, ", "), name)
+			}
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		a.events[name] = true
+	}
+	analytics = a
+}
+
+// anonymousID hashes id with the salt. The result stays the same across
+// events and deployments sharing the salt, but cannot be traced back.
+func (a *analyticsConfig) anonymousID(id string) string {
+	if id == "" {
+		return ""
+	}
+	h := hmac.New(sha256.New, a.salt)
+	h.Write([]byte(id))
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// funnelEvent is an event of the bus as a step of the funnel.
+type funnelEvent struct {
+	Name       string
+	Visitor    string // Anonymous ID of the browser, when known
+	User       string // Anonymous ID of the user, once signed in
+	Properties map[string]any
+}
+
+// sendFunnelEvent sends the funnel step of a bus event to the sink.
+func sendFunnelEvent(ctx context.Context, ev busEvent) {
+	name := ""
+	switch ev.Type {
+	case eventLoginStarted:
+		name = funnelLoginStarted
+	case eventOAuthCompleted:
+		name = funnelOAuthCompleted
+	case eventInviteFailed:
+		name = funnelInviteFailed
+		if gateCodes[ev.Entry.Code] {
+			name = funnelGateFailed
+		}
+	case eventInviteSent:
+		name = funnelInvited
+	case eventMemberJoined:
+		name = funnelAccepted
+	}
+	if !analytics.events[name] {
+		return
+	}
+	fe := funnelEvent{Name: name, Visitor: analytics.anonymousID(ev.Visitor), Properties: funnelProperties(ev.Entry)}
+	if ev.Entry.UserID != "" {
+		fe.User = analytics.anonymousID(ev.Entry.Provider + ":" + ev.Entry.UserID)
+	}
+	if err := analytics.send(ctx, fe); err != nil {
+		log.Printf("Sending %s to %s failed: %v", name, analytics.sink, err)
+	}
+}
+
+// funnelProperties are the properties of a funnel event: what the invite
+// log records about it, without the user's name.
+func funnelProperties(e activityEvent) map[string]any {
+	props := map[string]any{"org": orgOrDefault(e.Org)}
+	for name, v := range map[string]string{"provider": e.Provider, "campaign": e.Campaign, "error_code": e.Code, "referrer": e.Referrer} {
+		if v != "" {
+			props[name] = v
+		}
+	}
+	for name, v := range e.UTM {
+		props[name] = v
+	}
+	for name, v := range e.Variants {
+		props["experiment_"+name] = v
+	}
+	return props
+}
+
+// send posts fe to the sink.
+func (a *analyticsConfig) send(ctx context.Context, fe funnelEvent) error {
+	var body any
+	switch a.sink {
+	case analyticsPostHog:
+		distinctID := fe.User
+		if distinctID == "" {
+			distinctID = fe.Visitor
+		}
+		if distinctID == "" {
+			return nil
+		}
+		props := fe.Properties
+		if fe.User != "" && fe.Visitor != "" {
+			// Joins the steps taken before signing in to the user's.
+			props["$anon_distinct_id"] = fe.Visitor
+		}
+		body = map[string]any{"api_key": a.key, "event": fe.Name, "distinct_id": distinctID, "properties": props, "timestamp": time.Now().UTC()}
+	case analyticsGA4:
+		clientID := fe.Visitor
+		if clientID == "" {
+			clientID = fe.User
+		}
+		if clientID == "" {
+			return nil
+		}
+		ga := map[string]any{"client_id": clientID, "events": []any{map[string]any{"name": fe.Name, "params": fe.Properties}}}
+		if fe.User != "" {
+			ga["user_id"] = fe.User
+		}
+		body = ga
+	}
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return redactError(err, a.key)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", a.sink, resp.Status)
+	}
+	return nil
+}
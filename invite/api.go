@@ -0,0 +1,378 @@
+package invite
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys used by the API.
+const (
+	idempotencyKey    = "idem:"
+	apiInviteKey      = "api:invite:" // Invitations created through the API, by ID
+	apiInviteIndexKey = "api:invites" // Their IDs, oldest first
+	apiInvitePruneKey = "api:invites:pruning"
+)
+
+// Bounds of GET /api/v1/invites.
+const (
+	defaultInviteListLimit = 50
+	maxInviteListLimit     = 200
+)
+
+// maxIdempotencyKeyLen bounds the Idempotency-Key header.
+const maxIdempotencyKeyLen = 255
+
+// idempotencyLease is how long the claim of a request in flight holds its
+// Idempotency-Key. The key is kept for idempotencyTTL once the response is
+// saved; a request that dies before then frees it for a retry.
+const idempotencyLease = time.Minute
+
+// maxPrunedInvites bounds the expired invitations pruneAPIInvites drops from
+// the index per run.
+const maxPrunedInvites = 1000
+
+// handleAPI routes the server-to-server /api/v1/ API. It requires API_TOKEN
+// as a bearer token and is disabled when it is not set.
+func handleAPI(w http.ResponseWriter, r *http.Request) {
+	if apiToken == "" {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeJSONError(w, ErrUnauthorized)
+		return
+	}
+
+	switch path := r.URL.Path; {
+	case path == "/api/v1/invites":
+		switch r.Method {
+		case http.MethodPost:
+			handleCreateInvite(w, r)
+		case http.MethodGet:
+			handleListInvites(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case strings.HasPrefix(path, "/api/v1/invites/"):
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		handleGetInvite(w, r, strings.TrimPrefix(path, "/api/v1/invites/"))
+	default:
+		writeJSONError(w, ErrNotFound)
+	}
+}
+
+// createInviteRequest is the body of POST /api/v1/invites. Exactly one of
+// Username and Email must be set.
+type createInviteRequest struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Role     string   `json:"role"`
+	Teams    []string `json:"teams"`
+	Campaign string   `json:"campaign"`
+}
+
+// inviteResponse describes an invitation created through the API.
+type inviteResponse struct {
+	ID        string    `json:"id"`
+	Org       string    `json:"org,omitempty"`
+	Username  string    `json:"username,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Role      string    `json:"role,omitempty"`
+	Teams     []string  `json:"teams,omitempty"`
+	Status    string    `json:"status"`
+	AcceptURL string    `json:"accept_url,omitempty"` // Where the user accepts the invitation, for invitations by username
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// storedResponse is a response saved under an idempotency key.
+type storedResponse struct {
+	Fingerprint string          `json:"fingerprint"`
+	Status      int             `json:"status,omitempty"` // 0 while the request is in flight
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// handleCreateInvite invites a user. Clients may send an Idempotency-Key
+// header; a retried request with the same key gets the original response
+// back instead of sending (and logging) the invitation again.
+func handleCreateInvite(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, ErrBadRequest.Wrap(err))
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" {
+		status, resp := createInvite(r.Context(), body)
+		writeJSON(w, status, resp)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLen {
+		writeJSONError(w, ErrBadRequest.WithMessage("Idempotency-Key is too long."))
+		return
+	}
+
+	ctx := r.Context()
+	sum := sha256.Sum256(body)
+	fingerprint := hex.EncodeToString(sum[:])
+	storeKey := idempotencyKey + key
+
+	pending, _ := json.Marshal(storedResponse{Fingerprint: fingerprint})
+	claimed, err := dataStore.SetNX(ctx, storeKey, pending, idempotencyLease)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	if !claimed {
+		replayResponse(w, r, storeKey, fingerprint)
+		return
+	}
+
+	status, resp := createInvite(ctx, body)
+	b, _ := json.Marshal(resp)
+	// Transient failures are not remembered, so the client can retry them
+	// with the same key.
+	if status >= 500 || status == http.StatusTooManyRequests {
+		dataStore.Delete(ctx, storeKey)
+	} else {
+		saved, _ := json.Marshal(storedResponse{Fingerprint: fingerprint, Status: status, Body: b})
+		if err := dataStore.Set(ctx, storeKey, saved, idempotencyTTL); err != nil {
+			log.Printf("Failed to save idempotent response for key %q: %v", key, err)
+		}
+	}
+	writeJSON(w, status, json.RawMessage(b))
+}
+
+// replayResponse answers a request whose idempotency key was already used.
+func replayResponse(w http.ResponseWriter, r *http.Request, storeKey, fingerprint string) {
+	b, err := dataStore.Get(r.Context(), storeKey)
+	if errors.Is(err, store.ErrNotFound) {
+		// Expired between the claim and now; ask the client to retry.
+		writeJSONError(w, ErrConflict.WithMessage("Please retry the request."))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	var saved storedResponse
+	if err := json.Unmarshal(b, &saved); err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	if saved.Fingerprint != fingerprint {
+		e := ErrBadRequest.WithMessage("Idempotency-Key was already used with a different request body.")
+		e.Status = http.StatusUnprocessableEntity
+		writeJSONError(w, e)
+		return
+	}
+	if saved.Status == 0 {
+		writeJSONError(w, ErrConflict.WithMessage("A request with this Idempotency-Key is still in progress."))
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeJSON(w, saved.Status, saved.Body)
+}
+
+// createInvite sends the invitation described by body and returns the
+// response status and payload. Invitations granting one of the
+// DUAL_CONTROL_ROLES wait for an admin's approval instead; those by email
+// cannot, as approvals invite by username, so they are refused.
+func createInvite(ctx context.Context, body []byte) (int, any) {
+	var req createInviteRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return errorResponse(ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+	}
+	if (req.Username == "") == (req.Email == "") {
+		return errorResponse(ErrBadRequest.WithMessage("Exactly one of username and email is required."))
+	}
+	if !validRole(req.Role) {
+		return errorResponse(ErrBadRequest.WithMessage("role must be \"member\" or \"admin\"."))
+	}
+
+	opts := inviteOptions{Role: req.Role, Teams: req.Teams, Campaign: req.Campaign}
+	target := req.Username + req.Email
+	if needsDualControl(opts) {
+		if req.Email != "" {
+			return errorResponse(ErrForbidden.WithMessage("Invitations as " + req.Role + " need an admin's approval, so they must be by username."))
+		}
+		id, err := requestApproval(ctx, &Identity{Username: req.Username}, opts, reasonDualControl, "")
+		if err != nil {
+			log.Printf("Failed to queue the API invite for %s for approval: %v", target, err)
+			return errorResponse(ErrInvitationFailed.Wrap(err))
+		}
+		log.Printf("Queued the API invite for %s for approval (%s)", target, reasonDualControl)
+		return http.StatusAccepted, inviteResponse{
+			ID:        id,
+			Org:       orgOrDefault(""),
+			Username:  req.Username,
+			Role:      req.Role,
+			Teams:     req.Teams,
+			Status:    inviteStatusPendingApproval,
+			CreatedAt: time.Now().UTC(),
+		}
+	}
+	publish(ctx, busEvent{Type: eventInviteRequested, Entry: activityEvent{Username: target, Campaign: req.Campaign}})
+	var err error
+	if req.Email != "" {
+		err = inviteEmail(ctx, req.Email, opts)
+	} else {
+		err = activeProvider.Invite(ctx, &Identity{Username: req.Username}, opts)
+	}
+	if err != nil {
+		log.Printf("API invite for %s failed: %v", target, err)
+		publish(ctx, busEvent{Type: eventInviteFailed, Entry: activityEvent{Username: target, Code: asError(err, ErrInvitationFailed).Code, Campaign: req.Campaign}})
+		return errorResponse(asError(err, ErrInvitationFailed))
+	}
+
+	log.Printf("API invited %s", target)
+	publish(ctx, busEvent{Type: eventInviteSent, Entry: activityEvent{Username: target, Campaign: req.Campaign}})
+	resp := inviteResponse{
+		ID:        randomID(8),
+		Org:       orgOrDefault(""),
+		Username:  req.Username,
+		Email:     req.Email,
+		Role:      req.Role,
+		Teams:     req.Teams,
+		Status:    inviteStatusInvited,
+		CreatedAt: time.Now().UTC(),
+	}
+	// Invitations by email are accepted from the link of GitHub's email,
+	// which the API does not return.
+	if req.Username != "" {
+		resp.AcceptURL = invitationURL(resp.Org)
+	}
+	saveAPIInvite(ctx, resp)
+	return http.StatusCreated, resp
+}
+
+// Statuses of an invitation created through the API.
+const (
+	inviteStatusInvited = "invited" // Sent, not yet accepted
+	inviteStatusJoined  = "joined"  // Accepted; only known for invitations by username
+
+	// Waiting for an admin's approval: the ID is that of the approval, under
+	// /admin/approvals/.
+	inviteStatusPendingApproval = "pending_approval"
+)
+
+// saveAPIInvite records an invitation for the status and list endpoints.
+// It is kept as long as pending members are tracked.
+func saveAPIInvite(ctx context.Context, resp inviteResponse) {
+	b, _ := json.Marshal(resp)
+	err := dataStore.Set(ctx, apiInviteKey+resp.ID, b, pendingMemberTTL)
+	if err == nil {
+		_, err = dataStore.Append(ctx, apiInviteIndexKey, []byte(resp.ID))
+	}
+	if err != nil {
+		log.Printf("Failed to record API invitation %s: %v", resp.ID, err)
+	}
+}
+
+// pruneAPIInvites drops the IDs of expired invitations from the head of the
+// index, where they are, as every invitation is kept as long, and returns
+// how many it dropped. Runs are serialized, so that only one pops while the
+// API appends.
+func pruneAPIInvites(ctx context.Context) (int, error) {
+	ok, err := dataStore.SetNX(ctx, apiInvitePruneKey, nil, time.Minute)
+	if err != nil || !ok {
+		return 0, err
+	}
+	defer dataStore.Delete(ctx, apiInvitePruneKey)
+	pruned := 0
+	for pruned < maxPrunedInvites {
+		head, err := dataStore.Range(ctx, apiInviteIndexKey, 0, 0)
+		if err != nil || len(head) == 0 {
+			return pruned, err
+		}
+		if _, err := dataStore.Get(ctx, apiInviteKey+string(head[0])); !errors.Is(err, store.ErrNotFound) {
+			return pruned, err
+		}
+		if _, err := dataStore.Pop(ctx, apiInviteIndexKey); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// loadAPIInvite returns the invitation with id, its status brought up to
+// date with whether the user has joined.
+func loadAPIInvite(ctx context.Context, id string) (inviteResponse, error) {
+	var resp inviteResponse
+	b, err := dataStore.Get(ctx, apiInviteKey+id)
+	if err != nil {
+		return resp, err
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return resp, err
+	}
+	if resp.Username != "" && resp.Status == inviteStatusInvited {
+		if _, err := dataStore.Get(ctx, joinedMemberKey+orgOrDefault(resp.Org)+":"+resp.Username); err == nil {
+			resp.Status, resp.AcceptURL = inviteStatusJoined, ""
+		}
+	}
+	return resp, nil
+}
+
+// handleGetInvite returns one invitation created through the API.
+func handleGetInvite(w http.ResponseWriter, r *http.Request, id string) {
+	resp, err := loadAPIInvite(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleListInvites returns the most recent invitations created through the
+// API, newest first, up to ?limit.
+func handleListInvites(w http.ResponseWriter, r *http.Request) {
+	limit := defaultInviteListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxInviteListLimit {
+			writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("limit must be between 1 and %d.", maxInviteListLimit)))
+			return
+		}
+		limit = n
+	}
+	ctx := r.Context()
+	ids, err := dataStore.Range(ctx, apiInviteIndexKey, -int64(limit), -1)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	invites := []inviteResponse{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		resp, err := loadAPIInvite(ctx, string(ids[i]))
+		if err != nil {
+			// Expired entries are skipped.
+			continue
+		}
+		invites = append(invites, resp)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"invites": invites})
+}
@@ -0,0 +1,406 @@
+package invite
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+	"auto-invite/webhookverify"
+)
+
+// Store keys used by the approval queue.
+const (
+	approvalKey      = "approval:"
+	approvalIndexKey = "approvals"
+)
+
+// approvalLinkTTL is how long the approve/deny links in notifications work.
+const approvalLinkTTL = 7 * 24 * time.Hour
+
+// Approval queue modes, set with APPROVAL_QUEUE.
+const (
+	approvalAll    = "all"    // Every invitation needs an admin's approval
+	approvalReview = "review" // Only accounts the reputation service flags
+)
+
+// Approval decisions.
+const (
+	decisionApprove = "approve"
+	decisionDeny    = "deny"
+)
+
+// approval is a pending (or decided) invitation waiting for an admin.
+type approval struct {
+	ID        string        `json:"id"`
+	Provider  string        `json:"provider"`
+	UserID    string        `json:"user_id"`
+	Username  string        `json:"username"`
+	Email     string        `json:"email,omitempty"`
+	Options   inviteOptions `json:"options"`
+	Reason    string        `json:"reason"`
+	Status    string        `json:"status"` // pending, approved, denied, failed or expired
+	CreatedAt time.Time     `json:"created_at"`
+	DecidedAt *time.Time    `json:"decided_at,omitempty"`
+
+	// DualControl is set when the invitation grants one of the
+	// DUAL_CONTROL_ROLES, so that it needs the approval of an admin other
+	// than MintedBy, who minted the link it came from.
+	DualControl bool   `json:"dual_control,omitempty"`
+	MintedBy    string `json:"minted_by,omitempty"`
+	DecidedBy   string `json:"decided_by,omitempty"`
+}
+
+// decisionClaims is the payload of a signed approve/deny link.
+type decisionClaims struct {
+	ApprovalID string `json:"aid"`
+	Decision   string `json:"d"`
+	Expires    int64  `json:"exp"`
+}
+
+// approvalConfig is the optional approval queue and where its notifications
+// go. Any combination of Slack, Discord and email can be notified.
+type approvalConfig struct {
+	mode           string
+	slackWebhook   string
+	discordWebhook string
+	emails         []string
+}
+
+// approvals is nil when the approval queue is disabled.
+var approvals *approvalConfig
+
+// loadApprovalConfig reads the approval queue settings. The queue is enabled
+// by setting APPROVAL_QUEUE to "all" or "review", or DUAL_CONTROL_ROLES or
+// ABUSE_REPORT_SECRET, which queue only the invitations granting those roles
+// and those of reported users, however the others are decided.
+func loadApprovalConfig() {
+	mode := getenv("APPROVAL_QUEUE")
+	roles, err := parseDualControlRoles(getenv("DUAL_CONTROL_ROLES"))
+	if err != nil {
+		log.Fatalf("FATAL: Invalid DUAL_CONTROL_ROLES: %v.", err)
+	}
+	dualControlRoles = roles
+	if mode == "" && len(roles) == 0 && len(abuseReportSecret) == 0 {
+		return
+	}
+	if mode != "" && mode != approvalAll && mode != approvalReview {
+		log.Fatal("FATAL: APPROVAL_QUEUE must be \"all\" or \"review\".")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when APPROVAL_QUEUE, DUAL_CONTROL_ROLES, or ABUSE_REPORT_SECRET is set.")
+	}
+	a := &approvalConfig{
+		mode:           mode,
+		slackWebhook:   getenv("APPROVAL_SLACK_WEBHOOK"),
+		discordWebhook: getenv("APPROVAL_DISCORD_WEBHOOK"),
+	}
+	for _, email := range strings.Split(getenv("APPROVAL_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			a.emails = append(a.emails, email)
+		}
+	}
+	if len(a.emails) > 0 && mailer == nil {
+		log.Fatal("FATAL: SMTP_HOST must be set to email APPROVAL_EMAILS.")
+	}
+	// The links of notifications carry the tokens that decide, so they must
+	// not point wherever the Host header of the requester says.
+	if (a.slackWebhook != "" || a.discordWebhook != "" || len(a.emails) > 0) && publicURL == "" {
+		log.Fatal("FATAL: PUBLIC_URL must be set when APPROVAL_SLACK_WEBHOOK, APPROVAL_DISCORD_WEBHOOK, or APPROVAL_EMAILS is set.")
+	}
+	approvals = a
+}
+
+// requestApproval queues user's invitation for review, notifies the
+// admins, and returns the ID of the approval. mintedBy is the admin who
+// minted the invite link, if any.
+func requestApproval(ctx context.Context, user *Identity, opts inviteOptions, reason, mintedBy string) (string, error) {
+	a := &approval{
+		ID:          randomID(8),
+		Provider:    activeProvider.Name(),
+		UserID:      user.ID,
+		Username:    user.Username,
+		Email:       user.Email,
+		Options:     opts,
+		Reason:      reason,
+		Status:      "pending",
+		CreatedAt:   time.Now().UTC(),
+		DualControl: needsDualControl(opts),
+		MintedBy:    mintedBy,
+	}
+	if err := saveApproval(ctx, a); err != nil {
+		return "", err
+	}
+	if _, err := dataStore.Append(ctx, approvalIndexKey, []byte(a.ID)); err != nil {
+		return "", err
+	}
+	// Invitations under dual control are approved from the admin API,
+	// which knows who approves.
+	approveURL := ""
+	if !a.DualControl {
+		var err error
+		if approveURL, err = decisionURL(ctx, a.ID, decisionApprove); err != nil {
+			return "", err
+		}
+	}
+	denyURL, err := decisionURL(ctx, a.ID, decisionDeny)
+	if err != nil {
+		return "", err
+	}
+	approvals.notify(ctx, a, approveURL, denyURL)
+	return a.ID, nil
+}
+
+// decisionURL returns a signed one-click link deciding an approval, under
+// PUBLIC_URL and never the request's own host.
+func decisionURL(ctx context.Context, id, decision string) (string, error) {
+	payload, _ := json.Marshal(decisionClaims{ApprovalID: id, Decision: decision, Expires: time.Now().Add(approvalLinkTTL).Unix()})
+	token, err := signToken(ctx, tokenDecision, payload)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(publicURL, "/") + "/admin/decide?token=" + url.QueryEscape(token), nil
+}
+
+// notify tells the admins about a new approval. Failures are only logged.
+func (c *approvalConfig) notify(ctx context.Context, a *approval, approveURL, denyURL string) {
+	text := fmt.Sprintf("%s requested an invitation to %s (%s).\nApprove: %s\nDeny: %s", a.Username, orgOrDefault(a.Options.Org), a.Reason, approveURL, denyURL)
+	if a.DualControl {
+		text = fmt.Sprintf("%s requested an invitation to %s as %s, which a second admin has to approve with POST /admin/approvals/%s.\nDeny: %s", a.Username, orgOrDefault(a.Options.Org), cmp.Or(a.Options.OrgRole, a.Options.Role), a.ID, denyURL)
+	}
+	if c.slackWebhook != "" {
+		if err := postWebhook(ctx, c.slackWebhook, map[string]string{"text": text}); err != nil {
+			log.Printf("Slack approval notification failed: %v", err)
+		}
+	}
+	if c.discordWebhook != "" {
+		if err := postWebhook(ctx, c.discordWebhook, map[string]string{"content": text}); err != nil {
+			log.Printf("Discord approval notification failed: %v", err)
+		}
+	}
+	for _, to := range c.emails {
+		if err := mailer.send(to, "Invitation request from "+a.Username, text+"\n"); err != nil {
+			log.Printf("Emailing the approval request to %s failed: %v", to, err)
+		}
+	}
+}
+
+// webhookSecret signs outbound webhooks when set, from
+// WEBHOOK_SIGNING_SECRET.
+var webhookSecret []byte
+
+// signWebhook adds the timestamp, delivery ID, and signature headers that
+// webhookverify checks. Webhooks are sent unsigned without a secret.
+func signWebhook(h http.Header, body []byte) {
+	if len(webhookSecret) == 0 {
+		return
+	}
+	now, delivery := time.Now(), randomID(16)
+	h.Set(webhookverify.TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	h.Set(webhookverify.DeliveryHeader, delivery)
+	h.Set(webhookverify.SignatureHeader, webhookverify.Sign(webhookSecret, now, delivery, body))
+}
+
+// postWebhook POSTs body as JSON to an incoming webhook URL, signed when
+// WEBHOOK_SIGNING_SECRET is set.
+func postWebhook(ctx context.Context, webhookURL string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signWebhook(req.Header, b)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+func saveApproval(ctx context.Context, a *approval) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return dataStore.Set(ctx, approvalKey+a.ID, b, 0)
+}
+
+func loadApproval(ctx context.Context, id string) (*approval, error) {
+	b, err := dataStore.Get(ctx, approvalKey+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound.WithMessage("No such approval request.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var a approval
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// decide applies the decision of the admin named actor, sending the
+// invitation if approved. actor is empty for the one-click links, which name
+// no admin. Each request can only be decided once.
+func decide(ctx context.Context, id, decision, actor string) (*approval, error) {
+	a, err := loadApproval(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.DualControl && decision == decisionApprove {
+		if err := checkSecondAdmin(a, actor); err != nil {
+			return a, err
+		}
+	}
+	if ok, err := dataStore.SetNX(ctx, approvalKey+id+":decided", []byte(decision), 0); err != nil {
+		return nil, err
+	} else if !ok {
+		return a, ErrConflict.WithMessage("This request has already been decided.")
+	}
+
+	now := time.Now().UTC()
+	a.DecidedAt, a.DecidedBy = &now, actor
+	user := &Identity{ID: a.UserID, Username: a.Username, Email: a.Email}
+	entry := activityEvent{Provider: a.Provider, UserID: a.UserID, Username: a.Username, Campaign: a.Options.Campaign, Org: a.Options.Org}
+	if decision == decisionDeny {
+		a.Status = "denied"
+		entry.Code = ErrRejected.Code
+		publish(ctx, busEvent{Type: eventInviteFailed, User: user, Entry: entry})
+	} else {
+		publish(ctx, busEvent{Type: eventInviteRequested, User: user, Entry: entry})
+		if err := activeProvider.Invite(ctx, user, a.Options); err != nil {
+			log.Printf("Error inviting approved user %s: %v", a.Username, err)
+			a.Status = "failed"
+			entry.Code = asError(err, ErrInvitationFailed).Code
+			publish(ctx, busEvent{Type: eventInviteFailed, User: user, Entry: entry})
+		} else {
+			a.Status = "approved"
+			publish(ctx, busEvent{Type: eventInviteSent, User: user, Entry: entry})
+		}
+	}
+	log.Printf("Approval %s for %s: %s", a.ID, a.Username, a.Status)
+	return a, saveApproval(ctx, a)
+}
+
+// handleDecide handles the one-click links from approval notifications. The
+// signed token is the credential, so no admin token is needed. GET shows a
+// confirmation button, so that link scanners in mail and chat clients
+// cannot decide by fetching the link; POST applies the decision.
+func handleDecide(w http.ResponseWriter, r *http.Request) {
+	if approvals == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	token := r.FormValue("token")
+	payload, err := verifyToken(tokenDecision, token)
+	var claims decisionClaims
+	if err == nil {
+		err = json.Unmarshal(payload, &claims)
+	}
+	if err != nil || (claims.Decision != decisionApprove && claims.Decision != decisionDeny) {
+		writeJSONError(w, ErrInvalidLink)
+		return
+	}
+	if time.Now().Unix() >= claims.Expires {
+		writeJSONError(w, ErrLinkExpired)
+		return
+	}
+
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		a, err := loadApproval(ctx, claims.ApprovalID)
+		if err != nil {
+			writeJSONError(w, asError(err, ErrConfig))
+			return
+		}
+		renderPage(w, http.StatusOK, decideTemplate(), struct {
+			Approval *approval
+			Decision string
+			Token    string
+		}{a, claims.Decision, token})
+	case http.MethodPost:
+		a, err := decide(ctx, claims.ApprovalID, claims.Decision, "")
+		if err != nil && !errors.Is(err, ErrConflict) {
+			writeJSONError(w, asError(err, ErrConfig))
+			return
+		}
+		if err == nil {
+			recordAudit(ctx, r, "approval link", auditApprovalDecided, a.auditParams(claims.Decision))
+		}
+		renderPage(w, http.StatusOK, decidedTemplate(), a)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// auditParams describes a decision on a for the audit log, with the admin
+// who minted the link of an invitation under dual control.
+func (a *approval) auditParams(decision string) map[string]any {
+	params := map[string]any{"approval_id": a.ID, "username": a.Username, "decision": decision, "status": a.Status}
+	if a.DualControl {
+		params["dual_control"] = true
+		params["role"] = cmp.Or(a.Options.OrgRole, a.Options.Role)
+		params["minted_by"] = a.MintedBy
+	}
+	return params
+}
+
+// handleListApprovals lists the approval requests, newest first.
+func handleListApprovals(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ids, err := dataStore.Range(ctx, approvalIndexKey, 0, -1)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	list := []*approval{}
+	for i := len(ids) - 1; i >= 0; i-- {
+		if a, err := loadApproval(ctx, string(ids[i])); err == nil {
+			list = append(list, a)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"approvals": list})
+}
+
+// handleDecideApproval decides an approval request from the admin API. The
+// body is {"decision": "approve"} or {"decision": "deny"}.
+func handleDecideApproval(w http.ResponseWriter, r *http.Request) {
+	if approvals == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	var req struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Decision != decisionApprove && req.Decision != decisionDeny) {
+		writeJSONError(w, ErrBadRequest.WithMessage("decision must be \"approve\" or \"deny\"."))
+		return
+	}
+	ctx := r.Context()
+	a, err := decide(ctx, strings.TrimPrefix(r.URL.Path, "/admin/approvals/"), req.Decision, adminActor(r))
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	recordAudit(ctx, r, adminActor(r), auditApprovalDecided, a.auditParams(req.Decision))
+	writeJSON(w, http.StatusOK, a)
+}
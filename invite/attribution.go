@@ -0,0 +1,92 @@
+package invite
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// maxUTMParams bounds how many utm_* parameters a sign-in records.
+	maxUTMParams = 10
+	// maxAttributionValue bounds the length of each recorded value.
+	maxAttributionValue = 200
+)
+
+var utmParamName = regexp.MustCompile(`^utm_[a-z0-9_]{1,32}$`)
+
+// attribution is where a sign-in came from: the utm_* parameters of the
+// link the user followed and the page it was on.
+type attribution struct {
+	UTM      map[string]string `json:"utm,omitempty"`
+	Referrer string            `json:"referrer,omitempty"`
+}
+
+// captureAttribution reads the attribution of the sign-in r starts. The
+// referrer is the page that linked to /login, without its query. Our own
+// pages pass on the referrer they had as ref instead. It returns nil when there is nothing to record.
+func captureAttribution(r *http.Request) *attribution {
+	a := &attribution{}
+	for name, values := range r.Form {
+		if !utmParamName.MatchString(name) || len(values) == 0 || values[0] == "" {
+			continue
+		}
+		if len(a.UTM) == maxUTMParams {
+			break
+		}
+		if a.UTM == nil {
+			a.UTM = make(map[string]string)
+		}
+		a.UTM[name] = truncate(values[0], maxAttributionValue)
+	}
+	if a.Referrer = cleanReferrer(r.Header.Get("Referer")); a.Referrer == "" || sameOrigin(r) {
+		a.Referrer = cleanReferrer(r.FormValue("ref"))
+	}
+	if a.UTM == nil && a.Referrer == "" {
+		return nil
+	}
+	return a
+}
+
+// cleanReferrer drops the query and fragment of a referrer, which can carry
+// tokens of the site it was on.
+func cleanReferrer(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return ""
+	}
+	u.RawQuery, u.Fragment, u.User = "", "", nil
+	return truncate(u.String(), maxAttributionValue)
+}
+
+// passAttribution adds the utm_* parameters of r, and where r came from as
+// ref, to the query of a /login link on one of our own pages.
+func passAttribution(query url.Values, r *http.Request) {
+	for name, values := range r.URL.Query() {
+		if utmParamName.MatchString(name) && len(values) > 0 {
+			query.Set(name, values[0])
+		}
+	}
+	if ref := cleanReferrer(r.Header.Get("Referer")); ref != "" && !sameOrigin(r) {
+		query.Set("ref", ref)
+	}
+}
+
+// annotate adds the attribution to an invite log entry.
+func (a *attribution) annotate(ev *activityEvent) {
+	if a != nil {
+		ev.UTM, ev.Referrer = a.UTM, a.Referrer
+	}
+}
+
+// truncate cuts s to at most n bytes, on a rune boundary.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,145 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// Bitbucket Cloud API endpoints.
+const (
+	bitbucketAPI   = "https://api.bitbucket.org"
+	bitbucketOAuth = "https://bitbucket.org/site/oauth2"
+)
+
+// bitbucketProvider adds users to a group in a Bitbucket Cloud workspace.
+//
+// Signing in uses an OAuth consumer of the workspace; adding members uses an
+// app password of a workspace admin, since OAuth consumers cannot manage
+// groups.
+type bitbucketProvider struct {
+	oauth       *oauth2.Config
+	workspace   string
+	group       string // Group slug users are added to
+	adminUser   string
+	appPassword string
+	client      *http.Client
+}
+
+// newBitbucketProvider reads the Bitbucket settings from the environment.
+func newBitbucketProvider() (*bitbucketProvider, error) {
+	p := &bitbucketProvider{
+		oauth: &oauth2.Config{
+			ClientID:     getenv("BITBUCKET_CLIENT_ID"),
+			ClientSecret: getenv("BITBUCKET_CLIENT_SECRET"),
+			Scopes:       []string{"account", "email"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  bitbucketOAuth + "/authorize",
+				TokenURL: bitbucketOAuth + "/access_token",
+			},
+		},
+		workspace:   getenv("BITBUCKET_WORKSPACE"),
+		group:       getenv("BITBUCKET_GROUP"),
+		adminUser:   getenv("BITBUCKET_USERNAME"),
+		appPassword: getenv("BITBUCKET_APP_PASSWORD"),
+		client:      &http.Client{},
+	}
+	if p.oauth.ClientID == "" || p.oauth.ClientSecret == "" || p.workspace == "" || p.group == "" || p.adminUser == "" || p.appPassword == "" {
+		return nil, fmt.Errorf("BITBUCKET_CLIENT_ID, BITBUCKET_CLIENT_SECRET, BITBUCKET_WORKSPACE, BITBUCKET_GROUP, BITBUCKET_USERNAME, and BITBUCKET_APP_PASSWORD must be set")
+	}
+	return p, nil
+}
+
+func (p *bitbucketProvider) Name() string { return "bitbucket" }
+
+func (p *bitbucketProvider) OAuthConfig() *oauth2.Config { return p.oauth }
+
+func (p *bitbucketProvider) User(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	client := p.oauth.Client(ctx, token)
+
+	var user struct {
+		UUID     string `json:"uuid"`
+		Username string `json:"username"`
+	}
+	if err := bitbucketGet(ctx, client, bitbucketAPI+"/2.0/user", &user); err != nil {
+		return nil, err
+	}
+	id := &Identity{ID: user.UUID, Username: user.Username}
+
+	var emails struct {
+		Values []struct {
+			Email       string `json:"email"`
+			IsPrimary   bool   `json:"is_primary"`
+			IsConfirmed bool   `json:"is_confirmed"`
+		} `json:"values"`
+	}
+	if err := bitbucketGet(ctx, client, bitbucketAPI+"/2.0/user/emails", &emails); err == nil {
+		for _, e := range emails.Values {
+			if e.IsPrimary && e.IsConfirmed {
+				id.Email, id.EmailVerified = e.Email, true
+			}
+		}
+	}
+	return id, nil
+}
+
+// Invite adds the user to the configured group, plus any groups given as
+// opts.Teams. Bitbucket only exposes group membership through its 1.0 API.
+func (p *bitbucketProvider) Invite(ctx context.Context, user *Identity, opts inviteOptions) error {
+	if user.ID == "" {
+		return ErrInvitationFailed.WithMessage("Bitbucket users can only be added after signing in.")
+	}
+	for _, group := range append([]string{p.group}, opts.Teams...) {
+		u := fmt.Sprintf("%s/1.0/groups/%s/%s/members/%s/", bitbucketAPI,
+			url.PathEscape(p.workspace), url.PathEscape(group), url.PathEscape(user.ID))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, strings.NewReader("{}"))
+		if err != nil {
+			return ErrInvitationFailed.Wrap(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.SetBasicAuth(p.adminUser, p.appPassword)
+
+		resp, err := p.client.Do(req)
+		if err != nil {
+			return ErrInvitationFailed.Wrap(err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusConflict:
+			// Already in the group.
+			if group == p.group {
+				return ErrAlreadyMember
+			}
+		case resp.StatusCode == http.StatusTooManyRequests:
+			return ErrInviteRateLimited
+		case resp.StatusCode >= 300:
+			return ErrInvitationFailed.Wrap(fmt.Errorf("bitbucket: adding to group %s: %s: %s", group, resp.Status, body))
+		}
+	}
+	return nil
+}
+
+// bitbucketGet fetches a Bitbucket API resource into v.
+func bitbucketGet(ctx context.Context, client *http.Client, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bitbucket: GET %s: %s", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,80 @@
+package invite
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings of the bot signals, read in loadBotSignalConfig.
+var (
+	botSignals       bool          // Record bot signals of sign-ins, from BOT_SIGNALS
+	botHoneypotField string        // Form field real visitors leave empty
+	botMinFillTime   time.Duration // Forms submitted faster than this look automated
+)
+
+// loadBotSignalConfig reads BOT_SIGNALS, BOT_HONEYPOT_FIELD, and
+// BOT_MIN_FILL_TIME.
+func loadBotSignalConfig() {
+	botSignals = envBool("BOT_SIGNALS")
+	botHoneypotField = getenv("BOT_HONEYPOT_FIELD")
+	if botHoneypotField == "" {
+		botHoneypotField = "website"
+	}
+	botMinFillTime = envDuration("BOT_MIN_FILL_TIME", 2*time.Second)
+}
+
+// botReport is the bot score of a sign-in, carried in the flow state to the
+// invite log. Each signal adds one to the score.
+type botReport struct {
+	Score   int      `json:"score"`
+	Signals []string `json:"signals,omitempty"`
+}
+
+// detectBot collects the bot signals of a sign-in started from a form on an
+// embedded page. Such forms carry an invisible honeypot field, named by
+// BOT_HONEYPOT_FIELD, and form_ts, the Unix time in milliseconds at which
+// the form was shown. The signals are only recorded, so that gates can be
+// tuned with real data; nothing is rejected on their account.
+func detectBot(r *http.Request) *botReport {
+	if !botSignals {
+		return nil
+	}
+	formTS := r.FormValue("form_ts")
+	if r.Method != http.MethodPost && formTS == "" {
+		return nil
+	}
+	report := &botReport{}
+	signal := func(name string) {
+		report.Score++
+		report.Signals = append(report.Signals, name)
+	}
+	if r.FormValue(botHoneypotField) != "" {
+		signal("honeypot_filled")
+	}
+	if ms, err := strconv.ParseInt(formTS, 10, 64); err != nil {
+		signal("no_form_time")
+	} else if elapsed := time.Since(time.UnixMilli(ms)); elapsed < botMinFillTime {
+		signal("submitted_too_fast")
+	}
+	ua := strings.ToLower(r.UserAgent())
+	switch {
+	case ua == "":
+		signal("no_user_agent")
+	case strings.Contains(ua, "headless") || strings.Contains(ua, "phantomjs"):
+		signal("headless_browser")
+	}
+	if r.Header.Get("Accept-Language") == "" {
+		signal("no_accept_language")
+	}
+	return report
+}
+
+// annotate adds the bot score to an invite log entry.
+func (b *botReport) annotate(ev *activityEvent) {
+	if b != nil {
+		score := b.Score
+		ev.BotScore, ev.BotSignals = &score, b.Signals
+	}
+}
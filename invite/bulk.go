@@ -0,0 +1,246 @@
+package invite
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys used by bulk jobs.
+const bulkJobKey = "bulk:job:"
+
+// bulkPollBudget is how long a status poll may spend advancing the queue. On
+// serverless platforms there are no background goroutines, so polling is what
+// keeps a job moving.
+const bulkPollBudget = 5 * time.Second
+
+// maxBulkEntries caps the size of a single upload.
+const maxBulkEntries = 5000
+
+// bulkRequest is the JSON body of POST /admin/bulk. CSV uploads use the first
+// column of each row as the entry and take role and teams from the query.
+type bulkRequest struct {
+	Entries []string `json:"entries"` // GitHub usernames or email addresses
+	Role    string   `json:"role"`
+	Teams   []string `json:"teams"`
+}
+
+// bulkJob is the stored description of a bulk upload.
+type bulkJob struct {
+	ID        string    `json:"id"`
+	Total     int       `json:"total"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// bulkFailure records one entry that could not be invited.
+type bulkFailure struct {
+	Entry string `json:"entry"`
+	Code  string `json:"code"`
+}
+
+// bulkStatus is the response of the bulk endpoints.
+type bulkStatus struct {
+	bulkJob
+	Invited  int64         `json:"invited"`
+	Failed   int64         `json:"failed"`
+	Pending  int64         `json:"pending"`
+	Done     bool          `json:"done"`
+	Failures []bulkFailure `json:"failures,omitempty"`
+}
+
+// handleBulkCreate accepts a list of usernames or emails and queues an
+// invitation for each of them.
+func handleBulkCreate(w http.ResponseWriter, r *http.Request) {
+	req, err := parseBulkRequest(r)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrBadRequest))
+		return
+	}
+	if !validRole(req.Role) {
+		writeJSONError(w, ErrBadRequest.WithMessage("role must be \"member\" or \"admin\"."))
+		return
+	}
+	// Queued invitations are sent without review, so roles under dual
+	// control are granted one approval at a time instead.
+	if needsDualControl(inviteOptions{Role: req.Role}) {
+		writeJSONError(w, ErrForbidden.WithMessage("Invitations as "+req.Role+" need a second admin's approval; invite these users through the API or invite links instead."))
+		return
+	}
+
+	ctx := r.Context()
+	job := bulkJob{ID: randomID(8), CreatedAt: time.Now().UTC()}
+	var items []queuedInvite
+	seen := make(map[string]bool)
+	for _, entry := range req.Entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[strings.ToLower(entry)] {
+			continue
+		}
+		seen[strings.ToLower(entry)] = true
+		item := queuedInvite{JobID: job.ID, Role: req.Role, Teams: req.Teams}
+		if strings.Contains(entry, "@") {
+			item.Email = entry
+		} else {
+			item.Username = strings.TrimPrefix(entry, "@")
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		writeJSONError(w, ErrBadRequest.WithMessage("No usernames or emails given."))
+		return
+	}
+	if len(items) > maxBulkEntries {
+		writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("At most %d entries can be uploaded at once.", maxBulkEntries)))
+		return
+	}
+	job.Total = len(items)
+
+	b, _ := json.Marshal(job)
+	if err := dataStore.Set(ctx, bulkJobKey+job.ID, b, 0); err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	for _, item := range items {
+		if err := enqueueInvite(ctx, item); err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+	}
+	log.Printf("Queued bulk job %s with %d invitations", job.ID, job.Total)
+	recordAudit(ctx, r, adminActor(r), auditBulkJobStarted, map[string]any{"job_id": job.ID, "total": job.Total, "role": req.Role, "teams": req.Teams})
+
+	// Start working on the queue right away, unless the workers are on it.
+	// This only runs to completion where the process outlives the request;
+	// elsewhere polling continues it.
+	if activePool.Load() == nil {
+		go processQueue(context.Background(), time.Now().Add(bulkPollBudget))
+	}
+
+	status, err := loadBulkStatus(ctx, job.ID)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// handleBulkStatus reports the progress of a bulk job, advancing the queue
+// first.
+func handleBulkStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/bulk/")
+	ctx := r.Context()
+	if _, err := loadBulkStatus(ctx, id); err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	processQueue(ctx, time.Now().Add(bulkPollBudget))
+
+	status, err := loadBulkStatus(ctx, id)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// loadBulkStatus reads a job and its progress counters.
+func loadBulkStatus(ctx context.Context, id string) (*bulkStatus, error) {
+	b, err := dataStore.Get(ctx, bulkJobKey+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound.WithMessage("No such bulk job.")
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status bulkStatus
+	if err := json.Unmarshal(b, &status.bulkJob); err != nil {
+		return nil, err
+	}
+	status.Invited = readCounter(ctx, bulkJobKey+id+":invited")
+	status.Failed = readCounter(ctx, bulkJobKey+id+":failed")
+	status.Pending = int64(status.Total) - status.Invited - status.Failed
+	status.Done = status.Pending <= 0
+
+	failures, err := dataStore.Range(ctx, bulkJobKey+id+":failures", 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range failures {
+		var failure bulkFailure
+		if json.Unmarshal(f, &failure) == nil {
+			status.Failures = append(status.Failures, failure)
+		}
+	}
+	return &status, nil
+}
+
+// recordBulkResult updates a job's progress after one of its items ran.
+func recordBulkResult(ctx context.Context, item queuedInvite, err error) {
+	prefix := bulkJobKey + item.JobID
+	if err == nil {
+		dataStore.Incr(ctx, prefix+":invited", 0)
+		return
+	}
+	dataStore.Incr(ctx, prefix+":failed", 0)
+	b, _ := json.Marshal(bulkFailure{Entry: item.target(), Code: asError(err, ErrInvitationFailed).Code})
+	dataStore.Append(ctx, prefix+":failures", b)
+}
+
+// readCounter returns the counter at key, or 0 if it doesn't exist.
+func readCounter(ctx context.Context, key string) int64 {
+	b, err := dataStore.Get(ctx, key)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(string(b), 10, 64)
+	return n
+}
+
+// parseBulkRequest reads a JSON or CSV bulk upload.
+func parseBulkRequest(r *http.Request) (*bulkRequest, error) {
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mt != "text/csv" {
+		var req bulkRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err))
+		}
+		return &req, nil
+	}
+
+	req := &bulkRequest{Role: r.URL.Query().Get("role")}
+	if teams := r.URL.Query().Get("teams"); teams != "" {
+		req.Teams = strings.Split(teams, ",")
+	}
+	cr := csv.NewReader(r.Body)
+	cr.FieldsPerRecord = -1
+	for i := 0; ; i++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid CSV: %v", err))
+		}
+		if len(record) == 0 {
+			continue
+		}
+		entry := strings.TrimSpace(record[0])
+		// Skip a header row.
+		if i == 0 && (strings.EqualFold(entry, "username") || strings.EqualFold(entry, "email")) {
+			continue
+		}
+		req.Entries = append(req.Entries, entry)
+	}
+	return req, nil
+}
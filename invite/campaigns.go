@@ -0,0 +1,226 @@
+package invite
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// campaign is a landing page at /join/{name}, from CAMPAIGNS.
+type campaign struct {
+	Name    string
+	Title   string    // Heading of the page
+	Text    string    // Copy above the button; blank lines separate paragraphs
+	Button  string    // Label of the button
+	Preset  string    // Preset the sign-ins of the campaign get, if any
+	Expires time.Time // When the page stops taking sign-ins (zero = never)
+
+	Experiment string // Experiment whose variants change the copy, if any
+}
+
+var joinTemplate = lazyTemplate("join", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{font-family:system-ui,sans-serif;max-width:36rem;margin:4rem auto;padding:0 1rem;text-align:center}
+a.button{display:inline-block;padding:.7rem 1.2rem;border-radius:6px;background:#24292f;color:#fff;text-decoration:none;font-weight:600}</style>
+</head>
+<body{{if .Variant}} data-variant="{{.Variant}}"{{end}}>
+<h1>{{.Title}}</h1>
+{{range .Paragraphs}}<p>{{.}}</p>
+{{end}}
+{{- if .Ended}}<p>This campaign has ended.</p>
+{{- else}}<p><a class="button" href="{{.Login}}">{{.Button}}</a></p>
+{{- if not .Expires.IsZero}}
+<p><small>Open until {{.Expires.Format "January 2, 2006 15:04 MST"}}.</small></p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`)
+
+// parseCampaigns parses CAMPAIGNS, a ";"-separated list of landing pages
+// with ","-separated settings, for example:
+//
+//	hacktoberfest -> title="Hacktoberfest 2026", text="Pick an issue, get a PR merged.", preset=contributor, expires=2026-11-01
+//
+// The settings are title, text, button, preset, expires (a date, or an RFC
+// 3339 time), and experiment, an experiment of EXPERIMENTS whose variants
+// replace the copy. Values with commas or semicolons go in double quotes, in
+// which \n\n separates paragraphs of the text.
+func parseCampaigns(spec string, presets map[string]inviteOptions, experiments map[string]experiment) (map[string]campaign, error) {
+	campaigns := make(map[string]campaign)
+	for _, entry := range splitUnquoted(spec, ';') {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, settings, ok := strings.Cut(entry, "->")
+		name = strings.TrimSpace(name)
+		if !ok || !validPresetName.MatchString(name) {
+			return nil, fmt.Errorf("invalid campaign %q: expected name -> settings", entry)
+		}
+		if _, dup := campaigns[name]; dup {
+			return nil, fmt.Errorf("campaign %q is defined twice", name)
+		}
+		c := campaign{Name: name, Title: "Join us", Button: "Join on GitHub"}
+		for _, setting := range splitUnquoted(settings, ',') {
+			setting = strings.TrimSpace(setting)
+			if setting == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(setting, "=")
+			key = strings.TrimSpace(key)
+			if !ok {
+				return nil, fmt.Errorf("invalid campaign %q: expected key=value, got %q", name, setting)
+			}
+			value = strings.TrimSpace(value)
+			if strings.HasPrefix(value, `"`) {
+				var err error
+				if value, err = strconv.Unquote(value); err != nil {
+					return nil, fmt.Errorf("invalid campaign %q: bad quoting in %s", name, key)
+				}
+			}
+			switch key {
+			case "title":
+				c.Title = value
+			case "text":
+				c.Text = value
+			case "button":
+				c.Button = value
+			case "preset":
+				if _, ok := presets[value]; !ok {
+					return nil, fmt.Errorf("invalid campaign %q: unknown preset %q", name, value)
+				}
+				c.Preset = value
+			case "expires":
+				t, err := time.Parse(time.RFC3339, value)
+				if err != nil {
+					if t, err = time.Parse(time.DateOnly, value); err != nil {
+						return nil, fmt.Errorf("invalid campaign %q: expires must be a date such as 2026-11-01 or an RFC 3339 time", name)
+					}
+				}
+				c.Expires = t
+			case "experiment":
+				if _, ok := experiments[value]; !ok {
+					return nil, fmt.Errorf("invalid campaign %q: unknown experiment %q", name, value)
+				}
+				c.Experiment = value
+			default:
+				return nil, fmt.Errorf("invalid campaign %q: unknown setting %q", name, key)
+			}
+		}
+		campaigns[name] = c
+	}
+	return campaigns, nil
+}
+
+// splitUnquoted splits s at each sep that is not inside double quotes.
+func splitUnquoted(s string, sep byte) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// findCampaign returns the named campaign of rs, or why sign-ins cannot use
+// it.
+func findCampaign(rs *ruleSet, name string) (campaign, error) {
+	c, ok := rs.campaigns[name]
+	if !ok {
+		return campaign{}, ErrInvalidLink.WithMessage("This campaign does not exist.")
+	}
+	if !c.Expires.IsZero() && !time.Now().Before(c.Expires) {
+		return c, ErrLinkExpired.WithMessage("This campaign has ended.")
+	}
+	return c, nil
+}
+
+// handleJoin serves the landing page of the campaign in the path,
+// /join/{name}, for ?org or the primary org: its copy, and a button that
+// starts the sign-in with the campaign and its preset. An ended campaign
+// keeps its page, without the button.
+func handleJoin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	org := githubOrgName
+	if o := r.FormValue("org"); o != "" {
+		if org = knownOrg(o); org == "" {
+			writeJSONError(w, ErrUnknownOrg)
+			return
+		}
+	}
+	rs := currentRules().forOrg(org)
+	c, err := findCampaign(rs, strings.TrimPrefix(r.URL.Path, "/join/"))
+	if err != nil && c.Name == "" {
+		handleUnknownPath(w, r)
+		return
+	}
+	cacheControl := "public, max-age=60"
+	name := ""
+	if c.Experiment != "" {
+		var v variant
+		name, v = rs.variantFor(c.Experiment, visitorID(w, r))
+		c.Title, c.Text, c.Button = cmp.Or(v.Title, c.Title), cmp.Or(v.Text, c.Text), cmp.Or(v.Button, c.Button)
+		cacheControl = "private, no-cache"
+	}
+
+	query := url.Values{"campaign": {c.Name}}
+	passAttribution(query, r)
+	if r.FormValue("org") != "" {
+		query.Set("org", org)
+	}
+	data := struct {
+		campaign
+		Paragraphs []string
+		Login      string
+		Ended      bool
+		Variant    string
+	}{campaign: c, Login: (&url.URL{Path: "/login", RawQuery: query.Encode()}).String(), Ended: err != nil, Variant: name}
+	for _, p := range strings.Split(c.Text, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			data.Paragraphs = append(data.Paragraphs, p)
+		}
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Add("Vary", "Referer")
+	status := http.StatusOK
+	if data.Ended {
+		status = http.StatusGone
+	}
+	renderPage(w, status, joinTemplate(), data)
+}
+
+// campaignPreset checks the ?campaign of a sign-in against the rules of
+// each of orgs and returns the preset it comes with.
+func campaignPreset(name string, orgs []string) (string, error) {
+	preset := ""
+	for _, org := range orgs {
+		c, err := findCampaign(currentRules().forOrg(org), name)
+		if err != nil {
+			return "", err
+		}
+		if preset == "" {
+			preset = c.Preset
+		}
+	}
+	return preset, nil
+}
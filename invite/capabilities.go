@@ -0,0 +1,159 @@
+package invite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// fineGrainedPATPrefix starts fine-grained personal access tokens. A classic
+// token of an org owner can do everything the flow needs, but a fine-grained
+// one only what it was granted when it was created.
+const fineGrainedPATPrefix = "github_pat_"
+
+// patCapabilities are what GITHUB_PAT may do in an org.
+type patCapabilities struct {
+	Invite      bool `json:"invite"`      // Members: write, to send invitations
+	Teams       bool `json:"teams"`       // Members: read and write, to add invited users to teams
+	Invitations bool `json:"invitations"` // Members: read, to list pending invitations
+	Plan        bool `json:"plan"`        // Administration: read, for the seat check
+}
+
+// allCapabilities are assumed for classic tokens, and when they cannot be
+// detected.
+var allCapabilities = patCapabilities{Invite: true, Teams: true, Invitations: true, Plan: true}
+
+// patCaps caches the capabilities of a fine-grained GITHUB_PAT, by org.
+var patCaps struct {
+	sync.Mutex
+	byOrg map[string]patCapabilities
+}
+
+// capabilitiesOf returns what GITHUB_PAT may do in org, probing a
+// fine-grained token the first time the org asks. A probe that fails is not
+// cached and grants everything, so that a GitHub outage does not turn
+// features off.
+func capabilitiesOf(ctx context.Context, org string) patCapabilities {
+	if !strings.HasPrefix(githubPat, fineGrainedPATPrefix) {
+		return allCapabilities
+	}
+	patCaps.Lock()
+	caps, ok := patCaps.byOrg[org]
+	patCaps.Unlock()
+	if ok {
+		return caps
+	}
+	caps, err := probeCapabilities(ctx, adminClient(), org)
+	if err != nil {
+		log.Printf("Could not detect the permissions of GITHUB_PAT in %s, assuming it has them all: %v", org, err)
+		return allCapabilities
+	}
+	errs, warnings := caps.problems(org)
+	for _, msg := range append(errs, warnings...) {
+		log.Printf("WARNING: %s", msg)
+	}
+	patCaps.Lock()
+	if patCaps.byOrg == nil {
+		patCaps.byOrg = make(map[string]patCapabilities)
+	}
+	patCaps.byOrg[org] = caps
+	patCaps.Unlock()
+	return caps
+}
+
+// forgetCapabilities drops the detected capabilities, for a new GITHUB_PAT.
+func forgetCapabilities() {
+	patCaps.Lock()
+	patCaps.byOrg = nil
+	patCaps.Unlock()
+}
+
+// probeCapabilities detects what the token of client may do in org, with
+// requests that change nothing: the invitation probe sends an empty
+// invitation, which GitHub rejects as invalid only once the token is allowed
+// to invite.
+func probeCapabilities(ctx context.Context, client *github.Client, org string) (patCapabilities, error) {
+	var caps patCapabilities
+	probe := func(granted *bool, method, path string, body any) error {
+		req, err := client.NewRequest(method, path, body)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(ctx, req, nil)
+		switch {
+		case err == nil, resp != nil && resp.StatusCode == http.StatusUnprocessableEntity:
+			*granted = true
+		case resp != nil && (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound):
+			*granted = false
+		default:
+			return err
+		}
+		return nil
+	}
+	if err := probe(&caps.Invite, http.MethodPost, "orgs/"+org+"/invitations", struct{}{}); err != nil {
+		return caps, err
+	}
+	if err := probe(&caps.Teams, http.MethodGet, "orgs/"+org+"/teams?per_page=1", nil); err != nil {
+		return caps, err
+	}
+	if err := probe(&caps.Invitations, http.MethodGet, "orgs/"+org+"/invitations?per_page=1", nil); err != nil {
+		return caps, err
+	}
+	// Team memberships are written with the Members permission, like
+	// invitations.
+	caps.Teams = caps.Teams && caps.Invite
+	o, _, err := client.Organizations.Get(ctx, org)
+	if err != nil {
+		return caps, err
+	}
+	caps.Plan = o.GetPlan() != nil
+	return caps, nil
+}
+
+// problems describes what GITHUB_PAT cannot do in org: errors for what
+// sign-ins need, and warnings for the features turned off.
+func (c patCapabilities) problems(org string) (errs, warnings []string) {
+	if !c.Invite {
+		errs = append(errs, fmt.Sprintf("GITHUB_PAT cannot invite users to %s; grant it the Members organization permission with write access", org))
+	}
+	if !c.Teams {
+		warnings = append(warnings, fmt.Sprintf("GITHUB_PAT cannot manage the teams of %s, so invited users are not added to teams; grant it the Members organization permission with write access", org))
+	}
+	if !c.Invitations {
+		warnings = append(warnings, fmt.Sprintf("GITHUB_PAT cannot list the pending invitations of %s, so invitations by email are not checked against them; grant it the Members organization permission", org))
+	}
+	if !c.Plan {
+		warnings = append(warnings, fmt.Sprintf("GITHUB_PAT cannot read the plan of %s, so the seat check is skipped; grant it the Administration organization permission with read access", org))
+	}
+	return errs, warnings
+}
+
+// pendingInvitation reports whether email has a pending invitation to org.
+// Without the permission to list them, it reports none and leaves GitHub to
+// reject the duplicate.
+func pendingInvitation(ctx context.Context, client *github.Client, org, email string) (bool, error) {
+	if !capabilitiesOf(ctx, org).Invitations {
+		return false, nil
+	}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		invitations, resp, err := client.Organizations.ListPendingOrgInvitations(ctx, org, opts)
+		if err != nil {
+			return false, err
+		}
+		for _, inv := range invitations {
+			if strings.EqualFold(inv.GetEmail(), email) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return false, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
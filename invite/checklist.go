@@ -0,0 +1,224 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// checklistTokenTTL is how long the success page can ask for the checklist
+// of a user: as long as the status of their onboarding is kept.
+const checklistTokenTTL = onboardingTTL
+
+// maxStarredPages bounds the pages of a user's starred repositories searched
+// for CHECKLIST_REPO, newest first.
+const maxStarredPages = 10
+
+// Onboarding checklist settings, from ONBOARDING_CHECKLIST and
+// CHECKLIST_REPO.
+var (
+	onboardingChecklist bool
+	checklistRepo       string // owner/name of the repository new members are asked to star
+)
+
+// loadChecklistConfig reads ONBOARDING_CHECKLIST and CHECKLIST_REPO, which
+// is owner/name, or a repository of the primary org by name.
+func loadChecklistConfig() {
+	onboardingChecklist = envBool("ONBOARDING_CHECKLIST")
+	checklistRepo = getenv("CHECKLIST_REPO")
+	if !onboardingChecklist {
+		return
+	}
+	if activeProvider.Name() != "github" {
+		log.Fatal("FATAL: ONBOARDING_CHECKLIST requires the github provider.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when ONBOARDING_CHECKLIST is set.")
+	}
+	if checklistRepo != "" && !strings.Contains(checklistRepo, "/") {
+		checklistRepo = githubOrgName + "/" + checklistRepo
+	}
+}
+
+// checklistClaims is the payload of the checklist_token the success page
+// gets, naming the user and what they were invited to.
+type checklistClaims struct {
+	Username string   `json:"u"`
+	Org      string   `json:"o"`
+	Teams    []string `json:"t,omitempty"`
+	Expires  int64    `json:"exp"`
+}
+
+// newChecklistToken returns the checklist_token of the user of st.
+func newChecklistToken(ctx context.Context, st *stepState) (string, error) {
+	payload, _ := json.Marshal(checklistClaims{
+		Username: st.Username,
+		Org:      orgOrDefault(st.Org),
+		Teams:    st.Teams,
+		Expires:  time.Now().Add(checklistTokenTTL).Unix(),
+	})
+	return signToken(ctx, tokenChecklist, payload)
+}
+
+// Items of the onboarding checklist.
+const (
+	checklistAccepted  = "accept_invitation"
+	checklistTeams     = "join_teams"
+	checklistTwoFactor = "enable_2fa"
+	checklistStar      = "star_repo"
+)
+
+// checklistItem is a step of onboarding, and whether the user has done it.
+type checklistItem struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+	URL   string `json:"url,omitempty"` // Where the user does it
+}
+
+// checklist is the onboarding checklist of a user.
+type checklist struct {
+	Username string          `json:"username"`
+	Org      string          `json:"org"`
+	Items    []checklistItem `json:"items"`
+	Complete bool            `json:"complete"`
+}
+
+var checklistFragment = lazyTemplate("checklist_fragment", `<ul class="auto-invite-checklist">
+{{- range .Items}}
+<li class="auto-invite-checklist-{{if .Done}}done{{else}}todo{{end}}">{{if .Done}}&#10003;{{else}}&#9744;{{end}} {{if and .URL (not .Done)}}<a href="{{.URL}}">{{.Title}}</a>{{else}}{{.Title}}{{end}}</li>
+{{- end}}
+</ul>`)
+
+// handleChecklist serves /fragments/checklist?token=, the onboarding
+// checklist of the user the checklist_token of the success page names,
+// checked live on GitHub, so that the page can walk them through the steps
+// after the invitation: accepting it, joining their teams, turning on
+// two-factor authentication, and starring CHECKLIST_REPO.
+func handleChecklist(w http.ResponseWriter, r *http.Request) {
+	if !onboardingChecklist {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	var claims checklistClaims
+	payload, err := verifyToken(tokenChecklist, r.FormValue("token"))
+	if err != nil || json.Unmarshal(payload, &claims) != nil || time.Now().Unix() >= claims.Expires {
+		writeJSONError(w, ErrUnauthorized.WithMessage("The checklist token is invalid or has expired."))
+		return
+	}
+	list, err := buildChecklist(r.Context(), adminClient(), claims)
+	if err != nil {
+		log.Printf("Checking the onboarding checklist of %s failed: %v", claims.Username, err)
+		writeJSONError(w, ErrUserInfo.Wrap(err))
+		return
+	}
+	w.Header().Add("Vary", "HX-Request")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if r.Header.Get("HX-Request") == "true" || r.FormValue("format") == "html" {
+		renderPage(w, http.StatusOK, checklistFragment(), list)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// buildChecklist checks the onboarding of the user of claims on GitHub.
+// Teams and two-factor authentication can only be checked once the user has
+// joined.
+func buildChecklist(ctx context.Context, client *github.Client, claims checklistClaims) (*checklist, error) {
+	list := &checklist{Username: claims.Username, Org: claims.Org}
+	membership, resp, err := client.Organizations.GetOrgMembership(ctx, claims.Username, claims.Org)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, err
+	}
+	joined := err == nil && membership.GetState() == "active"
+	list.Items = append(list.Items, checklistItem{ID: checklistAccepted, Title: "Accept your invitation to " + claims.Org, Done: joined, URL: invitationURL(claims.Org)})
+
+	if len(claims.Teams) > 0 {
+		inTeams := joined
+		for _, slug := range claims.Teams {
+			if !inTeams {
+				break
+			}
+			m, resp, err := client.Teams.GetTeamMembershipBySlug(ctx, claims.Org, slug, claims.Username)
+			if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+				return nil, err
+			}
+			inTeams = err == nil && m.GetState() == "active"
+		}
+		list.Items = append(list.Items, checklistItem{ID: checklistTeams, Title: "Join your teams: " + strings.Join(claims.Teams, ", "), Done: inTeams, URL: githubWebURL() + "/orgs/" + claims.Org + "/teams"})
+	}
+
+	twoFactor := false
+	if joined {
+		without, err := membersWithout2FA(ctx, client, claims.Org)
+		if err != nil {
+			return nil, err
+		}
+		_, disabled := without[strings.ToLower(claims.Username)]
+		twoFactor = !disabled
+	}
+	list.Items = append(list.Items, checklistItem{ID: checklistTwoFactor, Title: "Enable two-factor authentication", Done: twoFactor, URL: githubWebURL() + "/settings/security"})
+
+	if checklistRepo != "" {
+		starred, err := hasStarred(ctx, client, claims.Username, checklistRepo)
+		if err != nil {
+			return nil, err
+		}
+		list.Items = append(list.Items, checklistItem{ID: checklistStar, Title: "Star " + checklistRepo, Done: starred, URL: githubWebURL() + "/" + checklistRepo})
+	}
+
+	list.Complete = true
+	for _, item := range list.Items {
+		list.Complete = list.Complete && item.Done
+	}
+	return list, nil
+}
+
+// membersWithout2FA returns the logins of the members of org without
+// two-factor authentication, by lower-case login. Only org owners can list
+// them.
+func membersWithout2FA(ctx context.Context, client *github.Client, org string) (map[string]string, error) {
+	logins := make(map[string]string)
+	opts := &github.ListMembersOptions{Filter: "2fa_disabled", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		members, resp, err := client.Organizations.ListMembers(ctx, org, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range members {
+			logins[strings.ToLower(m.GetLogin())] = m.GetLogin()
+		}
+		if resp.NextPage == 0 {
+			return logins, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// hasStarred reports whether username starred repo, an owner/name, looking
+// through their most recently starred repositories.
+func hasStarred(ctx context.Context, client *github.Client, username, repo string) (bool, error) {
+	opts := &github.ActivityListStarredOptions{Sort: "created", Direction: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	for range maxStarredPages {
+		starred, resp, err := client.Activity.ListStarred(ctx, username, opts)
+		if err != nil {
+			return false, fmt.Errorf("listing the starred repositories of %s: %w", username, err)
+		}
+		for _, s := range starred {
+			if strings.EqualFold(s.GetRepository().GetFullName(), repo) {
+				return true, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return false, nil
+}
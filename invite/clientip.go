@@ -0,0 +1,156 @@
+package invite
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Client address settings, from TRUSTED_PROXIES and VERCEL.
+var (
+	trustedProxies []netip.Prefix // Proxies whose forwarding headers are believed
+	onVercel       bool           // Vercel sets x-vercel-forwarded-for itself
+)
+
+// loadTrustedProxiesConfig reads TRUSTED_PROXIES, a comma-separated list of
+// addresses or CIDR ranges of the proxies in front of the handler, and
+// VERCEL, which the platform sets to 1.
+func loadTrustedProxiesConfig() {
+	var err error
+	if trustedProxies, err = parsePrefixes(getenv("TRUSTED_PROXIES")); err != nil {
+		log.Fatalf("FATAL: Invalid TRUSTED_PROXIES: %v", err)
+	}
+	onVercel = getenv("VERCEL") == "1"
+}
+
+// parsePrefixes parses a comma-separated list of addresses and CIDR ranges.
+func parsePrefixes(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses an address or CIDR range, an address being the range
+// of just itself.
+func parsePrefix(entry string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		addr, aerr := netip.ParseAddr(entry)
+		if aerr != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid entry %q: want an address or CIDR range", entry)
+		}
+		prefix = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	return prefix.Masked(), nil
+}
+
+// clientIP returns the address of the client of r. That is the peer
+// address, unless the platform names the client in a header it sets itself,
+// x-vercel-forwarded-for on Vercel, or the peer is one of TRUSTED_PROXIES:
+// then it is CF-Connecting-IP, or else the rightmost X-Forwarded-For entry
+// that is not a trusted proxy, as the entries left of it may be forged by
+// the client. The Cloudflare and Netlify entrypoints pass the client as the
+// peer address.
+func clientIP(r *http.Request) string {
+	if onVercel {
+		if ip, ok := forwardedAddr(r.Header.Get("X-Vercel-Forwarded-For")); ok {
+			return ip
+		}
+	}
+	ip := peerIP(r)
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	if cf, ok := forwardedAddr(r.Header.Get("CF-Connecting-IP")); ok {
+		return cf
+	}
+	hops := forwardedChain(r)
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip); i-- {
+		hop, ok := forwardedAddr(hops[i])
+		if !ok {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// peerIP returns the address r came from, without its port.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedChain returns the X-Forwarded-For entries of r, the client first,
+// when r came from a trusted proxy; the entries of anyone else are not
+// believed.
+func forwardedChain(r *http.Request) []string {
+	if !isTrustedProxy(peerIP(r)) {
+		return nil
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedAddr returns the first address of a forwarding header, and
+// whether it is one.
+func forwardedAddr(v string) (string, bool) {
+	v, _, _ = strings.Cut(v, ",")
+	addr, err := netip.ParseAddr(strings.TrimSpace(v))
+	if err != nil {
+		return "", false
+	}
+	return addr.Unmap().String(), true
+}
+
+// isTrustedProxy reports whether ip is one of TRUSTED_PROXIES.
+func isTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which
+// netip does not count as private.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// isRoutable reports whether ip is a globally routable unicast address, as
+// opposed to a private, loopback, or link-local one.
+func isRoutable(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
@@ -0,0 +1,235 @@
+package invite
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of request coalescing.
+const (
+	coalesceKey     = "coalesce:"      // Recorded responses, by coalescing key
+	coalesceLockKey = "coalesce:lock:" // Held by the instance serving a key
+)
+
+const (
+	// codeCoalesceTTL is how long the response of a callback is replayed
+	// for its code, as to refreshes of the page.
+	codeCoalesceTTL = time.Minute
+	// userCoalesceTTL is how long the response of a sign-in is replayed
+	// for the user: long enough for a duplicate to pick it up, and not so
+	// long that a retry gets the old answer.
+	userCoalesceTTL = 5 * time.Second
+	// coalesceWait is how long a duplicate waits for the first request.
+	coalesceWait = 15 * time.Second
+	// coalescePoll is how often a duplicate checks the store for the
+	// response of a request another instance is serving.
+	coalescePoll = 100 * time.Millisecond
+	// coalesceMaxBody is the largest response body recorded.
+	coalesceMaxBody = 64 << 10
+)
+
+// recordedResponse is a response kept for duplicates of its request.
+type recordedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// write replays the response.
+func (resp *recordedResponse) write(w http.ResponseWriter) {
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	status := resp.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(resp.Body)
+}
+
+// responseRecorder records a response instead of sending it.
+type responseRecorder struct {
+	resp recordedResponse
+}
+
+func newResponseRecorder() *responseRecorder {
+	return &responseRecorder{resp: recordedResponse{Header: make(http.Header)}}
+}
+
+func (rec *responseRecorder) Header() http.Header { return rec.resp.Header }
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.resp.Status == 0 {
+		rec.resp.Status = status
+	}
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.WriteHeader(http.StatusOK)
+	rec.resp.Body = append(rec.resp.Body, b...)
+	return len(b), nil
+}
+
+// flight is a coalesced request being served by this instance.
+type flight struct {
+	done chan struct{}
+	resp *recordedResponse
+}
+
+// flights holds the coalesced requests in progress, by key.
+var flights struct {
+	sync.Mutex
+	m map[string]*flight
+}
+
+// coalesce serves the first of identical requests, named by key, with serve
+// and answers the others with its response, so that double-clicks and
+// duplicated deliveries cause one invitation and one set of notifications.
+// Duplicates on this instance wait for the first; those on other instances
+// wait for its response in the store, where it is kept for ttl.
+func coalesce(w http.ResponseWriter, r *http.Request, key string, ttl time.Duration, serve http.HandlerFunc) {
+	if resp := awaitFlight(r.Context(), key); resp != nil {
+		resp.write(w)
+		return
+	}
+	// The first request is finished for its duplicates even if its own
+	// client went away, as the first click of a double-click does.
+	ctx := context.WithoutCancel(r.Context())
+	f := &flight{done: make(chan struct{})}
+	flights.Lock()
+	if flights.m == nil {
+		flights.m = make(map[string]*flight)
+	}
+	if _, ok := flights.m[key]; ok {
+		// Another request started in the meantime.
+		flights.Unlock()
+		coalesce(w, r, key, ttl, serve)
+		return
+	}
+	flights.m[key] = f
+	flights.Unlock()
+	defer func() {
+		flights.Lock()
+		delete(flights.m, key)
+		flights.Unlock()
+		close(f.done)
+	}()
+
+	if resp, err := loadResponse(ctx, key); err == nil {
+		f.resp = resp
+		resp.write(w)
+		return
+	}
+	// Store errors run the request rather than fail it.
+	if won, err := dataStore.SetNX(ctx, coalesceLockKey+key, nil, coalesceWait); err == nil && !won {
+		if resp := pollResponse(ctx, key); resp != nil {
+			f.resp = resp
+			resp.write(w)
+			return
+		}
+	}
+	rec := newResponseRecorder()
+	serve(rec, r)
+	f.resp = &rec.resp
+	if len(rec.resp.Body) <= coalesceMaxBody {
+		b, _ := json.Marshal(rec.resp)
+		if err := dataStore.Set(ctx, coalesceKey+key, b, ttl); err != nil {
+			log.Printf("Could not record the response for duplicate requests: %v", err)
+		}
+	}
+	if err := dataStore.Delete(ctx, coalesceLockKey+key); err != nil {
+		log.Printf("Could not release the coalescing lock: %v", err)
+	}
+	rec.resp.write(w)
+}
+
+// replayCoalesced answers r with the response of the request that key was
+// coalesced under, waiting for it when it is still being served, and
+// reports whether there was one.
+func replayCoalesced(w http.ResponseWriter, r *http.Request, key string) bool {
+	ctx := r.Context()
+	resp := awaitFlight(ctx, key)
+	if resp == nil {
+		resp = pollResponse(ctx, key)
+	}
+	if resp == nil {
+		return false
+	}
+	resp.write(w)
+	return true
+}
+
+// awaitFlight waits for the request with key this instance is serving, if
+// any, and returns its response.
+func awaitFlight(ctx context.Context, key string) *recordedResponse {
+	flights.Lock()
+	f := flights.m[key]
+	flights.Unlock()
+	if f == nil {
+		return nil
+	}
+	select {
+	case <-f.done:
+		return f.resp
+	case <-ctx.Done():
+		return nil
+	case <-time.After(coalesceWait):
+		return nil
+	}
+}
+
+// pollResponse waits up to coalesceWait for the response of key to appear
+// in the store.
+func pollResponse(ctx context.Context, key string) *recordedResponse {
+	deadline := time.Now().Add(coalesceWait)
+	for {
+		resp, err := loadResponse(ctx, key)
+		if err == nil {
+			return resp
+		}
+		if !errors.Is(err, store.ErrNotFound) || time.Now().After(deadline) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(coalescePoll):
+		}
+	}
+}
+
+// loadResponse returns the recorded response of key.
+func loadResponse(ctx context.Context, key string) (*recordedResponse, error) {
+	b, err := dataStore.Get(ctx, coalesceKey+key)
+	if err != nil {
+		return nil, err
+	}
+	var resp recordedResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// codeCoalesceKey is the coalescing key of a callback, by its code.
+func codeCoalesceKey(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return "code:" + hex.EncodeToString(sum[:])
+}
+
+// userCoalesceKey is the coalescing key of sign-ins of user to orgs.
+func userCoalesceKey(user *Identity, orgs []string) string {
+	sum := sha256.Sum256([]byte(activeProvider.Name() + "\n" + user.ID + "\n" + strings.Join(orgs, ",")))
+	return "user:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,507 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+
+	"golang.org/x/oauth2"
+)
+
+// storeTimeout bounds each call to a remote store.
+const storeTimeout = 5 * time.Second
+
+var (
+	// These will be read from environment variables for security.
+	githubClientID     string
+	githubClientSecret string
+	githubOrgName      string
+	githubOrgs         []string // Every org the deployment serves, githubOrgName first
+	githubPat          string   // Personal Access Token of an org owner
+	githubURL          string   // Base URL of GitHub Enterprise Server; github.com when empty
+	successRedirectURL string   // URL to redirect to on success
+	errorRedirectURL   string   // URL to redirect to on error
+
+	// Optional settings.
+	orgFullRedirectURL   string // URL to redirect to when the org has no seats left
+	waitlistWhenFull     bool   // Put users on the waitlist when the org is full
+	memberCountRefresh   time.Duration
+	closedRedirectURL    string         // URL to redirect to when membership is closed
+	usedRedirectURL      string         // URL to redirect to when a sign-in callback is replayed
+	cancelledRedirectURL string         // URL to redirect to when the user cancels the authorization
+	redirectStatuses     map[string]int // Status by redirect kind, from REDIRECT_STATUS
+	waitlistWhenClosed   bool           // Let visitors join the waitlist while membership is closed
+	errorCodeOnly        bool           // Pass only error_code to the error page, not error_message
+	signingKey           []byte         // Key for signed invite links
+	adminToken           string         // Bearer token for the /admin/ API
+	publicURL            string         // Externally visible base URL of this deployment
+	queueInterval        time.Duration  // Minimum time between queued invitations
+	apiToken             string         // Bearer token for the /api/v1/ API
+	idempotencyTTL       time.Duration  // How long Idempotency-Key responses are kept
+
+	// dataStore holds everything the flow persists, from Config.Store.
+	dataStore store.Store = store.NewMemory()
+
+	// oauth2.Config is configured once globally.
+	oauthConf *oauth2.Config
+
+	// A sync.Once to ensure initialization happens only once.
+	initOnce sync.Once
+
+	// getenv looks up the settings outside Config.
+	getenv = os.Getenv
+
+	// A simple in-memory state store for CSRF protection.
+	oauthStateString = "random-string-for-csrf-protection"
+)
+
+// Config is the core configuration of the invite flow. LoadConfig reads it
+// from the environment; embedders can instead build one, starting from
+// NewConfig so that unset fields keep their defaults, and pass it to
+// Configure.
+type Config struct {
+	Profile            string // Name of the profile, from APP_ENV
+	Provider           string // "github" (the default) or "bitbucket"
+	GitHubClientID     string
+	GitHubClientSecret string
+	GitHubOrg          string   // Primary org; defaults to the first of GitHubOrgs
+	GitHubOrgs         []string // Further orgs the deployment serves
+	GitHubPAT          string   // Personal Access Token of an org owner
+	GitHubURL          *url.URL // GitHub Enterprise Server, or a fake GitHub in tests; github.com when nil
+
+	SuccessRedirectURL   *url.URL // Required; the redirect URLs can contain {username}, {org}, {campaign}, and {error_code}
+	ErrorRedirectURL     *url.URL // Required
+	OrgFullRedirectURL   *url.URL
+	ClosedRedirectURL    *url.URL
+	UsedRedirectURL      *url.URL
+	CancelledRedirectURL *url.URL
+	RedirectStatuses     map[string]int // Status by redirect kind, as in REDIRECT_STATUS
+	ErrorCodeOnly        bool           // Leave error_message out of error redirects; the site owns the copy
+	PublicURL            *url.URL
+
+	WaitlistWhenFull    bool
+	WaitlistWhenClosed  bool
+	MemberCountRefresh  time.Duration
+	ConfigFile          string
+	ConfigCheckInterval time.Duration
+
+	SigningKey       []byte // At least 32 bytes, or empty
+	AdminToken       string
+	AdminCredentials string // name:role:token entries, as in ADMIN_CREDENTIALS
+	APIToken         string
+	CronSecret       string
+
+	RequestTimeout time.Duration
+	RouteTimeouts  map[string]time.Duration
+	MaxBodyBytes   int64
+	QueueInterval  time.Duration
+	IdempotencyTTL time.Duration
+
+	LogLevel  string // debug, info (the default), warn, or error
+	LogFormat string // text (the default) or json
+
+	// Store is where the flow persists its state. LoadConfig sets it to
+	// Vercel KV when KV_REST_API_URL and KV_REST_API_TOKEN are set; it is
+	// in memory when nil.
+	Store store.Store
+
+	// UnwrapStoreKey, when set, decrypts the keys of STORE_ENCRYPTION_KEYS,
+	// which then hold data keys encrypted by a KMS such as AWS KMS or GCP
+	// Cloud KMS.
+	UnwrapStoreKey func(ctx context.Context, wrapped []byte) ([]byte, error)
+
+	// Getenv looks up the settings outside this struct, such as the rules
+	// and the optional integrations. It defaults to os.Getenv.
+	Getenv func(string) string
+}
+
+// NewConfig returns a Config holding the defaults.
+func NewConfig() *Config {
+	return &Config{
+		Provider:            "github",
+		MemberCountRefresh:  5 * time.Minute,
+		ConfigCheckInterval: 10 * time.Second,
+		RequestTimeout:      10 * time.Second,
+		MaxBodyBytes:        1 << 20,
+		QueueInterval:       time.Second,
+		IdempotencyTTL:      24 * time.Hour,
+		LogLevel:            "info",
+		LogFormat:           "text",
+		Getenv:              os.Getenv,
+	}
+}
+
+// LoadConfig reads the Config from the environment and, with APP_ENV, the
+// profile of that name in PROFILES_FILE. It reports every invalid or missing
+// setting at once.
+func LoadConfig() (*Config, error) {
+	env, profile, err := profileEnv()
+	if err != nil {
+		return nil, err
+	}
+	cfg := NewConfig()
+	cfg.Profile, cfg.Getenv = profile, env
+	p := &envParser{getenv: env}
+	if v := env("PROVIDER"); v != "" {
+		cfg.Provider = v
+	}
+	cfg.GitHubClientID = env("GITHUB_CLIENT_ID")
+	cfg.GitHubClientSecret = env("GITHUB_CLIENT_SECRET")
+	cfg.GitHubOrg = env("GITHUB_ORG_NAME")
+	cfg.GitHubOrgs = parseOrgList("", env("GITHUB_ORGS"))
+	cfg.GitHubPAT = env("GITHUB_PAT")
+	cfg.GitHubURL = p.url("GITHUB_URL")
+
+	cfg.SuccessRedirectURL = p.url("SUCCESS_REDIRECT_URL")
+	cfg.ErrorRedirectURL = p.url("ERROR_REDIRECT_URL")
+	cfg.OrgFullRedirectURL = p.url("ORG_FULL_REDIRECT_URL")
+	cfg.ClosedRedirectURL = p.url("CLOSED_REDIRECT_URL")
+	cfg.UsedRedirectURL = p.url("USED_REDIRECT_URL")
+	cfg.CancelledRedirectURL = p.url("CANCELLED_REDIRECT_URL")
+	cfg.PublicURL = p.url("PUBLIC_URL")
+	cfg.ErrorCodeOnly = p.bool("ERROR_CODE_ONLY")
+	if cfg.RedirectStatuses, err = parseRedirectStatuses(env("REDIRECT_STATUS")); err != nil {
+		p.fail(fmt.Errorf("REDIRECT_STATUS: %v", err))
+	}
+
+	cfg.WaitlistWhenFull = p.bool("WAITLIST_WHEN_FULL")
+	cfg.WaitlistWhenClosed = p.bool("WAITLIST_WHEN_CLOSED")
+	cfg.MemberCountRefresh = p.duration("MEMBER_COUNT_REFRESH", cfg.MemberCountRefresh)
+	cfg.ConfigFile = env("CONFIG_FILE")
+	cfg.ConfigCheckInterval = p.duration("CONFIG_CHECK_INTERVAL", cfg.ConfigCheckInterval)
+
+	cfg.SigningKey = []byte(env("SIGNING_KEY"))
+	cfg.AdminToken = env("ADMIN_TOKEN")
+	cfg.AdminCredentials = env("ADMIN_CREDENTIALS")
+	cfg.APIToken = env("API_TOKEN")
+	cfg.CronSecret = env("CRON_SECRET")
+
+	cfg.RequestTimeout = p.duration("REQUEST_TIMEOUT", cfg.RequestTimeout)
+	if cfg.RouteTimeouts, err = parseRouteTimeouts(env("ROUTE_TIMEOUTS")); err != nil {
+		p.fail(fmt.Errorf("ROUTE_TIMEOUTS: %v", err))
+	}
+	cfg.MaxBodyBytes = int64(p.int("MAX_BODY_BYTES", int(cfg.MaxBodyBytes)))
+	cfg.QueueInterval = p.duration("QUEUE_INTERVAL", cfg.QueueInterval)
+	cfg.IdempotencyTTL = p.duration("IDEMPOTENCY_TTL", cfg.IdempotencyTTL)
+	if v := env("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if v := env("LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if kvURL := p.url("KV_REST_API_URL"); kvURL != nil {
+		if token := env("KV_REST_API_TOKEN"); token == "" {
+			p.fail(errors.New("KV_REST_API_TOKEN must be set with KV_REST_API_URL"))
+		} else {
+			cfg.Store = store.NewVercelKV(kvURL.String(), token, &http.Client{Timeout: storeTimeout})
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		p.fail(err)
+	}
+	if err := errors.Join(p.errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate checks the settings that LoadConfig cannot check one by one.
+func (cfg *Config) validate() error {
+	var errs []error
+	if cfg.SuccessRedirectURL == nil || cfg.ErrorRedirectURL == nil {
+		errs = append(errs, errors.New("SUCCESS_REDIRECT_URL and ERROR_REDIRECT_URL must be set"))
+	}
+	switch cfg.Provider {
+	case "", "github":
+		if cfg.GitHubClientID == "" || cfg.GitHubClientSecret == "" || (cfg.GitHubOrg == "" && len(cfg.GitHubOrgs) == 0) || cfg.GitHubPAT == "" {
+			errs = append(errs, errors.New("GITHUB_CLIENT_ID, GITHUB_CLIENT_SECRET, GITHUB_ORG_NAME, and GITHUB_PAT must be set"))
+		}
+	case "bitbucket":
+	default:
+		errs = append(errs, fmt.Errorf("unknown PROVIDER %q; expected github or bitbucket", cfg.Provider))
+	}
+	if len(cfg.SigningKey) > 0 && len(cfg.SigningKey) < minSigningKeyLen {
+		errs = append(errs, fmt.Errorf("SIGNING_KEY must be at least %d bytes long", minSigningKeyLen))
+	}
+	if _, err := parseAdminCredentials(cfg.AdminCredentials); err != nil {
+		errs = append(errs, fmt.Errorf("ADMIN_CREDENTIALS: %v", err))
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: %v", err))
+	}
+	if f := strings.ToLower(cfg.LogFormat); f != "" && f != "text" && f != "json" {
+		errs = append(errs, fmt.Errorf("unknown LOG_FORMAT %q; expected text or json", cfg.LogFormat))
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("MAX_BODY_BYTES must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// Configure sets up the invite flow from cfg instead of the environment. It
+// must be called before the first request, and only once.
+func Configure(cfg *Config) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	configured := false
+	initOnce.Do(func() {
+		applyConfig(cfg)
+		configured = true
+	})
+	if !configured {
+		return errors.New("invite: already configured")
+	}
+	return nil
+}
+
+// initVars loads the configuration from the environment once.
+func initVars() {
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("FATAL: Invalid configuration:\n%v", err)
+	}
+	applyConfig(cfg)
+}
+
+// applyConfig makes cfg the active configuration, and sets up the OAuth
+// config, the rules, and the optional integrations.
+func applyConfig(cfg *Config) {
+	defer func(start time.Time) { initTook = time.Since(start) }(time.Now())
+	setupLogging(cfg)
+	if cfg.Getenv != nil {
+		getenv = cfg.Getenv
+	}
+	registerSecrets(cfg)
+	if cfg.Store != nil {
+		dataStore = cfg.Store
+	}
+	loadStoreEncryptionConfig(cfg)
+	loadStorageDegradeConfig()
+	if cfg.Profile != "" {
+		log.Printf("Using the %s profile", cfg.Profile)
+	}
+	githubClientID = cfg.GitHubClientID
+	githubClientSecret = cfg.GitHubClientSecret
+	githubOrgName = cfg.GitHubOrg
+	githubOrgs = parseOrgList(githubOrgName, strings.Join(cfg.GitHubOrgs, ","))
+	if githubOrgName == "" && len(githubOrgs) > 0 {
+		githubOrgName = githubOrgs[0]
+	}
+	githubPat = cfg.GitHubPAT
+	forgetCapabilities()
+	githubURL = strings.TrimSuffix(urlString(cfg.GitHubURL), "/")
+	adminGitHub = newAdminGitHub()
+	successRedirectURL = urlString(cfg.SuccessRedirectURL)
+	errorRedirectURL = urlString(cfg.ErrorRedirectURL)
+	errorCodeOnly = cfg.ErrorCodeOnly
+	webhookSecret = []byte(getenv("WEBHOOK_SIGNING_SECRET"))
+
+	switch cfg.Provider {
+	case "", "github":
+		activeProvider = githubProvider{}
+	case "bitbucket":
+		p, err := newBitbucketProvider()
+		if err != nil {
+			log.Fatalf("FATAL: %v.", err)
+		}
+		activeProvider = p
+	}
+
+	oauthConf = &oauth2.Config{
+		ClientID:     githubClientID,
+		ClientSecret: githubClientSecret,
+		Scopes:       []string{"read:user"},
+		Endpoint:     githubOAuthEndpoint(),
+	}
+	orgFullRedirectURL = urlString(cfg.OrgFullRedirectURL)
+	waitlistWhenFull = cfg.WaitlistWhenFull
+	memberCountRefresh = cfg.MemberCountRefresh
+	closedRedirectURL = urlString(cfg.ClosedRedirectURL)
+	usedRedirectURL = urlString(cfg.UsedRedirectURL)
+	cancelledRedirectURL = urlString(cfg.CancelledRedirectURL)
+	loadSuccessParams()
+	redirectStatuses = cfg.RedirectStatuses
+	waitlistWhenClosed = cfg.WaitlistWhenClosed
+
+	configFile = cfg.ConfigFile
+	configCheckInterval = cfg.ConfigCheckInterval
+	if err := reloadRules(); err != nil {
+		log.Fatalf("FATAL: %v", err)
+	}
+
+	signingKey = cfg.SigningKey
+	loadSigningConfig()
+	adminToken = cfg.AdminToken
+	adminCredentials, _ = parseAdminCredentials(cfg.AdminCredentials)
+	if adminToken != "" {
+		adminCredentials = append(adminCredentials, adminCredential{name: "admin", role: roleOwner, token: adminToken})
+	}
+	publicURL = urlString(cfg.PublicURL)
+	requestTimeout = cfg.RequestTimeout
+	routeTimeouts = cfg.RouteTimeouts
+	maxBodyBytes = cfg.MaxBodyBytes
+	queueInterval = cfg.QueueInterval
+	loadWorkerConfig()
+	apiToken = cfg.APIToken
+	cronSecret = cfg.CronSecret
+	idempotencyTTL = cfg.IdempotencyTTL
+	loadTrustedProxiesConfig()
+	loadThrottleConfig()
+	loadDenylistConfig()
+	loadPowConfig()
+	loadBotSignalConfig()
+	loadLoadShedConfig()
+	loadRedirectCheckConfig()
+	loadRouteConfig()
+	loadLoginOriginConfig()
+	loadOnboardingConfig()
+	loadFlowTrailConfig()
+
+	// Optional integrations.
+	loadMailerConfig()
+	loadReminderConfig()
+	loadTwoFactorNudgeConfig()
+	loadDiscordConfig()
+	loadSlackConfig()
+	loadNPMConfig()
+	loadOIDCConfig()
+	loadTeamSyncConfig()
+	loadReputationConfig()
+	loadAbuseReportConfig()
+	loadApprovalConfig()
+	loadAdminLoginConfig()
+	loadStatusPageConfig()
+	loadResendConfig()
+	loadChecklistConfig()
+	loadAcceptanceConfig()
+	loadPublicMembershipConfig()
+	loadAnalyticsConfig()
+	loadAlertConfig()
+	loadSLOConfig()
+	registerSubscribers()
+	if slack != nil || reminderAfter > 0 || (acceptance != nil && acceptance.newsletter) {
+		oauthConf.Scopes = append(oauthConf.Scopes, "user:email")
+	}
+}
+
+// urlString returns u as a string, or "" when it is nil.
+func urlString(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.String()
+}
+
+// envParser reads typed environment variables for LoadConfig, collecting
+// the errors instead of stopping at the first.
+type envParser struct {
+	getenv func(string) string
+	errs   []error
+}
+
+func (p *envParser) fail(err error) { p.errs = append(p.errs, err) }
+
+// url reads an absolute http(s) URL, or nil when the variable is unset.
+func (p *envParser) url(name string) *url.URL {
+	v := p.getenv(name)
+	if v == "" {
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p.fail(fmt.Errorf("%s must be an absolute http or https URL, not %q", name, v))
+		return nil
+	}
+	return u
+}
+
+// value reads the setting name of kind, or "" when it is unset or invalid.
+func (p *envParser) value(name string, kind settingKind) string {
+	mustBeSetting(name, kind)
+	v := p.getenv(name)
+	if v == "" {
+		return ""
+	}
+	if err := kind.check(name, v); err != nil {
+		p.fail(err)
+		return ""
+	}
+	return v
+}
+
+func (p *envParser) bool(name string) bool {
+	b, _ := strconv.ParseBool(p.value(name, settingBool))
+	return b
+}
+
+func (p *envParser) int(name string, def int) int {
+	v := p.value(name, settingInt)
+	if v == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+func (p *envParser) duration(name string, def time.Duration) time.Duration {
+	v := p.value(name, settingDuration)
+	if v == "" {
+		return def
+	}
+	d, _ := time.ParseDuration(v)
+	return d
+}
+
+// envValue reads the setting name of kind, or "" when it is unset. Invalid
+// values are fatal so that typos don't silently disable a feature or a cap.
+func envValue(name string, kind settingKind) string {
+	mustBeSetting(name, kind)
+	v := getenv(name)
+	if v == "" {
+		return ""
+	}
+	if err := kind.check(name, v); err != nil {
+		log.Fatalf("FATAL: %v.", err)
+	}
+	return v
+}
+
+// envBool reads a boolean environment variable, false when it is unset.
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(envValue(name, settingBool))
+	return b
+}
+
+// envInt reads an integer environment variable, returning def when it is
+// unset.
+func envInt(name string, def int) int {
+	v := envValue(name, settingInt)
+	if v == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// envDuration reads a duration environment variable such as "5m", returning
+// def when it is unset.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := envValue(name, settingDuration)
+	if v == "" {
+		return def
+	}
+	d, _ := time.ParseDuration(v)
+	return d
+}
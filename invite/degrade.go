@@ -0,0 +1,263 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Modes of STORE_DEGRADE_MODE.
+const (
+	// degradeFailOpen keeps sign-ins going while the store is down: reads
+	// find nothing, writes are dropped, and nothing is logged. The flow
+	// state travels in the OAuth state parameter, so sign-ins still work,
+	// without the limits, replay checks, and logs the store backs.
+	degradeFailOpen = "fail-open"
+	// degradeFailClosed turns sign-ins away with ErrStorageUnavailable
+	// while the store is down, without waiting on it for every request.
+	degradeFailClosed = "fail-closed"
+)
+
+// degradingStore wraps the store of a deployment with STORE_DEGRADE_MODE.
+// Once a call fails, the store is considered down, and calls are not sent
+// to it again until retry has passed.
+type degradingStore struct {
+	store.Store
+	mode  string
+	retry time.Duration
+
+	mu      sync.Mutex
+	err     error // The last error, while down
+	since   time.Time
+	retryAt time.Time
+}
+
+// errStorageDown is returned in fail-closed mode by calls not sent to the
+// store while it is down.
+var errStorageDown = errors.New("storage is unavailable")
+
+// loadStorageDegradeConfig reads STORE_DEGRADE_MODE, fail-open or
+// fail-closed, and STORE_RETRY_INTERVAL, how long the store is left alone
+// after failing. Without a mode, store errors fail the requests that hit
+// them.
+func loadStorageDegradeConfig() {
+	mode := getenv("STORE_DEGRADE_MODE")
+	if mode == "" {
+		return
+	}
+	if mode != degradeFailOpen && mode != degradeFailClosed {
+		log.Fatalf("FATAL: STORE_DEGRADE_MODE must be %s or %s, not %q.", degradeFailOpen, degradeFailClosed, mode)
+	}
+	s := &degradingStore{Store: dataStore, mode: mode, retry: envDuration("STORE_RETRY_INTERVAL", 30*time.Second)}
+	if s.retry <= 0 {
+		log.Fatal("FATAL: STORE_RETRY_INTERVAL must be positive.")
+	}
+	dataStore = s
+	expvar.Publish("storage", expvar.Func(func() any { return storageHealth() }))
+}
+
+// skip reports whether a call is left out because the store is down.
+func (s *degradingStore) skip() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil && time.Now().Before(s.retryAt)
+}
+
+// observe records the outcome of a call sent to the store, and reports
+// whether it failed because the store is down. Missing keys and cancelled
+// requests say nothing about the store, nor do values it cannot decrypt.
+func (s *degradingStore) observe(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	down := err != nil && !errors.Is(err, store.ErrNotFound) && !errors.Is(err, store.ErrUndecryptable)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case down && s.err == nil:
+		log.Printf("ERROR: Storage is unavailable, running %s: %v", s.mode, err)
+		s.since = time.Now()
+		fallthrough
+	case down:
+		s.err, s.retryAt = err, time.Now().Add(s.retry)
+	case s.err != nil:
+		log.Printf("Storage is available again after %s", time.Since(s.since).Round(time.Second))
+		s.err = nil
+	}
+	return down
+}
+
+// degraded returns the error of a call made while the store is down: nil
+// in fail-open mode, where the caller returns an empty result, or else
+// err.
+func (s *degradingStore) degraded(err error) error {
+	if s.mode == degradeFailOpen {
+		return nil
+	}
+	return err
+}
+
+func (s *degradingStore) Get(ctx context.Context, key string) ([]byte, error) {
+	if s.skip() {
+		return nil, s.degradedRead(errStorageDown)
+	}
+	b, err := s.Store.Get(ctx, key)
+	if s.observe(ctx, err) {
+		return nil, s.degradedRead(err)
+	}
+	return b, err
+}
+
+// degradedRead is degraded for calls that read a value, which find nothing
+// in fail-open mode.
+func (s *degradingStore) degradedRead(err error) error {
+	if s.mode == degradeFailOpen {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+func (s *degradingStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if s.skip() {
+		return s.degraded(errStorageDown)
+	}
+	err := s.Store.Set(ctx, key, value, ttl)
+	if s.observe(ctx, err) {
+		return s.degraded(err)
+	}
+	return err
+}
+
+func (s *degradingStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	if s.skip() {
+		return true, s.degraded(errStorageDown)
+	}
+	ok, err := s.Store.SetNX(ctx, key, value, ttl)
+	if s.observe(ctx, err) {
+		return true, s.degraded(err)
+	}
+	return ok, err
+}
+
+func (s *degradingStore) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	if s.skip() {
+		return 1, s.degraded(errStorageDown)
+	}
+	n, err := s.Store.Incr(ctx, key, ttl)
+	if s.observe(ctx, err) {
+		return 1, s.degraded(err)
+	}
+	return n, err
+}
+
+func (s *degradingStore) Delete(ctx context.Context, key string) error {
+	if s.skip() {
+		return s.degraded(errStorageDown)
+	}
+	err := s.Store.Delete(ctx, key)
+	if s.observe(ctx, err) {
+		return s.degraded(err)
+	}
+	return err
+}
+
+func (s *degradingStore) Append(ctx context.Context, key string, value []byte) (int64, error) {
+	if s.skip() {
+		return 0, s.degraded(errStorageDown)
+	}
+	n, err := s.Store.Append(ctx, key, value)
+	if s.observe(ctx, err) {
+		return 0, s.degraded(err)
+	}
+	return n, err
+}
+
+func (s *degradingStore) Pop(ctx context.Context, key string) ([]byte, error) {
+	if s.skip() {
+		return nil, s.degradedRead(errStorageDown)
+	}
+	b, err := s.Store.Pop(ctx, key)
+	if s.observe(ctx, err) {
+		return nil, s.degradedRead(err)
+	}
+	return b, err
+}
+
+func (s *degradingStore) Range(ctx context.Context, key string, start, stop int64) ([][]byte, error) {
+	if s.skip() {
+		return nil, s.degraded(errStorageDown)
+	}
+	values, err := s.Store.Range(ctx, key, start, stop)
+	if s.observe(ctx, err) {
+		return nil, s.degraded(err)
+	}
+	return values, err
+}
+
+// storageStatus is the health of the store, for health checks.
+type storageStatus struct {
+	Mode     string     `json:"mode"`
+	Degraded bool       `json:"degraded"`
+	Since    *time.Time `json:"since,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// storageHealth returns the health of the store, or nil without
+// STORE_DEGRADE_MODE.
+func storageHealth() *storageStatus {
+	s, ok := dataStore.(*degradingStore)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := &storageStatus{Mode: s.mode}
+	if s.err != nil {
+		since := s.since
+		status.Degraded, status.Since, status.Error = true, &since, redact(s.err.Error())
+	}
+	return status
+}
+
+// storageDown returns the error the store last failed with while it is
+// down, or nil.
+func storageDown() error {
+	if s, ok := dataStore.(*degradingStore); ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.err
+	}
+	return nil
+}
+
+// storageClosed reports whether sign-ins are turned away because the store
+// is down in fail-closed mode. Once the retry interval has passed, the next
+// sign-in tries the store again.
+func storageClosed() bool {
+	s, ok := dataStore.(*degradingStore)
+	return ok && s.mode == degradeFailClosed && s.skip()
+}
+
+// Readiness reports whether this instance can take sign-ins, with a short
+// status for a readiness probe. While the store is down, a fail-open
+// deployment is ready but degraded, and a fail-closed one is not ready. A
+// store that is down is tried again once the retry interval has passed, so
+// that an instance taken out of rotation comes back by itself.
+func Readiness(ctx context.Context) (status string, ready bool) {
+	if s, ok := dataStore.(*degradingStore); ok && storageDown() != nil && !s.skip() {
+		s.Get(ctx, "warmup")
+	}
+	switch h := storageHealth(); {
+	case h == nil || !h.Degraded:
+		return "ok", true
+	case h.Mode == degradeFailOpen:
+		return "degraded: storage unavailable, running without it", true
+	default:
+		return "storage unavailable", false
+	}
+}
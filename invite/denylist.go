@@ -0,0 +1,185 @@
+package invite
+
+import (
+	"bufio"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// denylist blocks requests by client address or user agent before they are
+// routed, so that known scrapers and abusive ranges cost no GitHub calls.
+type denylist struct {
+	prefixes   []netip.Prefix
+	userAgents []string // Lower-case substrings
+}
+
+var (
+	staticDenylist  denylist // From DENY_IPS and DENY_USER_AGENTS
+	denylistURL     string   // Remote list, from DENYLIST_URL
+	denylistRefresh time.Duration
+	activeDenylist  atomic.Pointer[denylist]
+
+	denylistFetch struct {
+		sync.Mutex
+		checked time.Time
+		running bool
+	}
+)
+
+// denylistBlocked counts blocked requests by what matched.
+var denylistBlocked = expvar.NewMap("denylist_blocked")
+
+// loadDenylistConfig reads DENY_IPS and DENY_USER_AGENTS, comma-separated
+// lists of addresses or CIDR ranges and of user agent substrings, and
+// DENYLIST_URL, a remote list refreshed every DENYLIST_REFRESH.
+func loadDenylistConfig() {
+	var err error
+	if staticDenylist, err = parseDenylist(strings.Split(getenv("DENY_IPS"), ",")); err != nil {
+		log.Fatalf("FATAL: Invalid DENY_IPS: %v", err)
+	}
+	for _, ua := range strings.Split(getenv("DENY_USER_AGENTS"), ",") {
+		if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+			staticDenylist.userAgents = append(staticDenylist.userAgents, ua)
+		}
+	}
+	denylistURL = getenv("DENYLIST_URL")
+	denylistRefresh = envDuration("DENYLIST_REFRESH", time.Hour)
+	list := staticDenylist
+	activeDenylist.Store(&list)
+	if denylistURL != "" {
+		denylistFetch.checked = time.Now()
+		if err := refreshDenylist(context.Background()); err != nil {
+			log.Printf("ERROR: Could not fetch DENYLIST_URL, using the static entries: %v", err)
+		}
+	}
+}
+
+// parseDenylist parses address and CIDR entries, and user agent entries
+// given as "ua:substring". Blank entries and "#" comments are skipped.
+func parseDenylist(entries []string) (denylist, error) {
+	var list denylist
+	for _, entry := range entries {
+		entry, _, _ = strings.Cut(entry, "#")
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		if ua, ok := strings.CutPrefix(entry, "ua:"); ok {
+			if ua = strings.ToLower(strings.TrimSpace(ua)); ua != "" {
+				list.userAgents = append(list.userAgents, ua)
+			}
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return denylist{}, err
+		}
+		list.prefixes = append(list.prefixes, prefix)
+	}
+	return list, nil
+}
+
+// refreshDenylist fetches DENYLIST_URL, one entry per line, and replaces the
+// remote part of the active denylist.
+func refreshDenylist(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, denylistURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("denylist: %s", resp.Status)
+	}
+	var lines []string
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, 8<<20))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	remote, err := parseDenylist(lines)
+	if err != nil {
+		return err
+	}
+	list := denylist{
+		prefixes:   append(append([]netip.Prefix(nil), staticDenylist.prefixes...), remote.prefixes...),
+		userAgents: append(append([]string(nil), staticDenylist.userAgents...), remote.userAgents...),
+	}
+	activeDenylist.Store(&list)
+	return nil
+}
+
+// maybeRefreshDenylist starts a refresh of the remote denylist once it is
+// DENYLIST_REFRESH old. Requests keep using the current list meanwhile.
+func maybeRefreshDenylist() {
+	if denylistURL == "" {
+		return
+	}
+	denylistFetch.Lock()
+	defer denylistFetch.Unlock()
+	if denylistFetch.running || time.Since(denylistFetch.checked) < denylistRefresh {
+		return
+	}
+	denylistFetch.checked, denylistFetch.running = time.Now(), true
+	go func() {
+		if err := refreshDenylist(context.Background()); err != nil {
+			log.Printf("ERROR: Could not refresh DENYLIST_URL, keeping the current list: %v", err)
+		}
+		denylistFetch.Lock()
+		denylistFetch.running = false
+		denylistFetch.Unlock()
+	}()
+}
+
+// blockedBy returns what about r is denylisted, "ip" or "user_agent", or ""
+// when r may go ahead.
+func (list *denylist) blockedBy(r *http.Request) string {
+	if len(list.prefixes) > 0 {
+		if addr, err := netip.ParseAddr(clientIP(r)); err == nil {
+			addr = addr.Unmap()
+			for _, p := range list.prefixes {
+				if p.Contains(addr) {
+					return "ip"
+				}
+			}
+		}
+	}
+	if len(list.userAgents) > 0 {
+		ua := strings.ToLower(r.UserAgent())
+		for _, s := range list.userAgents {
+			if strings.Contains(ua, s) {
+				return "user_agent"
+			}
+		}
+	}
+	return ""
+}
+
+// withDenylist rejects denylisted requests before h sees them.
+func withDenylist(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if list := activeDenylist.Load(); list != nil {
+			if by := list.blockedBy(r); by != "" {
+				denylistBlocked.Add(by, 1)
+				writeJSONError(w, ErrForbidden)
+				return
+			}
+		}
+		h(w, r)
+	}
+}
@@ -0,0 +1,183 @@
+package invite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// discordAPI is the base URL of the Discord API.
+const discordAPI = "https://discord.com/api/v10"
+
+// discordConfig is the optional Discord auto-join step. After a successful
+// invitation the user is sent through a second OAuth flow (identify and
+// guilds.join) and added to the guild by the bot.
+type discordConfig struct {
+	oauth    *oauth2.Config
+	botToken string
+	guildID  string
+	roleID   string // Optional role given on join
+}
+
+// discord is nil when the Discord step is disabled.
+var discord *discordConfig
+
+// loadDiscordConfig reads the Discord settings. The step is enabled by
+// setting DISCORD_GUILD_ID.
+func loadDiscordConfig() {
+	guildID := getenv("DISCORD_GUILD_ID")
+	if guildID == "" {
+		return
+	}
+	d := &discordConfig{
+		oauth: &oauth2.Config{
+			ClientID:     getenv("DISCORD_CLIENT_ID"),
+			ClientSecret: getenv("DISCORD_CLIENT_SECRET"),
+			Scopes:       []string{"identify", "guilds.join"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:   "https://discord.com/oauth2/authorize",
+				TokenURL:  discordAPI + "/oauth2/token",
+				AuthStyle: oauth2.AuthStyleInParams,
+			},
+		},
+		botToken: getenv("DISCORD_BOT_TOKEN"),
+		guildID:  guildID,
+		roleID:   getenv("DISCORD_ROLE_ID"),
+	}
+	if d.oauth.ClientID == "" || d.oauth.ClientSecret == "" || d.botToken == "" {
+		log.Fatal("FATAL: DISCORD_CLIENT_ID, DISCORD_CLIENT_SECRET, and DISCORD_BOT_TOKEN must be set when DISCORD_GUILD_ID is set.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when the Discord step is enabled.")
+	}
+	discord = d
+}
+
+// startDiscord sends an invited user on to the Discord authorization page.
+// The step token is the OAuth state, tying the Discord account to the user.
+func startDiscord(w http.ResponseWriter, r *http.Request, token string) {
+	conf := *discord.oauth
+	conf.RedirectURL = publicBaseURL(r) + "/discord/callback"
+	http.Redirect(w, r, conf.AuthCodeURL(token), redirectStatus(r, redirectLogin))
+}
+
+// handleDiscordCallback adds the user to the guild after they authorized the
+// Discord application. The GitHub invitation has already been sent at this
+// point, so every outcome ends on the success page; the discord query
+// parameter tells the page whether joining worked.
+func handleDiscordCallback(w http.ResponseWriter, r *http.Request) {
+	if discord == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	state, err := parseStepToken(r.FormValue("state"))
+	if err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+
+	if r.FormValue("error") != "" {
+		log.Printf("%s skipped the Discord step: %s", state.Username, r.FormValue("error"))
+		redirectToSuccess(w, r, state, url.Values{"discord": {"skipped"}})
+		return
+	}
+
+	ctx := r.Context()
+	conf := *discord.oauth
+	conf.RedirectURL = publicBaseURL(r) + "/discord/callback"
+	token, err := conf.Exchange(ctx, r.FormValue("code"))
+	if err != nil {
+		log.Printf("Discord code exchange for %s failed: %v", state.Username, redactError(err, r.FormValue("code")))
+		redirectToSuccess(w, r, state, url.Values{"discord": {"failed"}})
+		return
+	}
+
+	discordID, err := discord.join(ctx, conf.Client(ctx, token), token.AccessToken)
+	if err != nil {
+		log.Printf("Adding %s to the Discord guild failed: %v", state.Username, err)
+		redirectToSuccess(w, r, state, url.Values{"discord": {"failed"}})
+		return
+	}
+
+	log.Printf("Linked %s to Discord user %s", state.Username, discordID)
+	recordActivity(ctx, activityEvent{
+		Type:     activityLinked,
+		Provider: activeProvider.Name(),
+		UserID:   state.UserID,
+		Username: state.Username,
+		Campaign: state.Campaign,
+		Links:    map[string]string{"discord": discordID},
+	})
+	redirectToSuccess(w, r, state, url.Values{"discord": {"joined"}})
+}
+
+// join adds the Discord user owning accessToken to the guild, with the
+// configured role, and returns their Discord user ID.
+func (d *discordConfig) join(ctx context.Context, userClient *http.Client, accessToken string) (string, error) {
+	resp, err := userClient.Get(discordAPI + "/users/@me")
+	if err != nil {
+		return "", err
+	}
+	var me struct {
+		ID string `json:"id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&me)
+	resp.Body.Close()
+	if err != nil || me.ID == "" {
+		return "", fmt.Errorf("reading Discord user: %v (status %s)", err, resp.Status)
+	}
+
+	body := map[string]any{"access_token": accessToken}
+	if d.roleID != "" {
+		body["roles"] = []string{d.roleID}
+	}
+	status, err := d.botRequest(ctx, http.MethodPut, fmt.Sprintf("/guilds/%s/members/%s", d.guildID, me.ID), body)
+	if err != nil {
+		return "", err
+	}
+	// 204 means they were already in the guild, in which case the roles in
+	// the body are ignored and must be added separately.
+	if status == http.StatusNoContent && d.roleID != "" {
+		if _, err := d.botRequest(ctx, http.MethodPut, fmt.Sprintf("/guilds/%s/members/%s/roles/%s", d.guildID, me.ID, d.roleID), nil); err != nil {
+			return "", err
+		}
+	}
+	return me.ID, nil
+}
+
+// botRequest calls the Discord API as the bot and returns the status code.
+func (d *discordConfig) botRequest(ctx context.Context, method, path string, body any) (int, error) {
+	var rd io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		rd = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, discordAPI+path, rd)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bot "+d.botToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp.StatusCode, fmt.Errorf("discord: %s %s: %s: %s", method, path, resp.Status, msg)
+	}
+	return resp.StatusCode, nil
+}
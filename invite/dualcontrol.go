@@ -0,0 +1,57 @@
+package invite
+
+import (
+	"fmt"
+	"strings"
+)
+
+// reasonDualControl is the reason of approvals queued only because the
+// invitation grants a privileged role.
+const reasonDualControl = "dual_control"
+
+// dualControlRoles are the org roles and custom org roles whose invitations
+// wait for a second admin's approval, from DUAL_CONTROL_ROLES.
+var dualControlRoles []string
+
+// parseDualControlRoles parses DUAL_CONTROL_ROLES, a ","-separated list of
+// org roles, such as admin, and custom org role names.
+func parseDualControlRoles(spec string) ([]string, error) {
+	var roles []string
+	for _, role := range strings.Split(spec, ",") {
+		if role = strings.TrimSpace(role); role == "" {
+			continue
+		}
+		if !validOrgRoleName.MatchString(role) {
+			return nil, fmt.Errorf("invalid role %q", role)
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// needsDualControl reports whether an invitation with opts grants one of
+// the DUAL_CONTROL_ROLES. Invite links carry the roles of the admin who
+// minted them, so a second admin has to approve before one is used to make
+// someone an admin.
+func needsDualControl(opts inviteOptions) bool {
+	for _, role := range dualControlRoles {
+		if strings.EqualFold(role, opts.Role) || strings.EqualFold(role, opts.OrgRole) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSecondAdmin checks that the admin named actor may approve a, which
+// needs dual control: a named admin, other than the one who minted the
+// link. The one-click links of notifications name no admin, so they can
+// only deny.
+func checkSecondAdmin(a *approval, actor string) error {
+	switch {
+	case actor == "":
+		return ErrForbidden.WithMessage("This invitation grants a privileged role, so a second admin has to approve it from the admin API.")
+	case strings.EqualFold(actor, a.MintedBy):
+		return ErrForbidden.WithMessage("The admin who minted the link cannot approve the invitation it grants; a second admin has to.")
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package invite
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// Error is a failure in the invite flow. Its Code is stable and is what the
+// error page receives as the error_code query parameter (or the "code" field
+// of a JSON error response), so downstream pages can branch on it.
+//
+// Documented codes:
+//
+//	invalid_state          the OAuth state parameter did not match
+//	oauth_exchange_failed  the authorization code could not be exchanged
+//	authorization_denied   the user cancelled the authorization on the provider's page
+//	callback_used          the sign-in callback was opened again, e.g. by a refresh a minute later
+//	user_info_failed       the GitHub profile could not be fetched
+//	invalid_profile        the provider returned a profile without a login or ID
+//	bot_account            the account is a bot, which cannot be invited
+//	account_suspended      GitHub has suspended or hidden the account
+//	already_member         the user is already a member of the org
+//	already_invited        the user already has a pending invitation
+//	invite_rate_limited    GitHub rate limited the invitation request
+//	throttled              too many sign-ups came from the user's network recently
+//	invalid_proof          the proof-of-work answer is missing, wrong, expired, or reused
+//	org_full               the org has no seats left for new members
+//	membership_closed      the org has reached its configured member cap
+//	requirements_not_met   the account is below a follower, repository, or contribution minimum
+//	spam_suspected         the account's spam score reached the threshold
+//	rejected               the reputation service denied the account
+//	pending_review         the reputation service flagged the account for review
+//	waitlisted             membership is closed and the user joined the waitlist
+//	unknown_org            the deployment does not serve the requested org
+//	invites_not_open       the request falls outside the scheduled invite windows
+//	invalid_link           the signed invite link is malformed or tampered with
+//	link_expired           the signed invite link has expired
+//	link_exhausted         the signed invite link has reached its maximum uses
+//	account_taken          the GitHub account is already linked to another SSO user
+//	invitation_failed      the invitation failed for any other reason
+//	high_demand            sign-ins are paused while the deployment sheds load
+//	origin_not_allowed     the sign-in was posted from a site not in LOGIN_ORIGINS
+//	config_error           the server is misconfigured
+//
+// API-only codes:
+//
+//	bad_request            the request body or parameters are invalid
+//	unauthorized           missing or invalid credentials
+//	forbidden              the credentials do not allow this action
+//	not_found              no such endpoint or resource
+//	method_not_allowed     the endpoint does not support the HTTP method
+//	conflict               the resource already exists
+//	payload_too_large      the request body exceeds MAX_BODY_BYTES
+//	request_timeout        the request took longer than its time limit
+type Error struct {
+	Code    string // Stable machine-readable code.
+	Message string // Human-readable message safe to show to the user.
+	Status  int    // HTTP status used for JSON responses.
+	Err     error  // Underlying cause, if any.
+}
+
+// The error taxonomy. Use errors.Is to test for a given kind; wrapped copies
+// created with Wrap or WithMessage still match their sentinel.
+var (
+	ErrInvalidState        = &Error{Code: "invalid_state", Message: "State token mismatch. Please try again.", Status: http.StatusBadRequest}
+	ErrOAuthExchange       = &Error{Code: "oauth_exchange_failed", Message: "Could not verify your GitHub login.", Status: http.StatusBadGateway}
+	ErrAuthorizationDenied = &Error{Code: "authorization_denied", Message: "You cancelled the authorization, so we couldn't invite you. Start again whenever you're ready.", Status: http.StatusForbidden}
+	ErrCallbackUsed        = &Error{Code: "callback_used", Message: "This sign-in link was already used. If you were invited, check your email; otherwise start again.", Status: http.StatusConflict}
+	ErrUserInfo            = &Error{Code: "user_info_failed", Message: "Could not fetch your GitHub profile.", Status: http.StatusBadGateway}
+	ErrInvalidProfile      = &Error{Code: "invalid_profile", Message: "GitHub returned an incomplete profile. Please try again.", Status: http.StatusBadGateway}
+	ErrBotAccount          = &Error{Code: "bot_account", Message: "Bot accounts can't be invited.", Status: http.StatusForbidden}
+	ErrAccountSuspended    = &Error{Code: "account_suspended", Message: "Your GitHub account is suspended, so it can't be invited.", Status: http.StatusForbidden}
+	ErrAlreadyMember       = &Error{Code: "already_member", Message: "You are already a member of the organization.", Status: http.StatusConflict}
+	ErrAlreadyInvited      = &Error{Code: "already_invited", Message: "You already have a pending invitation. Check your email or GitHub notifications.", Status: http.StatusConflict}
+	ErrInviteRateLimited   = &Error{Code: "invite_rate_limited", Message: "Too many invitations are being sent right now. Please try again later.", Status: http.StatusTooManyRequests}
+	ErrThrottled           = &Error{Code: "throttled", Message: "Too many sign-ups are coming from your network right now. Please try again later.", Status: http.StatusTooManyRequests}
+	ErrInvalidProof        = &Error{Code: "invalid_proof", Message: "The automatic anti-bot check failed. Please try again.", Status: http.StatusForbidden}
+	ErrOrgSeatLimit        = &Error{Code: "org_full", Message: "The organization has no seats left for new members.", Status: http.StatusConflict}
+	ErrMembershipClosed    = &Error{Code: "membership_closed", Message: "Membership is currently closed.", Status: http.StatusForbidden}
+	ErrRequirementsNotMet  = &Error{Code: "requirements_not_met", Message: "Your account doesn't meet the requirements to join.", Status: http.StatusForbidden}
+	ErrSuspectedSpam       = &Error{Code: "spam_suspected", Message: "Your account was flagged by our spam checks. Contact the organization if this is a mistake.", Status: http.StatusForbidden}
+	ErrSoftBanned          = &Error{Code: "temporarily_banned", Message: "You can't be invited right now. Contact the organization if this is a mistake.", Status: http.StatusForbidden}
+	ErrRejected            = &Error{Code: "rejected", Message: "We can't invite your account automatically. Contact the organization if this is a mistake.", Status: http.StatusForbidden}
+	ErrPendingReview       = &Error{Code: "pending_review", Message: "Your request needs a manual review. You'll hear from us soon.", Status: http.StatusAccepted}
+	ErrWaitlisted          = &Error{Code: "waitlisted", Message: "Membership is currently closed. You have been added to the waitlist.", Status: http.StatusAccepted}
+	ErrUnknownOrg          = &Error{Code: "unknown_org", Message: "You can't join this organization here.", Status: http.StatusNotFound}
+	ErrInvitesNotOpen      = &Error{Code: "invites_not_open", Message: "Invitations are not open right now.", Status: http.StatusForbidden}
+	ErrInvalidLink         = &Error{Code: "invalid_link", Message: "This invite link is invalid.", Status: http.StatusBadRequest}
+	ErrLinkExpired         = &Error{Code: "link_expired", Message: "This invite link has expired.", Status: http.StatusGone}
+	ErrLinkExhausted       = &Error{Code: "link_exhausted", Message: "This invite link has already been used the maximum number of times.", Status: http.StatusGone}
+	ErrAccountTaken        = &Error{Code: "account_taken", Message: "This GitHub account is already linked to another company account.", Status: http.StatusConflict}
+	ErrInvitationFailed    = &Error{Code: "invitation_failed", Message: "Failed to send the invitation.", Status: http.StatusBadGateway}
+	ErrHighDemand          = &Error{Code: "high_demand", Message: "We're experiencing high demand. Please try again in a few minutes.", Status: http.StatusServiceUnavailable}
+	ErrStorageUnavailable  = &Error{Code: "storage_unavailable", Message: "Sign-ins are unavailable right now. Please try again in a few minutes.", Status: http.StatusServiceUnavailable}
+	ErrOriginNotAllowed    = &Error{Code: "origin_not_allowed", Message: "Sign-ins can't be started from this site.", Status: http.StatusForbidden}
+	ErrConfig              = &Error{Code: "config_error", Message: "Server configuration error.", Status: http.StatusInternalServerError}
+	ErrBadRequest          = &Error{Code: "bad_request", Message: "The request is invalid.", Status: http.StatusBadRequest}
+	ErrUnauthorized        = &Error{Code: "unauthorized", Message: "Missing or invalid credentials.", Status: http.StatusUnauthorized}
+	ErrForbidden           = &Error{Code: "forbidden", Message: "Your credentials do not allow this action.", Status: http.StatusForbidden}
+	ErrNotFound            = &Error{Code: "not_found", Message: "Not found.", Status: http.StatusNotFound}
+	ErrMethodNotAllowed    = &Error{Code: "method_not_allowed", Message: "Method not allowed.", Status: http.StatusMethodNotAllowed}
+	ErrConflict            = &Error{Code: "conflict", Message: "The resource already exists.", Status: http.StatusConflict}
+	ErrRequestTimeout      = &Error{Code: "request_timeout", Message: "The request took too long.", Status: http.StatusServiceUnavailable}
+	ErrPayloadTooLarge     = &Error{Code: "payload_too_large", Message: "The request body is too large.", Status: http.StatusRequestEntityTooLarge}
+)
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Code, e.Err)
+	}
+	return e.Code
+}
+
+// Unwrap returns the underlying cause.
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is reports whether target is an *Error with the same code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e carrying err as its cause.
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// WithMessage returns a copy of e with a different user-facing message.
+func (e *Error) WithMessage(msg string) *Error {
+	c := *e
+	c.Message = msg
+	return &c
+}
+
+// asError converts any error into an *Error, falling back to fallback when
+// err does not carry a code of its own.
+func asError(err error, fallback *Error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return fallback.Wrap(err)
+}
+
+// classifyInviteError maps an error from the GitHub membership API onto the
+// error taxonomy.
+func classifyInviteError(err error) *Error {
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &rateErr) || errors.As(err, &abuseErr) {
+		return ErrInviteRateLimited.Wrap(err)
+	}
+
+	var respErr *github.ErrorResponse
+	if errors.As(err, &respErr) && respErr.Response != nil {
+		switch respErr.Response.StatusCode {
+		case http.StatusTooManyRequests:
+			return ErrInviteRateLimited.Wrap(err)
+		case http.StatusUnprocessableEntity:
+			if isSeatLimitMessage(respErr.Message) {
+				return ErrOrgSeatLimit.Wrap(err)
+			}
+		}
+	}
+	return ErrInvitationFailed.Wrap(err)
+}
@@ -0,0 +1,135 @@
+package invite
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"expvar"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// etagCacheSize bounds the responses kept for conditional requests.
+	etagCacheSize = 512
+	// etagMaxBody is the largest response body kept.
+	etagMaxBody = 256 << 10
+)
+
+// etagPaths are the GitHub API lookups repeated on every callback: org
+// metadata with the seat counts, and memberships. GitHub does not count a
+// 304 Not Modified against the rate limit.
+var etagPaths = regexp.MustCompile(`/(orgs/[^/]+|orgs/[^/]+/(members|memberships)/[^/]+|orgs/[^/]+/teams/[^/]+/memberships/[^/]+|user/memberships/orgs/[^/]+)$`)
+
+// etagStats counts the conditional requests to GitHub, for /debug/vars:
+// revalidated ones were answered from the cache, fetched ones had changed
+// or were not cached yet.
+var etagStats = expvar.NewMap("github_conditional_requests")
+
+// etagEntry is a cached response.
+type etagEntry struct {
+	etag   string
+	header http.Header
+	body   []byte
+	used   time.Time
+}
+
+// etagTransport makes the cacheable lookups conditional: it sends the ETag
+// of the last response with If-None-Match, and answers a 304 with the last
+// response. Every lookup still goes to GitHub, so none is stale.
+type etagTransport struct {
+	base http.RoundTripper
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*etagEntry
+}
+
+func newETagTransport(base http.RoundTripper) *etagTransport {
+	return &etagTransport{base: base, entries: make(map[[sha256.Size]byte]*etagEntry)}
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("If-None-Match") != "" || !etagPaths.MatchString(req.URL.Path) {
+		return t.base.RoundTrip(req)
+	}
+	// The credential is part of the key, as what a lookup returns depends
+	// on who asks.
+	key := sha256.Sum256([]byte(req.Header.Get("Authorization") + "\n" + req.URL.String()))
+	t.mu.Lock()
+	cached := t.entries[key]
+	t.mu.Unlock()
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		etagStats.Add("revalidated", 1)
+		t.mu.Lock()
+		cached.used = time.Now()
+		t.mu.Unlock()
+		header := cached.header.Clone()
+		for name, values := range resp.Header {
+			header[name] = values
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       resp.Request,
+		}, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		etagStats.Add("fetched", 1)
+		body, err := io.ReadAll(io.LimitReader(resp.Body, etagMaxBody+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		if len(body) <= etagMaxBody {
+			header := resp.Header.Clone()
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			t.store(key, &etagEntry{etag: resp.Header.Get("ETag"), header: header, body: body, used: time.Now()})
+		}
+		return resp, nil
+	default:
+		if cached != nil {
+			// Gone or failing: the next lookup starts over.
+			t.mu.Lock()
+			delete(t.entries, key)
+			t.mu.Unlock()
+		}
+		return resp, nil
+	}
+}
+
+// store caches e, making room by dropping the least recently used entry.
+func (t *etagTransport) store(key [sha256.Size]byte, e *etagEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.entries[key]; !ok && len(t.entries) >= etagCacheSize {
+		var oldest [sha256.Size]byte
+		var oldestUsed time.Time
+		for k, v := range t.entries {
+			if oldestUsed.IsZero() || v.used.Before(oldestUsed) {
+				oldest, oldestUsed = k, v.used
+			}
+		}
+		delete(t.entries, oldest)
+	}
+	t.entries[key] = e
+}
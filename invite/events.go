@@ -0,0 +1,117 @@
+package invite
+
+import (
+	"context"
+	"expvar"
+	"log"
+)
+
+// Events published on the event bus. The invite log, the metrics, and the
+// notifiers subscribe to them, so that the flow itself only has to publish.
+const (
+	eventInviteRequested = "invite_requested"   // An invitation is about to be sent
+	eventInviteSent      = activityInviteSent   // The platform accepted the invitation
+	eventInviteFailed    = activityInviteFailed // The user was turned away or the invitation failed
+	eventMemberJoined    = activityMemberJoined // An invited user accepted the invitation
+	eventLoginStarted    = "login_started"      // A visitor was sent to the provider to sign in
+	eventOAuthCompleted  = "oauth_completed"    // A user came back signed in from the provider
+)
+
+// busEvent is one event on the bus.
+type busEvent struct {
+	Type string
+	// User is the user the event is about. It is nil when only a username
+	// or email is known, as for API and queued invitations.
+	User *Identity
+	// Entry is the event as the invite log records it. Its Type is set from
+	// the event's.
+	Entry activityEvent
+	// Visitor is the visitor ID of the browser the event happened in, when
+	// there was one.
+	Visitor string
+}
+
+// A subscriber handles events from the bus. It runs synchronously, in the
+// publisher's request, unless registered with subscribeAsync; errors are the
+// subscriber's to log.
+type subscriber func(ctx context.Context, ev busEvent)
+
+// subscribers holds the subscribers by event type. It is only changed while
+// the configuration loads.
+var subscribers = make(map[string][]subscriber)
+
+// eventCounts counts the published events by type, for /debug/vars.
+var eventCounts = expvar.NewMap("invite_events")
+
+// subscribe registers fn for the given event types.
+func subscribe(fn subscriber, types ...string) {
+	for _, t := range types {
+		subscribers[t] = append(subscribers[t], fn)
+	}
+}
+
+// publish delivers ev to its subscribers, in the order they subscribed.
+func publish(ctx context.Context, ev busEvent) {
+	ev.Entry.Type = ev.Type
+	for _, fn := range subscribers[ev.Type] {
+		fn(ctx, ev)
+	}
+}
+
+// registerSubscribers subscribes the built-in integrations to the bus. It
+// runs once, after the optional integrations are configured.
+func registerSubscribers() {
+	subscribe(func(ctx context.Context, ev busEvent) {
+		eventCounts.Add(ev.Type, 1)
+	}, eventInviteRequested, eventInviteSent, eventInviteFailed, eventMemberJoined, eventLoginStarted, eventOAuthCompleted)
+	subscribe(func(ctx context.Context, ev busEvent) {
+		recordActivity(ctx, ev.Entry)
+	}, eventInviteSent, eventInviteFailed, eventMemberJoined)
+	if onboardingStatus {
+		subscribe(trackOnboarding, eventInviteRequested, eventInviteSent, eventInviteFailed, eventMemberJoined)
+	}
+	if loadShedErrorRate > 0 {
+		subscribe(countInviteOutcome, eventInviteSent, eventInviteFailed)
+	}
+	if _, ok := activeProvider.(membershipChecker); ok {
+		subscribe(trackPendingMember, eventInviteSent)
+	}
+	if _, ok := activeProvider.(githubProvider); ok {
+		subscribeAsync(assignOrgRole, eventMemberJoined)
+	}
+	if slack != nil && (acceptance == nil || !acceptance.slack) {
+		subscribeAsync(slackOnInviteSent, eventInviteSent)
+	}
+	if acceptance != nil {
+		subscribeAsync(runAcceptedActions, eventMemberJoined)
+	}
+	if publicMembership {
+		subscribeAsync(publicizeMembership, eventMemberJoined)
+	}
+	if alerts != nil {
+		subscribe(countAlertEvent, eventInviteSent, eventInviteFailed)
+	}
+	if analytics != nil {
+		subscribeAsync(sendFunnelEvent, eventLoginStarted, eventOAuthCompleted, eventInviteFailed, eventInviteSent, eventMemberJoined)
+	}
+}
+
+// slackInvitedKey marks users who were just sent a Slack invitation.
+const slackInvitedKey = "slack:invited:"
+
+// slackOnInviteSent sends the Slack invitation to users who signed in, once
+// the GitHub invitation is out.
+func slackOnInviteSent(ctx context.Context, ev busEvent) {
+	if ev.User == nil || !currentRules().flagEnabled(flagSlackInvite, ev.User.ID) {
+		return
+	}
+	// A sign-in that joins several orgs sends one Slack invitation.
+	if first, err := dataStore.SetNX(ctx, slackInvitedKey+ev.User.ID, nil, stepTTL); err == nil && !first {
+		return
+	}
+	if err := slack.invite(ctx, ev.User); err != nil {
+		log.Printf("Slack invitation for %s failed: %v", ev.User.Username, err)
+	} else {
+		log.Printf("Sent Slack invitation to %s", ev.User.Username)
+	}
+}
@@ -0,0 +1,191 @@
+package invite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// visitorCookie holds the random ID visitors are bucketed into experiments
+// by before they sign in.
+const visitorCookie = "auto_invite_visitor"
+
+// visitorTTL is how long a visitor keeps their variants.
+const visitorTTL = 365 * 24 * time.Hour
+
+// experimentLoginConfirm is the experiment of the page LOGIN_REQUIRE_POST
+// shows before a sign-in.
+const experimentLoginConfirm = "login_confirm"
+
+// variant is one version of the copy of a page under test. Empty fields
+// keep the page's own copy.
+type variant struct {
+	Weight int    `json:"weight"` // Share of visitors, relative to the other variants
+	Title  string `json:"title,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Button string `json:"button,omitempty"`
+}
+
+// experiment holds the variants of one experiment, by name.
+type experiment map[string]variant
+
+// parseExperiments parses EXPERIMENTS, a JSON object of experiments, each
+// mapping variant names to their weight and copy, for example:
+//
+//	{"landing": {"control": {"weight": 50}, "short": {"weight": 50, "text": "Join in one click."}}}
+//
+// Campaigns take part in one with experiment=name, and the page shown
+// before a sign-in with LOGIN_REQUIRE_POST is the login_confirm experiment.
+func parseExperiments(spec string) (map[string]experiment, error) {
+	experiments := make(map[string]experiment)
+	if spec == "" {
+		return experiments, nil
+	}
+	if err := json.Unmarshal([]byte(spec), &experiments); err != nil {
+		return nil, err
+	}
+	for name, exp := range experiments {
+		if !validPresetName.MatchString(name) {
+			return nil, fmt.Errorf("invalid experiment name %q", name)
+		}
+		total := 0
+		for v, c := range exp {
+			if !validPresetName.MatchString(v) {
+				return nil, fmt.Errorf("experiment %s: invalid variant name %q", name, v)
+			}
+			if c.Weight < 0 {
+				return nil, fmt.Errorf("experiment %s: variant %s has a negative weight", name, v)
+			}
+			total += c.Weight
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("experiment %s: needs a variant with a positive weight", name)
+		}
+	}
+	return experiments, nil
+}
+
+// variantFor returns the variant of the named experiment subject is in, or
+// "" when there is no such experiment. Like flagEnabled, it hashes the
+// experiment name and subject, so the same visitor or user always gets the
+// same variant while the weights stay the same.
+func (rs *ruleSet) variantFor(name, subject string) (string, variant) {
+	exp, ok := rs.experiments[name]
+	if !ok {
+		return "", variant{}
+	}
+	names := make([]string, 0, len(exp))
+	total := 0
+	for v, c := range exp {
+		names = append(names, v)
+		total += c.Weight
+	}
+	slices.Sort(names)
+	sum := sha256.Sum256([]byte(name + ":" + subject))
+	n := int(binary.BigEndian.Uint64(sum[:8]) % uint64(total))
+	for _, v := range names {
+		if n -= exp[v].Weight; n < 0 {
+			return v, exp[v]
+		}
+	}
+	return "", variant{}
+}
+
+// variantsFor returns the variant of every experiment of rs that subject is
+// in, by experiment name, or nil when there are none.
+func (rs *ruleSet) variantsFor(subject string) map[string]string {
+	if len(rs.experiments) == 0 || subject == "" {
+		return nil
+	}
+	variants := make(map[string]string, len(rs.experiments))
+	for name := range rs.experiments {
+		variants[name], _ = rs.variantFor(name, subject)
+	}
+	return variants
+}
+
+// visitorID returns the experiment ID of the visitor of r, giving them one
+// when they have none yet.
+func visitorID(w http.ResponseWriter, r *http.Request) string {
+	if id := visitorOf(r); id != "" {
+		return id
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	id := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     visitorCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(visitorTTL.Seconds()),
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+// visitorOf returns the visitor ID r carries, or "" when it has none.
+func visitorOf(r *http.Request) string {
+	if c, err := r.Cookie(visitorCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// experimentSubject is who a sign-in is bucketed by: the visitor ID the
+// pages showed their variants by, or else the user.
+func experimentSubject(r *http.Request, user *Identity) string {
+	if id := visitorOf(r); id != "" {
+		return id
+	}
+	return user.ID
+}
+
+// variantStats counts outcomes of the sign-ins in one variant.
+type variantStats struct {
+	SignIns int `json:"sign_ins"`
+	Invited int `json:"invited"`
+}
+
+// handleExperiments reports the configured experiments and, from the invite
+// log, how many sign-ins each variant had and how many of them ended in an
+// invitation.
+func handleExperiments(w http.ResponseWriter, r *http.Request) {
+	events, err := dataStore.Range(r.Context(), activityKey, 0, -1)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	rs := currentRules()
+	results := make(map[string]map[string]*variantStats, len(rs.experiments))
+	for name, exp := range rs.experiments {
+		results[name] = make(map[string]*variantStats, len(exp))
+		for v := range exp {
+			results[name][v] = &variantStats{}
+		}
+	}
+	for _, b := range events {
+		var ev activityEvent
+		if json.Unmarshal(b, &ev) != nil || ev.Type != activityInviteSent && ev.Type != activityInviteFailed {
+			continue
+		}
+		for name, v := range ev.Variants {
+			stats, ok := results[name][v]
+			if !ok {
+				continue
+			}
+			stats.SignIns++
+			if ev.Type == activityInviteSent {
+				stats.Invited++
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"experiments": rs.experiments, "results": results})
+}
@@ -0,0 +1,232 @@
+package invite
+
+import "strings"
+
+// ConfigSetting is one setting of ExportConfig.
+type ConfigSetting struct {
+	Name    string `json:"name"`
+	Feature string `json:"feature"`
+	Value   string `json:"value"`            // The current value, or a placeholder
+	Secret  bool   `json:"secret,omitempty"` // Never exported; Value is SecretPlaceholder
+	Missing bool   `json:"missing,omitempty"`
+}
+
+// Placeholders of ExportConfig.
+const (
+	SecretPlaceholder   = "<secret>"   // A secret, to be set from a secret store
+	RequiredPlaceholder = "<required>" // A required setting that is not set
+)
+
+// configFeature is a group of settings that are needed together.
+type configFeature struct {
+	name     string
+	enabled  func(getenv func(string) string) bool // Always enabled when nil
+	required []string
+	optional []string // Exported only when set
+}
+
+// anySet returns an enabled func for a feature turned on by any of names.
+func anySet(names ...string) func(func(string) string) bool {
+	return func(getenv func(string) string) bool {
+		for _, name := range names {
+			if getenv(name) != "" {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// configFeatures lists the settings of each feature, so that an export holds
+// the full set the enabled features need.
+var configFeatures = []configFeature{
+	{name: "core", required: []string{"SUCCESS_REDIRECT_URL", "ERROR_REDIRECT_URL"}, optional: []string{
+		"PUBLIC_URL", "ORG_FULL_REDIRECT_URL", "CLOSED_REDIRECT_URL", "USED_REDIRECT_URL", "CANCELLED_REDIRECT_URL",
+		"REDIRECT_STATUS", "ERROR_CODE_ONLY", "WAITLIST_WHEN_FULL", "WAITLIST_WHEN_CLOSED", "MEMBER_COUNT_REFRESH",
+		"CONFIG_FILE", "CONFIG_CHECK_INTERVAL", "REQUEST_TIMEOUT", "ROUTE_TIMEOUTS", "MAX_BODY_BYTES",
+		"QUEUE_INTERVAL", "WORKER_CONCURRENCY", "IDEMPOTENCY_TTL", "CRON_SECRET", "WEBHOOK_SIGNING_SECRET",
+		"LOG_LEVEL", "LOG_FORMAT", "REDIRECT_CHECK_INTERVAL", "UNKNOWN_PATHS", "ROUTES",
+		"LOGIN_ORIGINS", "LOGIN_REQUIRE_POST", "ONBOARDING_STATUS",
+	}},
+	{
+		name:     "github",
+		enabled:  func(getenv func(string) string) bool { p := getenv("PROVIDER"); return p == "" || p == "github" },
+		required: []string{"GITHUB_CLIENT_ID", "GITHUB_CLIENT_SECRET", "GITHUB_ORG_NAME", "GITHUB_PAT"},
+		optional: []string{"GITHUB_ORGS", "GITHUB_URL"},
+	},
+	{
+		name:     "bitbucket",
+		enabled:  func(getenv func(string) string) bool { return getenv("PROVIDER") == "bitbucket" },
+		required: []string{"PROVIDER", "BITBUCKET_CLIENT_ID", "BITBUCKET_CLIENT_SECRET", "BITBUCKET_WORKSPACE", "BITBUCKET_GROUP", "BITBUCKET_USERNAME", "BITBUCKET_APP_PASSWORD"},
+	},
+	{
+		name: "signing",
+		enabled: func(getenv func(string) string) bool {
+			needed := anySet("POW_DIFFICULTY", "DISCORD_GUILD_ID", "OIDC_ISSUER", "NPM_ORG", "APPROVAL_QUEUE", "DUAL_CONTROL_ROLES", "ABUSE_REPORT_SECRET", "ADMIN_GITHUB_LOGIN", "ONBOARDING_STATUS", "ONBOARDING_CHECKLIST", "PUBLIC_MEMBERSHIP")
+			return getenv("SIGNING_KEY") != "" || getenv("SIGNING_KMS_KEY") == "" && getenv("SIGNING_KEYS") == "" && needed(getenv)
+		},
+		required: []string{"SIGNING_KEY"},
+	},
+	{
+		name:     "signing_rotation",
+		enabled:  anySet("SIGNING_KEYS", "SUCCESS_TOKEN_KEYS"),
+		optional: []string{"SIGNING_KEYS", "SUCCESS_PARAMS", "SUCCESS_TOKEN_KEYS"},
+	},
+	{
+		name: "success_token",
+		enabled: func(getenv func(string) string) bool {
+			return getenv("SUCCESS_PARAMS") == successParamsJWT && getenv("SUCCESS_TOKEN_KMS_KEY") == "" && getenv("SUCCESS_TOKEN_KEYS") == ""
+		},
+		required: []string{"SUCCESS_PARAMS", "SUCCESS_TOKEN_KEY"},
+	},
+	{
+		name:     "kms_signing",
+		enabled:  anySet("SIGNING_KMS_KEY", "SUCCESS_TOKEN_KMS_KEY"),
+		optional: []string{"SIGNING_KMS_KEY", "SUCCESS_PARAMS", "SUCCESS_TOKEN_KMS_KEY", "KMS_ENDPOINT", "AWS_REGION"},
+	},
+	{
+		name:     "email",
+		enabled:  anySet("SMTP_HOST", "APPROVAL_EMAILS", "INVITE_REMINDER_AFTER", "TWO_FACTOR_NUDGE_INTERVAL"),
+		required: []string{"SMTP_HOST", "SMTP_FROM"},
+		optional: []string{"SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "INVITE_REMINDER_AFTER", "TWO_FACTOR_NUDGE_INTERVAL", "TWO_FACTOR_DEADLINE", "TWO_FACTOR_NUDGE_MESSAGE"},
+	},
+	{name: "admin", optional: []string{"ADMIN_TOKEN", "ADMIN_CREDENTIALS", "ADMIN_GITHUB_LOGIN", "ADMIN_GITHUB_TEAMS"}},
+	{name: "api", enabled: anySet("API_TOKEN"), required: []string{"API_TOKEN"}},
+	{
+		name:     "approvals",
+		enabled:  anySet("APPROVAL_QUEUE"),
+		required: []string{"APPROVAL_QUEUE"},
+		optional: []string{"APPROVAL_SLACK_WEBHOOK", "APPROVAL_DISCORD_WEBHOOK", "APPROVAL_EMAILS"},
+	},
+	{
+		name:     "abuse_reports",
+		enabled:  anySet("ABUSE_REPORT_SECRET"),
+		required: []string{"ABUSE_REPORT_SECRET"},
+		optional: []string{"ABUSE_FLAG_TTL", "APPROVAL_SLACK_WEBHOOK", "APPROVAL_DISCORD_WEBHOOK", "APPROVAL_EMAILS"},
+	},
+	{
+		name:     "dual_control",
+		enabled:  anySet("DUAL_CONTROL_ROLES"),
+		required: []string{"DUAL_CONTROL_ROLES"},
+		optional: []string{"APPROVAL_SLACK_WEBHOOK", "APPROVAL_DISCORD_WEBHOOK", "APPROVAL_EMAILS"},
+	},
+	{
+		name:     "slack",
+		enabled:  anySet("SLACK_ADMIN_TOKEN"),
+		required: []string{"SLACK_ADMIN_TOKEN", "SLACK_TEAM_ID", "SLACK_CHANNEL_IDS"},
+	},
+	{name: "slack_link", optional: []string{"SLACK_INVITE_LINK"}},
+	{
+		name:     "discord",
+		enabled:  anySet("DISCORD_GUILD_ID"),
+		required: []string{"DISCORD_GUILD_ID", "DISCORD_CLIENT_ID", "DISCORD_CLIENT_SECRET", "DISCORD_BOT_TOKEN"},
+		optional: []string{"DISCORD_ROLE_ID"},
+	},
+	{name: "npm", enabled: anySet("NPM_ORG"), required: []string{"NPM_ORG", "NPM_TOKEN"}, optional: []string{"NPM_ROLE"}},
+	{
+		name:     "oidc",
+		enabled:  anySet("OIDC_ISSUER"),
+		required: []string{"OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET"},
+		optional: []string{"OIDC_SCOPES"},
+	},
+	{
+		name:     "acceptance",
+		optional: []string{"ACCEPTED_ACTIONS", "GITHUB_WEBHOOK_SECRET", "WELCOME_WEBHOOK_URL", "NEWSLETTER_WEBHOOK_URL"},
+	},
+	{name: "reputation", enabled: anySet("REPUTATION_URL"), required: []string{"REPUTATION_URL"}, optional: []string{"REPUTATION_TOKEN", "REPUTATION_TIMEOUT", "REPUTATION_FAIL_OPEN"}},
+	{name: "abuse", optional: []string{
+		"TRUSTED_PROXIES", "THROTTLE_RULES", "THROTTLE_LOOKUP", "DENY_IPS", "DENY_USER_AGENTS", "DENYLIST_URL", "DENYLIST_REFRESH",
+		"POW_DIFFICULTY", "BOT_SIGNALS", "BOT_HONEYPOT_FIELD", "BOT_MIN_FILL_TIME",
+	}},
+	{
+		name:     "vercel_kv",
+		enabled:  anySet("KV_REST_API_URL", "KV_REST_API_TOKEN"),
+		required: []string{"KV_REST_API_URL", "KV_REST_API_TOKEN"},
+	},
+	{
+		name:     "analytics",
+		enabled:  anySet("ANALYTICS_SINK"),
+		required: []string{"ANALYTICS_SINK"},
+		optional: []string{"POSTHOG_API_KEY", "POSTHOG_HOST", "GA4_MEASUREMENT_ID", "GA4_API_SECRET", "ANALYTICS_EVENTS", "ANALYTICS_SALT", "ANALYTICS_TIMEOUT"},
+	},
+	{
+		name:     "alerts",
+		enabled:  anySet("ALERT_RULES"),
+		required: []string{"ALERT_RULES"},
+		optional: []string{"ALERT_SLACK_WEBHOOK", "ALERT_DISCORD_WEBHOOK", "ALERT_EMAILS", "ALERT_CHECK_INTERVAL", "ALERT_REPEAT"},
+	},
+	{name: "store_encryption", optional: []string{"STORE_ENCRYPTION_KEYS"}},
+	{name: "storage_degrade", optional: []string{"STORE_DEGRADE_MODE", "STORE_RETRY_INTERVAL"}},
+	{name: "slo", optional: []string{"SLO_LATENCY_TARGET", "SLO_OBJECTIVE", "SLO_WINDOW_DAYS"}},
+	{name: "flow_trails", optional: []string{"FLOW_TRAIL_TTL"}},
+	{name: "status_page", optional: []string{"STATUS_PAGE", "RESEND_INTERVAL"}},
+	{name: "public_membership", optional: []string{"PUBLIC_MEMBERSHIP"}},
+	{name: "onboarding_checklist", optional: []string{"ONBOARDING_CHECKLIST", "CHECKLIST_REPO"}},
+	{
+		name:     "team_sync",
+		enabled:  anySet("TEAM_SYNC"),
+		required: []string{"TEAM_SYNC"},
+		optional: []string{"TEAM_SYNC_INTERVAL", "TEAM_SYNC_REMOVE", "GOOGLE_WORKSPACE_CREDENTIALS", "GOOGLE_WORKSPACE_ADMIN", "AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET"},
+	},
+	{name: "load_shedding", optional: []string{"LOAD_SHED_QUEUE_DEPTH", "LOAD_SHED_ERROR_RATE", "LOAD_SHED_WINDOW", "LOAD_SHED_HOLD"}},
+}
+
+// coreSecrets are the secrets of Config, which secretSettings leaves out.
+var coreSecrets = []string{"GITHUB_CLIENT_SECRET", "GITHUB_PAT", "SIGNING_KEY", "ADMIN_TOKEN", "ADMIN_CREDENTIALS", "API_TOKEN", "CRON_SECRET"}
+
+// isSecretSetting reports whether the setting called name holds a secret.
+func isSecretSetting(name string) bool {
+	for _, list := range [][]string{coreSecrets, secretSettings} {
+		for _, s := range list {
+			if s == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ExportConfig returns the settings the deployment in the environment needs,
+// by feature: the required settings of every enabled feature, with
+// RequiredPlaceholder for those that are missing, and the optional settings
+// that are set. Secrets are replaced by SecretPlaceholder. The profile named
+// by APP_ENV is resolved, so the export runs without PROFILES_FILE.
+func ExportConfig() ([]ConfigSetting, error) {
+	env, _, err := profileEnv()
+	if err != nil {
+		return nil, err
+	}
+	return exportConfig(env), nil
+}
+
+func exportConfig(getenv func(string) string) []ConfigSetting {
+	var settings []ConfigSetting
+	seen := map[string]bool{}
+	add := func(feature, name string, required bool) {
+		value := strings.TrimSpace(getenv(name))
+		if seen[name] || (value == "" && !required) {
+			return
+		}
+		seen[name] = true
+		s := ConfigSetting{Name: name, Feature: feature, Value: value, Secret: isSecretSetting(name), Missing: value == ""}
+		switch {
+		case s.Secret:
+			s.Value = SecretPlaceholder
+		case s.Missing:
+			s.Value = RequiredPlaceholder
+		}
+		settings = append(settings, s)
+	}
+	for _, f := range configFeatures {
+		if f.enabled != nil && !f.enabled(getenv) {
+			continue
+		}
+		for _, name := range f.required {
+			add(f.name, name, true)
+		}
+		for _, name := range f.optional {
+			add(f.name, name, false)
+		}
+	}
+	return settings
+}
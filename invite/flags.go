@@ -0,0 +1,104 @@
+package invite
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Feature flags for optional steps: the npm and Discord steps of the page and
+// the Slack invite sent on joining. A flag that is not configured is fully
+// on, so existing deployments behave as before.
+const (
+	flagNPMStep     = "npm_step"
+	flagDiscordStep = "discord_step"
+	flagSlackInvite = "slack_invite"
+)
+
+// flagEnabled reports whether a flag is on for the user. Each flag is rolled
+// out to a percentage of users, chosen by a stable hash of the flag name and
+// user ID, so a user sees the same thing on every visit and raising the
+// percentage only adds users.
+func (rs *ruleSet) flagEnabled(name, userID string) bool {
+	percent, ok := rs.featureFlags[name]
+	if !ok || percent >= 100 {
+		return true
+	}
+	if percent <= 0 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(name + ":" + userID))
+	return binary.BigEndian.Uint64(sum[:8])%100 < uint64(percent)
+}
+
+// parseFeatureFlags parses FEATURE_FLAGS: either a JSON object mapping flag
+// names to rollout percentages, such as {"npm_step": 25}, or the same as a
+// comma-separated list, such as npm_step=25,discord_step=0. Only the
+// literals true and false stand for 100 and 0, so that npm_step=1 is a 1%
+// rollout.
+func parseFeatureFlags(spec string) (map[string]int, error) {
+	flags := make(map[string]int)
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "{") {
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(spec), &raw); err != nil {
+			return nil, err
+		}
+		for name, v := range raw {
+			if name == "" {
+				return nil, fmt.Errorf("flag names must not be empty")
+			}
+			switch v := v.(type) {
+			case bool:
+				flags[name] = boolPercent(v)
+			case float64:
+				flags[name] = int(v)
+			default:
+				return nil, fmt.Errorf("flag %s: want a percentage or a boolean", name)
+			}
+		}
+	} else {
+		for _, entry := range strings.Split(spec, ",") {
+			if entry = strings.TrimSpace(entry); entry == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(entry, "=")
+			name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid entry %q: want name=percentage", entry)
+			}
+			switch value {
+			case "true", "false":
+				flags[name] = boolPercent(value == "true")
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("flag %s: want a percentage, true, or false", name)
+			}
+			flags[name] = n
+		}
+	}
+	for name, percent := range flags {
+		if percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("flag %s: percentage must be between 0 and 100", name)
+		}
+	}
+	return flags, nil
+}
+
+func boolPercent(on bool) int {
+	if on {
+		return 100
+	}
+	return 0
+}
+
+// handleFlags shows the configured rollout percentages.
+func handleFlags(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"flags": currentRules().featureFlags})
+}
@@ -0,0 +1,45 @@
+package invite
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseFeatureFlags(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]int
+		wantErr bool
+	}{
+		{spec: "", want: map[string]int{}},
+		{spec: "npm_step=25, discord_step=0", want: map[string]int{"npm_step": 25, "discord_step": 0}},
+		{spec: "npm_step=1", want: map[string]int{"npm_step": 1}},
+		{spec: "npm_step=0", want: map[string]int{"npm_step": 0}},
+		{spec: "npm_step=true,discord_step=false", want: map[string]int{"npm_step": 100, "discord_step": 0}},
+		{spec: `{"npm_step": 1, "discord_step": true, "slack_invite": false}`, want: map[string]int{"npm_step": 1, "discord_step": 100, "slack_invite": 0}},
+		{spec: "npm_step=t", wantErr: true},
+		{spec: "npm_step=T", wantErr: true},
+		{spec: "npm_step=F", wantErr: true},
+		{spec: "npm_step=TRUE", wantErr: true},
+		{spec: "npm_step", wantErr: true},
+		{spec: "=50", wantErr: true},
+		{spec: "npm_step=", wantErr: true},
+		{spec: "npm_step=101", wantErr: true},
+		{spec: "npm_step=-1", wantErr: true},
+		{spec: `{"": 50}`, wantErr: true},
+		{spec: `{"npm_step": "50"}`, wantErr: true},
+		{spec: `{"npm_step": 150}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseFeatureFlags(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseFeatureFlags(%q) = %v, want an error", tt.spec, got)
+			}
+			continue
+		}
+		if err != nil || !maps.Equal(got, tt.want) {
+			t.Errorf("parseFeatureFlags(%q) = %v, %v; want %v", tt.spec, got, err, tt.want)
+		}
+	}
+}
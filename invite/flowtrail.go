@@ -0,0 +1,224 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// flowTrailKey prefixes the store keys of the flow trails, by flow ID.
+const flowTrailKey = "trail:"
+
+// flowTrailTTL is how long the trail of a sign-in is kept, from
+// FLOW_TRAIL_TTL. Zero turns trails off.
+var flowTrailTTL time.Duration
+
+// loadFlowTrailConfig reads FLOW_TRAIL_TTL.
+func loadFlowTrailConfig() {
+	flowTrailTTL = envDuration("FLOW_TRAIL_TTL", 0)
+	if flowTrailTTL < 0 {
+		log.Fatal("FATAL: FLOW_TRAIL_TTL must not be negative.")
+	}
+}
+
+// Steps of a flow trail.
+const (
+	stepStateIssued      = "state_issued"
+	stepCallbackReceived = "callback_received"
+	stepGitHubAPI        = "github_api"
+	stepSignedIn         = "signed_in"
+	stepGatesEvaluated   = "gates_evaluated"
+	stepQueued           = "queued_for_approval"
+	stepWaitlisted       = "waitlisted"
+	stepInviteSent       = "invite_sent"
+	stepInviteFailed     = "invite_failed"
+	stepCompleted        = "completed"
+	stepFailed           = "failed"
+)
+
+// maxTrailSteps bounds the steps kept in a trail; later ones are dropped.
+const maxTrailSteps = 100
+
+// trailStep is something that happened in a sign-in.
+type trailStep struct {
+	Step        string    `json:"step"`
+	Time        time.Time `json:"time"`
+	Org         string    `json:"org,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	Request     string    `json:"request,omitempty"`      // Method and path of a GitHub API call
+	Status      int       `json:"status,omitempty"`       // HTTP status of the GitHub API response
+	Gate        string    `json:"gate,omitempty"`         // Gate that turned the user away
+	ShadowGates []string  `json:"shadow_gates,omitempty"` // Gates in shadow mode that failed
+	SpamScore   *int      `json:"spam_score,omitempty"`
+	Reason      string    `json:"reason,omitempty"` // Why the invitation needs an approval
+	Code        string    `json:"code,omitempty"`   // Error code
+	Error       string    `json:"error,omitempty"`  // Underlying error, redacted
+}
+
+// flowTrail collects the steps of a sign-in during a request, saved when the
+// request ends. The OAuth state carries its ID from the login to the
+// callback, which is also the request ID the error page gets, so that a
+// support ticket about a failed invitation can be looked up step by step.
+type flowTrail struct {
+	mu    sync.Mutex
+	id    string
+	steps []trailStep
+}
+
+type flowTrailContextKey struct{}
+
+// traceFlow serves a request of the sign-in flow with a trail, saving the
+// steps it recorded once it is done.
+func traceFlow(w http.ResponseWriter, r *http.Request, serve http.HandlerFunc) {
+	if flowTrailTTL <= 0 {
+		serve(w, r)
+		return
+	}
+	t := &flowTrail{}
+	serve(w, r.WithContext(withTrail(r.Context(), t)))
+	if err := t.save(context.WithoutCancel(r.Context())); err != nil {
+		log.Printf("Failed to save the trail of flow %s: %v", t.id, err)
+	}
+}
+
+// withTrail returns ctx carrying t, for the GitHub API calls made with it.
+func withTrail(ctx context.Context, t *flowTrail) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, flowTrailContextKey{}, t)
+}
+
+// trailFrom returns the trail ctx carries, or nil.
+func trailFrom(ctx context.Context) *flowTrail {
+	t, _ := ctx.Value(flowTrailContextKey{}).(*flowTrail)
+	return t
+}
+
+// trailOf returns the trail of r, or nil when trails are off.
+func trailOf(r *http.Request) *flowTrail {
+	return trailFrom(r.Context())
+}
+
+// begin names the trail id, or a new ID when id is empty, as when the
+// deployment cannot sign the state that would carry it, and returns it.
+func (t *flowTrail) begin(id string) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id == "" {
+		id = randomID(8)
+	}
+	t.id = id
+	return id
+}
+
+// requestID returns the ID of the trail, or "" when it has none.
+func (t *flowTrail) requestID() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.id
+}
+
+// add records step.
+func (t *flowTrail) add(step trailStep) {
+	if t == nil {
+		return
+	}
+	step.Time = time.Now().UTC()
+	t.mu.Lock()
+	t.steps = append(t.steps, step)
+	t.mu.Unlock()
+}
+
+// fail records the step of a failure with err.
+func (t *flowTrail) fail(step trailStep, err error) {
+	e := asError(err, ErrInvitationFailed)
+	step.Code = e.Code
+	if e.Err != nil {
+		step.Error = redact(e.Err.Error())
+	}
+	t.add(step)
+}
+
+// save appends the steps recorded to those of the earlier requests of the
+// flow, keeping them for FLOW_TRAIL_TTL.
+func (t *flowTrail) save(ctx context.Context) error {
+	t.mu.Lock()
+	id, steps := t.id, t.steps
+	t.mu.Unlock()
+	if id == "" || len(steps) == 0 {
+		return nil
+	}
+	earlier, err := loadTrail(ctx, id)
+	if err != nil {
+		return err
+	}
+	steps = append(earlier, steps...)
+	if len(steps) > maxTrailSteps {
+		steps = steps[:maxTrailSteps]
+	}
+	b, _ := json.Marshal(steps)
+	return dataStore.Set(ctx, flowTrailKey+id, b, flowTrailTTL)
+}
+
+// loadTrail returns the steps of the trail id, or none when there is no
+// such trail.
+func loadTrail(ctx context.Context, id string) ([]trailStep, error) {
+	b, err := dataStore.Get(ctx, flowTrailKey+id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var steps []trailStep
+	if err := json.Unmarshal(b, &steps); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// traceAPICall records a GitHub API call made with the context of a flow.
+// Only the path is recorded: queries and bodies can carry codes and tokens.
+func traceAPICall(req *http.Request, resp *http.Response, err error) {
+	t := trailFrom(req.Context())
+	if t == nil {
+		return
+	}
+	step := trailStep{Step: stepGitHubAPI, Request: req.Method + " " + req.URL.Path}
+	if err != nil {
+		step.Error = redact(err.Error())
+	} else {
+		step.Status = resp.StatusCode
+	}
+	t.add(step)
+}
+
+// handleFlowTrail returns the trail of GET /admin/flows/{id}, the request ID
+// the error page got.
+func handleFlowTrail(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/flows/")
+	steps, err := loadTrail(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if steps == nil {
+		writeJSONError(w, ErrNotFound.WithMessage("No trail of that flow; it may have expired, or FLOW_TRAIL_TTL is not set."))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "steps": steps})
+}
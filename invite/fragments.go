@@ -0,0 +1,179 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fragmentMaxAge is how long pages and proxies can reuse a fragment.
+const fragmentMaxAge = "30"
+
+// htmxHeaders are the request headers htmx sends, which cross-origin
+// requests for fragments must be allowed to carry.
+const htmxHeaders = "HX-Request, HX-Current-URL, HX-Target, HX-Trigger, HX-Trigger-Name, HX-Boosted"
+
+var (
+	statusFragment = lazyTemplate("status_fragment", `<span class="auto-invite-status auto-invite-status-{{.Status}}">{{.Message}}</span>`)
+
+	waitlistFragment = lazyTemplate("waitlist_fragment", `<span class="auto-invite-waitlist">
+{{- if .Position}}{{.Username}} is number {{.Position}} of {{.Length}} on the waitlist.
+{{- else if .Username}}{{.Username}} is not on the waitlist.
+{{- else if eq .Length 1}}1 person is on the waitlist.
+{{- else}}{{.Length}} people are on the waitlist.{{end -}}
+</span>`)
+
+	membersFragment = lazyTemplate("members_fragment", `<span class="auto-invite-members">{{if eq .Members 1}}1 member{{else}}{{.Members}} members{{end}}</span>`)
+)
+
+// orgStatus is whether an org takes sign-ins right now.
+type orgStatus struct {
+	Org     string     `json:"org"`
+	Status  string     `json:"status"` // "open", or the error code sign-ins end with
+	Message string     `json:"message"`
+	OpensAt *time.Time `json:"opens_at,omitempty"`
+}
+
+// waitlistStatus is the length of the waitlist, and where a user is on it.
+type waitlistStatus struct {
+	Length   int    `json:"length"`
+	Username string `json:"username,omitempty"`
+	Position int    `json:"position,omitempty"` // From 1; 0 when the user is not on the waitlist
+}
+
+// memberStatus is the member count of an org.
+type memberStatus struct {
+	Org     string `json:"org"`
+	Members int    `json:"members"`
+}
+
+// handleFragment serves live data for other sites to show:
+// /fragments/status, /fragments/waitlist?username=, and /fragments/members,
+// each for ?org or the primary org, and with ONBOARDING_CHECKLIST
+// /fragments/checklist?token= for the success page. htmx requests (HX-Request) and
+// ?format=html get an HTML fragment to swap in; others get JSON. The sites
+// of LOGIN_ORIGINS can request them from the browser.
+func handleFragment(w http.ResponseWriter, r *http.Request) {
+	fragmentCORS(w, r)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodOptions)
+		return
+	}
+	org := githubOrgName
+	if o := r.FormValue("org"); o != "" {
+		if org = knownOrg(o); org == "" {
+			writeJSONError(w, ErrUnknownOrg)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	var tmpl func() *template.Template
+	var data any
+	switch strings.TrimPrefix(r.URL.Path, "/fragments/") {
+	case "status":
+		tmpl, data = statusFragment, currentOrgStatus(ctx, org)
+	case "waitlist":
+		status, err := currentWaitlistStatus(ctx, r.FormValue("username"))
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		tmpl, data = waitlistFragment, status
+	case "checklist":
+		handleChecklist(w, r)
+		return
+	case "members":
+		counter, ok := activeProvider.(memberCounter)
+		if !ok {
+			writeJSONError(w, ErrNotFound.WithMessage("Member counts are not available for this provider."))
+			return
+		}
+		n, err := counter.MemberCount(ctx, org)
+		if err != nil {
+			log.Printf("Counting the members of %s for a fragment failed: %v", org, err)
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		tmpl, data = membersFragment, memberStatus{Org: org, Members: n}
+	default:
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+
+	w.Header().Add("Vary", "HX-Request")
+	w.Header().Set("Cache-Control", "public, max-age="+fragmentMaxAge)
+	if r.Header.Get("HX-Request") == "true" || r.FormValue("format") == "html" {
+		renderPage(w, http.StatusOK, tmpl(), data)
+		return
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+// fragmentCORS lets the sites of LOGIN_ORIGINS request fragments from the
+// browser.
+func fragmentCORS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	if r.Header.Get("Origin") == "" || !slices.Contains(loginOrigins, requestOrigin(r)) {
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD")
+	w.Header().Set("Access-Control-Allow-Headers", htmxHeaders)
+	w.Header().Set("Access-Control-Max-Age", "86400")
+}
+
+// currentOrgStatus reports whether sign-ins to org get an invitation right
+// now, as far as can be told without a user.
+func currentOrgStatus(ctx context.Context, org string) orgStatus {
+	status := orgStatus{Org: org, Status: "open", Message: "Invitations are open."}
+	closed := func(e *Error) orgStatus {
+		status.Status, status.Message = e.Code, e.Message
+		return status
+	}
+	if shed.get(ctx).Shedding {
+		return closed(ErrHighDemand)
+	}
+	rs := currentRules().forOrg(org)
+	if open, next := invitesOpen(rs, time.Now()); !open {
+		if !next.IsZero() {
+			status.OpensAt = &next
+		}
+		return closed(ErrInvitesNotOpen)
+	}
+	if full, err := membershipClosed(ctx, rs, org); err == nil && full {
+		return closed(ErrMembershipClosed)
+	}
+	return status
+}
+
+// currentWaitlistStatus returns the length of the waitlist and, with a
+// username, where the user is on it.
+func currentWaitlistStatus(ctx context.Context, username string) (waitlistStatus, error) {
+	entries, err := dataStore.Range(ctx, waitlistKey, 0, -1)
+	if err != nil {
+		return waitlistStatus{}, err
+	}
+	status := waitlistStatus{Length: len(entries), Username: username}
+	if username == "" {
+		return status, nil
+	}
+	for i, b := range entries {
+		var entry waitlistEntry
+		if json.Unmarshal(b, &entry) == nil && strings.EqualFold(entry.Username, username) {
+			status.Position = i + 1
+			break
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,365 @@
+package invite
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// accountStats are the numbers the account gates look at.
+type accountStats struct {
+	Followers     int
+	PublicRepos   int
+	Contributions int // Contributions in the last year
+	CreatedAt     time.Time
+	BlankProfile  bool // No name, bio, company, blog or location
+}
+
+// Account gates, as the invite log and the gate metrics name them.
+const (
+	gateSuspended     = "suspended"
+	gateFollowers     = "min_followers"
+	gatePublicRepos   = "min_public_repos"
+	gateContributions = "min_contributions"
+	gateSpam          = "spam_score"
+	gateReputation    = "reputation"
+)
+
+// accountGates are the gates SHADOW_GATES may name.
+var accountGates = []string{gateSoftBan, gateSuspended, gateFollowers, gatePublicRepos, gateContributions, gateSpam, gateReputation}
+
+// parseShadowGates parses SHADOW_GATES, a ","-separated list of the gates
+// that run in shadow mode.
+func parseShadowGates(spec string) (map[string]bool, error) {
+	gates := make(map[string]bool)
+	for _, gate := range strings.Split(spec, ",") {
+		if gate = strings.TrimSpace(gate); gate == "" {
+			continue
+		}
+		if !slices.Contains(accountGates, gate) {
+			return nil, fmt.Errorf("unknown gate %q, want one of %s", gate, strings.Join(accountGates, ", "))
+		}
+		gates[gate] = true
+	}
+	return gates, nil
+}
+
+// gateDecisions counts the decisions of each gate, as gate:pass and
+// gate:fail, and gate:shadow_fail for the failures of a gate in shadow mode.
+var gateDecisions = expvar.NewMap("gate_decisions")
+
+// gateResult is what the gates found out about an account, recorded in the
+// invite log so thresholds can be tuned.
+type gateResult struct {
+	SpamScore   *int     // Nil unless the spam gate ran
+	SpamSignals []string // Spam signals that fired
+	Reputation  string   // Decision of the reputation service, if asked
+	Failed      string   // Gate that turned the account away, if any
+	Shadowed    []string // Gates in shadow mode that would have turned it away
+
+	shadow map[string]bool // Gates in shadow mode, from SHADOW_GATES
+}
+
+// decide counts the decision of gate and returns err, which is nil when the
+// account passed it. A gate in shadow mode only records its failures, and
+// lets the account through, so that a new gate's false positives can be
+// counted before it is enforced.
+func (g *gateResult) decide(gate string, err error) error {
+	switch {
+	case err != nil && g.shadow[gate]:
+		g.Shadowed = append(g.Shadowed, gate)
+		gateDecisions.Add(gate+":shadow_fail", 1)
+		log.Printf("Gate %s, in shadow mode, would have turned the account away: %v", gate, err)
+		return nil
+	case err != nil:
+		g.Failed = gate
+		gateDecisions.Add(gate+":fail", 1)
+	default:
+		gateDecisions.Add(gate+":pass", 1)
+	}
+	return err
+}
+
+// annotate adds the result to an invite log event.
+func (g *gateResult) annotate(ev *activityEvent) {
+	if g != nil {
+		ev.SpamScore, ev.SpamSignals, ev.Reputation, ev.Gate, ev.ShadowGates = g.SpamScore, g.SpamSignals, g.Reputation, g.Failed, g.Shadowed
+	}
+}
+
+// checkGates runs the account gates: it rejects accounts under a soft ban,
+// accounts GitHub has suspended (with REJECT_SUSPENDED), accounts below
+// MIN_FOLLOWERS, MIN_PUBLIC_REPOS, or MIN_CONTRIBUTIONS, or with a spam score
+// of SPAM_SCORE_THRESHOLD or more, to keep out throwaway accounts, and then
+// asks the reputation service, if configured. The statistics gates are not enforced for providers that cannot
+// report account statistics. The gates of SHADOW_GATES are not enforced
+// either, only recorded. The result is returned even when the account is
+// rejected.
+func checkGates(ctx context.Context, rs *ruleSet, user *Identity, campaign string) (*gateResult, error) {
+	result := &gateResult{shadow: rs.shadowGates}
+	if err := result.decide(gateSoftBan, checkSoftBan(ctx, user)); err != nil {
+		return result, err
+	}
+	if checker, ok := activeProvider.(suspensionChecker); ok && rs.rejectSuspended {
+		suspended, err := checker.Suspended(ctx, user)
+		if err != nil {
+			return result, ErrUserInfo.Wrap(err)
+		}
+		var failed error
+		if suspended {
+			failed = ErrAccountSuspended
+		}
+		if err := result.decide(gateSuspended, failed); err != nil {
+			return result, err
+		}
+	}
+	reporter, ok := activeProvider.(statsReporter)
+	if ok && (rs.minFollowers > 0 || rs.minPublicRepos > 0 || rs.minContributions > 0 || rs.spamThreshold > 0) {
+		stats, err := reporter.AccountStats(ctx, user, rs.minContributions > 0)
+		if err != nil {
+			return result, ErrUserInfo.Wrap(err)
+		}
+		minimums := []struct {
+			gate     string
+			min, got int
+			what     string
+		}{
+			{gateFollowers, rs.minFollowers, stats.Followers, "followers"},
+			{gatePublicRepos, rs.minPublicRepos, stats.PublicRepos, "public repositories"},
+			{gateContributions, rs.minContributions, stats.Contributions, "contributions in the last year"},
+		}
+		for _, m := range minimums {
+			if m.min <= 0 {
+				continue
+			}
+			var failed error
+			if m.got < m.min {
+				failed = ErrRequirementsNotMet.WithMessage(fmt.Sprintf("You need at least %d %s to join.", m.min, m.what))
+			}
+			if err := result.decide(m.gate, failed); err != nil {
+				return result, err
+			}
+		}
+		if rs.spamThreshold > 0 {
+			score, signals := spamScore(rs, user, stats)
+			result.SpamScore, result.SpamSignals = &score, signals
+			var failed error
+			if score >= rs.spamThreshold {
+				failed = ErrSuspectedSpam
+			}
+			if err := result.decide(gateSpam, failed); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if reputation != nil {
+		decision, err := reputation.check(ctx, user, campaign)
+		result.Reputation = decision
+		if err := result.decide(gateReputation, err); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// spamScore adds up the weights of the spam signals that fire for the
+// account.
+func spamScore(rs *ruleSet, user *Identity, stats *accountStats) (int, []string) {
+	_, domain, _ := strings.Cut(strings.ToLower(user.Email), "@")
+	fired := map[string]bool{
+		spamDisposableEmail: domain != "" && rs.disposableDomains[domain],
+		spamBlankProfile:    stats.BlankProfile,
+		spamNoRepos:         stats.PublicRepos == 0,
+		spamNewAccount:      !stats.CreatedAt.IsZero() && time.Since(stats.CreatedAt) < rs.spamNewAccountAge,
+	}
+	score := 0
+	var signals []string
+	for _, signal := range spamSignals {
+		if fired[signal] {
+			score += rs.spamWeights[signal]
+			signals = append(signals, signal)
+		}
+	}
+	return score, signals
+}
+
+// Spam signals. GitHub does not say whether an avatar is the default
+// identicon, so a blank profile stands in for it.
+const (
+	spamDisposableEmail = "disposable_email"
+	spamBlankProfile    = "blank_profile"
+	spamNoRepos         = "no_repos"
+	spamNewAccount      = "new_account"
+)
+
+var spamSignals = []string{spamDisposableEmail, spamBlankProfile, spamNoRepos, spamNewAccount}
+
+// defaultSpamWeights are used for signals SPAM_WEIGHTS does not mention.
+var defaultSpamWeights = map[string]int{
+	spamDisposableEmail: 50,
+	spamBlankProfile:    20,
+	spamNoRepos:         20,
+	spamNewAccount:      30,
+}
+
+// disposableDomains are well-known throwaway email providers. More can be
+// added with SPAM_DISPOSABLE_DOMAINS.
+var disposableDomains = map[string]bool{
+	"10minutemail.com":  true,
+	"dispostable.com":   true,
+	"emailondeck.com":   true,
+	"fakeinbox.com":     true,
+	"getnada.com":       true,
+	"guerrillamail.com": true,
+	"maildrop.cc":       true,
+	"mailinator.com":    true,
+	"mintemail.com":     true,
+	"mohmal.com":        true,
+	"sharklasers.com":   true,
+	"temp-mail.org":     true,
+	"tempmail.com":      true,
+	"throwawaymail.com": true,
+	"trashmail.com":     true,
+	"yopmail.com":       true,
+}
+
+// parseSpamWeights parses SPAM_WEIGHTS, a comma-separated list such as
+// "disposable_email=60,new_account=10", on top of the default weights.
+func parseSpamWeights(spec string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for k, v := range defaultSpamWeights {
+		weights[k] = v
+	}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(entry, "=")
+		weight, err := strconv.Atoi(value)
+		if _, ok := defaultSpamWeights[name]; !ok || err != nil {
+			return nil, fmt.Errorf("invalid weight %q", entry)
+		}
+		weights[name] = weight
+	}
+	return weights, nil
+}
+
+// contributionsLastYear returns the user's contribution count over the last
+// year, as shown on their profile. Only the GraphQL API has it.
+func contributionsLastYear(ctx context.Context, client *github.Client, login string) (int, error) {
+	body := map[string]any{
+		"query":     `query($login: String!) { user(login: $login) { contributionsCollection { contributionCalendar { totalContributions } } } }`,
+		"variables": map[string]string{"login": login},
+	}
+	// The GraphQL endpoint is /graphql on github.com and /api/graphql on
+	// GitHub Enterprise Server, beside the REST base URL either way.
+	req, err := client.NewRequest("POST", "../graphql", body)
+	if err != nil {
+		return 0, err
+	}
+	var result struct {
+		Data struct {
+			User *struct {
+				ContributionsCollection struct {
+					ContributionCalendar struct {
+						TotalContributions int `json:"totalContributions"`
+					} `json:"contributionCalendar"`
+				} `json:"contributionsCollection"`
+			} `json:"user"`
+		} `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if _, err := client.Do(ctx, req, &result); err != nil {
+		return 0, err
+	}
+	if len(result.Errors) > 0 {
+		return 0, fmt.Errorf("graphql: %s", result.Errors[0].Message)
+	}
+	if result.Data.User == nil {
+		return 0, fmt.Errorf("graphql: no such user %s", login)
+	}
+	return result.Data.User.ContributionsCollection.ContributionCalendar.TotalContributions, nil
+}
+
+// gateReason is a reason sign-ins were turned away, with how often.
+type gateReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// maxGateReasons bounds the reasons /admin/gates lists.
+const maxGateReasons = 10
+
+// handleGateStats reports the decisions of each gate since this instance
+// started, and from the invite log, how many sign-ins each gate turned away,
+// how many each gate in shadow mode would have, and the top reasons for
+// turning sign-ins away: the gate, with the spam signals that fired for
+// spam_score, or else the error code.
+func handleGateStats(w http.ResponseWriter, r *http.Request) {
+	events, err := dataStore.Range(r.Context(), activityKey, 0, -1)
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	decisions := make(map[string]map[string]int64)
+	gateDecisions.Do(func(kv expvar.KeyValue) {
+		gate, outcome, _ := strings.Cut(kv.Key, ":")
+		if decisions[gate] == nil {
+			decisions[gate] = make(map[string]int64)
+		}
+		decisions[gate][outcome] = kv.Value.(*expvar.Int).Value()
+	})
+
+	failures := make(map[string]int)
+	shadowFailures := make(map[string]int)
+	counts := make(map[string]int)
+	for _, b := range events {
+		var ev activityEvent
+		if json.Unmarshal(b, &ev) != nil {
+			continue
+		}
+		for _, gate := range ev.ShadowGates {
+			shadowFailures[gate]++
+		}
+		if ev.Type != activityInviteFailed {
+			continue
+		}
+		switch {
+		case ev.Gate == gateSpam && len(ev.SpamSignals) > 0:
+			failures[ev.Gate]++
+			for _, signal := range ev.SpamSignals {
+				counts[ev.Gate+":"+signal]++
+			}
+		case ev.Gate != "":
+			failures[ev.Gate]++
+			counts[ev.Gate]++
+		case ev.Code != "":
+			counts[ev.Code]++
+		}
+	}
+	reasons := make([]gateReason, 0, len(counts))
+	for reason, n := range counts {
+		reasons = append(reasons, gateReason{reason, n})
+	}
+	slices.SortFunc(reasons, func(a, b gateReason) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), cmp.Compare(a.Reason, b.Reason))
+	})
+	if len(reasons) > maxGateReasons {
+		reasons = reasons[:maxGateReasons]
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"decisions": decisions, "failures": failures, "shadow_failures": shadowFailures, "top_reasons": reasons})
+}
@@ -0,0 +1,949 @@
+// Package invite implements the GitHub organization auto-invite flow: the
+// user signs in with GitHub and is then invited to the configured org using
+// an org owner's Personal Access Token.
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+	githuboauth "golang.org/x/oauth2/github"
+)
+
+// Init loads the configuration, which otherwise happens on the first
+// request. Long-running servers call it at startup so that a bad
+// configuration stops them right away.
+func Init() {
+	initOnce.Do(initVars)
+}
+
+// Handler is the main entry point for the Vercel serverless function.
+// It acts as a router for all incoming requests.
+func Handler(w http.ResponseWriter, r *http.Request) {
+	// Ensure initialization happens only once per serverless instance lifecycle.
+	initOnce.Do(initVars)
+	maybeReloadRules()
+	maybeRefreshDenylist()
+	maybeCheckRedirects()
+	maybeCheckAlerts()
+	withDenylist(withLimits(route))(w, r)
+}
+
+// route dispatches a request based on its path.
+func route(w http.ResponseWriter, r *http.Request) {
+	if !routable(r.URL.Path) {
+		handleUnknownPath(w, r)
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/login":
+		log.Print("DEBUG: Handling login request")
+		traceFlow(w, r, handleLogin)
+	case path == "/"+activeProvider.Name()+"/callback":
+		log.Print("DEBUG: Handling callback")
+		observeCallback(w, r, func(w http.ResponseWriter, r *http.Request) { traceFlow(w, r, handleCallback) })
+	case path == "/status", path == "/status/login":
+		handleStatusPage(w, r)
+	case path == "/status/resend":
+		handleResend(w, r)
+	case path == "/qr":
+		handleQR(w, r)
+	case path == "/npm":
+		handleNPM(w, r)
+	case path == "/discord/callback":
+		handleDiscordCallback(w, r)
+	case path == "/oidc/callback":
+		handleOIDCCallback(w, r)
+	case path == "/warmup":
+		handleWarmup(w, r)
+	case path == "/.well-known/jwks.json":
+		handleJWKS(w, r)
+	case path == "/widget.js":
+		handleWidgetJS(w, r)
+	case strings.HasPrefix(path, "/join/"):
+		handleJoin(w, r)
+	case path == "/embed":
+		handleEmbed(w, r)
+	case strings.HasPrefix(path, "/fragments/"):
+		handleFragment(w, r)
+	case strings.HasPrefix(path, "/ws/status/"):
+		handleStatusSocket(w, r)
+	case path == "/cron/maintenance":
+		handleMaintenance(w, r)
+	case path == "/github/webhook":
+		handleGitHubWebhook(w, r)
+	case path == "/abuse/report":
+		handleAbuseReport(w, r)
+	case strings.HasPrefix(path, "/i/"):
+		handleShortLink(w, r)
+	case strings.HasPrefix(path, "/api/"):
+		handleAPI(w, r)
+	case strings.HasPrefix(path, "/admin/"):
+		handleAdmin(w, r)
+	default:
+		handleUnknownPath(w, r)
+	}
+}
+
+// handleLogin redirects the user to the provider (GitHub by default) to authorize.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	// While load is shed, visitors get a cached page instead of a sign-in
+	// that would spend the org owner's token on them.
+	if shed.get(r.Context()).Shedding {
+		renderHighDemand(w, r)
+		return
+	}
+	if storageClosed() {
+		redirectToErrorPage(w, r, ErrStorageUnavailable)
+		return
+	}
+	if !checkLoginStart(w, r) {
+		return
+	}
+	markPopupFlow(w, r)
+	if throttle != nil && !throttle.allow(r.Context(), r) {
+		redirectToErrorPage(w, r, ErrThrottled)
+		return
+	}
+	if powDifficulty > 0 {
+		proof := r.FormValue("pow")
+		if proof == "" {
+			renderPowChallenge(w, r)
+			return
+		}
+		if err := checkProof(r.Context(), proof); err != nil {
+			redirectToErrorPage(w, r, err)
+			return
+		}
+	}
+
+	// A signed invite link is carried through the OAuth round trip in the
+	// state parameter and verified again in the callback.
+	linkToken := r.FormValue("t")
+	var link *linkClaims
+	if linkToken != "" {
+		var err error
+		if link, err = parseLink(linkToken); err != nil {
+			redirectToErrorPage(w, r, err)
+			return
+		}
+	}
+
+	// Deployments serving several orgs ask which ones to join first, as the
+	// rules can differ between them.
+	orgs, err := requestedOrgs(r, link)
+	if err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	if len(orgs) == 0 {
+		renderOrgChooser(w, r)
+		return
+	}
+
+	// The sign-in goes ahead while any of the orgs is open.
+	open, closed := false, true
+	var opensAt time.Time
+	for _, org := range orgs {
+		rs := currentRules().forOrg(org)
+		if ok, next := invitesOpen(rs, time.Now()); ok {
+			open = true
+		} else if !next.IsZero() && (opensAt.IsZero() || next.Before(opensAt)) {
+			opensAt = next
+		}
+		orgClosed, err := membershipClosed(r.Context(), rs, org)
+		if err != nil {
+			log.Printf("Could not check member count, continuing: %v", err)
+		}
+		closed = closed && orgClosed
+	}
+	if !open {
+		renderCountdown(w, r, strings.Join(orgs, " and "), opensAt)
+		return
+	}
+	// Visitors can still sign in to join the waitlist; the callback sorts
+	// them onto it instead of inviting them.
+	if closed && !waitlistWhenClosed {
+		redirectToErrorPage(w, r, ErrMembershipClosed)
+		return
+	}
+
+	// So is a preset, which is checked again when it is applied. A campaign
+	// of a /join/{name} page brings its own.
+	preset := r.FormValue("preset")
+	campaign := r.FormValue("campaign")
+	if campaign != "" {
+		if preset, err = campaignPreset(campaign, orgs); err != nil {
+			redirectToErrorPage(w, r, err)
+			return
+		}
+		if !canSign() {
+			log.Printf("Campaign %q requested, but campaigns need SIGNING_KEY", campaign)
+			redirectToErrorPage(w, r, ErrConfig)
+			return
+		}
+	}
+	if preset != "" {
+		if !canSign() {
+			log.Printf("Preset %q requested, but presets need SIGNING_KEY", preset)
+			redirectToErrorPage(w, r, ErrConfig)
+			return
+		}
+		for _, org := range orgs {
+			if _, err := applyPreset(currentRules().forOrg(org), inviteOptions{}, preset); err != nil {
+				redirectToErrorPage(w, r, err)
+				return
+			}
+		}
+	}
+
+	visitor := visitorID(w, r)
+	flow := flowState{Link: linkToken, Preset: preset, Campaign: campaign, Bot: detectBot(r), Variants: currentRules().variantsFor(visitor), Source: captureAttribution(r), PublicMembership: wantsPublicMembership(r)}
+	if len(githubOrgs) > 1 {
+		flow.Orgs = orgs
+	}
+	// Without a SIGNING_KEY the state cannot carry the trail, which starts
+	// at the callback instead.
+	trail := trailOf(r)
+	if canSign() {
+		flow.Trail = trail.begin("")
+	}
+	state, err := encodeState(r.Context(), flow)
+	if err != nil {
+		redirectToErrorPage(w, r, ErrConfig.Wrap(err))
+		return
+	}
+	trail.add(trailStep{Step: stepStateIssued})
+	started := activityEvent{Provider: activeProvider.Name(), Campaign: campaign, Variants: flow.Variants}
+	flow.Source.annotate(&started)
+	publish(r.Context(), busEvent{Type: eventLoginStarted, Entry: started, Visitor: visitor})
+	if oidc != nil {
+		startOIDC(w, r, state)
+		return
+	}
+
+	redirectURL := authorizeURL(flow, state)
+	log.Print("DEBUG: Redirecting to the provider's authorization page")
+
+	http.Redirect(w, r, redirectURL, redirectStatus(r, redirectLogin))
+}
+
+// handleCallback handles the user after they authorize with the provider.
+func handleCallback(w http.ResponseWriter, r *http.Request) {
+	flow, ok := decodeState(r.FormValue("state"))
+	if !ok {
+		redirectToErrorPage(w, r, ErrInvalidState)
+		return
+	}
+	if !flow.Admin && !flow.Status {
+		trail := trailOf(r)
+		trail.begin(flow.Trail)
+		trail.add(trailStep{Step: stepCallbackReceived})
+	}
+	if err := callbackError(r); err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	if storageClosed() {
+		redirectToErrorPage(w, r, ErrStorageUnavailable)
+		return
+	}
+	code := r.FormValue("code")
+	if err := claimCode(r.Context(), code); err != nil {
+		// A double-click or a refresh of a callback that went through gets
+		// the same response.
+		if errors.Is(err, ErrCallbackUsed) && !flow.Admin && !flow.Status && replayCoalesced(w, r, codeCoalesceKey(code)) {
+			return
+		}
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	if flow.Admin {
+		handleAdminCallback(w, r)
+		return
+	}
+	if flow.Status {
+		handleStatusCallback(w, r)
+		return
+	}
+	coalesce(w, r, codeCoalesceKey(code), codeCoalesceTTL, func(w http.ResponseWriter, r *http.Request) {
+		finishCallback(w, r, flow, code)
+	})
+}
+
+// finishCallback signs in the user with the authorization code of the
+// callback and invites them.
+func finishCallback(w http.ResponseWriter, r *http.Request, flow flowState, code string) {
+	// With SSO configured, nobody gets here without having signed in first.
+	if oidc != nil && (flow.SSO == nil || time.Now().Unix() >= flow.SSO.Expires) {
+		redirectToErrorPage(w, r, ErrInvalidState.WithMessage("Please sign in with your company account first."))
+		return
+	}
+	link, err := flowLink(flow)
+	if err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+
+	ctx := withTrail(context.Background(), trailOf(r))
+	token, err := activeProvider.OAuthConfig().Exchange(withAPILogging(ctx), code)
+	if err != nil {
+		// Exchange errors can quote the request, code included.
+		err = redactError(err, code)
+		log.Printf("Failed to exchange code: %v", err)
+		redirectToErrorPage(w, r, ErrOAuthExchange.Wrap(err))
+		return
+	}
+
+	if flow.PublicMembership {
+		flow.userToken = token
+	}
+
+	user, err := activeProvider.User(ctx, token)
+	if err != nil {
+		log.Printf("Failed to get user info: %v", err)
+		redirectToErrorPage(w, r, asError(err, ErrUserInfo))
+		return
+	}
+	trailOf(r).add(trailStep{Step: stepSignedIn, Username: user.Username})
+	signedIn := activityEvent{Provider: activeProvider.Name(), UserID: user.ID, Username: user.Username, Campaign: flow.Campaign, Variants: flow.Variants}
+	flow.Source.annotate(&signedIn)
+	publish(ctx, busEvent{Type: eventOAuthCompleted, User: user, Entry: signedIn, Visitor: visitorOf(r)})
+
+	if flow.SSO != nil {
+		if err := saveSSOLink(ctx, flow.SSO, user); err != nil {
+			log.Printf("Linking SSO user %s to %s failed: %v", flow.SSO.Subject, user.Username, err)
+			redirectToErrorPage(w, r, asError(err, ErrInvitationFailed))
+			return
+		}
+	}
+	// Two sign-ins of the same user at once, as from a double-click on the
+	// sign-in button, invite them once.
+	coalesce(w, r, userCoalesceKey(user, flow.Orgs), userCoalesceTTL, func(w http.ResponseWriter, r *http.Request) {
+		inviteIdentity(w, r, user, link, flow)
+	})
+}
+
+// flowLink checks the invite link, orgs, campaign, and preset carried in the
+// flow state, if any, and that invitations to at least one of the orgs are
+// open.
+func flowLink(flow flowState) (*linkClaims, error) {
+	var link *linkClaims
+	if flow.Link != "" {
+		var err error
+		if link, err = parseLink(flow.Link); err != nil {
+			return nil, err
+		}
+	}
+	orgs := flow.Orgs
+	if len(orgs) == 0 {
+		orgs = []string{githubOrgName}
+	}
+	open := false
+	for _, org := range orgs {
+		if knownOrg(org) == "" {
+			return nil, ErrUnknownOrg
+		}
+		rs := currentRules().forOrg(org)
+		if _, err := applyPreset(rs, inviteOptions{}, flow.Preset); err != nil {
+			return nil, err
+		}
+		if flow.Campaign != "" {
+			if _, err := findCampaign(rs, flow.Campaign); err != nil {
+				return nil, err
+			}
+		}
+		if ok, _ := invitesOpen(rs, time.Now()); ok {
+			open = true
+		}
+	}
+	if !open {
+		return nil, ErrInvitesNotOpen
+	}
+	return link, nil
+}
+
+// inviteIdentity invites a signed-in user to each org in the flow and sends
+// them on to the post-invite steps. flow carries the orgs and preset they
+// picked and the SSO identity they signed in with, if any. With a single org
+// any failure goes to the error page; with several, the user goes to the
+// success page once at least one invitation was sent, with the outcome for
+// every org in the orgs parameter.
+func inviteIdentity(w http.ResponseWriter, r *http.Request, user *Identity, link *linkClaims, flow flowState) {
+	ctx := withTrail(context.Background(), trailOf(r))
+	orgs := flow.Orgs
+	if len(orgs) == 0 {
+		orgs = []string{githubOrgName}
+	}
+
+	// A link is used once per flow, by the first org that gets that far.
+	linkUsed := false
+	var linkErr error
+	useLinkOnce := func() error {
+		if link != nil && !linkUsed {
+			linkUsed, linkErr = true, useLink(ctx, link)
+		}
+		return linkErr
+	}
+
+	var st *stepState
+	var firstErr error
+	vars := redirectVars{Username: user.Username, Campaign: flow.Campaign}
+	if link != nil && link.Campaign != "" {
+		vars.Campaign = link.Campaign
+	}
+	outcomes := make(map[string]string)
+	for _, org := range orgs {
+		opts, err := inviteToOrg(ctx, r, user, link, flow, org, useLinkOnce)
+		if err != nil {
+			outcomes[org] = asError(err, ErrInvitationFailed).Code
+			if firstErr == nil {
+				firstErr, vars.Org = err, org
+			}
+			continue
+		}
+		outcomes[org] = "invited"
+		if st == nil {
+			st = newStepState(user, opts)
+		}
+	}
+	if st == nil {
+		redirectToErrorPage(w, withRedirectVars(r, vars), firstErr)
+		return
+	}
+	if len(orgs) > 1 {
+		st.Outcomes = outcomes
+	}
+	trailOf(r).add(trailStep{Step: stepCompleted, Username: user.Username})
+
+	if !canSign() {
+		redirectToSuccess(w, r, st, nil)
+		return
+	}
+	token, err := newStepToken(r.Context(), st)
+	if err != nil {
+		log.Printf("Signing the step token for %s failed: %v", st.Username, err)
+		redirectToSuccess(w, r, st, nil)
+		return
+	}
+	continueAfterInvite(w, r, token, "", nil)
+}
+
+// inviteToOrg runs the gates of org for user and invites them, returning the
+// options they were invited with. useLinkOnce records the use of the invite
+// link, if any, once the user is past the gates.
+func inviteToOrg(ctx context.Context, r *http.Request, user *Identity, link *linkClaims, flow flowState, org string, useLinkOnce func() error) (inviteOptions, error) {
+	sso := flow.SSO
+	username := user.Username
+	rs := currentRules().forOrg(org)
+	// The org is only recorded when there is a choice of orgs.
+	entryOrg := ""
+	if len(flow.Orgs) > 0 {
+		entryOrg = org
+	}
+	if open, _ := invitesOpen(rs, time.Now()); !open {
+		return inviteOptions{}, ErrInvitesNotOpen
+	}
+
+	campaign := flow.Campaign
+	if link != nil && link.Campaign != "" {
+		campaign = link.Campaign
+	}
+	// Without the flow state to carry them, the variants are worked out
+	// again from the visitor cookie.
+	variants := flow.Variants
+	if variants == nil {
+		variants = rs.variantsFor(experimentSubject(r, user))
+	}
+	needsReview := "" // Why the invitation needs an admin's approval, if it does
+	gates, err := checkGates(ctx, rs, user, campaign)
+	trail := trailOf(r)
+	evaluated := trailStep{Step: stepGatesEvaluated, Org: org, Gate: gates.Failed, ShadowGates: gates.Shadowed, SpamScore: gates.SpamScore}
+	if err != nil {
+		trail.fail(evaluated, err)
+	} else {
+		trail.add(evaluated)
+	}
+	if err != nil {
+		log.Printf("%s did not pass the account gates of %s: %v", username, org, err)
+		failed := activityEvent{Provider: activeProvider.Name(), UserID: user.ID, Username: username, Code: asError(err, ErrUserInfo).Code, Campaign: campaign, Org: entryOrg, Variants: variants}
+		gates.annotate(&failed)
+		flow.Bot.annotate(&failed)
+		flow.Source.annotate(&failed)
+		publish(ctx, busEvent{Type: eventInviteFailed, User: user, Entry: failed, Visitor: visitorOf(r)})
+		// Accounts flagged for review go to the approval queue, or else
+		// wait on the waitlist for an admin.
+		if errors.Is(err, ErrPendingReview) && approvals != nil && approvals.mode != "" {
+			needsReview = ErrPendingReview.Code
+		} else {
+			if errors.Is(err, ErrPendingReview) {
+				if _, werr := addToWaitlist(ctx, username, ErrPendingReview.Code); werr != nil {
+					log.Printf("Failed to add %s to the waitlist: %v", username, werr)
+				}
+			}
+			return inviteOptions{}, err
+		}
+	}
+	if approvals != nil && approvals.mode == approvalAll {
+		needsReview = "approval_required"
+	}
+
+	if closed, err := membershipClosed(ctx, rs, org); err != nil {
+		log.Printf("Could not check member count, continuing: %v", err)
+	} else if closed {
+		if !waitlistWhenClosed {
+			return inviteOptions{}, ErrMembershipClosed
+		}
+		if _, err := addToWaitlist(ctx, username, ErrMembershipClosed.Code); err != nil {
+			log.Printf("Failed to add %s to the waitlist: %v", username, err)
+			return inviteOptions{}, ErrMembershipClosed
+		}
+		log.Printf("Membership of %s closed, added %s to the waitlist", org, username)
+		trail.add(trailStep{Step: stepWaitlisted, Org: org})
+		recordActivity(ctx, activityEvent{Type: activityWaitlisted, Provider: activeProvider.Name(), UserID: user.ID, Username: username, Code: ErrMembershipClosed.Code, Org: entryOrg})
+		return inviteOptions{}, ErrWaitlisted
+	}
+
+	var opts inviteOptions
+	if link != nil {
+		if err := useLinkOnce(); err != nil {
+			return inviteOptions{}, err
+		}
+		opts = inviteOptions{Role: link.Role, OrgRole: link.OrgRole, Teams: link.Teams}
+	}
+	opts.Org, opts.Campaign = entryOrg, campaign
+	opts, err = applyPreset(rs, opts, flow.Preset)
+	if err != nil {
+		return inviteOptions{}, err
+	}
+	opts = applyPolicy(rs, opts, policyAttrs(ctx, rs, user, sso, opts))
+	switch {
+	case needsReview != "":
+	case needsDualControl(opts):
+		needsReview = reasonDualControl
+	case abuseFlagged(ctx, username):
+		needsReview = reasonAbuseReported
+	}
+
+	if needsReview != "" {
+		mintedBy := ""
+		if link != nil {
+			mintedBy = link.MintedBy
+		}
+		if _, err := requestApproval(ctx, user, opts, needsReview, mintedBy); err != nil {
+			log.Printf("Failed to queue %s for approval: %v", username, err)
+			return inviteOptions{}, ErrInvitationFailed.Wrap(err)
+		}
+		log.Printf("Queued %s for approval (%s)", username, needsReview)
+		trail.add(trailStep{Step: stepQueued, Org: org, Reason: needsReview})
+		return inviteOptions{}, ErrPendingReview
+	}
+
+	publish(ctx, busEvent{Type: eventInviteRequested, User: user, Entry: activityEvent{Provider: activeProvider.Name(), UserID: user.ID, Username: username, Campaign: opts.Campaign, Org: opts.Org}})
+	if err := activeProvider.Invite(ctx, user, opts); err != nil {
+		log.Printf("Error inviting user %s to %s: %v", username, org, err)
+		trail.fail(trailStep{Step: stepInviteFailed, Org: org}, err)
+		failed := activityEvent{Provider: activeProvider.Name(), UserID: user.ID, Username: username, Code: asError(err, ErrInvitationFailed).Code, Campaign: opts.Campaign, Org: opts.Org, Variants: variants}
+		flow.Source.annotate(&failed)
+		publish(ctx, busEvent{Type: eventInviteFailed, User: user, Entry: failed, Visitor: visitorOf(r)})
+		if errors.Is(err, ErrOrgSeatLimit) && waitlistWhenFull {
+			if _, werr := addToWaitlist(ctx, username, ErrOrgSeatLimit.Code); werr != nil {
+				log.Printf("Failed to add %s to the waitlist: %v", username, werr)
+			}
+		}
+		return inviteOptions{}, err
+	}
+
+	log.Printf("Successfully invited user %s to %s (role=%q teams=%v campaign=%q)", username, org, opts.Role, opts.Teams, opts.Campaign)
+	holdPublicMembership(ctx, flow, username, opts)
+	trail.add(trailStep{Step: stepInviteSent, Org: org})
+	sent := activityEvent{Provider: activeProvider.Name(), UserID: user.ID, Username: username, Campaign: opts.Campaign, Org: opts.Org, Variants: variants}
+	if sso != nil {
+		sent.Links = map[string]string{"sso": sso.Subject}
+	}
+	gates.annotate(&sent)
+	flow.Bot.annotate(&sent)
+	flow.Source.annotate(&sent)
+	publish(ctx, busEvent{Type: eventInviteSent, User: user, Entry: sent, Visitor: visitorOf(r)})
+	return opts, nil
+}
+
+// callbackError returns the error an OAuth provider redirected back with, if
+// any. access_denied means the user cancelled on the consent page.
+func callbackError(r *http.Request) error {
+	code := r.FormValue("error")
+	if code == "" {
+		return nil
+	}
+	if code == "access_denied" {
+		return ErrAuthorizationDenied
+	}
+	log.Printf("Authorization failed: %s: %s", code, r.FormValue("error_description"))
+	return ErrOAuthExchange.Wrap(fmt.Errorf("%s: %s", code, r.FormValue("error_description")))
+}
+
+// redirectToSuccess redirects to the success page on your main website,
+// with its variables expanded for st, adding params, and the SUCCESS_PARAMS
+// describing st, to its query.
+func redirectToSuccess(w http.ResponseWriter, r *http.Request, st *stepState, params url.Values) {
+	var vars redirectVars
+	if st != nil {
+		vars = redirectVars{Username: st.Username, Org: st.Org, Campaign: st.Campaign}
+	}
+	target := expandRedirectURL(successRedirectURL, vars)
+	if extra := successParamsFor(r.Context(), st); len(extra) > 0 {
+		merged := url.Values{}
+		for k, v := range extra {
+			merged[k] = v
+		}
+		for k, v := range params {
+			merged[k] = v
+		}
+		params = merged
+	}
+	if len(params) > 0 {
+		if u, err := url.Parse(target); err == nil {
+			query := u.Query()
+			for k, v := range params {
+				query[k] = v
+			}
+			u.RawQuery = query.Encode()
+			target = u.String()
+		}
+	}
+	if popupFlow(r) {
+		result := popupResult{OK: true, URL: target}
+		if st != nil {
+			result.AcceptURL = invitationURL(orgOrDefault(st.Org))
+		}
+		renderPopupResult(w, result)
+		return
+	}
+	http.Redirect(w, r, target, redirectStatus(r, redirectSuccess))
+}
+
+// Kinds of redirect, each of which can be given its own status with
+// REDIRECT_STATUS.
+const (
+	redirectLogin     = "login"     // To a sign-in page: the provider, the SSO IdP, or /login
+	redirectSuccess   = "success"   // To the success page
+	redirectError     = "error"     // To an error page
+	redirectShortLink = "shortlink" // From a short link to its invite link
+)
+
+// redirectStatus returns the status for a redirect of the given kind away
+// from r. The success and error pages end the flow, so they get 303 by
+// default: the browser always follows up with a plain GET. Sign-in
+// redirects get 307, except from form posts, which get 303 so that the
+// browser doesn't re-post.
+func redirectStatus(r *http.Request, kind string) int {
+	if status, ok := redirectStatuses[kind]; ok {
+		return status
+	}
+	switch kind {
+	case redirectSuccess, redirectError:
+		return http.StatusSeeOther
+	case redirectShortLink:
+		return http.StatusFound
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return http.StatusSeeOther
+	}
+	return http.StatusTemporaryRedirect
+}
+
+// parseRedirectStatuses parses REDIRECT_STATUS, a comma-separated list of
+// kind=status pairs such as "success=302,login=302".
+func parseRedirectStatuses(v string) (map[string]int, error) {
+	statuses := make(map[string]int)
+	for _, pair := range strings.Split(v, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kind, status, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not kind=status", pair)
+		}
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case redirectLogin, redirectSuccess, redirectError, redirectShortLink:
+		default:
+			return nil, fmt.Errorf("unknown redirect kind %q; expected login, success, error, or shortlink", kind)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(status))
+		if err != nil || (n != http.StatusFound && n != http.StatusSeeOther && n != http.StatusTemporaryRedirect) {
+			return nil, fmt.Errorf("%s: status must be 302, 303, or 307", kind)
+		}
+		statuses[kind] = n
+	}
+	return statuses, nil
+}
+
+// renderCountdown shows a page counting down to org's next invite window.
+func renderCountdown(w http.ResponseWriter, r *http.Request, org string, opensAt time.Time) {
+	if wantsJSON(r) {
+		writeJSONError(w, ErrInvitesNotOpen)
+		return
+	}
+	data := struct {
+		Org        string
+		OpensAt    time.Time
+		OpensAtISO string
+	}{Org: org, OpensAt: opensAt, OpensAtISO: opensAt.Format(time.RFC3339)}
+	renderPage(w, http.StatusOK, countdownTemplate(), data)
+}
+
+// githubMaxIdleConns is the most idle connections kept open to each GitHub
+// host.
+const githubMaxIdleConns = 32
+
+// githubTransport is the connection pool of all GitHub API and OAuth calls,
+// so that requests reuse open TLS connections instead of dialing GitHub.
+var githubTransport = newGitHubTransport()
+
+func newGitHubTransport() http.RoundTripper {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
+	}
+	t = t.Clone()
+	t.MaxIdleConns = 4 * githubMaxIdleConns
+	t.MaxIdleConnsPerHost = githubMaxIdleConns
+	return t
+}
+
+// adminGitHub is the client authenticated with the Personal Access Token
+// (PAT), built once by applyConfig and shared by all requests.
+var adminGitHub *github.Client
+
+// adminClient returns the client authenticated with the PAT.
+func adminClient() *github.Client {
+	return adminGitHub
+}
+
+// newAdminGitHub builds the client of adminClient.
+func newAdminGitHub() *github.Client {
+	return newGitHubClient(&http.Client{Transport: &oauth2.Transport{
+		Source: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: githubPat}),
+		Base:   newETagTransport(apiLogClient.Transport),
+	}})
+}
+
+// newGitHubClient returns a GitHub API client using httpClient, for
+// github.com or the GITHUB_URL server.
+func newGitHubClient(httpClient *http.Client) *github.Client {
+	client, err := githubClientAt(githubURL, httpClient)
+	if err != nil {
+		// GITHUB_URL was parsed when the configuration was loaded.
+		panic(err)
+	}
+	return client
+}
+
+// githubClientAt returns a GitHub API client for the server at base, or for
+// github.com when base is empty.
+func githubClientAt(base string, httpClient *http.Client) (*github.Client, error) {
+	if base == "" {
+		return github.NewClient(httpClient), nil
+	}
+	base = strings.TrimSuffix(base, "/")
+	return github.NewEnterpriseClient(base+"/api/v3/", base+"/api/uploads/", httpClient)
+}
+
+// githubOAuthEndpoint returns the OAuth endpoint of github.com or of the
+// GITHUB_URL server.
+func githubOAuthEndpoint() oauth2.Endpoint {
+	if githubURL == "" {
+		return githuboauth.Endpoint
+	}
+	return oauth2.Endpoint{
+		AuthURL:  githubURL + "/login/oauth/authorize",
+		TokenURL: githubURL + "/login/oauth/access_token",
+	}
+}
+
+// githubWebURL returns the base URL of GitHub's web pages.
+func githubWebURL() string {
+	if githubURL == "" {
+		return "https://github.com"
+	}
+	return githubURL
+}
+
+// inviteOptions controls how a user is invited.
+type inviteOptions struct {
+	Role     string   `json:"role,omitempty"`     // Org role: "member" (default), "admin", or "billing_manager"
+	OrgRole  string   `json:"org_role,omitempty"` // Custom org role, assigned once the user has joined
+	Teams    []string `json:"teams,omitempty"`    // Team slugs to add the user to
+	Campaign string   `json:"campaign,omitempty"` // Campaign the invite came from, for logging
+	Org      string   `json:"org,omitempty"`      // Org to invite to; githubOrgName when empty
+}
+
+// inviteUser invites username to the org. It checks the existing membership
+// first so that current members and pending invitees get a precise error code
+// (and existing members never have their role overwritten).
+func inviteUser(ctx context.Context, client *github.Client, username string, opts inviteOptions) error {
+	org := orgOrDefault(opts.Org)
+	membership, resp, err := client.Organizations.GetOrgMembership(ctx, username, org)
+	switch {
+	case err == nil:
+		if membership.GetState() == "pending" {
+			return ErrAlreadyInvited
+		}
+		return ErrAlreadyMember
+	case resp == nil || resp.StatusCode != http.StatusNotFound:
+		return classifyInviteError(err)
+	}
+
+	if opts.Role == roleBillingManager {
+		return inviteBillingManager(ctx, client, username, opts)
+	}
+	if err := checkSeats(ctx, client, org); err != nil {
+		return err
+	}
+
+	// Invite the user to the organization by editing their org membership
+	var m *github.Membership
+	if opts.Role != "" {
+		m = &github.Membership{Role: github.String(opts.Role)}
+	}
+	if _, _, err := client.Organizations.EditOrgMembership(ctx, username, org, m); err != nil {
+		return classifyInviteError(err)
+	}
+	holdOrgRole(ctx, username, opts)
+
+	// Team memberships stay pending until the org invitation is accepted.
+	if len(opts.Teams) > 0 && !capabilitiesOf(ctx, org).Teams {
+		log.Printf("Not adding %s to teams %v: GITHUB_PAT cannot manage the teams of %s", username, opts.Teams, org)
+		return nil
+	}
+	for _, slug := range opts.Teams {
+		if _, _, err := client.Teams.AddTeamMembershipBySlug(ctx, org, slug, username, nil); err != nil {
+			log.Printf("Failed to add %s to team %s: %v", username, slug, err)
+		}
+	}
+	return nil
+}
+
+// redirectToErrorPage redirects the user to your site's error page with details.
+// Requests that ask for JSON get a JSON error body with the same code instead.
+func redirectToErrorPage(w http.ResponseWriter, r *http.Request, err error) {
+	e := asError(err, ErrInvitationFailed)
+	if wantsJSON(r) {
+		writeJSONError(w, e)
+		return
+	}
+
+	// Parse the base error URL
+	vars := redirectVarsOf(r)
+	vars.ErrorCode = e.Code
+	parsedURL, perr := url.Parse(expandRedirectURL(errorPageURL(e), vars))
+	if perr != nil {
+		http.Error(w, "Server configuration error: Invalid error redirect URL.", http.StatusInternalServerError)
+		return
+	}
+
+	// Add error details as query parameters
+	query := parsedURL.Query()
+	query.Set("error_code", e.Code)
+	if !errorCodeOnly {
+		query.Set("error_message", errorPageMessage(e.Message))
+	}
+	// The request ID looks up the trail of the flow, for support.
+	if trail := trailOf(r); trail.requestID() != "" {
+		trail.fail(trailStep{Step: stepFailed}, e)
+		query.Set("request_id", trail.requestID())
+	}
+	parsedURL.RawQuery = query.Encode()
+
+	if popupFlow(r) {
+		renderPopupResult(w, popupResult{ErrorCode: e.Code, URL: parsedURL.String()})
+		return
+	}
+	http.Redirect(w, r, parsedURL.String(), redirectStatus(r, redirectError))
+}
+
+// maxErrorMessageLen is the most characters of error_message passed to the
+// error page.
+const maxErrorMessageLen = 200
+
+// errorPageMessage returns msg as passed to the error page: redacted, on one
+// line without control characters, and at most maxErrorMessageLen
+// characters long. The query encoding takes care of the rest.
+func errorPageMessage(msg string) string {
+	msg = strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return ' '
+		}
+		return c
+	}, redact(msg))
+	msg = strings.Join(strings.Fields(msg), " ")
+	if runes := []rune(msg); len(runes) > maxErrorMessageLen {
+		msg = strings.TrimSpace(string(runes[:maxErrorMessageLen-1])) + "…"
+	}
+	return msg
+}
+
+// errorPageURL returns the page that errors with e's code are sent to.
+func errorPageURL(e *Error) string {
+	switch {
+	case e.Is(ErrOrgSeatLimit) && orgFullRedirectURL != "":
+		return orgFullRedirectURL
+	case (e.Is(ErrMembershipClosed) || e.Is(ErrWaitlisted)) && closedRedirectURL != "":
+		return closedRedirectURL
+	case e.Is(ErrCallbackUsed) && usedRedirectURL != "":
+		return usedRedirectURL
+	case e.Is(ErrAuthorizationDenied) && cancelledRedirectURL != "":
+		return cancelledRedirectURL
+	}
+	return errorRedirectURL
+}
+
+// errorBody is the JSON shape of an error response.
+type errorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeJSONError writes e as a JSON error response.
+func writeJSONError(w http.ResponseWriter, e *Error) {
+	status, body := errorResponse(e)
+	writeJSON(w, status, body)
+}
+
+// errorResponse returns the HTTP status and JSON body for e.
+func errorResponse(e *Error) (int, errorBody) {
+	var body errorBody
+	body.Error.Code = e.Code
+	body.Error.Message = redact(e.Message)
+	status := e.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+	return status, body
+}
+
+// wantsJSON reports whether the client asked for a JSON response.
+func wantsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mt == "application/json" {
+			return true
+		}
+	}
+	return false
+}
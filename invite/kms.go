@@ -0,0 +1,343 @@
+package invite
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kmsTimeout bounds each call to a KMS.
+const kmsTimeout = 5 * time.Second
+
+// Schemes of SIGNING_KMS_KEY and SUCCESS_TOKEN_KMS_KEY.
+const (
+	kmsAWSScheme = "aws-kms://"
+	kmsGCPScheme = "gcp-kms://"
+)
+
+var kmsHTTPClient = &http.Client{Timeout: kmsTimeout}
+
+// A kmsClient signs with an ECDSA P-256 key held by a KMS.
+type kmsClient interface {
+	// signDigest signs a SHA-256 digest, returning an ASN.1 DER signature.
+	signDigest(ctx context.Context, digest []byte) ([]byte, error)
+	// publicKey fetches the public half of the key.
+	publicKey(ctx context.Context) (*ecdsa.PublicKey, error)
+}
+
+// kmsSigner signs tokens through a KMS, which keeps the private key, and
+// verifies them locally with the public key, fetched once.
+type kmsSigner struct {
+	client kmsClient
+
+	mu  sync.Mutex
+	pub *ecdsa.PublicKey
+}
+
+// newKMSSigner returns the signer of a KMS key, given as
+// aws-kms://arn:aws:kms:region:account:key/id, with the AWS credentials of
+// the environment, or as gcp-kms://projects/.../cryptoKeyVersions/n, with
+// the service account of the instance. The key must be an ECDSA P-256 key
+// for signing with SHA-256. KMS_ENDPOINT overrides the API endpoint, for
+// private endpoints.
+func newKMSSigner(spec string) (*kmsSigner, error) {
+	endpoint := strings.TrimSuffix(getenv("KMS_ENDPOINT"), "/")
+	switch {
+	case strings.HasPrefix(spec, kmsAWSScheme):
+		arn := strings.TrimPrefix(spec, kmsAWSScheme)
+		region := getenv("AWS_REGION")
+		if parts := strings.Split(arn, ":"); len(parts) >= 6 && parts[0] == "arn" && parts[2] == "kms" {
+			region = parts[3]
+		}
+		if region == "" {
+			return nil, errors.New("the region is neither in the key ARN nor in AWS_REGION")
+		}
+		if endpoint == "" {
+			endpoint = "https://kms." + region + ".amazonaws.com"
+		}
+		return &kmsSigner{client: &awsKMS{keyID: arn, region: region, endpoint: endpoint}}, nil
+	case strings.HasPrefix(spec, kmsGCPScheme):
+		name := strings.TrimPrefix(spec, kmsGCPScheme)
+		if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/cryptoKeyVersions/") {
+			return nil, errors.New("expected gcp-kms://projects/.../cryptoKeyVersions/n")
+		}
+		if endpoint == "" {
+			endpoint = "https://cloudkms.googleapis.com"
+		}
+		return &kmsSigner{client: &gcpKMS{name: name, endpoint: endpoint}}, nil
+	}
+	return nil, fmt.Errorf("expected %s or %s URI", kmsAWSScheme, kmsGCPScheme)
+}
+
+func (s *kmsSigner) sign(ctx context.Context, body []byte) ([]byte, error) {
+	digest := sha256.Sum256(body)
+	ctx, cancel := context.WithTimeout(ctx, kmsTimeout)
+	defer cancel()
+	return s.client.signDigest(ctx, digest[:])
+}
+
+func (s *kmsSigner) verify(body, sig []byte) bool {
+	pub, err := s.publicKey()
+	if err != nil {
+		log.Printf("ERROR: Could not fetch the public key of the KMS key: %v", err)
+		return false
+	}
+	digest := sha256.Sum256(body)
+	return ecdsa.VerifyASN1(pub, digest[:], sig)
+}
+
+// publicKey returns the public key, fetching it on first use.
+func (s *kmsSigner) publicKey() (*ecdsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pub != nil {
+		return s.pub, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	pub, err := s.client.publicKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.pub = pub
+	return pub, nil
+}
+
+// jwk returns the public key as a JSON Web Key, with its RFC 7638
+// thumbprint as the key ID.
+func (s *kmsSigner) jwk() (map[string]string, error) {
+	pub, err := s.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	b, err := pub.ECDH()
+	if err != nil {
+		return nil, err
+	}
+	point := b.Bytes() // 0x04 || x || y
+	x, y := base64.RawURLEncoding.EncodeToString(point[1:33]), base64.RawURLEncoding.EncodeToString(point[33:])
+	thumbprint := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	return map[string]string{
+		"kty": "EC", "crv": "P-256", "x": x, "y": y, "alg": "ES256", "use": "sig",
+		"kid": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	}, nil
+}
+
+// kmsKeyID is the ID tokens name a KMS key by, derived from its URI.
+func kmsKeyID(spec string) string {
+	sum := sha256.Sum256([]byte(spec))
+	return "kms-" + hex.EncodeToString(sum[:6])
+}
+
+// parseECDSAPublicKey parses a DER SubjectPublicKeyInfo holding a P-256 key.
+func parseECDSAPublicKey(der []byte) (*ecdsa.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok || pub.Curve.Params().Name != "P-256" {
+		return nil, errors.New("the key is not an ECDSA P-256 key")
+	}
+	return pub, nil
+}
+
+// kmsDo sends req and decodes the JSON response into out.
+func kmsDo(req *http.Request, out any) error {
+	resp, err := kmsHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(redact(string(b))))
+	}
+	return json.Unmarshal(b, out)
+}
+
+// awsKMS is a key of AWS KMS, called with Signature Version 4 and the
+// credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+// AWS_SESSION_TOKEN, which Lambda sets for the function's role.
+type awsKMS struct {
+	keyID, region, endpoint string
+}
+
+func (k *awsKMS) signDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	var out struct{ Signature []byte }
+	err := k.call(ctx, "Sign", map[string]any{"KeyId": k.keyID, "Message": digest, "MessageType": "DIGEST", "SigningAlgorithm": "ECDSA_SHA_256"}, &out)
+	return out.Signature, err
+}
+
+func (k *awsKMS) publicKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	var out struct{ PublicKey []byte }
+	if err := k.call(ctx, "GetPublicKey", map[string]any{"KeyId": k.keyID}, &out); err != nil {
+		return nil, err
+	}
+	return parseECDSAPublicKey(out.PublicKey)
+}
+
+// call calls the action of the KMS API.
+func (k *awsKMS) call(ctx context.Context, action string, body, out any) error {
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	accessKey, secretKey := getenv("AWS_ACCESS_KEY_ID"), getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+	if token := getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signAWSRequest(req, b, accessKey, secretKey, k.region, "kms", time.Now())
+	return kmsDo(req, out)
+}
+
+// signAWSRequest adds the Signature Version 4 authorization of req, whose
+// body is body, to its headers.
+func signAWSRequest(req *http.Request, body []byte, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		names = append(names, lower)
+		values[lower] = strings.TrimSpace(req.Header.Get(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + values[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:])}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// gcpKMS is a key version of Google Cloud KMS, called with an access token
+// of the service account of the instance, from the metadata server, or
+// GCP_ACCESS_TOKEN when set.
+type gcpKMS struct {
+	name, endpoint string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func (k *gcpKMS) signDigest(ctx context.Context, digest []byte) ([]byte, error) {
+	b, _ := json.Marshal(map[string]any{"digest": map[string][]byte{"sha256": digest}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+"/v1/"+k.name+":asymmetricSign", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := k.do(req, &out); err != nil {
+		return nil, err
+	}
+	return out.Signature, nil
+}
+
+func (k *gcpKMS) publicKey(ctx context.Context) (*ecdsa.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.endpoint+"/v1/"+k.name+"/publicKey", nil)
+	if err != nil {
+		return nil, err
+	}
+	var out struct {
+		PEM string `json:"pem"`
+	}
+	if err := k.do(req, &out); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(out.PEM))
+	if block == nil {
+		return nil, errors.New("the public key is not PEM encoded")
+	}
+	return parseECDSAPublicKey(block.Bytes)
+}
+
+// do sends req with an access token.
+func (k *gcpKMS) do(req *http.Request, out any) error {
+	token, err := k.accessToken(req.Context())
+	if err != nil {
+		return fmt.Errorf("getting an access token: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return kmsDo(req, out)
+}
+
+// accessToken returns GCP_ACCESS_TOKEN, or a token of the metadata server,
+// cached until shortly before it expires.
+func (k *gcpKMS) accessToken(ctx context.Context) (string, error) {
+	if token := getenv("GCP_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Now().Before(k.expires) {
+		return k.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := kmsDo(req, &out); err != nil {
+		return "", err
+	}
+	k.token, k.expires = out.AccessToken, time.Now().Add(time.Duration(out.ExpiresIn)*time.Second-time.Minute)
+	return k.token, nil
+}
@@ -0,0 +1,88 @@
+package invite
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request limits, read in initVars.
+var (
+	requestTimeout time.Duration            // Default time limit per request (0 = none)
+	routeTimeouts  map[string]time.Duration // Overrides by path prefix
+	maxBodyBytes   int64                    // Largest accepted request body
+)
+
+// untimedPaths stream responses for as long as the client stays connected,
+// which a timeout would cut off. Entries ending in "/" cover the paths
+// under them.
+var untimedPaths = []string{"/admin/events", "/ws/status/"}
+
+// withLimits wraps h with the request body limit and the time limit for the
+// route. Timed-out requests get a friendly page, or a JSON error for API
+// clients.
+func withLimits(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBodyBytes {
+			redirectToErrorPage(w, r, ErrPayloadTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+
+		d := timeoutFor(r.URL.Path)
+		if d <= 0 {
+			h(w, r)
+			return
+		}
+		var msg string
+		if wantsJSON(r) || strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/") {
+			// TimeoutHandler keeps headers the handler sets, so this only
+			// sticks for the timeout response and handlers that set none.
+			w.Header().Set("Content-Type", "application/json")
+			_, body := errorResponse(ErrRequestTimeout)
+			b, _ := json.Marshal(body)
+			msg = string(b)
+		} else {
+			msg = string(timeoutPage())
+		}
+		http.TimeoutHandler(h, d, msg).ServeHTTP(w, r)
+	}
+}
+
+// timeoutFor returns the time limit for path: the longest matching prefix
+// in ROUTE_TIMEOUTS, or REQUEST_TIMEOUT.
+func timeoutFor(path string) time.Duration {
+	for _, p := range untimedPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return 0
+		}
+	}
+	d, best := requestTimeout, ""
+	for prefix, t := range routeTimeouts {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(best) {
+			d, best = t, prefix
+		}
+	}
+	return d
+}
+
+// parseRouteTimeouts parses ROUTE_TIMEOUTS, a comma-separated list of
+// prefix=duration entries such as "/admin/bulk=60s,/qr=2s". A duration of 0
+// removes the limit.
+func parseRouteTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		prefix, value, _ := strings.Cut(entry, "=")
+		d, err := time.ParseDuration(value)
+		if !strings.HasPrefix(prefix, "/") || err != nil {
+			return nil, fmt.Errorf("invalid entry %q: want /prefix=duration", entry)
+		}
+		timeouts[prefix] = d
+	}
+	return timeouts, nil
+}
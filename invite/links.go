@@ -0,0 +1,164 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// linkClaims is the content of a signed invite link (the t parameter of
+// /login). Links are minted by admins, so users cannot tamper with the teams
+// or role they are invited with.
+type linkClaims struct {
+	ID       string   `json:"jti"`
+	Teams    []string `json:"teams,omitempty"`
+	Role     string   `json:"role,omitempty"`
+	OrgRole  string   `json:"org_role,omitempty"` // Custom org role, for orgs using fine-grained roles
+	Campaign string   `json:"campaign,omitempty"`
+	Orgs     []string `json:"orgs,omitempty"`     // Orgs to join, when the deployment serves several
+	Expires  int64    `json:"exp,omitempty"`      // Unix seconds; 0 = never
+	MaxUses  int      `json:"max_uses,omitempty"` // 0 = unlimited
+	MintedBy string   `json:"by,omitempty"`       // Admin who minted the link, for DUAL_CONTROL_ROLES
+}
+
+const linkUsesKey = "link:uses:"
+
+// parseLink verifies a signed link token and checks its expiry.
+func parseLink(token string) (*linkClaims, error) {
+	payload, err := verifyToken(tokenLink, token)
+	if err != nil {
+		return nil, ErrInvalidLink.Wrap(err)
+	}
+	var claims linkClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidLink.Wrap(err)
+	}
+	// mintLink gives every link an ID, which its uses are counted by.
+	if claims.ID == "" {
+		return nil, ErrInvalidLink
+	}
+	if claims.Expires != 0 && time.Now().Unix() >= claims.Expires {
+		return nil, ErrLinkExpired
+	}
+	return &claims, nil
+}
+
+// useLink records one use of the link and fails once MaxUses is exceeded.
+func useLink(ctx context.Context, claims *linkClaims) error {
+	if claims.MaxUses <= 0 {
+		return nil
+	}
+	var ttl time.Duration
+	if claims.Expires != 0 {
+		ttl = time.Until(time.Unix(claims.Expires, 0))
+	}
+	n, err := dataStore.Incr(ctx, linkUsesKey+claims.ID, ttl)
+	if err != nil {
+		return ErrInvalidLink.Wrap(err)
+	}
+	if n > int64(claims.MaxUses) {
+		return ErrLinkExhausted
+	}
+	return nil
+}
+
+// validRole reports whether role can be granted through a link.
+func validRole(role string) bool {
+	return role == "" || role == "member" || role == "admin"
+}
+
+// mintLinkRequest is the body of POST /admin/links.
+type mintLinkRequest struct {
+	Teams     []string `json:"teams"`
+	Role      string   `json:"role"`
+	OrgRole   string   `json:"org_role"`
+	Campaign  string   `json:"campaign"`
+	Orgs      []string `json:"orgs"`
+	ExpiresIn string   `json:"expires_in"` // Go duration such as "72h"
+	MaxUses   int      `json:"max_uses"`
+	MintedBy  string   `json:"-"` // The admin minting the link
+}
+
+// mintLinkResponse is the response of POST /admin/links.
+type mintLinkResponse struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Token     string     `json:"token"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// mintLink creates a signed invite link token from req.
+func mintLink(ctx context.Context, req mintLinkRequest) (*mintLinkResponse, error) {
+	if !canSign() {
+		return nil, ErrConfig.WithMessage("SIGNING_KEY or SIGNING_KMS_KEY must be set to mint links.")
+	}
+	if !validLinkRole(req.Role) {
+		return nil, ErrBadRequest.WithMessage("role must be \"member\", \"admin\", or \"billing_manager\".")
+	}
+	if req.OrgRole != "" && (!validOrgRoleName.MatchString(req.OrgRole) || req.Role == roleBillingManager) {
+		return nil, ErrBadRequest.WithMessage("org_role must be the name of a custom org role, and cannot be given to billing managers.")
+	}
+	orgs := make([]string, len(req.Orgs))
+	for i, org := range req.Orgs {
+		if orgs[i] = knownOrg(org); orgs[i] == "" {
+			return nil, ErrBadRequest.WithMessage("orgs must be among " + strings.Join(githubOrgs, ", ") + ".")
+		}
+	}
+
+	claims := linkClaims{
+		ID:       randomID(8),
+		Teams:    req.Teams,
+		Role:     req.Role,
+		OrgRole:  req.OrgRole,
+		Campaign: req.Campaign,
+		Orgs:     orgs,
+		MaxUses:  req.MaxUses,
+		MintedBy: req.MintedBy,
+	}
+	resp := &mintLinkResponse{ID: claims.ID}
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return nil, ErrBadRequest.WithMessage("expires_in must be a positive duration such as 72h.")
+		}
+		expires := time.Now().Add(d).UTC().Truncate(time.Second)
+		claims.Expires = expires.Unix()
+		resp.ExpiresAt = &expires
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return nil, ErrConfig.Wrap(err)
+	}
+	if resp.Token, err = signToken(ctx, tokenLink, payload); err != nil {
+		return nil, ErrConfig.Wrap(err)
+	}
+	return resp, nil
+}
+
+// handleMintLink creates a signed invite link.
+func handleMintLink(w http.ResponseWriter, r *http.Request) {
+	var req mintLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+		return
+	}
+	req.MintedBy = adminActor(r)
+	resp, err := mintLink(r.Context(), req)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrBadRequest))
+		return
+	}
+	resp.URL = publicBaseURL(r) + loginPath(resp.Token)
+	recordAudit(r.Context(), r, adminActor(r), auditLinkMinted, map[string]any{"id": resp.ID, "role": req.Role, "org_role": req.OrgRole, "teams": req.Teams, "campaign": req.Campaign, "max_uses": req.MaxUses, "expires_at": resp.ExpiresAt})
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// loginPath returns the /login path for a signed link token.
+func loginPath(token string) string {
+	return "/login?t=" + url.QueryEscape(token)
+}
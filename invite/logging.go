@@ -0,0 +1,168 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// logLevel is the level below which log lines are dropped. It is set from
+// LOG_LEVEL and can be changed at runtime through /admin/log-level.
+var logLevel = new(slog.LevelVar)
+
+// parseLogLevel parses a LOG_LEVEL: debug, info, warn, or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q; expected debug, info, warn, or error", s)
+}
+
+// setupLogging routes the standard logger through a text or JSON slog
+// handler at the configured level, redacting secrets. The settings were checked by validate.
+func setupLogging(cfg *Config) {
+	level, _ := parseLogLevel(cfg.LogLevel)
+	logLevel.Set(level)
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	if strings.EqualFold(cfg.LogFormat, "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	h = redactHandler{h}
+	slog.SetDefault(slog.New(h))
+	// SetDefault sends the standard logger to h at the info level; the
+	// bridge instead takes the level from the message prefix.
+	log.SetFlags(0)
+	log.SetOutput(logBridge{h})
+}
+
+// logBridge writes lines of the standard logger to a slog handler. Lines
+// starting with "FATAL:" or "ERROR:" are logged as errors, "WARN:" or
+// "WARNING:" as warnings, "DEBUG:" as debug, and the rest as info.
+type logBridge struct {
+	h slog.Handler
+}
+
+func (b logBridge) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	for _, prefix := range []struct {
+		text  string
+		level slog.Level
+	}{
+		{"FATAL:", slog.LevelError},
+		{"ERROR:", slog.LevelError},
+		{"WARNING:", slog.LevelWarn},
+		{"WARN:", slog.LevelWarn},
+		{"DEBUG:", slog.LevelDebug},
+	} {
+		if rest, ok := strings.CutPrefix(msg, prefix.text); ok {
+			msg, level = strings.TrimSpace(rest), prefix.level
+			break
+		}
+	}
+	ctx := context.Background()
+	if !b.h.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	if err := b.h.Handle(ctx, slog.NewRecord(time.Now(), level, msg, 0)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// apiLogTransport logs the metadata of GitHub API calls at the debug level:
+// method, path, status, duration, rate limit, and request ID, and records
+// them in the trail of the flow making them, if any. Query strings,
+// headers other than these, and bodies are never logged, as they can carry
+// tokens and secrets.
+type apiLogTransport struct {
+	base http.RoundTripper
+}
+
+func (t apiLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !slog.Default().Enabled(req.Context(), slog.LevelDebug) {
+		resp, err := t.base.RoundTrip(req)
+		traceAPICall(req, resp, err)
+		return resp, err
+	}
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	traceAPICall(req, resp, err)
+	attrs := []any{
+		"method", req.Method,
+		"host", req.URL.Host,
+		"path", req.URL.Path,
+		"duration_ms", time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	} else {
+		attrs = append(attrs, "status", resp.StatusCode)
+		for attr, header := range map[string]string{
+			"rate_remaining": "X-RateLimit-Remaining",
+			"rate_reset":     "X-RateLimit-Reset",
+			"request_id":     "X-GitHub-Request-Id",
+		} {
+			if v := resp.Header.Get(header); v != "" {
+				attrs = append(attrs, attr, v)
+			}
+		}
+	}
+	slog.DebugContext(req.Context(), "GitHub API call", attrs...)
+	return resp, err
+}
+
+// apiLogClient is the HTTP client of GitHub API and OAuth calls.
+var apiLogClient = &http.Client{Transport: apiLogTransport{githubTransport}}
+
+// withAPILogging returns ctx with the logged HTTP client, so that the
+// oauth2 clients and token exchanges made with it are logged.
+func withAPILogging(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, apiLogClient)
+}
+
+// handleLogLevel reports the log level, and with PUT changes it until the
+// instance restarts.
+func handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+			return
+		}
+		level, err := parseLogLevel(body.Level)
+		if err != nil {
+			writeJSONError(w, ErrBadRequest.WithMessage(err.Error()))
+			return
+		}
+		previous := logLevel.Level()
+		logLevel.Set(level)
+		recordAudit(r.Context(), r, adminActor(r), auditLogLevelChanged, map[string]any{"from": levelName(previous), "to": levelName(level)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"level": levelName(logLevel.Level())})
+}
+
+// levelName returns the LOG_LEVEL name of level.
+func levelName(level slog.Level) string {
+	return strings.ToLower(level.String())
+}
@@ -0,0 +1,149 @@
+package invite
+
+import (
+	"cmp"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Settings of where sign-ins can start, read in loadLoginOriginConfig.
+var (
+	loginOrigins     []string // Origins of the sites that can start a sign-in with POST, from LOGIN_ORIGINS
+	loginRequirePost bool     // Sign-ins linked from elsewhere need a click on our own page, from LOGIN_REQUIRE_POST
+)
+
+var loginConfirmTemplate = lazyTemplate("login_confirm", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}</style>
+</head>
+<body{{if .Variant}} data-variant="{{.Variant}}"{{end}}>
+<h1>{{.Title}}</h1>
+<p>{{.Text}}</p>
+<form method="post" action="{{.Action}}">
+<p><button type="submit">{{.Button}}</button></p>
+</form>
+</body>
+</html>
+`)
+
+// loadLoginOriginConfig reads LOGIN_ORIGINS and LOGIN_REQUIRE_POST. Without
+// LOGIN_ORIGINS, the sites of the success and error pages can start
+// sign-ins, as that is where the button usually is.
+func loadLoginOriginConfig() {
+	loginOrigins = nil
+	if list := getenv("LOGIN_ORIGINS"); list != "" {
+		for _, o := range strings.Split(list, ",") {
+			if o = strings.TrimSpace(o); o == "" {
+				continue
+			}
+			origin, ok := originOf(o)
+			if !ok {
+				log.Fatalf("FATAL: LOGIN_ORIGINS entries must be origins such as https://example.com, not %q.", o)
+			}
+			loginOrigins = append(loginOrigins, origin)
+		}
+	} else {
+		for _, target := range []string{successRedirectURL, errorRedirectURL} {
+			if origin, ok := originOf(target); ok && !slices.Contains(loginOrigins, origin) {
+				loginOrigins = append(loginOrigins, origin)
+			}
+		}
+	}
+	loginRequirePost = envBool("LOGIN_REQUIRE_POST")
+}
+
+// originOf returns the origin of an absolute URL, as browsers send it in
+// the Origin header.
+func originOf(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", false
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host), true
+}
+
+// requestOrigin returns the origin of the page r was sent from: its Origin
+// header, or else that of its Referer. It is "" when neither says.
+func requestOrigin(r *http.Request) string {
+	if o := r.Header.Get("Origin"); o != "" && o != "null" {
+		return strings.ToLower(o)
+	}
+	if o, ok := originOf(r.Header.Get("Referer")); ok {
+		return o
+	}
+	return ""
+}
+
+// sameOrigin reports whether r was sent from a page of this deployment, as
+// the org chooser and the proof-of-work page send the user on.
+func sameOrigin(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "same-origin" {
+		return true
+	}
+	o := requestOrigin(r)
+	return o != "" && o == strings.ToLower(publicBaseURL(r))
+}
+
+// loginOriginAllowed reports whether a sign-in started with POST comes from
+// this deployment or from one of the LOGIN_ORIGINS. A form on any other
+// site, or one that hides where it is, cannot start one.
+func loginOriginAllowed(r *http.Request) bool {
+	if sameOrigin(r) {
+		return true
+	}
+	o := requestOrigin(r)
+	return o != "" && slices.Contains(loginOrigins, o)
+}
+
+// checkLoginStart decides whether r can start a sign-in, and answers it when
+// it cannot. POSTs must come from an allowed origin. With LOGIN_REQUIRE_POST,
+// a GET from another site, as a hotlink to /login is, gets a page with a
+// button that starts the sign-in instead.
+func checkLoginStart(w http.ResponseWriter, r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost:
+		if !loginOriginAllowed(r) {
+			log.Printf("Rejected a sign-in started from %q", requestOrigin(r))
+			redirectToErrorPage(w, r, ErrOriginNotAllowed)
+			return false
+		}
+	case http.MethodGet, http.MethodHead:
+		if loginRequirePost && !sameOrigin(r) {
+			renderLoginConfirm(w, r)
+			return false
+		}
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPost)
+		return false
+	}
+	return true
+}
+
+// renderLoginConfirm shows the button that starts the sign-in r asked for,
+// with the copy of the visitor's variant of the login_confirm experiment.
+func renderLoginConfirm(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSONError(w, ErrOriginNotAllowed.WithMessage("Start the sign-in with a POST from an allowed site."))
+		return
+	}
+	query := r.URL.Query()
+	passAttribution(query, r)
+	action := url.URL{Path: "/login", RawQuery: query.Encode()}
+	name, v := currentRules().variantFor(experimentLoginConfirm, visitorID(w, r))
+	data := struct{ Title, Text, Button, Action, Variant string }{
+		Title:   cmp.Or(v.Title, "Join "+githubOrgName),
+		Text:    cmp.Or(v.Text, "Sign in with GitHub to get your invitation to the organization."),
+		Button:  cmp.Or(v.Button, "Continue"),
+		Action:  action.String(),
+		Variant: name,
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusOK, loginConfirmTemplate(), data)
+}
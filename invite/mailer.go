@@ -0,0 +1,57 @@
+package invite
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailerConfig sends plain-text email over SMTP. It is nil unless SMTP_HOST
+// is set.
+type mailerConfig struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+var mailer *mailerConfig
+
+// loadMailerConfig reads the SMTP settings.
+func loadMailerConfig() {
+	host := getenv("SMTP_HOST")
+	if host == "" {
+		return
+	}
+	port := getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	m := &mailerConfig{addr: net.JoinHostPort(host, port), from: getenv("SMTP_FROM")}
+	if m.from == "" {
+		log.Fatal("FATAL: SMTP_FROM must be set when SMTP_HOST is set.")
+	}
+	if user := getenv("SMTP_USERNAME"); user != "" {
+		m.auth = smtp.PlainAuth("", user, getenv("SMTP_PASSWORD"), host)
+	}
+	mailer = m
+}
+
+// send delivers a plain-text message to a single recipient.
+func (m *mailerConfig) send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid header value")
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg.String()))
+}
@@ -0,0 +1,264 @@
+package invite
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys used to follow up on sent invitations.
+const (
+	pendingMemberKey      = "members:pending:"
+	pendingMemberIndexKey = "members:pending"
+	joinedMemberKey       = "members:joined:"
+)
+
+const (
+	// pendingMemberTTL matches how long GitHub invitations stay valid.
+	pendingMemberTTL = 7 * 24 * time.Hour
+	// reconcileBatch bounds the membership checks per maintenance run.
+	reconcileBatch = 50
+	// cronQueueBudget bounds the time a maintenance run spends on the queue.
+	cronQueueBudget = 5 * time.Second
+)
+
+// cronSecret protects /cron/maintenance, from CRON_SECRET. Vercel Cron sends
+// it as a bearer token.
+var cronSecret string
+
+// membershipChecker is implemented by providers that can tell whether a user
+// has joined, which the membership reconciliation relies on.
+type membershipChecker interface {
+	IsMember(ctx context.Context, org, username string) (bool, error)
+}
+
+// pendingMember is an invited user who has not joined yet.
+type pendingMember struct {
+	Provider string `json:"provider,omitempty"`
+	UserID   string `json:"user_id,omitempty"`
+	Username string `json:"username"`
+	Org      string `json:"org,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Email    string `json:"email,omitempty"` // Verified address, for reminders and the post-acceptance actions
+
+	InvitedAt time.Time `json:"invited_at"`
+}
+
+// joined publishes MemberJoined for m and stops tracking them. Only the first
+// of the webhook and the maintenance runs to notice publishes.
+func (m pendingMember) joined(ctx context.Context) error {
+	id := orgOrDefault(m.Org) + ":" + m.Username
+	first, err := dataStore.SetNX(ctx, joinedMemberKey+id, nil, pendingMemberTTL)
+	if err != nil {
+		return err
+	}
+	dataStore.Delete(ctx, pendingMemberKey+id)
+	if !first {
+		return nil
+	}
+	rememberContact(ctx, m)
+	user := &Identity{ID: m.UserID, Username: m.Username, Email: m.Email, EmailVerified: m.Email != ""}
+	publish(ctx, busEvent{Type: eventMemberJoined, User: user, Entry: activityEvent{Provider: m.Provider, UserID: m.UserID, Username: m.Username, Org: m.Org, Campaign: m.Campaign}})
+	return nil
+}
+
+// trackPendingMember remembers invited users, so that maintenance runs can
+// tell when they join. Invitations by email are not tracked, as there is no
+// username to check yet.
+func trackPendingMember(ctx context.Context, ev busEvent) {
+	username := ev.Entry.Username
+	if username == "" || strings.Contains(username, "@") {
+		return
+	}
+	org := orgOrDefault(ev.Entry.Org)
+	id := org + ":" + username
+	m := pendingMember{Provider: ev.Entry.Provider, UserID: ev.Entry.UserID, Username: username, Org: org, Campaign: ev.Entry.Campaign, InvitedAt: time.Now().UTC()}
+	if (acceptance != nil || reminderAfter > 0 || twoFactorNudgeInterval > 0) && ev.User != nil && ev.User.EmailVerified {
+		m.Email = ev.User.Email
+	}
+	b, _ := json.Marshal(m)
+	added, err := dataStore.SetNX(ctx, pendingMemberKey+id, b, pendingMemberTTL)
+	if err == nil && added {
+		_, err = dataStore.Append(ctx, pendingMemberIndexKey, []byte(id))
+	}
+	if err != nil {
+		log.Printf("Failed to track the invitation for %s: %v", username, err)
+	}
+}
+
+// maintenanceReport is the response of /cron/maintenance.
+type maintenanceReport struct {
+	ExpiredApprovals int              `json:"expired_approvals"`
+	QueueProcessed   int              `json:"queue_processed"`
+	MembersJoined    int              `json:"members_joined"`
+	MembersPending   int              `json:"members_pending"`
+	RemindersSent    int              `json:"reminders_sent"`
+	TwoFactorNudges  int              `json:"two_factor_nudges,omitempty"`
+	TwoFactorMissing int              `json:"two_factor_unreachable,omitempty"` // Members without 2FA and no known address
+	APIInvitesPruned int              `json:"api_invites_pruned,omitempty"`
+	MemberCounts     map[string]int   `json:"member_counts,omitempty"`
+	TeamSync         []teamSyncResult `json:"team_sync,omitempty"` // When the teams were due for a sync
+	Alerts           []string         `json:"alerts,omitempty"`    // Alert rules firing
+	Errors           []string         `json:"errors,omitempty"`
+}
+
+// handleMaintenance runs the periodic tasks that serverless deployments have
+// no background goroutines for: it expires stale approval requests, sends
+// queued invitations, notices invited users who joined and reminds those who
+// haven't, nudges members without 2FA, syncs the teams of TEAM_SYNC, prunes
+// expired API invitations, refreshes the cached member count, and checks
+// the alert rules. Each task runs even when an earlier one fails. The
+// endpoint is disabled unless CRON_SECRET is set.
+func handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	if cronSecret == "" {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cronSecret)) != 1 {
+		writeJSONError(w, ErrUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	var report maintenanceReport
+	fail := func(task string, err error) {
+		log.Printf("Maintenance: %s failed: %v", task, err)
+		report.Errors = append(report.Errors, task+": "+err.Error())
+	}
+
+	var err error
+	if report.ExpiredApprovals, err = expireApprovals(ctx); err != nil {
+		fail("expire approvals", err)
+	}
+	report.QueueProcessed = processQueue(ctx, time.Now().Add(cronQueueBudget))
+	if report.MembersJoined, report.MembersPending, report.RemindersSent, err = reconcileMembers(ctx); err != nil {
+		fail("reconcile members", err)
+	}
+	if report.TwoFactorNudges, report.TwoFactorMissing, err = nudgeTwoFactor(ctx); err != nil {
+		fail("nudge members without 2FA", err)
+	}
+	if report.TeamSync, err = maybeSyncTeams(ctx); err != nil {
+		fail("sync teams", err)
+	}
+	if report.APIInvitesPruned, err = pruneAPIInvites(ctx); err != nil {
+		fail("prune API invitations", err)
+	}
+	if counter, ok := activeProvider.(memberCounter); ok {
+		forgetMemberCounts()
+		report.MemberCounts = make(map[string]int)
+		for _, org := range githubOrgs {
+			if n, err := counter.MemberCount(ctx, org); err != nil {
+				fail("refresh member count of "+org, err)
+			} else {
+				report.MemberCounts[org] = n
+			}
+		}
+	}
+	if alerts != nil {
+		for _, st := range checkAlerts(ctx) {
+			if st.Firing {
+				report.Alerts = append(report.Alerts, st.Spec)
+			}
+		}
+	}
+	log.Printf("Maintenance: %d approvals expired, %d queued invites processed, %d members joined, %d reminded", report.ExpiredApprovals, report.QueueProcessed, report.MembersJoined, report.RemindersSent)
+	writeJSON(w, http.StatusOK, report)
+}
+
+// expireApprovals closes pending approval requests whose approve/deny links
+// have expired, and returns how many it closed.
+func expireApprovals(ctx context.Context) (int, error) {
+	ids, err := dataStore.Range(ctx, approvalIndexKey, 0, -1)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, id := range ids {
+		a, err := loadApproval(ctx, string(id))
+		if err != nil || a.Status != "pending" || time.Since(a.CreatedAt) < approvalLinkTTL {
+			continue
+		}
+		// Claim the request like a decision would, so that it can't be
+		// decided and expired at once.
+		if ok, err := dataStore.SetNX(ctx, approvalKey+a.ID+":decided", []byte("expire"), 0); err != nil {
+			return expired, err
+		} else if !ok {
+			continue
+		}
+		now := time.Now().UTC()
+		a.Status, a.DecidedAt = "expired", &now
+		if err := saveApproval(ctx, a); err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// reconcileMembers checks up to reconcileBatch invited users for having
+// joined, publishing MemberJoined for each that has and reminding the others
+// when it is time, and returns how many joined, are still pending, and were
+// reminded. Users whose invitation has expired are dropped. The index is
+// rotated, so that successive runs get through all of it.
+func reconcileMembers(ctx context.Context) (joined, pending, reminded int, err error) {
+	checker, ok := activeProvider.(membershipChecker)
+	if !ok {
+		return 0, 0, 0, nil
+	}
+	ids, err := dataStore.Range(ctx, pendingMemberIndexKey, 0, -1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for i := range ids {
+		b, err := dataStore.Pop(ctx, pendingMemberIndexKey)
+		if errors.Is(err, store.ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return joined, pending, reminded, err
+		}
+		id := string(b)
+		entry, err := dataStore.Get(ctx, pendingMemberKey+id)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		var m pendingMember
+		if err == nil {
+			err = json.Unmarshal(entry, &m)
+		}
+		if err == nil && i < reconcileBatch {
+			var member bool
+			if member, err = checker.IsMember(ctx, orgOrDefault(m.Org), m.Username); err == nil && member {
+				if err = m.joined(ctx); err == nil {
+					joined++
+					continue
+				}
+			} else if err == nil {
+				var sent bool
+				if sent, err = remind(ctx, m); sent {
+					reminded++
+				}
+			}
+		}
+		if _, aerr := dataStore.Append(ctx, pendingMemberIndexKey, b); aerr != nil {
+			return joined, pending, reminded, aerr
+		}
+		pending++
+		if err != nil {
+			return joined, pending, reminded, err
+		}
+	}
+	return joined, pending, reminded, nil
+}
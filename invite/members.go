@@ -0,0 +1,71 @@
+package invite
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// memberCache holds each org's member count, refreshed every
+// memberCountRefresh so that /login doesn't hit the API on every visit.
+var memberCache struct {
+	sync.Mutex
+	counts map[string]cachedCount
+}
+
+// cachedCount is one org's cached member count.
+type cachedCount struct {
+	count   int
+	fetched time.Time
+}
+
+// memberCount returns the org's current member count, using the cached value
+// while it is fresh.
+func memberCount(ctx context.Context, client *github.Client, org string) (int, error) {
+	memberCache.Lock()
+	defer memberCache.Unlock()
+	if c, ok := memberCache.counts[org]; ok && time.Since(c.fetched) < memberCountRefresh {
+		return c.count, nil
+	}
+
+	// With one member per page, the index of the last page is the count.
+	opts := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 1}}
+	users, resp, err := client.Organizations.ListMembers(ctx, org, opts)
+	if err != nil {
+		return 0, err
+	}
+	count := resp.LastPage
+	if count == 0 {
+		count = len(users)
+	}
+
+	if memberCache.counts == nil {
+		memberCache.counts = make(map[string]cachedCount)
+	}
+	memberCache.counts[org] = cachedCount{count: count, fetched: time.Now()}
+	return count, nil
+}
+
+// forgetMemberCounts drops the cached member counts, so that the next
+// lookups go to the API.
+func forgetMemberCounts() {
+	memberCache.Lock()
+	memberCache.counts = nil
+	memberCache.Unlock()
+}
+
+// membershipClosed reports whether org has reached its MAX_MEMBERS. The cap
+// is not enforced for providers that cannot count members.
+func membershipClosed(ctx context.Context, rs *ruleSet, org string) (bool, error) {
+	counter, ok := activeProvider.(memberCounter)
+	if rs.maxMembers <= 0 || !ok {
+		return false, nil
+	}
+	count, err := counter.MemberCount(ctx, org)
+	if err != nil {
+		return false, err
+	}
+	return count >= rs.maxMembers, nil
+}
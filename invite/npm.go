@@ -0,0 +1,141 @@
+package invite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// npmRegistry is the registry whose org API is used.
+const npmRegistry = "https://registry.npmjs.org"
+
+// npmLinkedKey records which npm account each invited user added.
+const npmLinkedKey = "npm:linked:"
+
+var validNPMUsername = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,213}$`)
+
+// npmConfig is the optional npm org step. After the invitation the user is
+// asked for their npm username, which is then added to the npm org. The
+// registry API has no way to invite by email, so a username is required.
+type npmConfig struct {
+	org   string
+	token string // Token of an owner of the npm org
+	role  string // developer, admin or owner
+}
+
+// npm is nil when the npm step is disabled.
+var npm *npmConfig
+
+// loadNPMConfig reads the npm settings. The step is enabled by setting
+// NPM_ORG.
+func loadNPMConfig() {
+	org := strings.TrimPrefix(getenv("NPM_ORG"), "@")
+	if org == "" {
+		return
+	}
+	n := &npmConfig{org: org, token: getenv("NPM_TOKEN"), role: getenv("NPM_ROLE")}
+	if n.role == "" {
+		n.role = "developer"
+	}
+	if n.token == "" {
+		log.Fatal("FATAL: NPM_TOKEN must be set when NPM_ORG is set.")
+	}
+	if n.role != "developer" && n.role != "admin" && n.role != "owner" {
+		log.Fatal("FATAL: NPM_ROLE must be developer, admin, or owner.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when the npm step is enabled.")
+	}
+	npm = n
+}
+
+// renderNPMForm shows the form asking for the user's npm username.
+func renderNPMForm(w http.ResponseWriter, token, errMsg string) {
+	status := http.StatusOK
+	if errMsg != "" {
+		status = http.StatusBadRequest
+	}
+	renderPage(w, status, npmFormTemplate(), struct {
+		Org, NPMOrg, Token, Error string
+	}{githubOrgName, npm.org, token, errMsg})
+}
+
+// handleNPM handles the npm username form.
+func handleNPM(w http.ResponseWriter, r *http.Request) {
+	if npm == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	token := r.PostFormValue("token")
+	state, err := parseStepToken(token)
+	if err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	if r.PostFormValue("skip") != "" {
+		continueAfterInvite(w, r, token, stepNPM, url.Values{"npm": {"skipped"}})
+		return
+	}
+
+	username := strings.ToLower(strings.TrimSpace(r.PostFormValue("npm_username")))
+	if !validNPMUsername.MatchString(username) {
+		renderNPMForm(w, token, "That doesn't look like an npm username.")
+		return
+	}
+	// Each invited user can add one npm account.
+	linkKey := npmLinkedKey + activeProvider.Name() + ":" + state.UserID
+	if ok, err := dataStore.SetNX(r.Context(), linkKey, []byte(username), 0); err != nil || !ok {
+		renderNPMForm(w, token, "An npm account has already been added for you.")
+		return
+	}
+	if err := npm.addMember(r.Context(), username); err != nil {
+		log.Printf("Adding %s (npm %s) to the npm org failed: %v", state.Username, username, err)
+		dataStore.Delete(r.Context(), linkKey)
+		renderNPMForm(w, token, "We couldn't add that npm account. Check the username and try again, or skip this step.")
+		return
+	}
+
+	log.Printf("Linked %s to npm user %s", state.Username, username)
+	recordActivity(r.Context(), activityEvent{
+		Type:     activityLinked,
+		Provider: activeProvider.Name(),
+		UserID:   state.UserID,
+		Username: state.Username,
+		Campaign: state.Campaign,
+		Links:    map[string]string{"npm": username},
+	})
+	continueAfterInvite(w, r, token, stepNPM, url.Values{"npm": {"joined"}})
+}
+
+// addMember adds username to the npm org, as `npm org set` does.
+func (n *npmConfig) addMember(ctx context.Context, username string) error {
+	body, _ := json.Marshal(map[string]string{"user": username, "role": n.role})
+	u := fmt.Sprintf("%s/-/org/%s/user", npmRegistry, url.PathEscape(n.org))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+n.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("npm: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
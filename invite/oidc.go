@@ -0,0 +1,207 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+
+	"golang.org/x/oauth2"
+)
+
+// oidcConfig is the optional SSO sign-in. When enabled, users first sign in
+// with the OIDC provider (usually the company IdP) and are then sent through
+// the GitHub authorization, which only verifies the GitHub account to invite.
+type oidcConfig struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	mu       sync.Mutex
+	endpoint *oidcEndpoints // Discovered lazily, nil until then
+}
+
+// oidcEndpoints is the part of the provider's discovery document we use.
+type oidcEndpoints struct {
+	Authorization string `json:"authorization_endpoint"`
+	Token         string `json:"token_endpoint"`
+	UserInfo      string `json:"userinfo_endpoint"`
+}
+
+// ssoIdentity is the signed-in SSO user, carried in the flow state to the
+// GitHub callback.
+type ssoIdentity struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+	Expires int64  `json:"exp"`
+}
+
+// oidc is nil when SSO sign-in is disabled.
+var oidc *oidcConfig
+
+// loadOIDCConfig reads the OIDC settings. SSO is enabled by setting
+// OIDC_ISSUER.
+func loadOIDCConfig() {
+	issuer := strings.TrimSuffix(getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return
+	}
+	o := &oidcConfig{
+		issuer:       issuer,
+		clientID:     getenv("OIDC_CLIENT_ID"),
+		clientSecret: getenv("OIDC_CLIENT_SECRET"),
+		scopes:       strings.Fields(getenv("OIDC_SCOPES")),
+	}
+	if len(o.scopes) == 0 {
+		o.scopes = []string{"openid", "email", "profile"}
+	}
+	if o.clientID == "" || o.clientSecret == "" {
+		log.Fatal("FATAL: OIDC_CLIENT_ID and OIDC_CLIENT_SECRET must be set when OIDC_ISSUER is set.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when OIDC_ISSUER is set.")
+	}
+	oidc = o
+}
+
+// discover fetches the provider's discovery document, once it succeeds.
+func (o *oidcConfig) discover(ctx context.Context) (*oidcEndpoints, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.endpoint != nil {
+		return o.endpoint, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: discovery: %s", resp.Status)
+	}
+	var ep oidcEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&ep); err != nil {
+		return nil, fmt.Errorf("oidc: decoding discovery document: %v", err)
+	}
+	if ep.Authorization == "" || ep.Token == "" || ep.UserInfo == "" {
+		return nil, fmt.Errorf("oidc: discovery document is missing endpoints")
+	}
+	o.endpoint = &ep
+	return o.endpoint, nil
+}
+
+// oauth returns the OAuth configuration for the provider.
+func (o *oidcConfig) oauth(r *http.Request, ep *oidcEndpoints) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.clientID,
+		ClientSecret: o.clientSecret,
+		Scopes:       o.scopes,
+		RedirectURL:  publicBaseURL(r) + "/oidc/callback",
+		Endpoint:     oauth2.Endpoint{AuthURL: ep.Authorization, TokenURL: ep.Token},
+	}
+}
+
+// startOIDC sends the user to the SSO sign-in page. state is the encoded
+// flow state, passed on to the GitHub authorization afterwards.
+func startOIDC(w http.ResponseWriter, r *http.Request, state string) {
+	ep, err := oidc.discover(r.Context())
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		redirectToErrorPage(w, r, ErrOAuthExchange.Wrap(err))
+		return
+	}
+	http.Redirect(w, r, oidc.oauth(r, ep).AuthCodeURL(state), redirectStatus(r, redirectLogin))
+}
+
+// handleOIDCCallback completes the SSO sign-in and sends the user on to
+// GitHub to verify the account that should be invited.
+func handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	flow, ok := decodeState(r.FormValue("state"))
+	if !ok {
+		redirectToErrorPage(w, r, ErrInvalidState)
+		return
+	}
+	ctx := r.Context()
+	if err := callbackError(r); err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	if err := claimCode(ctx, r.FormValue("code")); err != nil {
+		redirectToErrorPage(w, r, err)
+		return
+	}
+	ep, err := oidc.discover(ctx)
+	if err != nil {
+		redirectToErrorPage(w, r, ErrOAuthExchange.Wrap(err))
+		return
+	}
+	conf := oidc.oauth(r, ep)
+	token, err := conf.Exchange(ctx, r.FormValue("code"))
+	if err != nil {
+		redirectToErrorPage(w, r, ErrOAuthExchange.Wrap(redactError(err, r.FormValue("code"))))
+		return
+	}
+
+	resp, err := conf.Client(ctx, token).Get(ep.UserInfo)
+	if err != nil {
+		redirectToErrorPage(w, r, ErrUserInfo.Wrap(err))
+		return
+	}
+	var info struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if err != nil || info.Subject == "" {
+		redirectToErrorPage(w, r, ErrUserInfo.Wrap(fmt.Errorf("oidc: userinfo: %v (status %s)", err, resp.Status)))
+		return
+	}
+	sso := &ssoIdentity{Subject: info.Subject, Expires: time.Now().Add(stepTTL).Unix()}
+	if info.EmailVerified {
+		sso.Email = info.Email
+	}
+	log.Printf("SSO sign-in for %s (%s)", sso.Subject, sso.Email)
+
+	flow.SSO = sso
+
+	// Returning users have already verified their GitHub account, unless
+	// making their membership public takes a token of their own.
+	if saved, err := loadSSOLink(ctx, sso.Subject); err == nil && !flow.PublicMembership {
+		link, err := flowLink(flow)
+		if err != nil {
+			redirectToErrorPage(w, r, err)
+			return
+		}
+		user := &Identity{ID: saved.UserID, Username: saved.Username, Email: sso.Email, EmailVerified: sso.Email != ""}
+		inviteIdentity(w, r, user, link, flow)
+		return
+	} else if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Loading the saved link for %s failed, asking for GitHub again: %v", sso.Subject, err)
+	}
+
+	state, err := encodeState(r.Context(), flow)
+	if err != nil {
+		redirectToErrorPage(w, r, ErrConfig.Wrap(err))
+		return
+	}
+	redirectURL := authorizeURL(flow, state)
+	http.Redirect(w, r, redirectURL, redirectStatus(r, redirectLogin))
+}
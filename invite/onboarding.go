@@ -0,0 +1,182 @@
+package invite
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// onboardingKey holds the onboarding status of a user, by status ID.
+const onboardingKey = "onboarding:"
+
+const (
+	// onboardingTTL is how long a status is kept: a little longer than a
+	// GitHub invitation stays valid.
+	onboardingTTL = 8 * 24 * time.Hour
+	// statusSocketLifetime bounds a status connection; clients reconnect.
+	statusSocketLifetime = time.Hour
+	// statusSocketPing is how often an idle status connection is pinged.
+	statusSocketPing = 30 * time.Second
+)
+
+// States of an onboarding.
+const (
+	onboardingQueued   = "queued"   // The invitation is about to be sent
+	onboardingInvited  = "invited"  // The invitation is out, waiting for the user to accept it
+	onboardingFailed   = "failed"   // No invitation was sent; Code says why
+	onboardingAccepted = "accepted" // The user joined
+)
+
+// onboardingStatus enables the status records and /ws/status/{id}, from
+// ONBOARDING_STATUS.
+var onboardingStatus bool
+
+// onboarding is where a user is in joining an org.
+type onboarding struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	Org       string    `json:"org"`
+	State     string    `json:"state"`
+	Code      string    `json:"code,omitempty"` // Error code, when failed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// loadOnboardingConfig reads ONBOARDING_STATUS.
+func loadOnboardingConfig() {
+	onboardingStatus = envBool("ONBOARDING_STATUS")
+	if onboardingStatus && len(signingKey) == 0 {
+		log.Fatal("FATAL: SIGNING_KEY must be set when ONBOARDING_STATUS is set.")
+	}
+}
+
+// onboardingID is the status ID of username joining org. It is derived
+// with SIGNING_KEY, so that it can be handed to the success page without a
+// lookup, and nobody else can guess it.
+func onboardingID(username, org string) string {
+	mac, _ := hmacSigner{signingKey}.sign(context.Background(), []byte("onboarding\n"+strings.ToLower(username)+"\n"+strings.ToLower(orgOrDefault(org))))
+	return base64.RawURLEncoding.EncodeToString(mac[:16])
+}
+
+// trackOnboarding records the state transitions of a user's onboarding.
+func trackOnboarding(ctx context.Context, ev busEvent) {
+	if ev.Entry.Username == "" {
+		return
+	}
+	org := orgOrDefault(ev.Entry.Org)
+	o := onboarding{ID: onboardingID(ev.Entry.Username, org), Username: ev.Entry.Username, Org: org, UpdatedAt: time.Now().UTC()}
+	switch ev.Type {
+	case eventInviteRequested:
+		o.State = onboardingQueued
+	case eventInviteSent:
+		o.State = onboardingInvited
+	case eventMemberJoined:
+		o.State = onboardingAccepted
+	case eventInviteFailed:
+		o.State, o.Code = onboardingFailed, ev.Entry.Code
+		if o.Code == ErrAlreadyMember.Code {
+			o.State, o.Code = onboardingAccepted, ""
+		}
+	}
+	// Members stay accepted when they sign in again.
+	if last, err := loadOnboarding(ctx, o.ID); err == nil && last.State == onboardingAccepted && o.State != onboardingAccepted {
+		return
+	}
+	b, _ := json.Marshal(o)
+	if err := dataStore.Set(ctx, onboardingKey+o.ID, b, onboardingTTL); err != nil {
+		log.Printf("Failed to record the onboarding status of %s: %v", o.Username, err)
+	}
+}
+
+// loadOnboarding returns the onboarding status with id.
+func loadOnboarding(ctx context.Context, id string) (*onboarding, error) {
+	b, err := dataStore.Get(ctx, onboardingKey+id)
+	if err != nil {
+		return nil, err
+	}
+	var o onboarding
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// handleStatusSocket pushes the onboarding status with the ID in the path,
+// /ws/status/{id}, over a WebSocket: the current state as soon as the
+// connection opens, and then every transition, as JSON text messages. The
+// connection is closed once the user has accepted. The success page gets
+// the ID as its status_id parameter. It needs a server that can hold
+// connections open, such as cmd/server.
+func handleStatusSocket(w http.ResponseWriter, r *http.Request) {
+	if !onboardingStatus {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	ctx := r.Context()
+	id := strings.TrimPrefix(r.URL.Path, "/ws/status/")
+	last, err := loadOnboarding(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return
+	}
+	conn, ok := upgradeWebSocket(w, r)
+	if !ok {
+		return
+	}
+	send := func(o *onboarding) bool {
+		b, _ := json.Marshal(o)
+		return conn.WriteText(b) == nil
+	}
+	if !send(last) {
+		conn.Close(1011)
+		return
+	}
+
+	poll := time.NewTicker(activityPollInterval)
+	defer poll.Stop()
+	ping := time.NewTicker(statusSocketPing)
+	defer ping.Stop()
+	lifetime := time.NewTimer(statusSocketLifetime)
+	defer lifetime.Stop()
+	for last.State != onboardingAccepted {
+		select {
+		case <-conn.Done():
+			return
+		case <-ctx.Done():
+			conn.Close(1001)
+			return
+		case <-lifetime.C:
+			conn.Close(1001)
+			return
+		case <-ping.C:
+			if conn.Ping() != nil {
+				return
+			}
+		case <-poll.C:
+			o, err := loadOnboarding(ctx, id)
+			if err != nil {
+				// Expired or unreadable: the client can reconnect.
+				conn.Close(1011)
+				return
+			}
+			if o.State == last.State && o.Code == last.Code {
+				continue
+			}
+			if !send(o) {
+				return
+			}
+			last = o
+		}
+	}
+	conn.Close(1000)
+}
@@ -0,0 +1,112 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"auto-invite/store"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// roleBillingManager is the org role of users who manage billing without
+// being members. GitHub only grants it through an org invitation.
+const roleBillingManager = "billing_manager"
+
+// orgRoleKey holds the custom org role to assign to an invited user once they
+// have joined, by org and username.
+const orgRoleKey = "orgrole:pending:"
+
+var validOrgRoleName = regexp.MustCompile(`^[A-Za-z0-9 _-]{1,100}$`)
+
+// validLinkRole reports whether role can be granted through a signed link,
+// which unlike the other invite paths may also make billing managers.
+func validLinkRole(role string) bool {
+	return validRole(role) || role == roleBillingManager
+}
+
+// inviteBillingManager invites username to the org as a billing manager.
+// Billing managers are not members, so they take no seat and join no teams.
+func inviteBillingManager(ctx context.Context, client *github.Client, username string, opts inviteOptions) error {
+	org := orgOrDefault(opts.Org)
+	user, _, err := client.Users.Get(ctx, username)
+	if err != nil {
+		return classifyInviteError(err)
+	}
+	if len(opts.Teams) > 0 {
+		log.Printf("Not adding billing manager %s to teams %v", username, opts.Teams)
+	}
+	invitation := &github.CreateOrgInvitationOptions{InviteeID: user.ID, Role: github.String(roleBillingManager)}
+	if _, _, err := client.Organizations.CreateOrgInvitation(ctx, org, invitation); err != nil {
+		return classifyInviteError(err)
+	}
+	return nil
+}
+
+// holdOrgRole remembers the custom org role of an invited user. GitHub only
+// assigns org roles to members, so the role is assigned by assignOrgRole when
+// the user is seen to have joined.
+func holdOrgRole(ctx context.Context, username string, opts inviteOptions) {
+	if opts.OrgRole == "" {
+		return
+	}
+	key := orgRoleKey + orgOrDefault(opts.Org) + ":" + strings.ToLower(username)
+	if err := dataStore.Set(ctx, key, []byte(opts.OrgRole), pendingMemberTTL); err != nil {
+		log.Printf("Failed to hold org role %q for %s: %v", opts.OrgRole, username, err)
+	}
+}
+
+// assignOrgRole assigns the custom org role held for a user who joined.
+func assignOrgRole(ctx context.Context, ev busEvent) {
+	org, username := orgOrDefault(ev.Entry.Org), ev.Entry.Username
+	key := orgRoleKey + org + ":" + strings.ToLower(username)
+	role, err := dataStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	}
+	if err == nil {
+		err = setOrgRole(ctx, adminClient(), org, username, string(role))
+	}
+	if err != nil {
+		log.Printf("Failed to assign org role %q to %s in %s: %v", role, username, org, err)
+		return
+	}
+	dataStore.Delete(ctx, key)
+	log.Printf("Assigned org role %q to %s in %s", role, username, org)
+}
+
+// orgRole is an entry of GET /orgs/{org}/organization-roles.
+type orgRole struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// setOrgRole assigns the custom org role called name to a member of org.
+func setOrgRole(ctx context.Context, client *github.Client, org, username, name string) error {
+	req, err := client.NewRequest("GET", fmt.Sprintf("orgs/%s/organization-roles", org), nil)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Roles []orgRole `json:"roles"`
+	}
+	if _, err := client.Do(ctx, req, &list); err != nil {
+		return err
+	}
+	for _, role := range list.Roles {
+		if !strings.EqualFold(role.Name, name) {
+			continue
+		}
+		req, err := client.NewRequest("PUT", fmt.Sprintf("orgs/%s/organization-roles/users/%s/%d", org, username, role.ID), nil)
+		if err != nil {
+			return err
+		}
+		_, err = client.Do(ctx, req, nil)
+		return err
+	}
+	return fmt.Errorf("org %s has no role %q", org, name)
+}
@@ -0,0 +1,108 @@
+package invite
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// parseOrgList returns the orgs a deployment serves: primary, then the
+// comma-separated GITHUB_ORGS, without duplicates.
+func parseOrgList(primary, extra string) []string {
+	var orgs []string
+	seen := make(map[string]bool)
+	for _, org := range append([]string{primary}, strings.Split(extra, ",")...) {
+		org = strings.TrimSpace(org)
+		if org != "" && !seen[strings.ToLower(org)] {
+			seen[strings.ToLower(org)] = true
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs
+}
+
+// knownOrg returns the configured spelling of org, or "" when the
+// deployment does not serve it.
+func knownOrg(org string) string {
+	for _, o := range githubOrgs {
+		if strings.EqualFold(o, org) {
+			return o
+		}
+	}
+	return ""
+}
+
+// orgOrDefault returns org, or githubOrgName when it is empty.
+func orgOrDefault(org string) string {
+	if org == "" {
+		return githubOrgName
+	}
+	return org
+}
+
+// orgEnvSuffix is the suffix of the per-org variants of the rule settings:
+// MIN_FOLLOWERS__ACME_LABS applies to the acme-labs org only.
+func orgEnvSuffix(org string) string {
+	return "__" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, org)
+}
+
+// requestedOrgs returns the orgs the visitor asked to join: the signed
+// link's, or else those in the comma-separated org query parameter, where
+// "all" stands for every org. With a single org that is always the one. It
+// returns nothing when the visitor has yet to choose.
+func requestedOrgs(r *http.Request, link *linkClaims) ([]string, error) {
+	if len(githubOrgs) <= 1 {
+		return []string{githubOrgName}, nil
+	}
+	if link != nil && len(link.Orgs) > 0 {
+		return link.Orgs, nil
+	}
+	requested := r.FormValue("org")
+	if requested == "all" {
+		return githubOrgs, nil
+	}
+	var orgs []string
+	for _, name := range strings.Split(requested, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		org := knownOrg(name)
+		if org == "" {
+			return nil, ErrUnknownOrg
+		}
+		if !slices.Contains(orgs, org) {
+			orgs = append(orgs, org)
+		}
+	}
+	return orgs, nil
+}
+
+// renderOrgChooser shows the page for picking which org to join, or all of
+// them. Each choice starts the sign-in again with ?org= set, keeping the
+// rest of the query.
+func renderOrgChooser(w http.ResponseWriter, r *http.Request) {
+	if wantsJSON(r) {
+		writeJSONError(w, ErrBadRequest.WithMessage("org is required; expected one of "+strings.Join(githubOrgs, ", ")+"."))
+		return
+	}
+	type choice struct{ Org, URL string }
+	var choices []choice
+	for _, org := range githubOrgs {
+		query := r.URL.Query()
+		query.Set("org", org)
+		choices = append(choices, choice{org, (&url.URL{Path: "/login", RawQuery: query.Encode()}).String()})
+	}
+	query := r.URL.Query()
+	query.Set("org", "all")
+	choices = append(choices, choice{"All of them", (&url.URL{Path: "/login", RawQuery: query.Encode()}).String()})
+	renderPage(w, http.StatusOK, orgChooserTemplate(), struct{ Choices []choice }{choices})
+}
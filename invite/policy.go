@@ -0,0 +1,179 @@
+package invite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// A policyRule assigns a role and teams to the invitations it matches. All
+// conditions of a rule must match; a rule without conditions matches
+// everyone.
+type policyRule struct {
+	conditions map[string]string // Attribute name to required value
+	role       string
+	teams      []string
+}
+
+// policyAttributes are the attributes rules can match on. Profile text
+// attributes match when they contain the value; the others must equal it.
+// Matching ignores case.
+var policyAttributes = map[string]bool{
+	"campaign": false, // Campaign of the invite link
+	"domain":   false, // Domain of the user's verified email address
+	"provider": false, // Identity provider name
+	"company":  true,  // Company field of the profile
+	"location": true,  // Location field of the profile
+	"bio":      true,  // Bio of the profile, for keywords
+	"language": false, // One of the user's top languages (GitHub only)
+}
+
+// applyPolicy evaluates the rules in order and applies every one that
+// matches attrs: teams accumulate, and the role of the last matching rule
+// that sets one wins. A role set on the invite link takes precedence.
+func applyPolicy(rs *ruleSet, opts inviteOptions, attrs map[string][]string) inviteOptions {
+	role := ""
+	for _, rule := range rs.policyRules {
+		if !rule.matches(attrs) {
+			continue
+		}
+		if rule.role != "" {
+			role = rule.role
+		}
+		for _, team := range rule.teams {
+			if !slices.Contains(opts.Teams, team) {
+				opts.Teams = append(opts.Teams, team)
+			}
+		}
+	}
+	if opts.Role == "" {
+		opts.Role = role
+	}
+	return opts
+}
+
+// matches reports whether attrs satisfy every condition of the rule. An
+// attribute with several values matches if any of them does.
+func (rule policyRule) matches(attrs map[string][]string) bool {
+	for name, want := range rule.conditions {
+		want = strings.ToLower(want)
+		if !slices.ContainsFunc(attrs[name], func(v string) bool {
+			v = strings.ToLower(v)
+			if policyAttributes[name] {
+				return strings.Contains(v, want)
+			}
+			return v == want
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// policyUses reports whether any rule has a condition on the attribute.
+func (rs *ruleSet) policyUses(name string) bool {
+	for _, rule := range rs.policyRules {
+		if _, ok := rule.conditions[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// policyAttrs returns the attributes of an invitation for matching rules.
+// The user's top languages are only looked up when a rule needs them.
+func policyAttrs(ctx context.Context, rs *ruleSet, user *Identity, sso *ssoIdentity, opts inviteOptions) map[string][]string {
+	email := ""
+	if user.EmailVerified {
+		email = user.Email
+	} else if sso != nil {
+		email = sso.Email
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	attrs := map[string][]string{
+		"campaign": {opts.Campaign},
+		"domain":   {domain},
+		"provider": {activeProvider.Name()},
+		"company":  {user.Profile.Company},
+		"location": {user.Profile.Location},
+		"bio":      {user.Profile.Bio},
+	}
+	if lister, ok := activeProvider.(languageLister); ok && rs.policyUses("language") {
+		langs, err := lister.TopLanguages(ctx, user, rs.policyTopLanguages)
+		if err != nil {
+			log.Printf("Could not list the top languages of %s, skipping language rules: %v", user.Username, err)
+		}
+		attrs["language"] = langs
+	}
+	return attrs
+}
+
+// parsePolicy parses POLICY_RULES: a semicolon-separated list of rules such
+// as
+//
+//	campaign=interns -> team=interns, role=member
+//	domain=acme.com -> team=employees
+//	language=go -> team=go
+//	bio=kubernetes, location=berlin -> team=k8s-berlin
+//	* -> team=everyone
+//
+// Conditions and actions are comma-separated. A rule may set one role and
+// any number of teams.
+func parsePolicy(spec string) ([]policyRule, error) {
+	var rules []policyRule
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parsePolicyRule(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule %q: %w", entry, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parsePolicyRule(entry string) (policyRule, error) {
+	rule := policyRule{conditions: make(map[string]string)}
+	when, then, ok := strings.Cut(entry, "->")
+	if !ok {
+		return rule, fmt.Errorf("missing \"->\"")
+	}
+	if when = strings.TrimSpace(when); when != "*" {
+		for _, cond := range strings.Split(when, ",") {
+			cond = strings.TrimSpace(cond)
+			name, value, ok := strings.Cut(cond, "=")
+			if _, known := policyAttributes[name]; !ok || !known || value == "" {
+				return rule, fmt.Errorf("invalid condition %q", cond)
+			}
+			rule.conditions[name] = value
+		}
+	}
+	var err error
+	rule.role, rule.teams, err = parseInviteActions(then)
+	return rule, err
+}
+
+// parseInviteActions parses a comma-separated list of team=slug and
+// role=member|admin actions, as used by POLICY_RULES and PRESETS.
+func parseInviteActions(spec string) (role string, teams []string, err error) {
+	for _, action := range strings.Split(spec, ",") {
+		action = strings.TrimSpace(action)
+		name, value, ok := strings.Cut(action, "=")
+		switch {
+		case !ok || value == "":
+			return "", nil, fmt.Errorf("invalid action %q", action)
+		case name == "team":
+			teams = append(teams, value)
+		case name == "role" && role == "" && validRole(value):
+			role = value
+		default:
+			return "", nil, fmt.Errorf("invalid action %q", action)
+		}
+	}
+	return role, teams, nil
+}
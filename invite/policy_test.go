@@ -0,0 +1,67 @@
+package invite
+
+import (
+	"slices"
+	"testing"
+)
+
+// policyExamples are the rules of the parsePolicy doc comment.
+var policyExamples = []string{
+	"campaign=interns -> team=interns, role=member",
+	"domain=acme.com -> team=employees",
+	"language=go -> team=go",
+	"bio=kubernetes, location=berlin -> team=k8s-berlin",
+	"* -> team=everyone",
+}
+
+func TestParsePolicyRule(t *testing.T) {
+	for _, entry := range policyExamples {
+		if _, err := parsePolicyRule(entry); err != nil {
+			t.Errorf("parsePolicyRule(%q): %v", entry, err)
+		}
+	}
+	for _, entry := range []string{
+		"team=x",
+		"unknown=x -> team=x",
+		"campaign= -> team=x",
+		"campaign -> team=x",
+		"campaign=x -> role=owner",
+		"campaign=x -> team=",
+	} {
+		if _, err := parsePolicyRule(entry); err == nil {
+			t.Errorf("parsePolicyRule(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	rules, err := parsePolicy(policyExamples[0] + ";" + policyExamples[1] + ";" + policyExamples[2] + ";" + policyExamples[3] + ";" + policyExamples[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := &ruleSet{policyRules: rules}
+	tests := []struct {
+		name  string
+		opts  inviteOptions
+		attrs map[string][]string
+		role  string
+		teams []string
+	}{
+		{"nothing else matches", inviteOptions{}, map[string][]string{"campaign": {"spring"}}, "", []string{"everyone"}},
+		{"campaign", inviteOptions{}, map[string][]string{"campaign": {"Interns"}}, "member", []string{"interns", "everyone"}},
+		{"domain", inviteOptions{}, map[string][]string{"domain": {"acme.com"}}, "", []string{"employees", "everyone"}},
+		{"domain must be equal", inviteOptions{}, map[string][]string{"domain": {"notacme.com"}}, "", []string{"everyone"}},
+		{"language", inviteOptions{}, map[string][]string{"language": {"Rust", "Go"}}, "", []string{"go", "everyone"}},
+		{"profile text contains", inviteOptions{}, map[string][]string{"bio": {"I run Kubernetes"}, "location": {"Berlin, Germany"}}, "", []string{"k8s-berlin", "everyone"}},
+		{"all conditions must match", inviteOptions{}, map[string][]string{"bio": {"kubernetes"}, "location": {"Paris"}}, "", []string{"everyone"}},
+		{"link role wins", inviteOptions{Role: "admin", Teams: []string{"everyone"}}, map[string][]string{"campaign": {"interns"}}, "admin", []string{"everyone", "interns"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyPolicy(rs, tt.opts, tt.attrs)
+			if got.Role != tt.role || !slices.Equal(got.Teams, tt.teams) {
+				t.Errorf("applyPolicy = role %q, teams %v; want role %q, teams %v", got.Role, got.Teams, tt.role, tt.teams)
+			}
+		})
+	}
+}
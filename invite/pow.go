@@ -0,0 +1,114 @@
+package invite
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"math/bits"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// powChallengeTTL is how long a proof-of-work challenge can be solved and
+// used for.
+const powChallengeTTL = 10 * time.Minute
+
+// powUsedKey marks challenges whose proof was already used.
+const powUsedKey = "pow:used:"
+
+// powDifficulty is the number of leading zero bits a proof must have, from
+// POW_DIFFICULTY; 0 disables the challenge.
+var powDifficulty int
+
+// loadPowConfig reads POW_DIFFICULTY. Challenges are signed, so they need
+// SIGNING_KEY.
+func loadPowConfig() {
+	powDifficulty = envInt("POW_DIFFICULTY", 0)
+	switch {
+	case powDifficulty == 0:
+	case powDifficulty < 0 || powDifficulty > 32:
+		log.Fatal("FATAL: POW_DIFFICULTY must be between 0 and 32 bits.")
+	case !canSign():
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when POW_DIFFICULTY is set.")
+	}
+}
+
+// powChallenge is the signed content of a challenge.
+type powChallenge struct {
+	ID      string `json:"jti"`
+	Bits    int    `json:"bits"`
+	Expires int64  `json:"exp"`
+}
+
+// newPowChallenge returns a fresh signed challenge.
+func newPowChallenge(ctx context.Context) (string, error) {
+	b, _ := json.Marshal(powChallenge{ID: randomID(12), Bits: powDifficulty, Expires: time.Now().Add(powChallengeTTL).Unix()})
+	return signToken(ctx, tokenPow, b)
+}
+
+// checkProof verifies a "challenge~nonce" proof: the challenge must be ours
+// and current, SHA-256 of "challenge:nonce" must start with the challenge's
+// zero bits, and each challenge is good for one sign-in.
+func checkProof(ctx context.Context, proof string) error {
+	challenge, nonce, ok := strings.Cut(proof, "~")
+	if !ok || nonce == "" || len(nonce) > 32 {
+		return ErrInvalidProof
+	}
+	payload, err := verifyToken(tokenPow, challenge)
+	if err != nil {
+		return ErrInvalidProof.Wrap(err)
+	}
+	var c powChallenge
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return ErrInvalidProof.Wrap(err)
+	}
+	if time.Now().Unix() >= c.Expires || c.Bits < powDifficulty || leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) < c.Bits {
+		return ErrInvalidProof
+	}
+	first, err := dataStore.SetNX(ctx, powUsedKey+c.ID, nil, powChallengeTTL)
+	if err != nil {
+		return ErrInvalidProof.Wrap(err)
+	}
+	if !first {
+		return ErrInvalidProof
+	}
+	return nil
+}
+
+// leadingZeroBits counts the zero bits at the start of sum.
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// powChallengeResponse is the JSON answer to a sign-in without a proof.
+type powChallengeResponse struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// renderPowChallenge serves a challenge: a page that solves it in the
+// browser and starts the sign-in again with the proof in the pow parameter,
+// or the bare challenge for JSON clients.
+func renderPowChallenge(w http.ResponseWriter, r *http.Request) {
+	challenge, err := newPowChallenge(r.Context())
+	if err != nil {
+		redirectToErrorPage(w, r, ErrConfig.Wrap(err))
+		return
+	}
+	resp := powChallengeResponse{Challenge: challenge, Difficulty: powDifficulty}
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusPreconditionRequired, resp)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusOK, powTemplate(), resp)
+}
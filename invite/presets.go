@@ -0,0 +1,60 @@
+package invite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var validPresetName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// parsePresets parses PRESETS, a ";"-separated list of named presets in the
+// action syntax of POLICY_RULES, for example:
+//
+//	contributor -> team=docs, team=triage, role=member; sponsor -> team=sponsors
+//
+// Anyone can pick a preset with /login?preset=name, so presets cannot grant
+// the admin role; use a signed link for that.
+func parsePresets(spec string) (map[string]inviteOptions, error) {
+	presets := make(map[string]inviteOptions)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, then, ok := strings.Cut(entry, "->")
+		name = strings.TrimSpace(name)
+		if !ok || !validPresetName.MatchString(name) {
+			return nil, fmt.Errorf("invalid preset %q: expected name -> actions", entry)
+		}
+		if _, dup := presets[name]; dup {
+			return nil, fmt.Errorf("preset %q is defined twice", name)
+		}
+		role, teams, err := parseInviteActions(then)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset %q: %w", name, err)
+		}
+		if role == "admin" {
+			return nil, fmt.Errorf("invalid preset %q: presets cannot grant the admin role", name)
+		}
+		presets[name] = inviteOptions{Role: role, Teams: teams}
+	}
+	return presets, nil
+}
+
+// applyPreset adds the teams of the named preset to opts, and its role when
+// opts has none.
+func applyPreset(rs *ruleSet, opts inviteOptions, name string) (inviteOptions, error) {
+	if name == "" {
+		return opts, nil
+	}
+	preset, ok := rs.presets[name]
+	if !ok {
+		return opts, ErrInvalidLink.WithMessage("This invite link refers to an unknown preset.")
+	}
+	opts.Teams = append(append([]string(nil), opts.Teams...), preset.Teams...)
+	if opts.Role == "" {
+		opts.Role = preset.Role
+	}
+	return opts, nil
+}
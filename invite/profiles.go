@@ -0,0 +1,103 @@
+package invite
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var validProfileName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// profileEnv returns the settings lookup for APP_ENV and the profile's name.
+// PROFILES_FILE holds KEY=VALUE lines, grouped in [name] sections, one per
+// profile, for example:
+//
+//	GITHUB_CLIENT_ID=Iv1.abc
+//
+//	[staging]
+//	GITHUB_ORG_NAME=acme-staging
+//	SUCCESS_REDIRECT_URL=https://staging.example.com/welcome
+//
+//	[prod]
+//	GITHUB_ORG_NAME=acme
+//	MIN_FOLLOWERS=5
+//
+// Lines before the first section apply to every profile. Environment
+// variables win over the file, so secrets can stay out of it.
+func profileEnv() (func(string) string, string, error) {
+	name, path := os.Getenv("APP_ENV"), os.Getenv("PROFILES_FILE")
+	if path == "" {
+		return os.Getenv, name, nil
+	}
+	common, profiles, err := readProfiles(path)
+	if err != nil {
+		return nil, "", err
+	}
+	var profile map[string]string
+	if len(profiles) > 0 {
+		var ok bool
+		if profile, ok = profiles[name]; !ok {
+			names := make([]string, 0, len(profiles))
+			for n := range profiles {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			return nil, "", fmt.Errorf("APP_ENV must name one of the profiles in %s: %s", path, strings.Join(names, ", "))
+		}
+	}
+	return func(key string) string {
+		if v, ok := os.LookupEnv(key); ok {
+			return v
+		}
+		if v, ok := profile[key]; ok {
+			return v
+		}
+		return common[key]
+	}, name, nil
+}
+
+// readProfiles reads PROFILES_FILE into the settings common to all profiles
+// and those of each profile. Blank lines and lines starting with # are
+// skipped.
+func readProfiles(path string) (map[string]string, map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	common := make(map[string]string)
+	profiles := make(map[string]map[string]string)
+	section := common
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, ok := strings.CutPrefix(line, "["); ok {
+			name, ok = strings.CutSuffix(name, "]")
+			if !ok || !validProfileName.MatchString(name) {
+				return nil, nil, fmt.Errorf("%s:%d: invalid profile header %q", path, n, line)
+			}
+			if _, dup := profiles[name]; dup {
+				return nil, nil, fmt.Errorf("%s:%d: profile %q is defined twice", path, n, name)
+			}
+			section = make(map[string]string)
+			profiles[name] = section
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if key = strings.TrimSpace(key); !ok || key == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, n)
+		}
+		if key == "APP_ENV" || key == "PROFILES_FILE" {
+			return nil, nil, fmt.Errorf("%s:%d: %s cannot be set in the profiles file", path, n, key)
+		}
+		section[key] = strings.TrimSpace(value)
+	}
+	return common, profiles, scanner.Err()
+}
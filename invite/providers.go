@@ -0,0 +1,220 @@
+package invite
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+)
+
+// Identity is the user who signed in, as reported by a provider.
+type Identity struct {
+	ID       string // Stable provider-specific user ID
+	Username string // Login or username shown to admins
+	Email    string // Primary email, if the provider shares it
+
+	// EmailVerified reports whether the provider has verified Email.
+	EmailVerified bool
+
+	// Profile holds public profile details, where the provider has them.
+	Profile Profile
+}
+
+// Profile is the public part of a user's profile, used by policy rules.
+type Profile struct {
+	Company  string
+	Location string
+	Bio      string
+}
+
+// A Provider authenticates users with OAuth and adds them to the configured
+// organization (or workspace) on its platform. The active provider is chosen
+// with the PROVIDER environment variable.
+type Provider interface {
+	// Name is the provider's identifier, as used in PROVIDER and in the
+	// /{name}/callback path.
+	Name() string
+	// OAuthConfig returns the OAuth client configuration for signing in.
+	OAuthConfig() *oauth2.Config
+	// User returns the identity of the user the token belongs to.
+	User(ctx context.Context, token *oauth2.Token) (*Identity, error)
+	// Invite adds user to the organization.
+	Invite(ctx context.Context, user *Identity, opts inviteOptions) error
+}
+
+// memberCounter is implemented by providers that can count the current
+// members, which MAX_MEMBERS relies on.
+type memberCounter interface {
+	MemberCount(ctx context.Context, org string) (int, error)
+}
+
+// languageLister is implemented by providers that can tell which
+// programming languages a user mostly works in, for "language" policy rules.
+type languageLister interface {
+	TopLanguages(ctx context.Context, user *Identity, n int) ([]string, error)
+}
+
+// statsReporter is implemented by providers that can report account
+// statistics, which the MIN_* gates rely on.
+type statsReporter interface {
+	// AccountStats returns the user's statistics. Contributions are only
+	// looked up when withContributions is set, as that costs an extra call.
+	AccountStats(ctx context.Context, user *Identity, withContributions bool) (*accountStats, error)
+}
+
+// suspensionChecker is implemented by providers that can tell whether the
+// platform has suspended an account, for REJECT_SUSPENDED.
+type suspensionChecker interface {
+	Suspended(ctx context.Context, user *Identity) (bool, error)
+}
+
+// activeProvider is the provider selected by PROVIDER.
+var activeProvider Provider
+
+// githubProvider invites users to a GitHub organization.
+type githubProvider struct{}
+
+func (githubProvider) Name() string { return "github" }
+
+func (githubProvider) OAuthConfig() *oauth2.Config { return oauthConf }
+
+func (githubProvider) User(ctx context.Context, token *oauth2.Token) (*Identity, error) {
+	userClient := newGitHubClient(oauthConf.Client(withAPILogging(ctx), token))
+	user, _, err := userClient.Users.Get(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := validateGitHubUser(user); err != nil {
+		return nil, err
+	}
+	id := &Identity{
+		ID:       fmt.Sprint(user.GetID()),
+		Username: user.GetLogin(),
+		Email:    user.GetEmail(),
+		Profile:  Profile{Company: user.GetCompany(), Location: user.GetLocation(), Bio: user.GetBio()},
+	}
+
+	// The verified primary address is only visible with the user:email
+	// scope, which is requested when a later step needs it.
+	if hasScope(oauthConf, "user:email") {
+		emails, _, err := userClient.Users.ListEmails(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range emails {
+			if e.GetPrimary() && e.GetVerified() {
+				id.Email, id.EmailVerified = e.GetEmail(), true
+			}
+		}
+	}
+	return id, nil
+}
+
+// validateGitHubUser rejects profiles the flow cannot work with: ones
+// missing the login or ID, bot accounts, and suspended accounts.
+func validateGitHubUser(user *github.User) error {
+	if user == nil || user.GetLogin() == "" || user.GetID() == 0 {
+		return ErrInvalidProfile
+	}
+	if user.GetType() == "Bot" {
+		return ErrBotAccount
+	}
+	if user.SuspendedAt != nil {
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+// hasScope reports whether conf requests scope.
+func hasScope(conf *oauth2.Config, scope string) bool {
+	for _, s := range conf.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (githubProvider) Invite(ctx context.Context, user *Identity, opts inviteOptions) error {
+	return inviteUser(ctx, adminClient(), user.Username, opts)
+}
+
+func (githubProvider) MemberCount(ctx context.Context, org string) (int, error) {
+	return memberCount(ctx, adminClient(), org)
+}
+
+func (githubProvider) IsMember(ctx context.Context, org, username string) (bool, error) {
+	member, _, err := adminClient().Organizations.IsMember(ctx, org, username)
+	return member, err
+}
+
+// TopLanguages ranks the primary languages of the user's public repositories,
+// forks excluded, by how many repositories use them.
+func (githubProvider) TopLanguages(ctx context.Context, user *Identity, n int) ([]string, error) {
+	repos, _, err := adminClient().Repositories.List(ctx, user.Username, &github.RepositoryListOptions{
+		Type:        "owner",
+		Sort:        "pushed",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, repo := range repos {
+		if lang := repo.GetLanguage(); lang != "" && !repo.GetFork() {
+			counts[lang]++
+		}
+	}
+	langs := make([]string, 0, len(counts))
+	for lang := range counts {
+		langs = append(langs, lang)
+	}
+	sort.Slice(langs, func(i, j int) bool {
+		if counts[langs[i]] != counts[langs[j]] {
+			return counts[langs[i]] > counts[langs[j]]
+		}
+		return langs[i] < langs[j]
+	})
+	if len(langs) > n {
+		langs = langs[:n]
+	}
+	return langs, nil
+}
+
+func (githubProvider) AccountStats(ctx context.Context, user *Identity, withContributions bool) (*accountStats, error) {
+	client := adminClient()
+	u, _, err := client.Users.Get(ctx, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	stats := &accountStats{
+		Followers:    u.GetFollowers(),
+		PublicRepos:  u.GetPublicRepos(),
+		CreatedAt:    u.GetCreatedAt().Time,
+		BlankProfile: u.GetName() == "" && u.GetBio() == "" && u.GetCompany() == "" && u.GetBlog() == "" && u.GetLocation() == "",
+	}
+	if withContributions {
+		if stats.Contributions, err = contributionsLastYear(ctx, client, user.Username); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// Suspended looks the user up as the org owner. On GitHub Enterprise Server
+// the profile carries suspended_at; on github.com suspended and flagged
+// accounts are hidden from everyone else, so a missing profile for a user
+// who just signed in means the same.
+func (githubProvider) Suspended(ctx context.Context, user *Identity) (bool, error) {
+	u, resp, err := adminClient().Users.Get(ctx, user.Username)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return false, err
+	}
+	return u.SuspendedAt != nil, nil
+}
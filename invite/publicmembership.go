@@ -0,0 +1,109 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"auto-invite/store"
+
+	"golang.org/x/oauth2"
+)
+
+// publicMemberKey holds the OAuth token of an invited user who asked for
+// their membership to be public, by org and username, until they join.
+const publicMemberKey = "members:publicize:"
+
+// publicMembershipScope lets the token of a user publicize their own
+// membership; GitHub does not let anyone else.
+const publicMembershipScope = "write:org"
+
+// publicMembership lets users ask for their membership to show on their
+// profile, from PUBLIC_MEMBERSHIP.
+var publicMembership bool
+
+// loadPublicMembershipConfig reads PUBLIC_MEMBERSHIP. The consent travels in
+// the signed OAuth state, so it needs a signing key, and the write:org
+// tokens of consenting users are kept until they join, so only in an
+// encrypted store.
+func loadPublicMembershipConfig() {
+	publicMembership = envBool("PUBLIC_MEMBERSHIP")
+	if !publicMembership {
+		return
+	}
+	if activeProvider.Name() != "github" {
+		log.Fatal("FATAL: PUBLIC_MEMBERSHIP requires the github provider.")
+	}
+	if !canSign() {
+		log.Fatal("FATAL: SIGNING_KEY or SIGNING_KMS_KEY must be set when PUBLIC_MEMBERSHIP is set.")
+	}
+	if !storeEncrypted {
+		log.Fatal("FATAL: STORE_ENCRYPTION_KEYS must be set when PUBLIC_MEMBERSHIP is set, so that the tokens of users are not kept in the clear.")
+	}
+}
+
+// wantsPublicMembership reports whether the user signing in with r agreed to
+// have their membership made public, with ?public_membership=true, as from a
+// checkbox of the sign-in form.
+func wantsPublicMembership(r *http.Request) bool {
+	consent, _ := strconv.ParseBool(r.FormValue("public_membership"))
+	return publicMembership && consent
+}
+
+// authorizeURL returns the authorization page of the provider for flow.
+// Users who agreed to a public membership are asked for the scope that
+// publicizes it too; the others are not.
+func authorizeURL(flow flowState, state string) string {
+	conf := activeProvider.OAuthConfig()
+	opts := []oauth2.AuthCodeOption{oauth2.AccessTypeOnline}
+	if flow.PublicMembership && !hasScope(conf, publicMembershipScope) {
+		scopes := append(slices.Clone(conf.Scopes), publicMembershipScope)
+		opts = append(opts, oauth2.SetAuthURLParam("scope", strings.Join(scopes, " ")))
+	}
+	return conf.AuthCodeURL(state, opts...)
+}
+
+// holdPublicMembership remembers the token of a user invited to the org of
+// opts who agreed to a public membership, sealed by the encrypted store, so
+// that publicizeMembership can use it once they have joined. Tokens the user
+// did not grant the scope are not kept.
+func holdPublicMembership(ctx context.Context, flow flowState, username string, opts inviteOptions) {
+	if !flow.PublicMembership || flow.userToken == nil {
+		return
+	}
+	if granted, ok := flow.userToken.Extra("scope").(string); ok && !slices.Contains(strings.Split(granted, ","), publicMembershipScope) {
+		log.Printf("Not making the membership of %s public: the %s scope was not granted", username, publicMembershipScope)
+		return
+	}
+	key := publicMemberKey + orgOrDefault(opts.Org) + ":" + strings.ToLower(username)
+	if err := dataStore.Set(ctx, key, []byte(flow.userToken.AccessToken), pendingMemberTTL); err != nil {
+		log.Printf("Failed to hold the public membership of %s: %v", username, err)
+	}
+}
+
+// publicizeMembership makes the membership of a user who joined public with
+// the token they signed in with, when they agreed to it. The token is
+// forgotten either way.
+func publicizeMembership(ctx context.Context, ev busEvent) {
+	org, username := orgOrDefault(ev.Entry.Org), ev.Entry.Username
+	key := publicMemberKey + org + ":" + strings.ToLower(username)
+	token, err := dataStore.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load the public membership of %s in %s: %v", username, org, err)
+		return
+	}
+	defer dataStore.Delete(ctx, key)
+	client := newGitHubClient(activeProvider.OAuthConfig().Client(withAPILogging(ctx), &oauth2.Token{AccessToken: string(token)}))
+	if _, err := client.Organizations.PublicizeMembership(ctx, org, username); err != nil {
+		log.Printf("Failed to make the membership of %s in %s public: %v", username, org, err)
+		return
+	}
+	log.Printf("Made the membership of %s in %s public", username, org)
+}
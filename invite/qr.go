@@ -0,0 +1,94 @@
+package invite
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	defaultQRSize = 256
+	maxQRSize     = 1024
+)
+
+// handleQR renders a QR code for one of this deployment's own URLs, such as
+// an invite or short link: /qr?link=<url>&format=png|svg&size=<pixels>.
+func handleQR(w http.ResponseWriter, r *http.Request) {
+	link, err := ownURL(r, r.FormValue("link"))
+	if err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage(err.Error()))
+		return
+	}
+
+	size := defaultQRSize
+	if v := r.FormValue("size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 64 || size > maxQRSize {
+			writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("size must be between 64 and %d.", maxQRSize)))
+			return
+		}
+	}
+
+	code, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		writeJSONError(w, ErrBadRequest.Wrap(err).WithMessage("link is too long for a QR code."))
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	switch r.FormValue("format") {
+	case "", "png":
+		png, err := code.PNG(size)
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(qrSVG(code.Bitmap(), size)))
+	default:
+		writeJSONError(w, ErrBadRequest.WithMessage("format must be png or svg."))
+	}
+}
+
+// ownURL resolves link against this deployment and rejects URLs pointing
+// anywhere else, so the endpoint can't be used as a generic QR service.
+func ownURL(r *http.Request, link string) (string, error) {
+	if link == "" {
+		return "", fmt.Errorf("link is required")
+	}
+	base, err := url.Parse(publicBaseURL(r) + "/")
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("link is not a valid URL")
+	}
+	if u.Scheme != base.Scheme || !strings.EqualFold(u.Host, base.Host) {
+		return "", fmt.Errorf("link must point to %s", base.Host)
+	}
+	return u.String(), nil
+}
+
+// qrSVG draws a QR bitmap as an SVG image of the given pixel size.
+func qrSVG(bitmap [][]bool, size int) string {
+	var b strings.Builder
+	n := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size, n, n)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, n, n)
+	for y, row := range bitmap {
+		for x, black := range row {
+			if black {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.String()
+}
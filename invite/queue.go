@@ -0,0 +1,216 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"auto-invite/store"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// Store keys used by the invite queue.
+const (
+	queueKey     = "queue:invites"
+	queuePaceKey = "queue:invites:pace"
+)
+
+// queuedInvite is one invitation waiting to be sent. Exactly one of
+// Username and Email is set.
+type queuedInvite struct {
+	JobID    string   `json:"job_id,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Role     string   `json:"role,omitempty"`
+	Teams    []string `json:"teams,omitempty"`
+
+	QueuedAt time.Time `json:"queued_at,omitempty"`
+}
+
+// queueStats counts what the queue processors did, for /debug/vars.
+var queueStats struct {
+	sent, failed, retried atomic.Int64
+	latencyMillis         atomic.Int64 // Time from enqueueing to sending, summed over sent invitations
+}
+
+// Rate limit backoff, when GitHub does not say how long to wait.
+const defaultRateLimitWait = time.Minute
+
+// enqueueInvite adds an invitation to the queue.
+func enqueueInvite(ctx context.Context, item queuedInvite) error {
+	if item.QueuedAt.IsZero() {
+		item.QueuedAt = time.Now().UTC()
+	}
+	b, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	_, err = dataStore.Append(ctx, queueKey, b)
+	return err
+}
+
+// processQueue sends queued invitations until the queue is empty or the
+// deadline passes, and returns how many it processed. Sends are paced to one
+// per queueInterval across all instances sharing the store, so several
+// processors running at once do not add up to a burst against GitHub. When
+// GitHub rate limits a send, the invitation goes back on the queue and all
+// processors wait until the limit resets.
+func processQueue(ctx context.Context, deadline time.Time) int {
+	processed := 0
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		ok, err := dataStore.SetNX(ctx, queuePaceKey, nil, queueInterval)
+		if err != nil {
+			log.Printf("Queue pacing failed: %v", err)
+			return processed
+		}
+		if !ok {
+			time.Sleep(queueInterval / 4)
+			continue
+		}
+
+		b, err := dataStore.Pop(ctx, queueKey)
+		if errors.Is(err, store.ErrNotFound) {
+			return processed
+		}
+		if err != nil {
+			log.Printf("Failed to read the invite queue: %v", err)
+			return processed
+		}
+		var item queuedInvite
+		if err := json.Unmarshal(b, &item); err != nil {
+			log.Printf("Dropping malformed queue item %q: %v", b, err)
+			continue
+		}
+
+		publish(ctx, busEvent{Type: eventInviteRequested, Entry: activityEvent{Username: item.target()}})
+		err = sendQueuedInvite(ctx, item)
+		if wait, limited := rateLimitWait(err); limited {
+			log.Printf("Rate limited sending the queued invite for %s, pausing the queue for %s", item.target(), wait)
+			if err := dataStore.Set(ctx, queuePaceKey, nil, wait); err != nil {
+				log.Printf("Queue pacing failed: %v", err)
+			}
+			if err := enqueueInvite(ctx, item); err != nil {
+				log.Printf("Failed to requeue the invite for %s: %v", item.target(), err)
+			} else {
+				queueStats.retried.Add(1)
+				continue
+			}
+		}
+		if err != nil {
+			queueStats.failed.Add(1)
+			log.Printf("Queued invite for %s failed: %v", item.target(), err)
+			publish(ctx, busEvent{Type: eventInviteFailed, Entry: activityEvent{Username: item.target(), Code: asError(err, ErrInvitationFailed).Code}})
+		} else {
+			log.Printf("Queued invite for %s sent", item.target())
+			queueStats.sent.Add(1)
+			if !item.QueuedAt.IsZero() {
+				queueStats.latencyMillis.Add(time.Since(item.QueuedAt).Milliseconds())
+			}
+			publish(ctx, busEvent{Type: eventInviteSent, Entry: activityEvent{Username: item.target()}})
+		}
+		if item.JobID != "" {
+			recordBulkResult(ctx, item, err)
+		}
+		processed++
+	}
+	return processed
+}
+
+// rateLimitWait reports whether err is a GitHub rate limit, and if so how
+// long to wait before sending again.
+func rateLimitWait(err error) (time.Duration, bool) {
+	if !errors.Is(err, ErrInviteRateLimited) {
+		return 0, false
+	}
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	wait := defaultRateLimitWait
+	if errors.As(err, &rateErr) {
+		wait = time.Until(rateErr.Rate.Reset.Time)
+	} else if errors.As(err, &abuseErr) && abuseErr.RetryAfter != nil {
+		wait = *abuseErr.RetryAfter
+	}
+	if wait < queueInterval {
+		wait = queueInterval
+	}
+	return wait, true
+}
+
+// queueMetrics reports the queue depth and processing for /debug/vars.
+func queueMetrics() any {
+	depth := -1
+	if items, err := dataStore.Range(context.Background(), queueKey, 0, -1); err == nil {
+		depth = len(items)
+	}
+	sent := queueStats.sent.Load()
+	var avgLatency int64
+	if sent > 0 {
+		avgLatency = queueStats.latencyMillis.Load() / sent
+	}
+	return map[string]any{
+		"depth":              depth,
+		"sent":               sent,
+		"failed":             queueStats.failed.Load(),
+		"retried":            queueStats.retried.Load(),
+		"avg_latency_millis": avgLatency,
+		"workers":            workerStats(),
+	}
+}
+
+// target returns the username or email the item is for.
+func (item queuedInvite) target() string {
+	if item.Email != "" {
+		return item.Email
+	}
+	return item.Username
+}
+
+// sendQueuedInvite sends one queued invitation.
+func sendQueuedInvite(ctx context.Context, item queuedInvite) error {
+	opts := inviteOptions{Role: item.Role, Teams: item.Teams}
+	if item.Email != "" {
+		return inviteEmail(ctx, item.Email, opts)
+	}
+	return activeProvider.Invite(ctx, &Identity{Username: item.Username}, opts)
+}
+
+// inviteEmail invites an email address to the org, adding the invitation to
+// opts.Teams. Only the GitHub provider supports invitations by email.
+func inviteEmail(ctx context.Context, email string, opts inviteOptions) error {
+	if _, ok := activeProvider.(githubProvider); !ok {
+		return ErrInvitationFailed.WithMessage("Invitations by email are only supported for GitHub.")
+	}
+	client := adminClient()
+	org := orgOrDefault(opts.Org)
+	role := "direct_member"
+	if opts.Role == "admin" || opts.Role == roleBillingManager {
+		role = opts.Role
+	}
+	switch pending, err := pendingInvitation(ctx, client, org, email); {
+	case err != nil:
+		log.Printf("Could not list the pending invitations of %s, continuing: %v", org, err)
+	case pending:
+		return ErrAlreadyInvited
+	}
+	invitation := &github.CreateOrgInvitationOptions{Email: github.String(email), Role: github.String(role)}
+	if len(opts.Teams) > 0 && !capabilitiesOf(ctx, org).Teams {
+		log.Printf("Not adding the invitation for %s to teams %v: GITHUB_PAT cannot manage the teams of %s", email, opts.Teams, org)
+		opts.Teams = nil
+	}
+	for _, slug := range opts.Teams {
+		team, _, err := client.Teams.GetTeamBySlug(ctx, org, slug)
+		if err != nil {
+			log.Printf("Skipping unknown team %s for %s: %v", slug, email, err)
+			continue
+		}
+		invitation.TeamID = append(invitation.TeamID, team.GetID())
+	}
+	if _, _, err := client.Organizations.CreateOrgInvitation(ctx, org, invitation); err != nil {
+		return classifyInviteError(err)
+	}
+	return nil
+}
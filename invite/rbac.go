@@ -0,0 +1,95 @@
+package invite
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// An adminRole grants access to the admin endpoints at or below it.
+type adminRole int
+
+const (
+	roleViewer   adminRole = iota + 1 // Read stats, events and approvals
+	roleApprover                      // Also decide approvals
+	roleOwner                         // Everything, including minting links
+)
+
+var adminRoleNames = map[string]adminRole{
+	"viewer":   roleViewer,
+	"approver": roleApprover,
+	"owner":    roleOwner,
+}
+
+func (role adminRole) String() string {
+	for name, r := range adminRoleNames {
+		if r == role {
+			return name
+		}
+	}
+	return "none"
+}
+
+// adminCredential is one admin bearer token and the role it grants.
+type adminCredential struct {
+	name  string // Shown as the actor in the audit log
+	role  adminRole
+	token string
+}
+
+// adminCredentials are the accepted admin tokens. ADMIN_TOKEN, if set, is an
+// owner credential named "admin".
+var adminCredentials []adminCredential
+
+// parseAdminCredentials parses ADMIN_CREDENTIALS, a semicolon-separated list
+// of name:role:token entries, such as
+//
+//	alice:owner:s3cret;mods:approver:an0ther
+func parseAdminCredentials(spec string) ([]adminCredential, error) {
+	var creds []adminCredential
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid credential %q: want name:role:token", parts[0])
+		}
+		role, ok := adminRoleNames[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("invalid role %q for %s: want viewer, approver, or owner", parts[1], parts[0])
+		}
+		creds = append(creds, adminCredential{name: parts[0], role: role, token: parts[2]})
+	}
+	return creds, nil
+}
+
+// findAdminCredential returns the credential matching token, or nil. Every
+// credential is compared, so timing does not reveal which one matched.
+func findAdminCredential(token string) *adminCredential {
+	var found *adminCredential
+	for i := range adminCredentials {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminCredentials[i].token)) == 1 {
+			found = &adminCredentials[i]
+		}
+	}
+	return found
+}
+
+type adminContextKey struct{}
+
+// withAdmin returns r carrying the authenticated admin credential.
+func withAdmin(r *http.Request, cred *adminCredential) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), adminContextKey{}, cred))
+}
+
+// adminActor names who made an admin API request, for the audit log.
+func adminActor(r *http.Request) string {
+	if cred, ok := r.Context().Value(adminContextKey{}).(*adminCredential); ok {
+		return cred.name
+	}
+	return "admin"
+}
@@ -0,0 +1,185 @@
+package invite
+
+import (
+	"context"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactedText replaces secrets in logs, error messages, and redirects.
+const redactedText = "[REDACTED]"
+
+// minSecretLen is the shortest configured value treated as a secret, so
+// that short or placeholder settings do not mask unrelated text.
+const minSecretLen = 8
+
+// secretSettings are the settings whose values are redacted wherever they
+// appear, in addition to the core Config secrets.
+var secretSettings = []string{
+	"ABUSE_REPORT_SECRET",
+	"ANALYTICS_SALT",
+	"AWS_SECRET_ACCESS_KEY",
+	"AZURE_CLIENT_SECRET",
+	"AWS_SESSION_TOKEN",
+	"BITBUCKET_APP_PASSWORD",
+	"BITBUCKET_CLIENT_SECRET",
+	"DISCORD_BOT_TOKEN",
+	"DISCORD_CLIENT_SECRET",
+	"GA4_API_SECRET",
+	"GCP_ACCESS_TOKEN",
+	"GITHUB_WEBHOOK_SECRET",
+	"GOOGLE_WORKSPACE_CREDENTIALS",
+	"KV_REST_API_TOKEN",
+	"NPM_TOKEN",
+	"OIDC_CLIENT_SECRET",
+	"POSTHOG_API_KEY",
+	"REPUTATION_TOKEN",
+	"SIGNING_KEYS",
+	"SLACK_ADMIN_TOKEN",
+	"SMTP_PASSWORD",
+	"STORE_ENCRYPTION_KEYS",
+	"SUCCESS_TOKEN_KEY",
+	"SUCCESS_TOKEN_KEYS",
+	"WEBHOOK_SIGNING_SECRET",
+}
+
+// secretPatterns match secrets that are not configured values: OAuth codes
+// and tokens in query strings and form bodies, bearer tokens, and GitHub
+// tokens by their prefixes. The first group, if any, is kept.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\b((?:code|access_token|refresh_token|id_token|client_secret|token|password)=)[^&\s"']+`),
+	regexp.MustCompile(`(?i)("(?:access_token|refresh_token|id_token|client_secret|token|password)"\s*:\s*")[^"]*`),
+	regexp.MustCompile(`(?i)\b(Bearer\s+)[A-Za-z0-9._~+/=-]+`),
+	regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{20,}|github_pat_[A-Za-z0-9_]{20,})\b`),
+}
+
+// secretValues holds the configured secrets, longest first so that a secret
+// containing another is replaced whole.
+var secretValues struct {
+	sync.RWMutex
+	values []string
+}
+
+// addSecrets registers values to be redacted. Values shorter than
+// minSecretLen are ignored.
+func addSecrets(values ...string) {
+	secretValues.Lock()
+	defer secretValues.Unlock()
+next:
+	for _, v := range values {
+		if len(v) < minSecretLen {
+			continue
+		}
+		i := 0
+		for i < len(secretValues.values) && len(secretValues.values[i]) >= len(v) {
+			if secretValues.values[i] == v {
+				continue next
+			}
+			i++
+		}
+		secretValues.values = append(secretValues.values[:i], append([]string{v}, secretValues.values[i:]...)...)
+	}
+}
+
+// registerSecrets registers the secrets of cfg and of the optional
+// integrations.
+func registerSecrets(cfg *Config) {
+	addSecrets(cfg.GitHubClientSecret, cfg.GitHubPAT, string(cfg.SigningKey), cfg.AdminToken, cfg.APIToken, cfg.CronSecret)
+	if creds, err := parseAdminCredentials(cfg.AdminCredentials); err == nil {
+		for _, c := range creds {
+			addSecrets(c.token)
+		}
+	}
+	for _, name := range secretSettings {
+		addSecrets(getenv(name))
+	}
+}
+
+// redact returns s with the configured secrets and anything that looks like
+// an OAuth code or token replaced. extra are further values to remove, such
+// as the code of the request at hand.
+func redact(s string, extra ...string) string {
+	for _, v := range extra {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, redactedText)
+		}
+	}
+	secretValues.RLock()
+	for _, v := range secretValues.values {
+		s = strings.ReplaceAll(s, v, redactedText)
+	}
+	secretValues.RUnlock()
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "${1}"+redactedText)
+	}
+	return s
+}
+
+// redactedError is an error whose message has been redacted. It unwraps to
+// the original, so errors.Is and errors.As still see through it.
+type redactedError struct {
+	err   error
+	extra []string
+}
+
+func (e redactedError) Error() string { return redact(e.err.Error(), e.extra...) }
+
+func (e redactedError) Unwrap() error { return e.err }
+
+// redactError wraps err so that its message has extra and the configured
+// secrets removed. It returns nil for a nil err.
+func redactError(err error, extra ...string) error {
+	if err == nil {
+		return nil
+	}
+	return redactedError{err: err, extra: extra}
+}
+
+// redactHandler redacts the message and string attributes of every log
+// record before passing it on.
+type redactHandler struct {
+	slog.Handler
+}
+
+func (h redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, redact(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	for i, a := range attrs {
+		attrs[i] = redactAttr(a)
+	}
+	return redactHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr redacts the string values of a, including within groups.
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redact(v.String()))
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]any, len(group))
+		for i, g := range group {
+			attrs[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, attrs...)
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, redact(err.Error()))
+		}
+	}
+	return a
+}
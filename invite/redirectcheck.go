@@ -0,0 +1,196 @@
+package invite
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// redirectCheckTimeout bounds the check of one redirect destination.
+const redirectCheckTimeout = 10 * time.Second
+
+// redirectCheckInterval is how often the redirect destinations are checked,
+// from REDIRECT_CHECK_INTERVAL; 0 disables the checks.
+var redirectCheckInterval time.Duration
+
+// redirectCheck is the last check of a redirect destination.
+type redirectCheck struct {
+	Setting   string    `json:"setting"`
+	URL       string    `json:"url"`
+	OK        bool      `json:"ok"`
+	Status    int       `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var redirectChecks struct {
+	sync.Mutex
+	results []redirectCheck
+	checked time.Time
+	running bool
+}
+
+// loadRedirectCheckConfig reads REDIRECT_CHECK_INTERVAL and starts the first
+// check, so that a broken page shows up in the log right after a deploy.
+func loadRedirectCheckConfig() {
+	redirectCheckInterval = envDuration("REDIRECT_CHECK_INTERVAL", 0)
+	if redirectCheckInterval < 0 {
+		log.Fatal("FATAL: REDIRECT_CHECK_INTERVAL must not be negative.")
+	}
+	expvar.Publish("redirect_checks", expvar.Func(redirectCheckMetrics))
+	maybeCheckRedirects()
+}
+
+// redirectDestinations returns the configured redirect targets by setting,
+// with their variables expanded for the default org and no user.
+func redirectDestinations() [][2]string {
+	var dests [][2]string
+	for _, d := range [][2]string{
+		{"SUCCESS_REDIRECT_URL", successRedirectURL},
+		{"ERROR_REDIRECT_URL", errorRedirectURL},
+		{"ORG_FULL_REDIRECT_URL", orgFullRedirectURL},
+		{"CLOSED_REDIRECT_URL", closedRedirectURL},
+		{"USED_REDIRECT_URL", usedRedirectURL},
+		{"CANCELLED_REDIRECT_URL", cancelledRedirectURL},
+	} {
+		if d[1] != "" {
+			dests = append(dests, [2]string{d[0], expandRedirectURL(d[1], redirectVars{})})
+		}
+	}
+	return dests
+}
+
+// maybeCheckRedirects starts a check of the redirect destinations once the
+// last one is REDIRECT_CHECK_INTERVAL old. Requests do not wait for it.
+func maybeCheckRedirects() {
+	if redirectCheckInterval <= 0 {
+		return
+	}
+	redirectChecks.Lock()
+	defer redirectChecks.Unlock()
+	if redirectChecks.running || time.Since(redirectChecks.checked) < redirectCheckInterval {
+		return
+	}
+	redirectChecks.checked, redirectChecks.running = time.Now(), true
+	go func() {
+		results := checkRedirects(context.Background())
+		redirectChecks.Lock()
+		redirectChecks.results, redirectChecks.running = results, false
+		redirectChecks.Unlock()
+	}()
+}
+
+// checkRedirects checks every redirect destination and logs a warning for
+// each that is broken.
+func checkRedirects(ctx context.Context) []redirectCheck {
+	var results []redirectCheck
+	for _, d := range redirectDestinations() {
+		c := checkRedirect(ctx, d[0], d[1])
+		if !c.OK {
+			log.Printf("WARNING: %s (%s) looks broken: %s", c.Setting, c.URL, c.problem())
+		}
+		results = append(results, c)
+	}
+	return results
+}
+
+// checkRedirect requests the page at target the way a browser arriving
+// from the flow would, following redirects. Servers that do not support
+// HEAD get a GET instead.
+func checkRedirect(ctx context.Context, setting, target string) redirectCheck {
+	c := redirectCheck{Setting: setting, URL: target, CheckedAt: time.Now().UTC()}
+	ctx, cancel := context.WithTimeout(ctx, redirectCheckTimeout)
+	defer cancel()
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, target, nil)
+		if err != nil {
+			c.Error = err.Error()
+			return c
+		}
+		req.Header.Set("User-Agent", "auto-invite redirect check")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			c.Error = redact(err.Error())
+			return c
+		}
+		resp.Body.Close()
+		c.Status = resp.StatusCode
+		if method == http.MethodHead && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+			continue
+		}
+		break
+	}
+	c.OK = c.Status < http.StatusBadRequest
+	return c
+}
+
+// problem describes what is wrong with a failed check.
+func (c redirectCheck) problem() string {
+	if c.Error != "" {
+		return c.Error
+	}
+	return "HTTP " + strconv.Itoa(c.Status)
+}
+
+// redirectCheckMetrics reports the last checks for /debug/vars: the status
+// of each destination, 0 when unreachable, and how many are failing.
+func redirectCheckMetrics() any {
+	redirectChecks.Lock()
+	defer redirectChecks.Unlock()
+	statuses := make(map[string]int)
+	failing := 0
+	for _, c := range redirectChecks.results {
+		statuses[c.Setting] = c.Status
+		if !c.OK {
+			failing++
+		}
+	}
+	return map[string]any{"status": statuses, "failing": failing}
+}
+
+// handleRedirectChecks shows the last checks of the redirect destinations.
+// With ?refresh=true they are checked again first.
+func handleRedirectChecks(w http.ResponseWriter, r *http.Request) {
+	refresh, _ := strconv.ParseBool(r.URL.Query().Get("refresh"))
+	redirectChecks.Lock()
+	results, checked := redirectChecks.results, redirectChecks.checked
+	redirectChecks.Unlock()
+	if refresh || results == nil {
+		results = checkRedirects(r.Context())
+		checked = time.Now()
+		redirectChecks.Lock()
+		redirectChecks.results, redirectChecks.checked = results, checked
+		redirectChecks.Unlock()
+	}
+	failing := 0
+	for _, c := range results {
+		if !c.OK {
+			failing++
+		}
+	}
+	body := map[string]any{"checks": results, "failing": failing}
+	if redirectCheckInterval > 0 {
+		body["next_check"] = checked.Add(redirectCheckInterval).UTC()
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// checkRedirectPages reports broken redirect destinations as warnings of
+// the online configuration check.
+func (v *configValidator) checkRedirectPages(ctx context.Context) {
+	var warnings []string
+	for _, d := range [][2]string{{"SUCCESS_REDIRECT_URL", v.getenv("SUCCESS_REDIRECT_URL")}, {"ERROR_REDIRECT_URL", v.getenv("ERROR_REDIRECT_URL")}} {
+		if d[1] == "" {
+			continue
+		}
+		if c := checkRedirect(ctx, d[0], expandRedirectURL(d[1], redirectVars{})); !c.OK {
+			warnings = append(warnings, fmt.Sprintf("%s (%s) looks broken: %s", c.Setting, c.URL, c.problem()))
+		}
+	}
+	v.check("redirect pages", nil, warnings)
+}
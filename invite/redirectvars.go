@@ -0,0 +1,77 @@
+package invite
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redirectVars are the values of the variables in redirect URLs: a success
+// or error page of "https://example.com/welcome/{username}?org={org}" is
+// expanded for the user before they are sent there, so that it can be
+// deep-linked without parsing query parameters.
+type redirectVars struct {
+	Username  string // {username}
+	Org       string // {org}; githubOrgName when empty
+	Campaign  string // {campaign}
+	ErrorCode string // {error_code}, on error pages
+}
+
+// redirectVarPattern matches the variables of redirect URLs, as written
+// and as escaped by URL parsing.
+var redirectVarPattern = regexp.MustCompile(`(?i)\{(username|org|campaign|error_code)\}|%7B(username|org|campaign|error_code)%7D`)
+
+// anyRedirectVar matches anything that looks like a variable, for the
+// configuration check to spot misspelled ones.
+var anyRedirectVar = regexp.MustCompile(`\{[^{}/?&=]*\}`)
+
+// expandRedirectURL returns target with its variables replaced by vars.
+// Values are escaped so that they fit in a path segment or a query.
+func expandRedirectURL(target string, vars redirectVars) string {
+	if !strings.ContainsAny(target, "{%") {
+		return target
+	}
+	return redirectVarPattern.ReplaceAllStringFunc(target, func(m string) string {
+		sub := redirectVarPattern.FindStringSubmatch(m)
+		var value string
+		switch strings.ToLower(sub[1] + sub[2]) {
+		case "username":
+			value = vars.Username
+		case "org":
+			value = orgOrDefault(vars.Org)
+		case "campaign":
+			value = vars.Campaign
+		case "error_code":
+			value = vars.ErrorCode
+		}
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	})
+}
+
+// unknownRedirectVars returns the variables in target that are not
+// expanded.
+func unknownRedirectVars(target string) []string {
+	var unknown []string
+	for _, v := range anyRedirectVar.FindAllString(target, -1) {
+		if !redirectVarPattern.MatchString(v) {
+			unknown = append(unknown, v)
+		}
+	}
+	return unknown
+}
+
+type redirectVarsKey struct{}
+
+// withRedirectVars returns r carrying what is known about the user, for
+// the variables of the error page it may end on.
+func withRedirectVars(r *http.Request, vars redirectVars) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), redirectVarsKey{}, vars))
+}
+
+// redirectVarsOf returns the variables carried by r.
+func redirectVarsOf(r *http.Request) redirectVars {
+	vars, _ := r.Context().Value(redirectVarsKey{}).(redirectVars)
+	return vars
+}
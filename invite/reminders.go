@@ -0,0 +1,61 @@
+package invite
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// remindedMemberKey marks invited users who were sent a reminder.
+const remindedMemberKey = "members:reminded:"
+
+// reminderAfter is how long after the invitation a user who has not joined
+// is reminded, from INVITE_REMINDER_AFTER; 0 disables reminders.
+var reminderAfter time.Duration
+
+// loadReminderConfig reads INVITE_REMINDER_AFTER. Reminders are emailed, so
+// they need SMTP, and must go out before the invitation expires.
+func loadReminderConfig() {
+	reminderAfter = envDuration("INVITE_REMINDER_AFTER", 0)
+	if reminderAfter <= 0 {
+		reminderAfter = 0
+		return
+	}
+	if mailer == nil {
+		log.Fatal("FATAL: SMTP_HOST must be set to send INVITE_REMINDER_AFTER reminders.")
+	}
+	if reminderAfter >= pendingMemberTTL {
+		log.Fatalf("FATAL: INVITE_REMINDER_AFTER must be shorter than %v, when invitations expire.", pendingMemberTTL)
+	}
+}
+
+// invitationURL is where a user accepts their invitation to org.
+func invitationURL(org string) string {
+	return githubWebURL() + "/orgs/" + org + "/invitation"
+}
+
+// remind emails m a reminder with the accept link once their invitation is
+// reminderAfter old, and reports whether it did. Each user is reminded once.
+func remind(ctx context.Context, m pendingMember) (bool, error) {
+	if reminderAfter == 0 || m.Email == "" || m.InvitedAt.IsZero() {
+		return false, nil
+	}
+	age := time.Since(m.InvitedAt)
+	if age < reminderAfter || age >= pendingMemberTTL {
+		return false, nil
+	}
+	org := orgOrDefault(m.Org)
+	first, err := dataStore.SetNX(ctx, remindedMemberKey+org+":"+m.Username, nil, pendingMemberTTL)
+	if err != nil || !first {
+		return false, err
+	}
+	expires := m.InvitedAt.Add(pendingMemberTTL)
+	body := fmt.Sprintf("Hi %s,\n\nYour invitation to join %s on GitHub is still waiting for you. Accept it here before it expires on %s:\n\n%s\n",
+		m.Username, org, expires.UTC().Format("January 2, 2006"), invitationURL(org))
+	if err := mailer.send(m.Email, "Your invitation to "+org+" expires soon", body); err != nil {
+		return false, err
+	}
+	log.Printf("Sent an invitation reminder to %s", m.Username)
+	return true, nil
+}
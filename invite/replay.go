@@ -0,0 +1,34 @@
+package invite
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// usedCodeKey records OAuth authorization codes that reached a callback.
+const usedCodeKey = "oauth:code:"
+
+// usedCodeTTL outlives the codes themselves, which GitHub expires after ten
+// minutes.
+const usedCodeTTL = 15 * time.Minute
+
+// claimCode marks an authorization code as used. It fails with
+// ErrCallbackUsed when the code was seen before, which happens when users
+// refresh the callback page or use the back button. Codes are stored hashed.
+func claimCode(ctx context.Context, code string) error {
+	if code == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(code))
+	ok, err := dataStore.SetNX(ctx, usedCodeKey+hex.EncodeToString(sum[:]), nil, usedCodeTTL)
+	if err != nil {
+		// Without the store the exchange itself still rejects replays.
+		return nil
+	}
+	if !ok {
+		return ErrCallbackUsed
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package invite
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Decisions a reputation service can return.
+const (
+	reputationAllow  = "allow"
+	reputationDeny   = "deny"
+	reputationReview = "review"
+)
+
+// reputationConfig is the optional external vetting hook. Every candidate is
+// POSTed to the service, which answers with a decision:
+//
+//	{"decision": "allow" | "deny" | "review", "reason": "..."}
+//
+// Accounts flagged for review are put on the waitlist instead of invited.
+type reputationConfig struct {
+	url      string
+	token    string // Optional bearer token sent to the service
+	timeout  time.Duration
+	failOpen bool // Let candidates through when the service is unavailable
+}
+
+// reputationRequest is the body POSTed to the service.
+type reputationRequest struct {
+	Provider string `json:"provider"`
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+}
+
+// reputation is nil when the hook is disabled.
+var reputation *reputationConfig
+
+// loadReputationConfig reads the reputation service settings. The hook is
+// enabled by setting REPUTATION_URL.
+func loadReputationConfig() {
+	u := getenv("REPUTATION_URL")
+	if u == "" {
+		return
+	}
+	reputation = &reputationConfig{
+		url:      u,
+		token:    getenv("REPUTATION_TOKEN"),
+		timeout:  envDuration("REPUTATION_TIMEOUT", 3*time.Second),
+		failOpen: envBool("REPUTATION_FAIL_OPEN"),
+	}
+}
+
+// check asks the service about user and returns its decision, with an
+// error for anything but allow. When the service fails, the candidate is
+// let through or rejected depending on REPUTATION_FAIL_OPEN.
+func (c *reputationConfig) check(ctx context.Context, user *Identity, campaign string) (string, error) {
+	decision, reason, err := c.ask(ctx, reputationRequest{
+		Provider: activeProvider.Name(),
+		UserID:   user.ID,
+		Username: user.Username,
+		Email:    user.Email,
+		Campaign: campaign,
+	})
+	if err != nil {
+		log.Printf("Reputation check for %s failed (fail open: %t): %v", user.Username, c.failOpen, err)
+		if c.failOpen {
+			return "", nil
+		}
+		return "", ErrInvitationFailed.Wrap(err)
+	}
+	if reason != "" {
+		log.Printf("Reputation service: %s for %s: %s", decision, user.Username, reason)
+	}
+	switch decision {
+	case reputationDeny:
+		return decision, ErrRejected
+	case reputationReview:
+		return decision, ErrPendingReview
+	}
+	return decision, nil
+}
+
+// ask POSTs the request to the service and decodes its answer.
+func (c *reputationConfig) ask(ctx context.Context, body reputationRequest) (decision, reason string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	b, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("reputation service: %s", resp.Status)
+	}
+	var result struct {
+		Decision string `json:"decision"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", "", fmt.Errorf("reputation service: decoding response: %v", err)
+	}
+	switch result.Decision {
+	case reputationAllow, reputationDeny, reputationReview:
+		return result.Decision, result.Reason, nil
+	}
+	return "", "", fmt.Errorf("reputation service: unknown decision %q", result.Decision)
+}
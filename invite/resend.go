@@ -0,0 +1,171 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// resendKey marks the users who resent their invitation recently, by org
+// and username.
+const resendKey = "resend:"
+
+// resendTokenTTL is how long the resend button of a status page works.
+const resendTokenTTL = 15 * time.Minute
+
+// resendInterval is how often users may resend their invitation, from
+// RESEND_INTERVAL. Zero turns resending off.
+var resendInterval time.Duration
+
+// loadResendConfig reads RESEND_INTERVAL, a day by default.
+func loadResendConfig() {
+	resendInterval = envDuration("RESEND_INTERVAL", 24*time.Hour)
+	if resendInterval < 0 {
+		log.Fatal("FATAL: RESEND_INTERVAL must not be negative.")
+	}
+}
+
+// canResend reports whether users signed in to the status page may resend
+// their invitation.
+func canResend() bool {
+	return statusPage && resendInterval > 0 && canSign()
+}
+
+// resendClaims is the payload of the token of a resend button, naming the
+// user who signed in to the status page.
+type resendClaims struct {
+	Username string `json:"u"`
+	Org      string `json:"o"`
+	Expires  int64  `json:"exp"`
+}
+
+// newResendToken returns the token of the resend button of username's
+// invitation to org.
+func newResendToken(ctx context.Context, org, username string) (string, error) {
+	payload, _ := json.Marshal(resendClaims{Username: username, Org: org, Expires: time.Now().Add(resendTokenTTL).Unix()})
+	return signToken(ctx, tokenResend, payload)
+}
+
+// handleResend serves POST /status/resend, the resend button of the status
+// page: it cancels the pending invitation of the user who signed in and
+// invites them again with the same role and teams, so that GitHub sends a
+// new email. Each user may resend once per RESEND_INTERVAL. Only the tokens
+// of newResendToken are taken, not those of other pages naming a user.
+func handleResend(w http.ResponseWriter, r *http.Request) {
+	if !canResend() {
+		handleUnknownPath(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	var claims resendClaims
+	payload, err := verifyToken(tokenResend, r.FormValue("token"))
+	if err != nil || json.Unmarshal(payload, &claims) != nil || time.Now().Unix() >= claims.Expires || claims.Username == "" || knownOrg(claims.Org) == "" {
+		renderStatus(w, r, statusPageData{CanSignIn: true, Error: "This page has expired. Sign in again to resend your invitation."})
+		return
+	}
+
+	ctx := r.Context()
+	data := statusPageData{Username: claims.Username, SignedIn: true}
+	key := resendKey + claims.Org + ":" + strings.ToLower(claims.Username)
+	first, err := dataStore.SetNX(ctx, key, nil, resendInterval)
+	switch {
+	case err != nil:
+		log.Printf("Checking the resend limit of %s failed: %v", claims.Username, err)
+		data.Error = "We could not resend your invitation right now. Please try again later."
+	case !first:
+		data.Error = "Your invitation was resent recently. Check your email, including the spam folder, or accept it on GitHub."
+	default:
+		if err := resendInvitation(ctx, adminClient(), claims.Org, claims.Username); err != nil {
+			log.Printf("Resending the invitation of %s to %s failed: %v", claims.Username, claims.Org, err)
+			dataStore.Delete(ctx, key)
+			data.Error = asError(err, ErrInvitationFailed).Message
+			break
+		}
+		log.Printf("Resent the invitation of %s to %s", claims.Username, claims.Org)
+		recordActivity(ctx, activityEvent{Type: activityInviteResent, Provider: activeProvider.Name(), Username: claims.Username, Org: claims.Org})
+		data.Notice = "We sent you a new invitation. Check your email, or accept it on GitHub."
+	}
+	statuses, msg := lookUpStatuses(ctx, claims.Username, true)
+	data.Statuses = statuses
+	if data.Error == "" {
+		data.Error = msg
+	}
+	renderStatus(w, r, data)
+}
+
+// resendInvitation cancels the pending invitation of username to org and
+// sends a new one with the role and teams of the old one.
+func resendInvitation(ctx context.Context, client *github.Client, org, username string) error {
+	caps := capabilitiesOf(ctx, org)
+	if !caps.Invite || !caps.Invitations {
+		return ErrInvitationFailed.WithMessage("Invitations can't be resent here. Contact the organization to get a new one.")
+	}
+	membership, resp, err := client.Organizations.GetOrgMembership(ctx, username, org)
+	switch {
+	case err == nil && membership.GetState() != "pending":
+		return ErrAlreadyMember
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+		return ErrInvitationFailed.WithMessage("You have no pending invitation to resend. Sign in to join again.")
+	case err != nil:
+		return classifyInviteError(err)
+	}
+	opts := inviteOptions{Org: org}
+	if role := membership.GetRole(); role == "admin" || role == roleBillingManager {
+		opts.Role = role
+	}
+	if opts.Teams, err = invitationTeams(ctx, client, org, username); err != nil {
+		return classifyInviteError(err)
+	}
+
+	if _, err := client.Organizations.RemoveOrgMembership(ctx, username, org); err != nil {
+		return classifyInviteError(err)
+	}
+	if err := inviteUser(ctx, client, username, opts); err != nil {
+		// The old invitation is gone: this needs a maintainer.
+		log.Printf("ERROR: Cancelled the invitation of %s to %s, but inviting them again failed: %v", username, org, err)
+		return err
+	}
+	return nil
+}
+
+// invitationTeams returns the slugs of the teams of username's pending
+// invitation to org.
+func invitationTeams(ctx context.Context, client *github.Client, org, username string) ([]string, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		invitations, resp, err := client.Organizations.ListPendingOrgInvitations(ctx, org, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, inv := range invitations {
+			if !strings.EqualFold(inv.GetLogin(), username) {
+				continue
+			}
+			if inv.GetTeamCount() == 0 {
+				return nil, nil
+			}
+			teams, _, err := client.Organizations.ListOrgInvitationTeams(ctx, org, strconv.FormatInt(inv.GetID(), 10), &github.ListOptions{PerPage: 100})
+			if err != nil {
+				return nil, err
+			}
+			var slugs []string
+			for _, t := range teams {
+				slugs = append(slugs, t.GetSlug())
+			}
+			return slugs, nil
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
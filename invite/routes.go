@@ -0,0 +1,95 @@
+package invite
+
+import (
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// What unknown paths get, from UNKNOWN_PATHS.
+const (
+	unknownPathsNotFound = "not_found" // A 404 page, or a JSON error (default)
+	unknownPathsLogin    = "login"     // A redirect to /login, as before there was a choice
+)
+
+var (
+	unknownPaths string
+	// routeAllowlist is the paths that are routed, from ROUTES; every path
+	// is when empty. An entry ending in "/" allows the paths under it.
+	routeAllowlist []string
+)
+
+var notFoundTemplate = lazyTemplate("not_found", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Page not found</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}</style>
+</head>
+<body>
+<h1>Page not found</h1>
+<p>There is nothing here. If you followed a link to join the organization, it may be mistyped.</p>
+<p><a href="/login">Join the organization</a></p>
+</body>
+</html>
+`)
+
+var notFoundPage = staticPage(notFoundTemplate)
+
+// loadRouteConfig reads UNKNOWN_PATHS and ROUTES.
+func loadRouteConfig() {
+	unknownPaths = getenv("UNKNOWN_PATHS")
+	switch unknownPaths {
+	case "":
+		unknownPaths = unknownPathsNotFound
+	case unknownPathsNotFound, unknownPathsLogin:
+	default:
+		log.Fatalf("FATAL: UNKNOWN_PATHS must be %s or %s, not %q.", unknownPathsNotFound, unknownPathsLogin, unknownPaths)
+	}
+	routeAllowlist = nil
+	for _, p := range strings.Split(getenv("ROUTES"), ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			log.Fatalf("FATAL: ROUTES entries must be paths starting with /, not %q.", p)
+		}
+		routeAllowlist = append(routeAllowlist, p)
+	}
+}
+
+// routable reports whether path is served. The callback of the provider is
+// always served, as no sign-in finishes without it.
+func routable(path string) bool {
+	if len(routeAllowlist) == 0 || path == "/"+activeProvider.Name()+"/callback" {
+		return true
+	}
+	return slices.ContainsFunc(routeAllowlist, func(p string) bool {
+		return path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)
+	})
+}
+
+// handleUnknownPath answers a request for a path that is not served. The
+// bare domain goes to /login, so that it can be linked as the join button.
+func handleUnknownPath(w http.ResponseWriter, r *http.Request) {
+	if unknownPaths == unknownPathsLogin || r.URL.Path == "/" && routable("/login") {
+		http.Redirect(w, r, "/login", redirectStatus(r, redirectLogin))
+		return
+	}
+	if wantsJSON(r) || r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write(notFoundPage())
+}
+
+// methodNotAllowed answers a request with a method the route does not take,
+// listing those it does.
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSONError(w, ErrMethodNotAllowed)
+}
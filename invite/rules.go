@@ -0,0 +1,295 @@
+package invite
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ruleSet holds the eligibility settings that can be changed without a
+// redeploy: gates, schedules, role and team rules, and feature flags. It is read from the
+// environment, overridden by CONFIG_FILE, and swapped atomically on reload;
+// a ruleSet is never modified once active.
+type ruleSet struct {
+	maxMembers         int      // Close membership once the org has this many members (0 = no cap)
+	inviteWindows      []window // Periods during which invitations are accepted (none = always)
+	policyRules        []policyRule
+	policyTopLanguages int // How many top languages "language" rules look at
+	minFollowers       int // Reject accounts with fewer followers (0 = no gate)
+	minPublicRepos     int // Reject accounts with fewer public repositories (0 = no gate)
+	minContributions   int // Reject accounts with fewer contributions in the last year (0 = no gate)
+	spamThreshold      int // Reject accounts with at least this spam score (0 = no gate)
+	spamWeights        map[string]int
+	spamNewAccountAge  time.Duration // Accounts younger than this count as new
+	disposableDomains  map[string]bool
+	presets            map[string]inviteOptions // Invite options by preset name, for ?preset=
+	campaigns          map[string]campaign      // Landing pages by name, for /join/{name}
+	experiments        map[string]experiment    // Copy variants under test, by experiment name
+	rejectSuspended    bool                     // Check that accounts are not suspended before inviting them
+	featureFlags       map[string]int           // Rollout percentage by flag name
+	shadowGates        map[string]bool          // Gates whose failures are only recorded, not enforced
+
+	orgs map[string]*ruleSet // Rules of each org, when the deployment serves several
+}
+
+// forOrg returns the rules for org: these rules with the org's own
+// overrides, such as MIN_FOLLOWERS__ACME_LABS, applied.
+func (rs *ruleSet) forOrg(org string) *ruleSet {
+	if o, ok := rs.orgs[org]; ok {
+		return o
+	}
+	return rs
+}
+
+// ruleKeys are the settings CONFIG_FILE may set.
+var ruleKeys = map[string]bool{
+	"MAX_MEMBERS": true, "INVITE_WINDOWS": true, "INVITE_TIMEZONE": true,
+	"POLICY_RULES": true, "POLICY_TOP_LANGUAGES": true,
+	"MIN_FOLLOWERS": true, "MIN_PUBLIC_REPOS": true, "MIN_CONTRIBUTIONS": true,
+	"SPAM_SCORE_THRESHOLD": true, "SPAM_WEIGHTS": true, "SPAM_NEW_ACCOUNT_AGE": true, "SPAM_DISPOSABLE_DOMAINS": true,
+	"FEATURE_FLAGS": true, "REJECT_SUSPENDED": true, "PRESETS": true, "SHADOW_GATES": true,
+	"CAMPAIGNS": true, "EXPERIMENTS": true,
+}
+
+var (
+	activeRules atomic.Pointer[ruleSet]
+
+	configFile          string        // Optional file overriding the settings in ruleKeys
+	configCheckInterval time.Duration // How often the file's mtime is checked
+
+	// reloadState tracks the config file between checks.
+	reloadState struct {
+		sync.Mutex
+		checked time.Time
+		modTime time.Time
+	}
+)
+
+// currentRules returns the active rules. Callers should read it once per
+// decision so that a reload midway cannot mix old and new settings.
+func currentRules() *ruleSet {
+	return activeRules.Load()
+}
+
+// loadRules reads the rules from the environment and CONFIG_FILE.
+func loadRules() (*ruleSet, time.Time, error) {
+	return loadRulesFrom(configFile, githubOrgs, getenv)
+}
+
+// loadRulesFrom reads the rules from the settings getenv returns and the
+// config file at path, if any. When orgs holds several orgs, each gets its
+// own copy of the rules, with the settings suffixed with orgEnvSuffix taking
+// precedence.
+func loadRulesFrom(path string, orgs []string, getenv func(string) string) (*ruleSet, time.Time, error) {
+	overrides, modTime, err := readConfigFile(path, orgs)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	get := func(name string) string {
+		if v, ok := overrides[name]; ok {
+			return v
+		}
+		return getenv(name)
+	}
+	rs, err := buildRules(get)
+	if err != nil {
+		return nil, modTime, err
+	}
+	if len(orgs) > 1 {
+		rs.orgs = make(map[string]*ruleSet)
+		for _, org := range orgs {
+			suffix := orgEnvSuffix(org)
+			getOrg := func(name string) string {
+				if v := get(name + suffix); v != "" {
+					return v
+				}
+				return get(name)
+			}
+			if rs.orgs[org], err = buildRules(getOrg); err != nil {
+				return nil, modTime, fmt.Errorf("%s: %v", org, err)
+			}
+		}
+	}
+	return rs, modTime, nil
+}
+
+// buildRules parses the rules from the settings get returns.
+func buildRules(get func(string) string) (*ruleSet, error) {
+	var err error
+	num := func(name string, def int) int {
+		v := get(name)
+		if v == "" || err != nil {
+			return def
+		}
+		n, perr := strconv.Atoi(v)
+		if perr != nil {
+			err = fmt.Errorf("%s must be an integer: %v", name, perr)
+		}
+		return n
+	}
+
+	rs := &ruleSet{
+		maxMembers:         num("MAX_MEMBERS", 0),
+		policyTopLanguages: num("POLICY_TOP_LANGUAGES", 3),
+		minFollowers:       num("MIN_FOLLOWERS", 0),
+		minPublicRepos:     num("MIN_PUBLIC_REPOS", 0),
+		minContributions:   num("MIN_CONTRIBUTIONS", 0),
+		spamThreshold:      num("SPAM_SCORE_THRESHOLD", 0),
+		spamNewAccountAge:  30 * 24 * time.Hour,
+		disposableDomains:  make(map[string]bool),
+	}
+	if err != nil {
+		return nil, err
+	}
+	if v := get("SPAM_NEW_ACCOUNT_AGE"); v != "" {
+		if rs.spamNewAccountAge, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("SPAM_NEW_ACCOUNT_AGE must be a duration such as 720h: %v", err)
+		}
+	}
+	loc, err := time.LoadLocation(get("INVITE_TIMEZONE"))
+	if err != nil {
+		return nil, fmt.Errorf("INVITE_TIMEZONE is not a valid time zone: %v", err)
+	}
+	if rs.inviteWindows, err = parseWindows(get("INVITE_WINDOWS"), loc); err != nil {
+		return nil, fmt.Errorf("INVITE_WINDOWS: %v", err)
+	}
+	if rs.policyRules, err = parsePolicy(get("POLICY_RULES")); err != nil {
+		return nil, fmt.Errorf("POLICY_RULES: %v", err)
+	}
+	if rs.presets, err = parsePresets(get("PRESETS")); err != nil {
+		return nil, fmt.Errorf("PRESETS: %v", err)
+	}
+	if rs.experiments, err = parseExperiments(get("EXPERIMENTS")); err != nil {
+		return nil, fmt.Errorf("EXPERIMENTS: %v", err)
+	}
+	if rs.campaigns, err = parseCampaigns(get("CAMPAIGNS"), rs.presets, rs.experiments); err != nil {
+		return nil, fmt.Errorf("CAMPAIGNS: %v", err)
+	}
+	if rs.spamWeights, err = parseSpamWeights(get("SPAM_WEIGHTS")); err != nil {
+		return nil, fmt.Errorf("SPAM_WEIGHTS: %v", err)
+	}
+	if v := get("REJECT_SUSPENDED"); v != "" {
+		if rs.rejectSuspended, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("REJECT_SUSPENDED must be true or false: %v", err)
+		}
+	}
+	if rs.featureFlags, err = parseFeatureFlags(get("FEATURE_FLAGS")); err != nil {
+		return nil, fmt.Errorf("FEATURE_FLAGS: %v", err)
+	}
+	if rs.shadowGates, err = parseShadowGates(get("SHADOW_GATES")); err != nil {
+		return nil, fmt.Errorf("SHADOW_GATES: %v", err)
+	}
+	for domain := range disposableDomains {
+		rs.disposableDomains[domain] = true
+	}
+	for _, domain := range strings.Split(get("SPAM_DISPOSABLE_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			rs.disposableDomains[domain] = true
+		}
+	}
+	return rs, nil
+}
+
+// readConfigFile reads a file of KEY=VALUE lines. Blank lines and lines
+// starting with # are skipped, and only the reloadable settings of orgs are
+// allowed. With no file configured it returns nothing.
+func readConfigFile(path string, orgs []string) (map[string]string, time.Time, error) {
+	if path == "" {
+		return nil, time.Time{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isRuleKey(key, orgs) {
+			return nil, time.Time{}, fmt.Errorf("%s:%d: not a reloadable setting: %q", path, n, key)
+		}
+		values[key] = strings.TrimSpace(value)
+	}
+	return values, info.ModTime(), scanner.Err()
+}
+
+// isRuleKey reports whether key is a reloadable setting, or the variant of
+// one for one of orgs.
+func isRuleKey(key string, orgs []string) bool {
+	if ruleKeys[key] {
+		return true
+	}
+	for _, org := range orgs {
+		if base, ok := strings.CutSuffix(key, orgEnvSuffix(org)); ok && ruleKeys[base] {
+			return true
+		}
+	}
+	return false
+}
+
+// reloadRules loads the rules again and makes them active. On error the
+// current rules stay in place.
+func reloadRules() error {
+	rs, modTime, err := loadRules()
+	if err != nil {
+		return err
+	}
+	activeRules.Store(rs)
+	reloadState.Lock()
+	reloadState.modTime = modTime
+	reloadState.Unlock()
+	log.Printf("Reloaded the eligibility rules")
+	return nil
+}
+
+// maybeReloadRules reloads the rules when CONFIG_FILE has changed. The file
+// is checked at most once per configCheckInterval.
+func maybeReloadRules() {
+	if configFile == "" {
+		return
+	}
+	reloadState.Lock()
+	if time.Since(reloadState.checked) < configCheckInterval {
+		reloadState.Unlock()
+		return
+	}
+	reloadState.checked = time.Now()
+	last := reloadState.modTime
+	reloadState.Unlock()
+
+	info, err := os.Stat(configFile)
+	if err != nil || info.ModTime().Equal(last) {
+		return
+	}
+	if err := reloadRules(); err != nil {
+		log.Printf("ERROR: %s changed but could not be loaded, keeping the current rules: %v", configFile, err)
+	}
+}
+
+// handleReload reloads the rules on demand.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := reloadRules(); err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage(err.Error()))
+		return
+	}
+	recordAudit(r.Context(), r, adminActor(r), auditConfigReloaded, map[string]any{"file": configFile})
+	writeJSON(w, http.StatusOK, map[string]any{"reloaded": true})
+}
@@ -0,0 +1,194 @@
+package invite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A window is a period during which invitations are accepted.
+type window interface {
+	contains(t time.Time) bool
+	// nextStart returns the first time after t at which the window opens.
+	nextStart(t time.Time) (time.Time, bool)
+}
+
+// absoluteWindow is a single period between two instants.
+type absoluteWindow struct {
+	start, end time.Time
+}
+
+func (w absoluteWindow) contains(t time.Time) bool {
+	return !t.Before(w.start) && t.Before(w.end)
+}
+
+func (w absoluteWindow) nextStart(t time.Time) (time.Time, bool) {
+	return w.start, w.start.After(t)
+}
+
+// weeklyWindow repeats every week on the given days, between two wall-clock
+// times in loc.
+type weeklyWindow struct {
+	days       [7]bool
+	start, end clock
+	loc        *time.Location
+}
+
+// clock is a wall-clock time of day.
+type clock struct{ hour, min int }
+
+func (c clock) minutes() int { return c.hour*60 + c.min }
+
+func (w weeklyWindow) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	return w.days[t.Weekday()] && m >= w.start.minutes() && m < w.end.minutes()
+}
+
+func (w weeklyWindow) nextStart(t time.Time) (time.Time, bool) {
+	t = t.In(w.loc)
+	for i := 0; i <= 7; i++ {
+		s := time.Date(t.Year(), t.Month(), t.Day()+i, w.start.hour, w.start.min, 0, 0, w.loc)
+		if w.days[s.Weekday()] && s.After(t) {
+			return s, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// invitesOpen reports whether rs accepts invitations at t. With no windows
+// configured, invitations are always open. When closed, it also returns the
+// next time they open, if any.
+func invitesOpen(rs *ruleSet, t time.Time) (bool, time.Time) {
+	inviteWindows := rs.inviteWindows
+	if len(inviteWindows) == 0 {
+		return true, time.Time{}
+	}
+	var next time.Time
+	for _, w := range inviteWindows {
+		if w.contains(t) {
+			return true, time.Time{}
+		}
+		if s, ok := w.nextStart(t); ok && (next.IsZero() || s.Before(next)) {
+			next = s
+		}
+	}
+	return false, next
+}
+
+// parseWindows parses INVITE_WINDOWS: a semicolon-separated list where each
+// entry is either an absolute range such as
+//
+//	2026-11-01T09:00/2026-11-03T17:00
+//
+// (RFC 3339, with the offset optional) or a weekly schedule such as
+//
+//	Mon-Fri 09:00-17:00
+//	Sat,Sun 10:00-12:00
+//
+// Times without an explicit offset are interpreted in loc.
+func parseWindows(spec string, loc *time.Location) ([]window, error) {
+	var windows []window
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var w window
+		var err error
+		if strings.Contains(entry, "/") {
+			w, err = parseAbsoluteWindow(entry, loc)
+		} else {
+			w, err = parseWeeklyWindow(entry, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", entry, err)
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parseAbsoluteWindow(entry string, loc *time.Location) (window, error) {
+	from, to, _ := strings.Cut(entry, "/")
+	start, err := parseInstant(from, loc)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseInstant(to, loc)
+	if err != nil {
+		return nil, err
+	}
+	if !end.After(start) {
+		return nil, fmt.Errorf("end must be after start")
+	}
+	return absoluteWindow{start: start, end: end}, nil
+}
+
+// parseInstant parses an RFC 3339 timestamp, with seconds and offset optional.
+func parseInstant(s string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse time %q", s)
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWeeklyWindow(entry string, loc *time.Location) (window, error) {
+	daySpec, timeSpec, ok := strings.Cut(entry, " ")
+	if !ok {
+		return nil, fmt.Errorf("expected days and times, e.g. Mon-Fri 09:00-17:00")
+	}
+	w := weeklyWindow{loc: loc}
+	for _, part := range strings.Split(daySpec, ",") {
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !isRange {
+			last, ok2 = first, ok1
+		}
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("unknown day in %q", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	from, to, ok := strings.Cut(strings.TrimSpace(timeSpec), "-")
+	if !ok {
+		return nil, fmt.Errorf("expected a time range such as 09:00-17:00")
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return nil, err
+	}
+	if w.end.minutes() <= w.start.minutes() {
+		return nil, fmt.Errorf("end time must be after start time")
+	}
+	return w, nil
+}
+
+func parseClock(s string) (clock, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return clock{}, fmt.Errorf("cannot parse time of day %q", s)
+	}
+	return clock{hour: t.Hour(), min: t.Minute()}, nil
+}
@@ -0,0 +1,39 @@
+package invite
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// checkSeats returns ErrOrgSeatLimit when the named org's plan has no seats left.
+// Plan details are only visible to org owners; when they are missing, or the
+// plan has no seat limit, the check is skipped and the invitation itself is
+// relied on to report exhaustion, as it is when a fine-grained GITHUB_PAT
+// cannot read the plan.
+func checkSeats(ctx context.Context, client *github.Client, name string) error {
+	if !capabilitiesOf(ctx, name).Plan {
+		return nil
+	}
+	org, _, err := client.Organizations.Get(ctx, name)
+	if err != nil {
+		log.Printf("Could not check org seats, continuing: %v", err)
+		return nil
+	}
+	plan := org.GetPlan()
+	if plan == nil || plan.GetSeats() <= 0 {
+		return nil
+	}
+	if plan.GetFilledSeats() >= plan.GetSeats() {
+		return ErrOrgSeatLimit
+	}
+	return nil
+}
+
+// isSeatLimitMessage reports whether a 422 message from the membership API
+// means the org has run out of seats.
+func isSeatLimitMessage(msg string) bool {
+	return strings.Contains(strings.ToLower(msg), "seat")
+}
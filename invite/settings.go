@@ -0,0 +1,101 @@
+package invite
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// settingKind is the syntax of a setting holding a single value.
+type settingKind int
+
+const (
+	settingBool settingKind = iota + 1
+	settingInt
+	settingDuration
+)
+
+func (k settingKind) String() string {
+	switch k {
+	case settingBool:
+		return "true or false"
+	case settingInt:
+		return "an integer"
+	default:
+		return "a duration such as 5m"
+	}
+}
+
+// check returns an error when value is not of kind k.
+func (k settingKind) check(name, value string) error {
+	var err error
+	switch k {
+	case settingBool:
+		_, err = strconv.ParseBool(value)
+	case settingInt:
+		_, err = strconv.Atoi(value)
+	case settingDuration:
+		_, err = time.ParseDuration(value)
+	}
+	if err != nil {
+		return fmt.Errorf("%s must be %s, not %q", name, k, value)
+	}
+	return nil
+}
+
+// valueSettings are all the settings holding a single boolean, integer, or
+// duration, whether LoadConfig or a feature loader reads them. Only these
+// can be read with envBool, envInt, envDuration, and envParser, and
+// ValidateConfig checks every one, so that the two cannot drift apart.
+var valueSettings = map[string]settingKind{
+	"ADMIN_GITHUB_LOGIN":   settingBool,
+	"BOT_SIGNALS":          settingBool,
+	"ERROR_CODE_ONLY":      settingBool,
+	"LOGIN_REQUIRE_POST":   settingBool,
+	"ONBOARDING_CHECKLIST": settingBool,
+	"ONBOARDING_STATUS":    settingBool,
+	"PUBLIC_MEMBERSHIP":    settingBool,
+	"REPUTATION_FAIL_OPEN": settingBool,
+	"STATUS_PAGE":          settingBool,
+	"TEAM_SYNC_REMOVE":     settingBool,
+	"WAITLIST_WHEN_CLOSED": settingBool,
+	"WAITLIST_WHEN_FULL":   settingBool,
+
+	"LOAD_SHED_ERROR_RATE":  settingInt,
+	"LOAD_SHED_QUEUE_DEPTH": settingInt,
+	"MAX_BODY_BYTES":        settingInt,
+	"POW_DIFFICULTY":        settingInt,
+	"SLO_WINDOW_DAYS":       settingInt,
+	"WORKER_CONCURRENCY":    settingInt,
+
+	"ABUSE_FLAG_TTL":            settingDuration,
+	"ALERT_CHECK_INTERVAL":      settingDuration,
+	"ALERT_REPEAT":              settingDuration,
+	"ANALYTICS_TIMEOUT":         settingDuration,
+	"BOT_MIN_FILL_TIME":         settingDuration,
+	"CONFIG_CHECK_INTERVAL":     settingDuration,
+	"DENYLIST_REFRESH":          settingDuration,
+	"FLOW_TRAIL_TTL":            settingDuration,
+	"IDEMPOTENCY_TTL":           settingDuration,
+	"INVITE_REMINDER_AFTER":     settingDuration,
+	"LOAD_SHED_HOLD":            settingDuration,
+	"LOAD_SHED_WINDOW":          settingDuration,
+	"MEMBER_COUNT_REFRESH":      settingDuration,
+	"QUEUE_INTERVAL":            settingDuration,
+	"REDIRECT_CHECK_INTERVAL":   settingDuration,
+	"REPUTATION_TIMEOUT":        settingDuration,
+	"REQUEST_TIMEOUT":           settingDuration,
+	"RESEND_INTERVAL":           settingDuration,
+	"SLO_LATENCY_TARGET":        settingDuration,
+	"STORE_RETRY_INTERVAL":      settingDuration,
+	"TEAM_SYNC_INTERVAL":        settingDuration,
+	"TWO_FACTOR_NUDGE_INTERVAL": settingDuration,
+}
+
+// mustBeSetting panics unless name is registered in valueSettings as kind:
+// a setting read without being registered would go unvalidated.
+func mustBeSetting(name string, kind settingKind) {
+	if valueSettings[name] != kind {
+		panic(fmt.Sprintf("invite: %s is read as %s but not registered as such in valueSettings", name, kind))
+	}
+}
@@ -0,0 +1,296 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of load shedding. The switches are in the store so that every
+// instance sheds at once.
+const (
+	shedManualKey   = "shed:manual"    // Set by an admin, with the admin's reason
+	shedAutoKey     = "shed:auto"      // Set when a threshold was crossed, for loadShedHold
+	shedInvitesKey  = "shed:invites:"  // Invitations sent or failed, by window
+	shedFailuresKey = "shed:failures:" // Invitations GitHub failed or rate limited, by window
+)
+
+const (
+	// shedCheckInterval is how often an instance reads the switches and
+	// thresholds again. Sign-ins in between use the last answer.
+	shedCheckInterval = 5 * time.Second
+	// shedMinSamples is the fewest invitations of a window that the error
+	// rate is computed over, so that a single failure does not shed load.
+	shedMinSamples = 20
+	// shedPageMaxAge is how long browsers and CDNs may cache the page.
+	shedPageMaxAge = 30 * time.Second
+)
+
+// Settings of load shedding, read in loadLoadShedConfig.
+var (
+	loadShedQueueDepth int           // Queued invitations that start shedding, from LOAD_SHED_QUEUE_DEPTH; 0 disables
+	loadShedErrorRate  int           // Percentage of failed invitations that starts shedding, from LOAD_SHED_ERROR_RATE; 0 disables
+	loadShedWindow     time.Duration // Window of the error rate, from LOAD_SHED_WINDOW
+	loadShedHold       time.Duration // Least time automatic shedding lasts, from LOAD_SHED_HOLD
+)
+
+// loadLoadShedConfig reads LOAD_SHED_QUEUE_DEPTH, LOAD_SHED_ERROR_RATE,
+// LOAD_SHED_WINDOW, and LOAD_SHED_HOLD.
+func loadLoadShedConfig() {
+	loadShedQueueDepth = envInt("LOAD_SHED_QUEUE_DEPTH", 0)
+	loadShedErrorRate = envInt("LOAD_SHED_ERROR_RATE", 0)
+	loadShedWindow = envDuration("LOAD_SHED_WINDOW", 5*time.Minute)
+	loadShedHold = envDuration("LOAD_SHED_HOLD", 5*time.Minute)
+	switch {
+	case loadShedQueueDepth < 0:
+		log.Fatal("FATAL: LOAD_SHED_QUEUE_DEPTH must not be negative.")
+	case loadShedErrorRate < 0 || loadShedErrorRate > 100:
+		log.Fatal("FATAL: LOAD_SHED_ERROR_RATE must be a percentage between 0 and 100.")
+	case loadShedWindow < time.Second:
+		log.Fatal("FATAL: LOAD_SHED_WINDOW must be at least 1s.")
+	case loadShedHold < shedCheckInterval:
+		log.Fatalf("FATAL: LOAD_SHED_HOLD must be at least %s.", shedCheckInterval)
+	}
+	shed.reset()
+}
+
+// shedTemplate is the page served instead of starting sign-ins.
+var shedTemplate = lazyTemplate("shed", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>We're experiencing high demand</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}</style>
+</head>
+<body>
+<h1>We're experiencing high demand</h1>
+<p>So many people are signing up right now that we've paused new sign-ins for a moment. Please try again in a few minutes.</p>
+<p><a href="/login">Try again</a></p>
+</body>
+</html>
+`)
+
+var shedPage = staticPage(shedTemplate)
+
+// shedStatus is whether load is being shed, and why.
+type shedStatus struct {
+	Shedding bool        `json:"shedding"`
+	Reason   string      `json:"reason,omitempty"` // manual, queue_depth, or error_rate
+	Manual   *manualShed `json:"manual,omitempty"`
+	// The automatic signals, when their thresholds are set.
+	QueueDepthAtLeast int     `json:"queue_depth_at_least,omitempty"`
+	ErrorRate         float64 `json:"error_rate,omitempty"`
+	checked           time.Time
+}
+
+// manualShed is the switch an admin turned on.
+type manualShed struct {
+	Actor  string     `json:"actor"`
+	Reason string     `json:"reason,omitempty"`
+	Since  time.Time  `json:"since"`
+	Until  *time.Time `json:"until,omitempty"` // Nil when on until turned off
+}
+
+// shed caches the last shedStatus of this instance.
+var shed shedCache
+
+type shedCache struct {
+	mu     sync.Mutex
+	status shedStatus
+}
+
+func (c *shedCache) reset() {
+	c.mu.Lock()
+	c.status = shedStatus{}
+	c.mu.Unlock()
+}
+
+// get returns the cached status, checking again once it is older than
+// shedCheckInterval.
+func (c *shedCache) get(ctx context.Context) shedStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.status.checked) < shedCheckInterval {
+		return c.status
+	}
+	prev := c.status
+	c.status = checkLoadShed(ctx)
+	switch {
+	case c.status.Shedding && !prev.Shedding:
+		log.Printf("WARNING: Shedding load (%s): sign-ins get the high demand page", c.status.Reason)
+	case !c.status.Shedding && prev.Shedding:
+		log.Printf("Stopped shedding load")
+	}
+	return c.status
+}
+
+// checkLoadShed reads the switches and the automatic signals. Store errors
+// leave load shedding off, so a failing store does not close sign-ins on its
+// own.
+func checkLoadShed(ctx context.Context) shedStatus {
+	status := shedStatus{checked: time.Now()}
+	if b, err := dataStore.Get(ctx, shedManualKey); err == nil {
+		var m manualShed
+		if json.Unmarshal(b, &m) == nil {
+			status.Shedding, status.Reason, status.Manual = true, "manual", &m
+		}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Could not check the load shedding switch: %v", err)
+	}
+
+	if loadShedQueueDepth > 0 {
+		// Reading the one item at the threshold tells whether the queue is
+		// that deep without reading all of it.
+		if items, err := dataStore.Range(ctx, queueKey, int64(loadShedQueueDepth-1), int64(loadShedQueueDepth-1)); err != nil {
+			log.Printf("Could not check the queue depth for load shedding: %v", err)
+		} else if len(items) > 0 {
+			status.QueueDepthAtLeast = loadShedQueueDepth
+			status.trigger(ctx, "queue_depth")
+		}
+	}
+	if loadShedErrorRate > 0 {
+		invites, failures, err := shedCounts(ctx)
+		if err != nil {
+			log.Printf("Could not check the error rate for load shedding: %v", err)
+		} else if invites > 0 {
+			status.ErrorRate = float64(failures) / float64(invites)
+			if invites >= shedMinSamples && failures*100 >= invites*int64(loadShedErrorRate) {
+				status.trigger(ctx, "error_rate")
+			}
+		}
+	}
+
+	// Automatic shedding holds for loadShedHold after the last time a
+	// threshold was crossed, so that it does not flap as the signals recover.
+	if !status.Shedding {
+		if b, err := dataStore.Get(ctx, shedAutoKey); err == nil {
+			status.Shedding, status.Reason = true, string(b)
+		}
+	}
+	return status
+}
+
+// trigger starts or extends automatic shedding for reason.
+func (s *shedStatus) trigger(ctx context.Context, reason string) {
+	if err := dataStore.Set(ctx, shedAutoKey, []byte(reason), loadShedHold); err != nil {
+		log.Printf("Could not record automatic load shedding: %v", err)
+	}
+	if !s.Shedding {
+		s.Shedding, s.Reason = true, reason
+	}
+}
+
+// shedWindow returns the start of the error rate window t falls in.
+func shedWindow(t time.Time) int64 {
+	return t.Truncate(loadShedWindow).Unix()
+}
+
+// shedCounts returns the invitations and failures of the current and the
+// previous window, so that the rate does not reset when a window starts.
+func shedCounts(ctx context.Context) (invites, failures int64, err error) {
+	now := time.Now()
+	for _, window := range []int64{shedWindow(now), shedWindow(now.Add(-loadShedWindow))} {
+		suffix := strconv.FormatInt(window, 10)
+		for key, n := range map[string]*int64{shedInvitesKey + suffix: &invites, shedFailuresKey + suffix: &failures} {
+			b, err := dataStore.Get(ctx, key)
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return 0, 0, err
+			}
+			v, _ := strconv.ParseInt(string(b), 10, 64)
+			*n += v
+		}
+	}
+	return invites, failures, nil
+}
+
+// countInviteOutcome counts sent and failed invitations for the error rate.
+// Only failures on GitHub's side count.
+func countInviteOutcome(ctx context.Context, ev busEvent) {
+	suffix := strconv.FormatInt(shedWindow(time.Now()), 10)
+	if _, err := dataStore.Incr(ctx, shedInvitesKey+suffix, 2*loadShedWindow); err != nil {
+		log.Printf("Could not count the invitation for load shedding: %v", err)
+		return
+	}
+	if ev.Type != eventInviteFailed {
+		return
+	}
+	if providerFailure(ev.Entry.Code) {
+		if _, err := dataStore.Incr(ctx, shedFailuresKey+suffix, 2*loadShedWindow); err != nil {
+			log.Printf("Could not count the failed invitation for load shedding: %v", err)
+		}
+	}
+}
+
+// renderHighDemand serves the high demand page, or ErrHighDemand to JSON
+// clients. The page may be cached briefly, so a spike of visitors is served
+// by the CDN.
+func renderHighDemand(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(shedPageMaxAge.Seconds())))
+	if wantsJSON(r) {
+		writeJSONError(w, ErrHighDemand)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(shedPageMaxAge.Seconds())))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(shedPage())
+}
+
+// handleLoadShed shows whether load is shed. PUT turns the manual switch on
+// or off, with a body such as {"enabled": true, "duration": "30m",
+// "reason": "launch"}; without a duration it stays on until turned off.
+// Automatic shedding cannot be turned off, only waited out.
+func handleLoadShed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method == http.MethodPut {
+		var body struct {
+			Enabled  bool   `json:"enabled"`
+			Duration string `json:"duration"`
+			Reason   string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+			return
+		}
+		var ttl time.Duration
+		if body.Duration != "" {
+			d, err := time.ParseDuration(body.Duration)
+			if err != nil || d <= 0 {
+				writeJSONError(w, ErrBadRequest.WithMessage("duration must be a positive duration such as 30m"))
+				return
+			}
+			ttl = d
+		}
+		var err error
+		if body.Enabled {
+			m := manualShed{Actor: adminActor(r), Reason: body.Reason, Since: time.Now().UTC()}
+			if ttl > 0 {
+				until := m.Since.Add(ttl)
+				m.Until = &until
+			}
+			b, _ := json.Marshal(m)
+			err = dataStore.Set(ctx, shedManualKey, b, ttl)
+		} else {
+			err = dataStore.Delete(ctx, shedManualKey)
+		}
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		shed.reset()
+		recordAudit(ctx, r, adminActor(r), auditLoadShedChanged, map[string]any{"enabled": body.Enabled, "duration": body.Duration, "reason": body.Reason})
+	}
+	writeJSON(w, http.StatusOK, shed.get(ctx))
+}
@@ -0,0 +1,147 @@
+package invite
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+const shortLinkKey = "shortlink:"
+
+// shortCodeAlphabet avoids characters that are easily confused when read off
+// a slide (0/O, 1/l/I).
+const shortCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+
+var validShortCode = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// createShortLinkRequest is the body of POST /admin/shortlinks. Either Target
+// is given, or a signed link is minted from the embedded link fields.
+type createShortLinkRequest struct {
+	mintLinkRequest
+	Code   string `json:"code"`   // Custom code; random if empty
+	Target string `json:"target"` // Existing /login URL path to shorten
+}
+
+// createShortLinkResponse is the response of POST /admin/shortlinks.
+type createShortLinkResponse struct {
+	Code      string     `json:"code"`
+	URL       string     `json:"url"`
+	Target    string     `json:"target"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleCreateShortLink stores a short code that expands to a login URL.
+func handleCreateShortLink(w http.ResponseWriter, r *http.Request) {
+	var req createShortLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+		return
+	}
+
+	var resp createShortLinkResponse
+	if req.Target != "" {
+		// Only this deployment's own login URL can be shortened, so short
+		// links can't be used as an open redirect.
+		if req.Target != "/login" && !strings.HasPrefix(req.Target, "/login?") {
+			writeJSONError(w, ErrBadRequest.WithMessage("target must be a /login path."))
+			return
+		}
+		resp.Target = req.Target
+	} else {
+		req.MintedBy = adminActor(r)
+		link, err := mintLink(r.Context(), req.mintLinkRequest)
+		if err != nil {
+			writeJSONError(w, asError(err, ErrBadRequest))
+			return
+		}
+		resp.Target = loginPath(link.Token)
+		resp.ExpiresAt = link.ExpiresAt
+	}
+
+	var ttl time.Duration
+	if resp.ExpiresAt != nil {
+		ttl = time.Until(*resp.ExpiresAt)
+	}
+	code, err := saveShortLink(r.Context(), req.Code, resp.Target, ttl)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	resp.Code = code
+	resp.URL = publicBaseURL(r) + "/i/" + code
+	recordAudit(r.Context(), r, adminActor(r), auditShortLinkAdded, map[string]any{"code": code, "target": resp.Target, "expires_at": resp.ExpiresAt})
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// saveShortLink stores target under code, generating a random code when
+// code is empty. Existing codes are never overwritten.
+func saveShortLink(ctx context.Context, code, target string, ttl time.Duration) (string, error) {
+	if code != "" {
+		if !validShortCode.MatchString(code) {
+			return "", ErrBadRequest.WithMessage("code may only contain letters, digits, '-' and '_'.")
+		}
+		ok, err := dataStore.SetNX(ctx, shortLinkKey+code, []byte(target), ttl)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", ErrConflict.WithMessage(fmt.Sprintf("Short code %q is already taken.", code))
+		}
+		return code, nil
+	}
+
+	for i := 0; i < 5; i++ {
+		code = randomShortCode(7)
+		ok, err := dataStore.SetNX(ctx, shortLinkKey+code, []byte(target), ttl)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return code, nil
+		}
+	}
+	return "", errors.New("could not allocate a unique short code")
+}
+
+// handleShortLink expands /i/{code} to the login URL it was created for.
+func handleShortLink(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/i/")
+	target, err := dataStore.Get(r.Context(), shortLinkKey+code)
+	if errors.Is(err, store.ErrNotFound) {
+		redirectToErrorPage(w, r, ErrInvalidLink.WithMessage("This short link does not exist or has expired."))
+		return
+	}
+	if err != nil {
+		redirectToErrorPage(w, r, ErrConfig.Wrap(err))
+		return
+	}
+	http.Redirect(w, r, string(target), redirectStatus(r, redirectShortLink))
+}
+
+// randomShortCode returns a random code of n characters, each as likely as
+// the others: random bytes past the largest multiple of the alphabet's
+// length are drawn again rather than wrapped around.
+func randomShortCode(n int) string {
+	const limit = 256 - 256%len(shortCodeAlphabet)
+	code := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(code) < n {
+		if _, err := rand.Read(buf); err != nil {
+			panic(err)
+		}
+		for _, c := range buf {
+			if int(c) < limit && len(code) < n {
+				code = append(code, shortCodeAlphabet[int(c)%len(shortCodeAlphabet)])
+			}
+		}
+	}
+	return string(code)
+}
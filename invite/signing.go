@@ -0,0 +1,190 @@
+package invite
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// minSigningKeyLen is the minimum SIGNING_KEY length, in bytes.
+const minSigningKeyLen = 32
+
+var errBadSignature = errors.New("invalid token signature")
+
+// A tokenSigner signs the tokens of signToken: the OAuth state, invite
+// links, step tokens, and the other tokens the flow hands out.
+type tokenSigner interface {
+	sign(ctx context.Context, body []byte) ([]byte, error)
+	verify(body, sig []byte) bool
+}
+
+// hmacSigner signs with HMAC-SHA256 and SIGNING_KEY.
+type hmacSigner struct {
+	key []byte
+}
+
+func (s hmacSigner) sign(ctx context.Context, body []byte) ([]byte, error) {
+	h := hmac.New(sha256.New, s.key)
+	h.Write(body)
+	return h.Sum(nil), nil
+}
+
+func (s hmacSigner) verify(body, sig []byte) bool {
+	mac, _ := s.sign(context.Background(), body)
+	return hmac.Equal(sig, mac)
+}
+
+// tokenKey is a signing key, with the ID tokens name it by. SIGNING_KEY
+// has no ID, and its tokens none either, as before keys could be rotated.
+type tokenKey struct {
+	id     string
+	signer tokenSigner
+}
+
+// tokenKeys are the keys tokens are verified with. The first signs new
+// tokens.
+var tokenKeys []tokenKey
+
+var validKeyID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// loadSigningConfig sets up the signing keys: the KMS keys of
+// SIGNING_KMS_KEY, so that the signing key never lives in the environment,
+// the id:secret entries of SIGNING_KEYS, and SIGNING_KEY, in that order.
+// The first signs, and the others keep verifying the tokens they signed, so
+// that a key is rotated by putting a new one first, and dropped once the
+// links it signed have expired.
+func loadSigningConfig() {
+	tokenKeys = nil
+	for _, spec := range strings.Split(getenv("SIGNING_KMS_KEY"), ",") {
+		if spec = strings.TrimSpace(spec); spec == "" {
+			continue
+		}
+		s, err := newKMSSigner(spec)
+		if err != nil {
+			log.Fatalf("FATAL: Invalid SIGNING_KMS_KEY: %v", err)
+		}
+		tokenKeys = append(tokenKeys, tokenKey{kmsKeyID(spec), s})
+	}
+	keys, err := parseSigningKeys(getenv("SIGNING_KEYS"))
+	if err != nil {
+		log.Fatalf("FATAL: Invalid SIGNING_KEYS: %v", err)
+	}
+	tokenKeys = append(tokenKeys, keys...)
+	if len(signingKey) > 0 {
+		tokenKeys = append(tokenKeys, tokenKey{"", hmacSigner{signingKey}})
+	}
+}
+
+// parseSigningKeys parses a ","-separated list of id:secret HMAC keys,
+// SIGNING_KEYS or SUCCESS_TOKEN_KEYS.
+func parseSigningKeys(spec string) ([]tokenKey, error) {
+	var keys []tokenKey
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(entry, ":")
+		switch {
+		case !ok || !validKeyID.MatchString(id):
+			return nil, fmt.Errorf("invalid entry for key %q: want id:secret, the ID made of letters, digits, _ and -", id)
+		case seen[id]:
+			return nil, fmt.Errorf("key %q is listed twice", id)
+		case len(secret) < minSigningKeyLen:
+			return nil, fmt.Errorf("key %q must be at least %d bytes long", id, minSigningKeyLen)
+		}
+		seen[id] = true
+		keys = append(keys, tokenKey{id, hmacSigner{[]byte(secret)}})
+	}
+	return keys, nil
+}
+
+// canSign reports whether tokens can be signed, which signed links,
+// presets, SSO, and the post-invite steps need.
+func canSign() bool {
+	return len(tokenKeys) > 0
+}
+
+// Types of signed tokens. The type is part of what is signed, so that a
+// token of one type never verifies as another: a step token handed out in
+// a form cannot be replayed as an invite link.
+const (
+	tokenLink         = "link"          // Invite links, the t of /login
+	tokenState        = "state"         // OAuth state parameters
+	tokenStep         = "step"          // Post-invite steps
+	tokenChecklist    = "checklist"     // checklist_token of the success page
+	tokenResend       = "resend"        // Resend buttons of the status page
+	tokenDecision     = "decision"      // One-click approval decisions
+	tokenAdminSession = "admin_session" // Admin session cookies
+	tokenPow          = "pow"           // Proof-of-work challenges
+)
+
+// signedInput returns what the signature of a token of type typ covers.
+func signedInput(typ, body string) []byte {
+	return []byte(typ + ":" + body)
+}
+
+// signToken returns payload encoded as "<payload>.<signature>", both parts
+// base64url encoded, followed by ".<key ID>" when the key has one. The
+// signature covers typ, which verifyToken must be given again. A KMS key
+// signs through its API, which can fail.
+func signToken(ctx context.Context, typ string, payload []byte) (string, error) {
+	if !canSign() {
+		return "", errors.New("SIGNING_KEY is not configured")
+	}
+	key := tokenKeys[0]
+	body := base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := key.signer.sign(ctx, signedInput(typ, body))
+	if err != nil {
+		return "", err
+	}
+	token := body + "." + base64.RawURLEncoding.EncodeToString(sig)
+	if key.id != "" {
+		token += "." + key.id
+	}
+	return token, nil
+}
+
+// verifyToken checks a token of type typ produced by signToken, with the key
+// it names, and returns its payload.
+func verifyToken(typ, token string) ([]byte, error) {
+	if !canSign() {
+		return nil, errors.New("SIGNING_KEY is not configured")
+	}
+	parts := strings.Split(token, ".")
+	id := ""
+	switch len(parts) {
+	case 2:
+	case 3:
+		id = parts[2]
+	default:
+		return nil, errBadSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errBadSignature
+	}
+	for _, key := range tokenKeys {
+		if key.id == id && key.signer.verify(signedInput(typ, parts[0]), sig) {
+			return base64.RawURLEncoding.DecodeString(parts[0])
+		}
+	}
+	return nil, errBadSignature
+}
+
+// randomID returns a random hex identifier of n bytes.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
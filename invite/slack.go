@@ -0,0 +1,89 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// slackConfig is the optional Slack step, run after a successful invitation.
+// With an admin token the user is invited through admin.users.invite
+// (Enterprise Grid); otherwise the workspace's shared invite link is emailed
+// to them.
+type slackConfig struct {
+	adminToken string
+	teamID     string
+	channelIDs string // Comma-separated channels the invitee is added to
+	inviteLink string
+}
+
+// slack is nil when the Slack step is disabled.
+var slack *slackConfig
+
+// loadSlackConfig reads the Slack settings.
+func loadSlackConfig() {
+	s := &slackConfig{
+		adminToken: getenv("SLACK_ADMIN_TOKEN"),
+		teamID:     getenv("SLACK_TEAM_ID"),
+		channelIDs: getenv("SLACK_CHANNEL_IDS"),
+		inviteLink: getenv("SLACK_INVITE_LINK"),
+	}
+	switch {
+	case s.adminToken != "":
+		if s.teamID == "" || s.channelIDs == "" {
+			log.Fatal("FATAL: SLACK_TEAM_ID and SLACK_CHANNEL_IDS must be set when SLACK_ADMIN_TOKEN is set.")
+		}
+	case s.inviteLink != "":
+		if mailer == nil {
+			log.Fatal("FATAL: SMTP_HOST must be set to email SLACK_INVITE_LINK.")
+		}
+	default:
+		return
+	}
+	slack = s
+}
+
+// invite sends a Slack invitation to the user's verified email address.
+func (s *slackConfig) invite(ctx context.Context, user *Identity) error {
+	if user.Email == "" || !user.EmailVerified {
+		return fmt.Errorf("no verified email address for %s", user.Username)
+	}
+	if s.adminToken == "" {
+		body := fmt.Sprintf("Hi %s,\n\nYou're now invited to %s on GitHub. Join our Slack community too:\n\n%s\n", user.Username, githubOrgName, s.inviteLink)
+		return mailer.send(user.Email, "Join us on Slack", body)
+	}
+
+	form := url.Values{
+		"team_id":     {s.teamID},
+		"email":       {user.Email},
+		"channel_ids": {s.channelIDs},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://slack.com/api/admin.users.invite", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.adminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("slack: decoding response: %v", err)
+	}
+	// Users who are already in the workspace are not an error.
+	if !result.OK && result.Error != "already_in_team" && result.Error != "already_invited" {
+		return fmt.Errorf("slack: admin.users.invite: %s", result.Error)
+	}
+	return nil
+}
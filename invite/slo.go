@@ -0,0 +1,278 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// sloKey prefixes the daily callback latency counters.
+const sloKey = "slo:"
+
+// sloBuckets are the upper bounds of the latency histogram, in
+// milliseconds. Slower callbacks count in the last bucket.
+var sloBuckets = []int{50, 100, 250, 500, 750, 1000, 1500, 2000, 3000, 5000, 10000, 30000}
+
+// Settings of the callback latency objective.
+var (
+	sloTarget    time.Duration // Callbacks slower than this miss the objective
+	sloObjective float64       // Share of callbacks that must meet the target
+	sloWindow    int           // Days the objective is measured over
+)
+
+// callbackLatency is the histogram of this instance since it started, for
+// /debug/vars. /admin/slo reports the histogram of every instance over the
+// window from the store.
+var callbackLatency struct {
+	sync.Mutex
+	counts []int64
+	bad    int64
+	errors int64
+}
+
+// loadSLOConfig reads the callback latency objective: SLO_LATENCY_TARGET,
+// SLO_OBJECTIVE as a percentage, and SLO_WINDOW_DAYS. It publishes the
+// latency of this instance as callback_latency.
+func loadSLOConfig() {
+	sloTarget = envDuration("SLO_LATENCY_TARGET", 2*time.Second)
+	sloWindow = envInt("SLO_WINDOW_DAYS", 7)
+	sloObjective = 0.99
+	if v := getenv("SLO_OBJECTIVE"); v != "" {
+		var err error
+		if sloObjective, err = parseSLOObjective(v); err != nil {
+			log.Fatalf("FATAL: SLO_OBJECTIVE %v.", err)
+		}
+	}
+	switch {
+	case sloTarget <= 0:
+		log.Fatal("FATAL: SLO_LATENCY_TARGET must be positive.")
+	case sloWindow < 1 || sloWindow > 90:
+		log.Fatal("FATAL: SLO_WINDOW_DAYS must be between 1 and 90.")
+	}
+	callbackLatency.counts = make([]int64, len(sloBuckets))
+	expvar.Publish("callback_latency", expvar.Func(callbackLatencyMetrics))
+}
+
+// parseSLOObjective parses SLO_OBJECTIVE, a percentage such as 99.5, into
+// a share.
+func parseSLOObjective(v string) (float64, error) {
+	percent, err := strconv.ParseFloat(v, 64)
+	if err != nil || percent <= 0 || percent >= 100 {
+		return 0, fmt.Errorf("must be a percentage between 0 and 100, not %q", v)
+	}
+	return percent / 100, nil
+}
+
+// statusWriter remembers the status of the response it writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
+
+// observeCallback serves the OAuth callback with serve and records how long
+// it took. A callback misses the objective when it is slower than the
+// target or fails with a server error; users turned away do not count
+// against it.
+func observeCallback(w http.ResponseWriter, r *http.Request, serve http.HandlerFunc) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w}
+	serve(sw, r)
+	elapsed := time.Since(start)
+	failed := sw.status >= 500
+	runAsync(r.Context(), func(ctx context.Context) { recordCallback(ctx, start, elapsed, failed) })
+}
+
+// sloBucket returns the histogram bucket of a callback that took d.
+func sloBucket(d time.Duration) int {
+	ms := int(d.Milliseconds())
+	for i, bound := range sloBuckets {
+		if ms <= bound {
+			return i
+		}
+	}
+	return len(sloBuckets) - 1
+}
+
+// recordCallback counts a callback in the histogram of this instance and in
+// the daily counters of the store.
+func recordCallback(ctx context.Context, at time.Time, elapsed time.Duration, failed bool) {
+	bucket := sloBucket(elapsed)
+	bad := failed || elapsed > sloTarget
+	callbackLatency.Lock()
+	callbackLatency.counts[bucket]++
+	if bad {
+		callbackLatency.bad++
+	}
+	if failed {
+		callbackLatency.errors++
+	}
+	callbackLatency.Unlock()
+
+	prefix := sloDayKey(at)
+	counters := []string{strconv.Itoa(sloBuckets[bucket])}
+	if bad {
+		counters = append(counters, "bad")
+	}
+	if failed {
+		counters = append(counters, "errors")
+	}
+	ttl := time.Duration(sloWindow+1) * 24 * time.Hour
+	for _, counter := range counters {
+		if _, err := dataStore.Incr(ctx, prefix+counter, ttl); err != nil {
+			log.Printf("Could not record the callback latency: %v", err)
+			return
+		}
+	}
+}
+
+// sloDayKey is the prefix of the counters of the UTC day of t.
+func sloDayKey(t time.Time) string {
+	return sloKey + t.UTC().Format(time.DateOnly) + ":"
+}
+
+// latencyReport summarizes a latency histogram against the objective.
+type latencyReport struct {
+	Requests int64 `json:"requests"`
+	Bad      int64 `json:"bad"`    // Slower than the target, or failed
+	Errors   int64 `json:"errors"` // Failed with a server error
+	P50      int   `json:"p50_ms"`
+	P95      int   `json:"p95_ms"`
+	P99      int   `json:"p99_ms"`
+
+	// BudgetRemaining is the share of the error budget, the callbacks
+	// allowed to miss the objective, that is left. It goes negative once the
+	// objective is missed.
+	BudgetRemaining float64 `json:"error_budget_remaining"`
+}
+
+// newLatencyReport summarizes the histogram counts. Percentiles are the
+// upper bound of the bucket they fall in.
+func newLatencyReport(counts []int64, bad, errs int64) latencyReport {
+	rep := latencyReport{Bad: bad, Errors: errs, BudgetRemaining: 1}
+	for _, n := range counts {
+		rep.Requests += n
+	}
+	if rep.Requests == 0 {
+		return rep
+	}
+	rep.P50, rep.P95, rep.P99 = percentile(counts, rep.Requests, 0.50), percentile(counts, rep.Requests, 0.95), percentile(counts, rep.Requests, 0.99)
+	rep.BudgetRemaining = 1 - float64(bad)/(float64(rep.Requests)*(1-sloObjective))
+	return rep
+}
+
+// percentile returns the upper bound of the bucket holding the p-th share of
+// the total callbacks.
+func percentile(counts []int64, total int64, p float64) int {
+	rank := int64(p*float64(total-1)) + 1
+	for i, n := range counts {
+		if rank -= n; rank <= 0 {
+			return sloBuckets[i]
+		}
+	}
+	return sloBuckets[len(sloBuckets)-1]
+}
+
+// callbackLatencyMetrics reports the latency of this instance for
+// /debug/vars.
+func callbackLatencyMetrics() any {
+	callbackLatency.Lock()
+	defer callbackLatency.Unlock()
+	buckets := make(map[string]int64, len(sloBuckets))
+	for i, bound := range sloBuckets {
+		buckets["le_"+strconv.Itoa(bound)+"ms"] = callbackLatency.counts[i]
+	}
+	return map[string]any{
+		"buckets": buckets,
+		"summary": newLatencyReport(callbackLatency.counts, callbackLatency.bad, callbackLatency.errors),
+	}
+}
+
+// dayLatency is the latency of the callbacks of one day.
+type dayLatency struct {
+	Day string `json:"day"`
+	latencyReport
+}
+
+// loadLatency reads the counters of the day prefix from the store.
+func loadLatency(ctx context.Context, prefix string) (counts []int64, bad, errs int64, err error) {
+	read := func(counter string) (int64, error) {
+		b, err := dataStore.Get(ctx, prefix+counter)
+		if errors.Is(err, store.ErrNotFound) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	counts = make([]int64, len(sloBuckets))
+	for i, bound := range sloBuckets {
+		if counts[i], err = read(strconv.Itoa(bound)); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+	if bad, err = read("bad"); err != nil {
+		return nil, 0, 0, err
+	}
+	if errs, err = read("errors"); err != nil {
+		return nil, 0, 0, err
+	}
+	return counts, bad, errs, nil
+}
+
+// handleSLO reports the callback latency of every instance over the last
+// SLO_WINDOW_DAYS against the objective: p50, p95, and p99, and how much of
+// the error budget is left, in total and by day.
+func handleSLO(w http.ResponseWriter, r *http.Request) {
+	total := make([]int64, len(sloBuckets))
+	var bad, errs int64
+	days := make([]dayLatency, 0, sloWindow)
+	now := time.Now()
+	for i := sloWindow - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i)
+		counts, b, e, err := loadLatency(r.Context(), sloDayKey(day))
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		for j, n := range counts {
+			total[j] += n
+		}
+		bad, errs = bad+b, errs+e
+		days = append(days, dayLatency{Day: day.UTC().Format(time.DateOnly), latencyReport: newLatencyReport(counts, b, e)})
+	}
+	summary := newLatencyReport(total, bad, errs)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"target_ms":   sloTarget.Milliseconds(),
+		"objective":   sloObjective * 100,
+		"window_days": sloWindow,
+		"met":         summary.BudgetRemaining >= 0,
+		"summary":     summary,
+		"days":        days,
+	})
+}
@@ -0,0 +1,214 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of the soft bans.
+const (
+	softBanKey      = "softban:"
+	softBanIndexKey = "softbans"
+)
+
+// Kinds of soft ban.
+const (
+	softBanUser   = "user"
+	softBanDomain = "domain"
+)
+
+// validGitHubLogin matches GitHub usernames: letters, digits, and single
+// hyphens between them.
+var validGitHubLogin = regexp.MustCompile(`^[A-Za-z0-9](?:-?[A-Za-z0-9]){0,38}$`)
+
+// gateSoftBan is the gate of the soft bans, in the invite log and the gate
+// metrics.
+const gateSoftBan = "soft_ban"
+
+// softBanHits counts the sign-ins turned away by a soft ban, by kind.
+// Requests the denylist blocks are counted in denylist_blocked instead.
+var softBanHits = expvar.NewMap("soft_ban_hits")
+
+// softBan keeps a GitHub user, or the users with an email address at a
+// domain, from being invited until it expires, for example after an abuse
+// incident. Unlike the denylist, which blocks addresses and user agents for
+// good, soft bans are set and lifted from the admin API, and lapse by
+// themselves.
+type softBan struct {
+	Kind      string    `json:"kind"`  // "user" or "domain"
+	Value     string    `json:"value"` // Lower-case username or email domain
+	Reason    string    `json:"reason,omitempty"`
+	By        string    `json:"by"` // Admin who set it
+	CreatedAt time.Time `json:"created_at"`
+	Expires   time.Time `json:"expires_at"`
+}
+
+// id identifies the ban in the store, as kind:value.
+func (b *softBan) id() string {
+	return b.Kind + ":" + b.Value
+}
+
+// loadSoftBan returns the ban of kind on value, or nil when there is none
+// or it has expired.
+func loadSoftBan(ctx context.Context, kind, value string) (*softBan, error) {
+	raw, err := dataStore.Get(ctx, softBanKey+kind+":"+strings.ToLower(value))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var b softBan
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(b.Expires) {
+		return nil, nil
+	}
+	return &b, nil
+}
+
+// checkSoftBan is the soft ban gate: it returns ErrSoftBanned while user, or
+// the domain of their verified email address, is banned.
+func checkSoftBan(ctx context.Context, user *Identity) error {
+	candidates := [][2]string{{softBanUser, user.Username}}
+	if _, domain, ok := strings.Cut(user.Email, "@"); ok && user.EmailVerified {
+		candidates = append(candidates, [2]string{softBanDomain, domain})
+	}
+	for _, c := range candidates {
+		b, err := loadSoftBan(ctx, c[0], c[1])
+		if err != nil {
+			return ErrUserInfo.Wrap(err)
+		}
+		if b != nil {
+			softBanHits.Add(b.Kind, 1)
+			log.Printf("Soft ban on %s %s turned %s away until %s: %s", b.Kind, b.Value, user.Username, b.Expires.Format(time.RFC3339), b.Reason)
+			return ErrSoftBanned.WithMessage(fmt.Sprintf("You can't be invited until %s. Contact the organization if this is a mistake.", b.Expires.Format("January 2, 2006 15:04 MST")))
+		}
+	}
+	return nil
+}
+
+// softBanRequest is the body of POST /admin/soft-bans: a username or an
+// email domain, and until when, as a duration or a time.
+type softBanRequest struct {
+	Username  string     `json:"username"`
+	Domain    string     `json:"domain"`
+	ExpiresIn string     `json:"expires_in"` // Go duration such as "72h"
+	ExpiresAt *time.Time `json:"expires_at"`
+	Reason    string     `json:"reason"`
+}
+
+// handleAddSoftBan adds a soft ban, replacing any ban on the same user or
+// domain.
+func handleAddSoftBan(w http.ResponseWriter, r *http.Request) {
+	var req softBanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, ErrBadRequest.WithMessage(fmt.Sprintf("Invalid JSON body: %v", err)))
+		return
+	}
+	b := &softBan{Reason: req.Reason, By: adminActor(r), CreatedAt: time.Now().UTC().Truncate(time.Second)}
+	switch {
+	case req.Username != "" && req.Domain == "" && validGitHubLogin.MatchString(req.Username):
+		b.Kind, b.Value = softBanUser, strings.ToLower(req.Username)
+	case req.Domain != "" && req.Username == "" && !strings.ContainsAny(req.Domain, "@/ :"):
+		b.Kind, b.Value = softBanDomain, strings.ToLower(strings.TrimPrefix(req.Domain, "."))
+	default:
+		writeJSONError(w, ErrBadRequest.WithMessage("Give either a GitHub username or an email domain."))
+		return
+	}
+	switch {
+	case req.ExpiresAt != nil && req.ExpiresIn == "":
+		b.Expires = req.ExpiresAt.UTC()
+	case req.ExpiresIn != "" && req.ExpiresAt == nil:
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			writeJSONError(w, ErrBadRequest.WithMessage("expires_in must be a duration such as 72h."))
+			return
+		}
+		b.Expires = b.CreatedAt.Add(d)
+	default:
+		writeJSONError(w, ErrBadRequest.WithMessage("Give either expires_in or expires_at."))
+		return
+	}
+	ttl := time.Until(b.Expires)
+	if ttl <= 0 {
+		writeJSONError(w, ErrBadRequest.WithMessage("The ban must expire in the future."))
+		return
+	}
+
+	ctx := r.Context()
+	raw, _ := json.Marshal(b)
+	if err := dataStore.Set(ctx, softBanKey+b.id(), raw, ttl); err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if _, err := dataStore.Append(ctx, softBanIndexKey, []byte(b.id())); err != nil {
+		log.Printf("Failed to index the soft ban on %s: %v", b.id(), err)
+	}
+	log.Printf("Soft-banned %s %s until %s", b.Kind, b.Value, b.Expires.Format(time.RFC3339))
+	recordAudit(ctx, r, b.By, auditSoftBanAdded, map[string]any{"kind": b.Kind, "value": b.Value, "reason": b.Reason, "expires_at": b.Expires})
+	writeJSON(w, http.StatusCreated, b)
+}
+
+// handleListSoftBans lists the active soft bans, soonest to expire first.
+func handleListSoftBans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	ids, err := dataStore.Range(ctx, softBanIndexKey, 0, -1)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	bans := []*softBan{}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[string(id)] {
+			continue
+		}
+		seen[string(id)] = true
+		kind, value, _ := strings.Cut(string(id), ":")
+		if b, err := loadSoftBan(ctx, kind, value); err == nil && b != nil {
+			bans = append(bans, b)
+		}
+	}
+	slices.SortFunc(bans, func(a, b *softBan) int { return a.Expires.Compare(b.Expires) })
+	writeJSON(w, http.StatusOK, map[string]any{"soft_bans": bans})
+}
+
+// handleLiftSoftBan lifts the soft ban of DELETE
+// /admin/soft-bans/{user|domain}/{value} before it expires.
+func handleLiftSoftBan(w http.ResponseWriter, r *http.Request) {
+	kind, value, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/soft-bans/"), "/")
+	if kind != softBanUser && kind != softBanDomain || value == "" {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	ctx := r.Context()
+	b, err := loadSoftBan(ctx, kind, value)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if b == nil {
+		writeJSONError(w, ErrNotFound.WithMessage("No such soft ban."))
+		return
+	}
+	if err := dataStore.Delete(ctx, softBanKey+b.id()); err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	log.Printf("Lifted the soft ban on %s %s", b.Kind, b.Value)
+	recordAudit(ctx, r, adminActor(r), auditSoftBanLifted, map[string]any{"kind": b.Kind, "value": b.Value, "expires_at": b.Expires})
+	w.WriteHeader(http.StatusNoContent)
+}
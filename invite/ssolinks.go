@@ -0,0 +1,151 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys used by SSO links. Each link is stored under the SSO subject,
+// with a reverse entry so a GitHub account can only be linked once.
+const (
+	ssoLinkKey    = "sso:link:"
+	ssoAccountKey = "sso:account:"
+	ssoLinkIndex  = "sso:links"
+)
+
+// ssoLink maps an SSO user to the GitHub account they verified. Returning
+// users with a saved link skip the GitHub authorization.
+type ssoLink struct {
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email,omitempty"`
+	Provider string    `json:"provider"`
+	UserID   string    `json:"user_id"`
+	Username string    `json:"username"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// loadSSOLink returns the saved link for an SSO subject, or
+// store.ErrNotFound.
+func loadSSOLink(ctx context.Context, subject string) (*ssoLink, error) {
+	b, err := dataStore.Get(ctx, ssoLinkKey+subject)
+	if err != nil {
+		return nil, err
+	}
+	var link ssoLink
+	if err := json.Unmarshal(b, &link); err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// saveSSOLink links an SSO user to the GitHub account they just verified.
+// It fails with ErrAccountTaken if that account belongs to another SSO user.
+func saveSSOLink(ctx context.Context, sso *ssoIdentity, user *Identity) error {
+	accountKey := ssoAccountKey + activeProvider.Name() + ":" + user.ID
+	ok, err := dataStore.SetNX(ctx, accountKey, []byte(sso.Subject), 0)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		owner, err := dataStore.Get(ctx, accountKey)
+		if err != nil {
+			return err
+		}
+		if string(owner) != sso.Subject {
+			return ErrAccountTaken
+		}
+	}
+
+	// Free the account of an earlier link, which this one replaces.
+	if old, err := loadSSOLink(ctx, sso.Subject); err == nil && (old.Provider != activeProvider.Name() || old.UserID != user.ID) {
+		dataStore.Delete(ctx, ssoAccountKey+old.Provider+":"+old.UserID)
+	}
+
+	b, _ := json.Marshal(ssoLink{
+		Subject:  sso.Subject,
+		Email:    sso.Email,
+		Provider: activeProvider.Name(),
+		UserID:   user.ID,
+		Username: user.Username,
+		LinkedAt: time.Now().UTC(),
+	})
+	if err := dataStore.Set(ctx, ssoLinkKey+sso.Subject, b, 0); err != nil {
+		return err
+	}
+	if ok {
+		if _, err := dataStore.Append(ctx, ssoLinkIndex, []byte(sso.Subject)); err != nil {
+			log.Printf("Failed to index the SSO link for %s: %v", sso.Subject, err)
+		}
+	}
+	return nil
+}
+
+// handleListSSOLinks lists all saved SSO links.
+func handleListSSOLinks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	subjects, err := dataStore.Range(ctx, ssoLinkIndex, 0, -1)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	links := []*ssoLink{}
+	seen := make(map[string]bool)
+	for _, s := range subjects {
+		subject := string(s)
+		if seen[subject] {
+			continue
+		}
+		seen[subject] = true
+		// Broken links stay in the index; skip them.
+		if link, err := loadSSOLink(ctx, subject); err == nil {
+			links = append(links, link)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"links": links})
+}
+
+// handleGetSSOLink shows the saved link for one SSO subject.
+func handleGetSSOLink(w http.ResponseWriter, r *http.Request) {
+	subject := strings.TrimPrefix(r.URL.Path, "/admin/sso-links/")
+	link, err := loadSSOLink(r.Context(), subject)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, ErrNotFound.WithMessage("No such SSO link."))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+// handleDeleteSSOLink breaks the link for one SSO subject. The user will be
+// asked to verify a GitHub account again on their next sign-in.
+func handleDeleteSSOLink(w http.ResponseWriter, r *http.Request) {
+	subject := strings.TrimPrefix(r.URL.Path, "/admin/sso-links/")
+	ctx := r.Context()
+	link, err := loadSSOLink(ctx, subject)
+	if errors.Is(err, store.ErrNotFound) {
+		writeJSONError(w, ErrNotFound.WithMessage("No such SSO link."))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if err := dataStore.Delete(ctx, ssoLinkKey+subject); err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	dataStore.Delete(ctx, ssoAccountKey+link.Provider+":"+link.UserID)
+	log.Printf("Broke the SSO link between %s and %s", subject, link.Username)
+	recordAudit(ctx, r, adminActor(r), auditSSOLinkBroken, map[string]any{"subject": subject, "username": link.Username})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// flowState is carried through the OAuth round trips in the state parameter.
+type flowState struct {
+	Link     string            `json:"link,omitempty"`     // Signed invite link token
+	Preset   string            `json:"preset,omitempty"`   // Preset picked with ?preset=
+	Campaign string            `json:"campaign,omitempty"` // Campaign of the /join/{name} page the sign-in started on
+	Orgs     []string          `json:"orgs,omitempty"`     // Orgs picked, when the deployment serves several
+	SSO      *ssoIdentity      `json:"sso,omitempty"`      // Set once the user signed in with OIDC
+	Bot      *botReport        `json:"bot,omitempty"`      // Bot signals of the sign-in form, with BOT_SIGNALS
+	Variants map[string]string `json:"variants,omitempty"` // Experiment variants of the visitor, by experiment
+	Source   *attribution      `json:"source,omitempty"`   // utm_* parameters and referrer of the sign-in
+	Trail    string            `json:"trail,omitempty"`    // ID of the flow trail, with FLOW_TRAIL_TTL
+
+	// PublicMembership is set when the user agreed to have their
+	// membership made public once they join, with PUBLIC_MEMBERSHIP.
+	PublicMembership bool `json:"public_membership,omitempty"`
+	// userToken is the token the user signed in with, for
+	// PublicMembership. It is never carried in the state.
+	userToken *oauth2.Token
+
+	Admin  bool `json:"admin,omitempty"`  // An admin signing in to /admin
+	Status bool `json:"status,omitempty"` // A user signing in to /status
+}
+
+// encodeState builds the OAuth state parameter. When a SIGNING_KEY is
+// configured, the flow state is signed and appended to the CSRF prefix;
+// without one there is nothing to carry, since links, presets, and SSO all
+// need the key.
+func encodeState(ctx context.Context, flow flowState) (string, error) {
+	if !canSign() {
+		return oauthStateString, nil
+	}
+	payload, _ := json.Marshal(flow)
+	token, err := signToken(ctx, tokenState, payload)
+	if err != nil {
+		return "", err
+	}
+	return oauthStateString + "." + token, nil
+}
+
+// decodeState checks the OAuth state parameter and returns the flow state it
+// carries.
+func decodeState(state string) (flowState, bool) {
+	var flow flowState
+	prefix, token, signed := strings.Cut(state, ".")
+	if prefix != oauthStateString {
+		return flow, false
+	}
+	if !signed {
+		return flow, !canSign()
+	}
+	payload, err := verifyToken(tokenState, token)
+	if err != nil || json.Unmarshal(payload, &flow) != nil {
+		return flow, false
+	}
+	return flow, true
+}
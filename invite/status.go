@@ -0,0 +1,308 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"auto-invite/store"
+	"golang.org/x/oauth2"
+)
+
+// statusPage enables /status, from STATUS_PAGE.
+var statusPage bool
+
+// loadStatusPageConfig reads STATUS_PAGE. Signing in to the page needs a
+// signing key too, to mark the OAuth state as a status check.
+func loadStatusPageConfig() {
+	statusPage = envBool("STATUS_PAGE")
+	if statusPage && activeProvider.Name() != "github" {
+		log.Fatal("FATAL: STATUS_PAGE requires the github provider.")
+	}
+}
+
+// Where a user stands with an org, on the status page.
+const (
+	statusMember        = "member"         // The user has joined
+	statusInvited       = "invited"        // The invitation waits for the user to accept it
+	statusWaitlisted    = "waitlisted"     // The user is on the waitlist
+	statusPendingReview = "pending_review" // The request waits for an admin's approval
+	statusRejected      = "rejected"       // The gates or an admin turned the request down
+	statusFailed        = "failed"         // The last request failed for another reason
+	statusNotRequested  = "not_requested"  // The user has not asked to join
+	statusUnknown       = "unknown"        // Only the user can see, once signed in
+)
+
+// rejectionErrors are the errors of the gates, which the status page reports
+// as rejections. Their messages are meant for the user, unlike the gate
+// details in the invite log.
+var rejectionErrors = []*Error{
+	ErrBotAccount, ErrAccountSuspended, ErrRequirementsNotMet, ErrSuspectedSpam,
+	ErrSoftBanned, ErrRejected, ErrAccountTaken,
+}
+
+// userStatus is where a user stands with an org.
+type userStatus struct {
+	Org              string `json:"org"`
+	State            string `json:"state"`
+	Message          string `json:"message"`
+	AcceptURL        string `json:"accept_url,omitempty"`        // Where to accept a pending invitation
+	WaitlistPosition int    `json:"waitlist_position,omitempty"` // From 1
+	ResendToken      string `json:"resend_token,omitempty"`      // Posted to /status/resend to resend the invitation
+}
+
+var statusTemplate = lazyTemplate("status", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Check your invitation</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem}.error{color:#b00}input[type=text]{width:100%;padding:.5rem;font-size:1rem}li{margin:.5rem 0}</style>
+</head>
+<body>
+<h1>{{if .Username}}Status of {{.Username}}{{else}}Check your invitation{{end}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{if .Notice}}<p>{{.Notice}}</p>{{end}}
+{{if .Statuses}}<ul>
+{{range .Statuses}}<li><strong>{{.Org}}</strong>: {{.Message}}{{if .AcceptURL}} <a href="{{.AcceptURL}}">Accept the invitation</a>{{end}}
+{{- if .ResendToken}}
+<form method="post" action="/status/resend"><input type="hidden" name="token" value="{{.ResendToken}}"><button type="submit">Lost the email? Resend the invitation</button></form>
+{{- end}}</li>
+{{end}}</ul>{{end}}
+{{if not .SignedIn}}
+{{if .CanSignIn}}<p><a href="/status/login">Sign in with GitHub</a> to see your invitation and why a request was turned down.</p>{{end}}
+<form method="get" action="/status">
+<p><label>GitHub username<br><input type="text" name="username" value="{{.Username}}" autocomplete="username" required></label></p>
+<p><button type="submit">Check</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`)
+
+// statusPageData is what the status page shows.
+type statusPageData struct {
+	Username  string
+	Statuses  []userStatus
+	SignedIn  bool
+	CanSignIn bool
+	Error     string
+	Notice    string
+}
+
+// handleStatusPage serves /status, where users check where they stand:
+// signed in with GitHub, whether they are a member, have a pending
+// invitation, are waitlisted or waiting for a review, or were turned down
+// and why. Anyone can look up a username, so lookups only show what is
+// public already: public membership and the waitlist.
+func handleStatusPage(w http.ResponseWriter, r *http.Request) {
+	if !statusPage {
+		handleUnknownPath(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	if r.URL.Path == "/status/login" {
+		if !canSign() {
+			writeJSONError(w, ErrNotFound)
+			return
+		}
+		state, err := encodeState(r.Context(), flowState{Status: true})
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		http.Redirect(w, r, oauthConf.AuthCodeURL(state, oauth2.AccessTypeOnline), redirectStatus(r, redirectLogin))
+		return
+	}
+
+	data := statusPageData{Username: strings.TrimSpace(r.FormValue("username")), CanSignIn: canSign()}
+	if data.Username != "" {
+		if !validGitHubLogin.MatchString(data.Username) {
+			data.Error = "That is not a GitHub username."
+		} else {
+			data.Statuses, data.Error = lookUpStatuses(r.Context(), data.Username, false)
+		}
+	}
+	renderStatus(w, r, data)
+}
+
+// handleStatusCallback finishes signing in to the status page and shows the
+// full status of the user.
+func handleStatusCallback(w http.ResponseWriter, r *http.Request) {
+	if !statusPage {
+		writeJSONError(w, ErrNotFound)
+		return
+	}
+	ctx := r.Context()
+	token, err := oauthConf.Exchange(withAPILogging(ctx), r.FormValue("code"))
+	if err != nil {
+		writeJSONError(w, ErrOAuthExchange.Wrap(redactError(err, r.FormValue("code"))))
+		return
+	}
+	user, _, err := newGitHubClient(oauthConf.Client(withAPILogging(ctx), token)).Users.Get(ctx, "")
+	if err != nil {
+		writeJSONError(w, ErrUserInfo.Wrap(err))
+		return
+	}
+	data := statusPageData{Username: user.GetLogin(), SignedIn: true}
+	data.Statuses, data.Error = lookUpStatuses(ctx, data.Username, true)
+	renderStatus(w, r, data)
+}
+
+// renderStatus renders the status page, or its statuses as JSON.
+func renderStatus(w http.ResponseWriter, r *http.Request, data statusPageData) {
+	w.Header().Set("Cache-Control", "no-store")
+	if wantsJSON(r) {
+		if data.Error != "" {
+			writeJSONError(w, ErrBadRequest.WithMessage(data.Error))
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"username": data.Username, "statuses": data.Statuses})
+		return
+	}
+	renderPage(w, http.StatusOK, statusTemplate(), data)
+}
+
+// lookUpStatuses returns where username stands with each org, or a message
+// for the user when that cannot be checked. Only a signed-in user gets more
+// than what is public.
+func lookUpStatuses(ctx context.Context, username string, signedIn bool) ([]userStatus, string) {
+	var statuses []userStatus
+	for _, org := range githubOrgs {
+		status, err := statusOf(ctx, org, username, signedIn)
+		if err != nil {
+			log.Printf("Checking the status of %s in %s failed: %v", username, org, err)
+			return nil, "We could not check right now. Please try again later."
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, ""
+}
+
+// statusOf returns where username stands with org. The membership comes
+// first, then the waitlist, the approval queue, and the last failure in the
+// invite log.
+func statusOf(ctx context.Context, org, username string, signedIn bool) (userStatus, error) {
+	status := userStatus{Org: org}
+	client := adminClient()
+	if !signedIn {
+		public, _, err := client.Organizations.IsPublicMember(ctx, org, username)
+		if err != nil {
+			return status, err
+		}
+		if public {
+			status.State, status.Message = statusMember, fmt.Sprintf("%s is a member of %s.", username, org)
+			return status, nil
+		}
+	} else {
+		membership, resp, err := client.Organizations.GetOrgMembership(ctx, username, org)
+		switch {
+		case err == nil && membership.GetState() == "pending":
+			status.State, status.Message, status.AcceptURL = statusInvited, "You have a pending invitation. Accept it on GitHub to join.", invitationURL(org)
+			if canResend() {
+				if status.ResendToken, err = newResendToken(ctx, org, username); err != nil {
+					log.Printf("Signing the resend token of %s failed: %v", username, err)
+				}
+			}
+			return status, nil
+		case err == nil:
+			status.State, status.Message = statusMember, fmt.Sprintf("You are a member of %s.", org)
+			return status, nil
+		case resp == nil || resp.StatusCode != http.StatusNotFound:
+			return status, err
+		}
+	}
+
+	waitlist, err := currentWaitlistStatus(ctx, username)
+	if err != nil {
+		return status, err
+	}
+	if waitlist.Position > 0 {
+		status.State, status.WaitlistPosition = statusWaitlisted, waitlist.Position
+		status.Message = fmt.Sprintf("Number %d of %d on the waitlist. You'll be invited when a seat opens.", waitlist.Position, waitlist.Length)
+		return status, nil
+	}
+	if !signedIn {
+		status.State, status.Message = statusUnknown, "Sign in with GitHub to see whether you have an invitation."
+		return status, nil
+	}
+
+	a, err := latestApproval(ctx, org, username)
+	if err != nil {
+		return status, err
+	}
+	switch {
+	case a != nil && a.Status == "pending":
+		status.State, status.Message = statusPendingReview, ErrPendingReview.Message
+		return status, nil
+	case a != nil && a.Status == "denied":
+		status.State, status.Message = statusRejected, "The organization did not approve your request. Contact the organization if this is a mistake."
+		return status, nil
+	}
+
+	code, err := lastFailure(ctx, org, username)
+	if err != nil {
+		return status, err
+	}
+	switch i := slices.IndexFunc(rejectionErrors, func(e *Error) bool { return e.Code == code }); {
+	case code == "":
+		status.State, status.Message = statusNotRequested, fmt.Sprintf("You have not asked to join %s yet, or your invitation has expired.", org)
+	case i >= 0:
+		status.State, status.Message = statusRejected, rejectionErrors[i].Message
+	case code == ErrPendingReview.Code:
+		status.State, status.Message = statusPendingReview, ErrPendingReview.Message
+	default:
+		status.State, status.Message = statusFailed, "Your last request did not go through. Please try again."
+	}
+	return status, nil
+}
+
+// latestApproval returns the newest approval request of username for org,
+// or nil.
+func latestApproval(ctx context.Context, org, username string) (*approval, error) {
+	ids, err := dataStore.Range(ctx, approvalIndexKey, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		a, err := loadApproval(ctx, string(ids[i]))
+		if err == nil && strings.EqualFold(a.Username, username) && orgOrDefault(a.Options.Org) == org {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
+
+// lastFailure returns the error code of the newest invite log entry of
+// username for org when it is a failure, or "" when it is not or there is
+// none.
+func lastFailure(ctx context.Context, org, username string) (string, error) {
+	events, err := dataStore.Range(ctx, activityKey, 0, -1)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		var ev activityEvent
+		if json.Unmarshal(events[i], &ev) != nil || !strings.EqualFold(ev.Username, username) || orgOrDefault(ev.Org) != org {
+			continue
+		}
+		switch ev.Type {
+		case activityInviteFailed:
+			return ev.Code, nil
+		case activityInviteSent:
+			return "", nil
+		}
+	}
+	return "", nil
+}
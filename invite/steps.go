@@ -0,0 +1,110 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// stepTTL bounds how long a user has to finish the post-invite steps.
+const stepTTL = 15 * time.Minute
+
+// Interactive steps that can follow a successful invitation, in the order
+// they run.
+const (
+	stepNPM     = "npm"
+	stepDiscord = "discord"
+)
+
+var stepOrder = []string{stepNPM, stepDiscord}
+
+// stepState identifies an invited user across the interactive post-invite
+// steps. It travels signed, in OAuth state parameters and hidden form fields.
+type stepState struct {
+	UserID   string   `json:"uid"`
+	Username string   `json:"sub"`
+	Campaign string   `json:"campaign,omitempty"`
+	Teams    []string `json:"teams,omitempty"`
+	Org      string   `json:"org,omitempty"`
+	// Outcomes is the result of each invitation, when the user joined
+	// several orgs at once: "invited" or an error code, by org.
+	Outcomes map[string]string `json:"orgs,omitempty"`
+	Expires  int64             `json:"exp"`
+}
+
+// newStepState returns the step state for user, invited with opts.
+func newStepState(user *Identity, opts inviteOptions) *stepState {
+	return &stepState{
+		UserID:   user.ID,
+		Username: user.Username,
+		Campaign: opts.Campaign,
+		Teams:    opts.Teams,
+		Org:      opts.Org,
+		Expires:  time.Now().Add(stepTTL).Unix(),
+	}
+}
+
+// newStepToken returns a signed step token for st.
+func newStepToken(ctx context.Context, st *stepState) (string, error) {
+	payload, _ := json.Marshal(st)
+	return signToken(ctx, tokenStep, payload)
+}
+
+// parseStepToken verifies a step token.
+func parseStepToken(token string) (*stepState, error) {
+	payload, err := verifyToken(tokenStep, token)
+	if err != nil {
+		return nil, ErrInvalidState.Wrap(err)
+	}
+	var st stepState
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return nil, ErrInvalidState.Wrap(err)
+	}
+	if time.Now().Unix() >= st.Expires {
+		return nil, ErrInvalidState.WithMessage("This page has expired. Your invitation was sent; check your email.")
+	}
+	return &st, nil
+}
+
+// stepEnabled reports whether the given step is configured and its feature
+// flag is on for the user.
+func stepEnabled(step, userID string) bool {
+	rs := currentRules()
+	switch step {
+	case stepNPM:
+		return npm != nil && rs.flagEnabled(flagNPMStep, userID)
+	case stepDiscord:
+		return discord != nil && rs.flagEnabled(flagDiscordStep, userID)
+	}
+	return false
+}
+
+// continueAfterInvite sends the user to the first enabled step after the
+// step named after (or the first step, if after is empty), or to the success
+// page once there are none left. params are added to the success redirect.
+func continueAfterInvite(w http.ResponseWriter, r *http.Request, token, after string, params map[string][]string) {
+	var userID string
+	st, err := parseStepToken(token)
+	if err == nil {
+		userID = st.UserID
+	}
+	started := after == ""
+	for _, step := range stepOrder {
+		if !started {
+			started = step == after
+			continue
+		}
+		if !stepEnabled(step, userID) {
+			continue
+		}
+		switch step {
+		case stepNPM:
+			renderNPMForm(w, token, "")
+		case stepDiscord:
+			startDiscord(w, r, token)
+		}
+		return
+	}
+	redirectToSuccess(w, r, st, params)
+}
@@ -0,0 +1,85 @@
+package invite
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// storeKeyUnwrapTimeout bounds each call of Config.UnwrapStoreKey.
+const storeKeyUnwrapTimeout = 10 * time.Second
+
+// storeEncrypted is set when STORE_ENCRYPTION_KEYS seals what the flow
+// persists, for the features that keep credentials.
+var storeEncrypted bool
+
+// loadStoreEncryptionConfig reads STORE_ENCRYPTION_KEYS and, when it is set,
+// encrypts what the flow persists with the first of its keys. The others
+// only decrypt, so that a key can be rotated by putting a new one first and
+// dropping the old one once the values it sealed have expired. With
+// Config.UnwrapStoreKey, the keys are data keys wrapped by a KMS, so that the
+// plaintext keys never live in the environment.
+func loadStoreEncryptionConfig(cfg *Config) {
+	storeEncrypted = false
+	spec := getenv("STORE_ENCRYPTION_KEYS")
+	if spec == "" {
+		return
+	}
+	keys, current, err := parseStoreKeys(spec)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid STORE_ENCRYPTION_KEYS: %v", err)
+	}
+	if cfg.UnwrapStoreKey != nil {
+		for id, wrapped := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), storeKeyUnwrapTimeout)
+			keys[id], err = cfg.UnwrapStoreKey(ctx, wrapped)
+			cancel()
+			if err != nil {
+				log.Fatalf("FATAL: Could not unwrap the store key %s: %v", id, err)
+			}
+		}
+	}
+	s, err := store.NewEncrypted(dataStore, keys, current)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid STORE_ENCRYPTION_KEYS: %v", err)
+	}
+	dataStore, storeEncrypted = s, true
+}
+
+// parseStoreKeys parses STORE_ENCRYPTION_KEYS, a ","-separated list of
+// id:key entries with base64 keys, and returns the keys by ID and the ID of
+// the first.
+func parseStoreKeys(spec string) (map[string][]byte, string, error) {
+	keys := make(map[string][]byte)
+	current := ""
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" || encoded == "" {
+			return nil, "", fmt.Errorf("invalid entry %q: want id:base64-key", id)
+		}
+		if _, dup := keys[id]; dup {
+			return nil, "", fmt.Errorf("key %q is listed twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", fmt.Errorf("key %q is not valid base64", id)
+		}
+		keys[id] = key
+		if current == "" {
+			current = id
+		}
+	}
+	if current == "" {
+		return nil, "", fmt.Errorf("no keys")
+	}
+	return keys, current, nil
+}
@@ -0,0 +1,222 @@
+package invite
+
+import (
+	"context"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ways of describing the invited user to the success page, for
+// SUCCESS_PARAMS.
+const (
+	successParamsQuery = "query" // username, org, teams, and campaign parameters
+	successParamsJWT   = "jwt"   // the same claims in a signed token parameter
+)
+
+// successTokenTTL is how long the success page may accept a token.
+const successTokenTTL = 5 * time.Minute
+
+var (
+	successParams   string       // How the success redirect describes the user, if at all
+	successTokenKey tokenKey     // HS256 key for SUCCESS_PARAMS=jwt, shared with the success page
+	successTokenKMS []*kmsSigner // ES256 keys for SUCCESS_PARAMS=jwt, from SUCCESS_TOKEN_KMS_KEY; the first signs
+)
+
+// loadSuccessParams reads SUCCESS_PARAMS. The jwt variant is signed with its
+// own key, as the success page has to know it and must not be able to sign
+// invite links: the first id:key entry of SUCCESS_TOKEN_KEYS, named by the
+// kid header so that the success page can accept the old and new keys while
+// they are rotated, or else SUCCESS_TOKEN_KEY. With SUCCESS_TOKEN_KMS_KEY it
+// is signed by the first of its KMS keys instead, and the success page
+// verifies it with the public keys of /.well-known/jwks.json, which lists
+// them all.
+func loadSuccessParams() {
+	successParams = getenv("SUCCESS_PARAMS")
+	successTokenKey, successTokenKMS = tokenKey{}, nil
+	switch successParams {
+	case "", successParamsQuery:
+	case successParamsJWT:
+		for _, spec := range strings.Split(getenv("SUCCESS_TOKEN_KMS_KEY"), ",") {
+			if spec = strings.TrimSpace(spec); spec == "" {
+				continue
+			}
+			s, err := newKMSSigner(spec)
+			if err != nil {
+				log.Fatalf("FATAL: Invalid SUCCESS_TOKEN_KMS_KEY: %v", err)
+			}
+			successTokenKMS = append(successTokenKMS, s)
+		}
+		if len(successTokenKMS) > 0 {
+			return
+		}
+		keys, err := parseSigningKeys(getenv("SUCCESS_TOKEN_KEYS"))
+		if err != nil {
+			log.Fatalf("FATAL: Invalid SUCCESS_TOKEN_KEYS: %v", err)
+		}
+		if len(keys) > 0 {
+			successTokenKey = keys[0]
+			return
+		}
+		key := []byte(getenv("SUCCESS_TOKEN_KEY"))
+		if len(key) < minSigningKeyLen {
+			log.Fatalf("FATAL: SUCCESS_TOKEN_KEY must be at least %d bytes long when SUCCESS_PARAMS is jwt.", minSigningKeyLen)
+		}
+		successTokenKey = tokenKey{"", hmacSigner{key}}
+	default:
+		log.Fatalf("FATAL: Unknown SUCCESS_PARAMS %q; expected query or jwt.", successParams)
+	}
+}
+
+// successClaims describes the invited user to the success page.
+type successClaims struct {
+	Subject  string   `json:"sub"`
+	Org      string   `json:"org"`
+	Teams    []string `json:"teams,omitempty"`
+	Campaign string   `json:"campaign,omitempty"`
+	IssuedAt int64    `json:"iat"`
+	Expires  int64    `json:"exp"`
+}
+
+// successParamsFor returns the parameters describing st that SUCCESS_PARAMS
+// asks for, the accept_url where the user accepts the invitation on GitHub,
+// the outcome of each invitation when st covers several orgs, and with
+// ONBOARDING_STATUS the status_id for /ws/status/{id}, and with
+// ONBOARDING_CHECKLIST the checklist_token for /fragments/checklist. st is nil when the
+// user is no longer known, e.g. after an expired step.
+func successParamsFor(ctx context.Context, st *stepState) url.Values {
+	if st == nil {
+		return nil
+	}
+	params := url.Values{}
+	params.Set("accept_url", invitationURL(orgOrDefault(st.Org)))
+	if len(st.Outcomes) > 0 {
+		params.Set("orgs", formatOutcomes(st.Outcomes))
+	}
+	if onboardingStatus {
+		params.Set("status_id", onboardingID(st.Username, st.Org))
+	}
+	if onboardingChecklist {
+		if token, err := newChecklistToken(ctx, st); err != nil {
+			log.Printf("ERROR: Signing the checklist token for %s failed: %v", st.Username, err)
+		} else {
+			params.Set("checklist_token", token)
+		}
+	}
+	switch successParams {
+	case "":
+		return params
+	case successParamsJWT:
+		now := time.Now()
+		token, err := signJWT(ctx, successClaims{
+			Subject:  st.Username,
+			Org:      orgOrDefault(st.Org),
+			Teams:    st.Teams,
+			Campaign: st.Campaign,
+			IssuedAt: now.Unix(),
+			Expires:  now.Add(successTokenTTL).Unix(),
+		})
+		if err != nil {
+			log.Printf("ERROR: Signing the success token for %s failed: %v", st.Username, err)
+			return params
+		}
+		params.Set("token", token)
+		return params
+	}
+	params.Set("username", st.Username)
+	params.Set("org", orgOrDefault(st.Org))
+	if len(st.Teams) > 0 {
+		params.Set("teams", strings.Join(st.Teams, ","))
+	}
+	if st.Campaign != "" {
+		params.Set("campaign", st.Campaign)
+	}
+	return params
+}
+
+// formatOutcomes renders per-org outcomes as "org:outcome" pairs, sorted by
+// org, for example "acme:invited,acme-labs:requirements_not_met".
+func formatOutcomes(outcomes map[string]string) string {
+	pairs := make([]string, 0, len(outcomes))
+	for _, org := range slices.Sorted(maps.Keys(outcomes)) {
+		pairs = append(pairs, org+":"+outcomes[org])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// signJWT returns claims as an HS256 JSON Web Token signed with the success
+// token key, or an ES256 one signed with the first SUCCESS_TOKEN_KMS_KEY.
+func signJWT(ctx context.Context, claims successClaims) (string, error) {
+	payload, _ := json.Marshal(claims)
+	if len(successTokenKMS) > 0 {
+		jwk, err := successTokenKMS[0].jwk()
+		if err != nil {
+			return "", err
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": jwk["kid"]})
+		body := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		der, err := successTokenKMS[0].sign(ctx, []byte(body))
+		if err != nil {
+			return "", err
+		}
+		sig, err := jwsSignature(der)
+		if err != nil {
+			return "", err
+		}
+		return body + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+	}
+	header := map[string]string{"alg": "HS256", "typ": "JWT"}
+	if successTokenKey.id != "" {
+		header["kid"] = successTokenKey.id
+	}
+	encoded, _ := json.Marshal(header)
+	body := base64.RawURLEncoding.EncodeToString(encoded) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := successTokenKey.signer.sign(ctx, []byte(body))
+	if err != nil {
+		return "", err
+	}
+	return body + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// jwsSignature converts an ASN.1 DER ECDSA P-256 signature into the 64-byte
+// r || s form of ES256.
+func jwsSignature(der []byte) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid signature from the KMS: %w", err)
+	}
+	out := make([]byte, 64)
+	sig.R.FillBytes(out[:32])
+	sig.S.FillBytes(out[32:])
+	return out, nil
+}
+
+// handleJWKS serves the public keys of SUCCESS_TOKEN_KMS_KEY as a JSON Web
+// Key Set, for the success page to verify tokens with. The keys after the
+// first keep verifying the tokens they signed before a rotation.
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	if len(successTokenKMS) == 0 {
+		handleUnknownPath(w, r)
+		return
+	}
+	keys := make([]map[string]string, 0, len(successTokenKMS))
+	for _, s := range successTokenKMS {
+		jwk, err := s.jwk()
+		if err != nil {
+			writeJSONError(w, ErrConfig.Wrap(err))
+			return
+		}
+		keys = append(keys, jwk)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
@@ -0,0 +1,465 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2/clientcredentials"
+	"golang.org/x/oauth2/jwt"
+)
+
+// teamSyncRanKey is set while the teams were synced recently, so that
+// maintenance runs more frequent than TEAM_SYNC_INTERVAL skip the sync.
+const teamSyncRanKey = "teamsync:ran"
+
+// APIs of the group directories, variables for tests.
+var (
+	googleDirectoryAPI = "https://admin.googleapis.com/admin/directory/v1"
+	googleTokenURL     = "https://oauth2.googleapis.com/token"
+	microsoftGraphAPI  = "https://graph.microsoft.com/v1.0"
+	microsoftLoginURL  = "https://login.microsoftonline.com"
+)
+
+// groupSource lists the members of the groups of an external directory.
+type groupSource interface {
+	// GroupMembers returns the lower-case email addresses of the members of
+	// group, including those of its nested groups.
+	GroupMembers(ctx context.Context, group string) ([]string, error)
+}
+
+// teamMapping is one entry of TEAM_SYNC: the members of an external group
+// are the members of a GitHub team.
+type teamMapping struct {
+	Spec   string `json:"mapping"`
+	source string // google or azure
+	group  string
+	org    string
+	team   string // Slug
+}
+
+// teamSyncConfig is the optional sync of GitHub teams with external groups.
+type teamSyncConfig struct {
+	mappings []teamMapping
+	sources  map[string]groupSource
+	interval time.Duration // How often maintenance runs sync the teams
+	remove   bool          // Remove members who left the group from the team
+}
+
+// teamSync is nil unless TEAM_SYNC is set.
+var teamSync *teamSyncConfig
+
+// loadTeamSyncConfig reads TEAM_SYNC, comma-separated source:group=team
+// mappings such as "google:eng@example.com=engineering" or
+// "azure:<group ID>=acme-labs/core", with the team in the primary org unless
+// it names one; TEAM_SYNC_INTERVAL, an hour by default; and
+// TEAM_SYNC_REMOVE. The google source needs GOOGLE_WORKSPACE_CREDENTIALS, the
+// JSON key of a service account with domain-wide delegation, and
+// GOOGLE_WORKSPACE_ADMIN, the admin it acts as; the azure source needs
+// AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET of an app
+// allowed GroupMember.Read.All.
+func loadTeamSyncConfig() {
+	teamSync = nil
+	spec := getenv("TEAM_SYNC")
+	if spec == "" {
+		return
+	}
+	if activeProvider.Name() != "github" {
+		log.Fatal("FATAL: TEAM_SYNC requires the github provider.")
+	}
+	if oidc == nil {
+		log.Fatal("FATAL: OIDC_ISSUER must be set when TEAM_SYNC is set, to match group members to their GitHub accounts.")
+	}
+	mappings, err := parseTeamMappings(spec)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid TEAM_SYNC: %v", err)
+	}
+	ts := &teamSyncConfig{
+		mappings: mappings,
+		sources:  make(map[string]groupSource),
+		interval: envDuration("TEAM_SYNC_INTERVAL", time.Hour),
+		remove:   envBool("TEAM_SYNC_REMOVE"),
+	}
+	if ts.interval <= 0 {
+		log.Fatal("FATAL: TEAM_SYNC_INTERVAL must be positive.")
+	}
+	for _, m := range mappings {
+		if ts.sources[m.source] != nil {
+			continue
+		}
+		switch m.source {
+		case "google":
+			ts.sources[m.source], err = newGoogleGroups()
+		case "azure":
+			ts.sources[m.source], err = newAzureGroups()
+		}
+		if err != nil {
+			log.Fatalf("FATAL: TEAM_SYNC: %v", err)
+		}
+	}
+	teamSync = ts
+}
+
+// parseTeamMappings parses TEAM_SYNC.
+func parseTeamMappings(spec string) ([]teamMapping, error) {
+	var mappings []teamMapping
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		source, rest, _ := strings.Cut(entry, ":")
+		i := strings.LastIndex(rest, "=")
+		if i <= 0 || i == len(rest)-1 {
+			return nil, fmt.Errorf("%q is not source:group=team", entry)
+		}
+		m := teamMapping{Spec: entry, source: source, group: rest[:i], org: githubOrgName, team: rest[i+1:]}
+		if org, team, ok := strings.Cut(m.team, "/"); ok {
+			if m.org = knownOrg(org); m.org == "" {
+				return nil, fmt.Errorf("%q names an org not in GITHUB_ORGS", entry)
+			}
+			m.team = team
+		}
+		if source != "google" && source != "azure" {
+			return nil, fmt.Errorf("%q has an unknown source; use google or azure", entry)
+		}
+		mappings = append(mappings, m)
+	}
+	if len(mappings) == 0 {
+		return nil, fmt.Errorf("no mappings")
+	}
+	return mappings, nil
+}
+
+// googleGroups lists Google Workspace groups with the Admin SDK Directory
+// API.
+type googleGroups struct {
+	client *http.Client
+}
+
+// newGoogleGroups reads the service account key of GOOGLE_WORKSPACE_CREDENTIALS.
+func newGoogleGroups() (*googleGroups, error) {
+	var key struct {
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		PrivateKeyID string `json:"private_key_id"`
+	}
+	if err := json.Unmarshal([]byte(getenv("GOOGLE_WORKSPACE_CREDENTIALS")), &key); err != nil || key.ClientEmail == "" || key.PrivateKey == "" {
+		return nil, fmt.Errorf("GOOGLE_WORKSPACE_CREDENTIALS must be the JSON key of a service account")
+	}
+	admin := getenv("GOOGLE_WORKSPACE_ADMIN")
+	if admin == "" {
+		return nil, fmt.Errorf("GOOGLE_WORKSPACE_ADMIN must be set for the google source")
+	}
+	conf := &jwt.Config{
+		Email:        key.ClientEmail,
+		PrivateKey:   []byte(key.PrivateKey),
+		PrivateKeyID: key.PrivateKeyID,
+		Subject:      admin,
+		Scopes:       []string{"https://www.googleapis.com/auth/admin.directory.group.member.readonly"},
+		TokenURL:     googleTokenURL,
+	}
+	return &googleGroups{client: conf.Client(context.Background())}, nil
+}
+
+func (g *googleGroups) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	var emails []string
+	pageToken := ""
+	for {
+		q := url.Values{"includeDerivedMembership": {"true"}, "maxResults": {"200"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Members []struct {
+				Email  string `json:"email"`
+				Type   string `json:"type"`
+				Status string `json:"status"`
+			} `json:"members"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := directoryGet(ctx, g.client, googleDirectoryAPI+"/groups/"+url.PathEscape(group)+"/members?"+q.Encode(), &page); err != nil {
+			return nil, err
+		}
+		for _, m := range page.Members {
+			if m.Type == "USER" && (m.Status == "" || m.Status == "ACTIVE") {
+				emails = append(emails, strings.ToLower(m.Email))
+			}
+		}
+		if page.NextPageToken == "" {
+			return emails, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// azureGroups lists Azure AD (Microsoft Entra ID) groups with Microsoft
+// Graph.
+type azureGroups struct {
+	client *http.Client
+}
+
+// newAzureGroups reads the app registration of AZURE_TENANT_ID,
+// AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET.
+func newAzureGroups() (*azureGroups, error) {
+	tenant, id, secret := getenv("AZURE_TENANT_ID"), getenv("AZURE_CLIENT_ID"), getenv("AZURE_CLIENT_SECRET")
+	if tenant == "" || id == "" || secret == "" {
+		return nil, fmt.Errorf("AZURE_TENANT_ID, AZURE_CLIENT_ID, and AZURE_CLIENT_SECRET must be set for the azure source")
+	}
+	conf := &clientcredentials.Config{
+		ClientID:     id,
+		ClientSecret: secret,
+		TokenURL:     microsoftLoginURL + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token",
+		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	}
+	return &azureGroups{client: conf.Client(context.Background())}, nil
+}
+
+func (a *azureGroups) GroupMembers(ctx context.Context, group string) ([]string, error) {
+	var emails []string
+	next := microsoftGraphAPI + "/groups/" + url.PathEscape(group) + "/transitiveMembers/microsoft.graph.user?$select=mail,userPrincipalName,accountEnabled&$top=999"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				Mail              string `json:"mail"`
+				UserPrincipalName string `json:"userPrincipalName"`
+				AccountEnabled    *bool  `json:"accountEnabled"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if err := directoryGet(ctx, a.client, next, &page); err != nil {
+			return nil, err
+		}
+		for _, u := range page.Value {
+			if u.AccountEnabled != nil && !*u.AccountEnabled {
+				continue
+			}
+			if u.Mail != "" {
+				emails = append(emails, strings.ToLower(u.Mail))
+			}
+			if u.UserPrincipalName != "" && !strings.EqualFold(u.UserPrincipalName, u.Mail) {
+				emails = append(emails, strings.ToLower(u.UserPrincipalName))
+			}
+		}
+		next = page.NextLink
+	}
+	return emails, nil
+}
+
+// directoryGet fetches a page of a group directory into v.
+func directoryGet(ctx context.Context, client *http.Client, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// teamSyncResult is what syncing one mapping did, or would do.
+type teamSyncResult struct {
+	Mapping    string   `json:"mapping"`
+	Org        string   `json:"org"`
+	Team       string   `json:"team"`
+	Added      []string `json:"added,omitempty"`
+	Removed    []string `json:"removed,omitempty"`
+	Unlinked   int      `json:"unlinked,omitempty"`    // Group members with no GitHub account linked over SSO
+	NotMembers int      `json:"not_members,omitempty"` // Linked accounts that have not joined the org
+	Error      string   `json:"error,omitempty"`
+}
+
+// maybeSyncTeams syncs the teams when they were not synced within
+// TEAM_SYNC_INTERVAL, across all instances sharing the store, and returns
+// the results, or nil when it did not sync.
+func maybeSyncTeams(ctx context.Context) ([]teamSyncResult, error) {
+	if teamSync == nil {
+		return nil, nil
+	}
+	first, err := dataStore.SetNX(ctx, teamSyncRanKey, nil, teamSync.interval)
+	if err != nil || !first {
+		return nil, err
+	}
+	return syncTeams(ctx, adminClient(), false)
+}
+
+// syncTeams makes the members of each team of TEAM_SYNC the members of its
+// group, or with dryRun reports what that would change. Group members are
+// matched to GitHub accounts by the address of their SSO link, so only
+// users who signed in with SSO are synced. Teams only get members of the
+// org; joining it stays with the invite flow. With TEAM_SYNC_REMOVE, team
+// members with an SSO link whose address left the group are removed; those
+// without a link were added some other way and are left alone. A mapping
+// that fails is reported, and the others still sync.
+func syncTeams(ctx context.Context, client *github.Client, dryRun bool) ([]teamSyncResult, error) {
+	linked, err := linkedAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool)
+	for _, login := range linked {
+		managed[strings.ToLower(login)] = true
+	}
+	orgMembers := make(map[string]map[string]bool)
+	var results []teamSyncResult
+	for _, m := range teamSync.mappings {
+		res := teamSyncResult{Mapping: m.Spec, Org: m.org, Team: m.team}
+		if err := syncTeam(ctx, client, m, linked, managed, orgMembers, dryRun, &res); err != nil {
+			log.Printf("Syncing team %s/%s with %s failed: %v", m.org, m.team, m.group, err)
+			res.Error = redact(err.Error())
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// syncTeam syncs the team of m into res.
+func syncTeam(ctx context.Context, client *github.Client, m teamMapping, linked map[string]string, managed map[string]bool, orgMembers map[string]map[string]bool, dryRun bool, res *teamSyncResult) error {
+	emails, err := teamSync.sources[m.source].GroupMembers(ctx, m.group)
+	if err != nil {
+		return fmt.Errorf("listing group %s: %w", m.group, err)
+	}
+	members, ok := orgMembers[m.org]
+	if !ok {
+		if members, err = orgMemberLogins(ctx, client, m.org); err != nil {
+			return err
+		}
+		orgMembers[m.org] = members
+	}
+	want := make(map[string]string)
+	for _, email := range emails {
+		login, ok := linked[email]
+		switch {
+		case !ok:
+			res.Unlinked++
+		case !members[strings.ToLower(login)]:
+			res.NotMembers++
+		default:
+			want[strings.ToLower(login)] = login
+		}
+	}
+
+	current := make(map[string]string)
+	opts := &github.TeamListTeamMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		users, resp, err := client.Teams.ListTeamMembersBySlug(ctx, m.org, m.team, opts)
+		if err != nil {
+			return fmt.Errorf("listing team %s: %w", m.team, err)
+		}
+		for _, u := range users {
+			current[strings.ToLower(u.GetLogin())] = u.GetLogin()
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for lower, login := range want {
+		if _, ok := current[lower]; !ok {
+			res.Added = append(res.Added, login)
+		}
+	}
+	if teamSync.remove {
+		for lower, login := range current {
+			if _, ok := want[lower]; !ok && managed[lower] {
+				res.Removed = append(res.Removed, login)
+			}
+		}
+	}
+	slices.Sort(res.Added)
+	slices.Sort(res.Removed)
+	if dryRun {
+		return nil
+	}
+	// Report only the changes made when one fails.
+	for i, login := range res.Added {
+		if _, _, err := client.Teams.AddTeamMembershipBySlug(ctx, m.org, m.team, login, nil); err != nil {
+			res.Added, res.Removed = res.Added[:i], nil
+			return fmt.Errorf("adding %s to team %s: %w", login, m.team, err)
+		}
+		log.Printf("Team sync added %s to %s/%s", login, m.org, m.team)
+	}
+	for i, login := range res.Removed {
+		if _, err := client.Teams.RemoveTeamMembershipBySlug(ctx, m.org, m.team, login); err != nil {
+			res.Removed = res.Removed[:i]
+			return fmt.Errorf("removing %s from team %s: %w", login, m.team, err)
+		}
+		log.Printf("Team sync removed %s from %s/%s", login, m.org, m.team)
+	}
+	return nil
+}
+
+// linkedAccounts returns the GitHub logins of the SSO links, by lower-case
+// address.
+func linkedAccounts(ctx context.Context) (map[string]string, error) {
+	subjects, err := dataStore.Range(ctx, ssoLinkIndex, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]string)
+	for _, s := range subjects {
+		link, err := loadSSOLink(ctx, string(s))
+		if err != nil || link.Email == "" || link.Provider != "github" {
+			continue
+		}
+		linked[strings.ToLower(link.Email)] = link.Username
+	}
+	return linked, nil
+}
+
+// orgMemberLogins returns the lower-case logins of the members of org.
+func orgMemberLogins(ctx context.Context, client *github.Client, org string) (map[string]bool, error) {
+	logins := make(map[string]bool)
+	opts := &github.ListMembersOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		members, resp, err := client.Organizations.ListMembers(ctx, org, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing the members of %s: %w", org, err)
+		}
+		for _, m := range members {
+			logins[strings.ToLower(m.GetLogin())] = true
+		}
+		if resp.NextPage == 0 {
+			return logins, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// handleTeamSync serves /admin/team-sync: GET shows what a sync would
+// change, and POST syncs the teams now.
+func handleTeamSync(w http.ResponseWriter, r *http.Request) {
+	if teamSync == nil {
+		writeJSONError(w, ErrNotFound.WithMessage("Team sync is not configured; set TEAM_SYNC."))
+		return
+	}
+	ctx := r.Context()
+	dryRun := r.Method == http.MethodGet
+	results, err := syncTeams(ctx, adminClient(), dryRun)
+	if err != nil {
+		writeJSONError(w, asError(err, ErrConfig))
+		return
+	}
+	if !dryRun {
+		added, removed := 0, 0
+		for _, res := range results {
+			added, removed = added+len(res.Added), removed+len(res.Removed)
+		}
+		recordAudit(ctx, r, adminActor(r), auditTeamsSynced, map[string]any{"added": added, "removed": removed})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"dry_run": dryRun, "teams": results})
+}
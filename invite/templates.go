@@ -0,0 +1,215 @@
+package invite
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Pages rendered by the handler itself, for situations where there is no
+// page on the main website to redirect to. Each is parsed the first time it
+// is used, so cold starts only pay for the pages they render.
+var (
+	countdownTemplate = lazyTemplate("countdown", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Invitations are closed</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}#countdown{font-size:2rem;font-variant-numeric:tabular-nums}</style>
+</head>
+<body>
+<h1>Invitations to {{.Org}} are closed right now</h1>
+{{if .OpensAt.IsZero}}
+<p>No upcoming enrollment window is scheduled. Check back later.</p>
+{{else}}
+<p>The next enrollment window opens at <time datetime="{{.OpensAtISO}}">{{.OpensAtISO}}</time>.</p>
+<p id="countdown"></p>
+<p><a href="/login">Join now</a> once it opens.</p>
+<script>
+(function () {
+  var opens = new Date({{.OpensAtISO}}).getTime();
+  var el = document.getElementById("countdown");
+  function tick() {
+    var s = Math.max(0, Math.floor((opens - Date.now()) / 1000));
+    var d = Math.floor(s / 86400), h = Math.floor(s % 86400 / 3600), m = Math.floor(s % 3600 / 60);
+    el.textContent = (d ? d + "d " : "") + h + "h " + m + "m " + (s % 60) + "s";
+    if (s === 0) { location.reload(); return; }
+    setTimeout(tick, 1000);
+  }
+  tick();
+})();
+</script>
+{{end}}
+</body>
+</html>
+`)
+
+	npmFormTemplate = lazyTemplate("npm", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Join the npm organization</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem}.error{color:#b00}input[type=text]{width:100%;padding:.5rem;font-size:1rem}</style>
+</head>
+<body>
+<h1>Your invitation to {{.Org}} is on its way</h1>
+<p>We also publish packages under <strong>@{{.NPMOrg}}</strong> on npm. Enter your npm username to join the npm organization too.</p>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+<form method="post" action="/npm">
+<input type="hidden" name="token" value="{{.Token}}">
+<p><label>npm username<br><input type="text" name="npm_username" autocomplete="username" required></label></p>
+<p><button type="submit">Join @{{.NPMOrg}}</button></p>
+</form>
+<form method="post" action="/npm">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit" name="skip" value="1">Skip this step</button>
+</form>
+</body>
+</html>
+`)
+
+	timeoutTemplate = lazyTemplate("timeout", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>This is taking too long</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}</style>
+</head>
+<body>
+<h1>This is taking longer than it should</h1>
+<p>We couldn't finish your request in time. If you were signing in, your invitation may still arrive; check your email before trying again.</p>
+<p><a href="/login">Start over</a></p>
+</body>
+</html>
+`)
+
+	decideTemplate = lazyTemplate("decide", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Review invitation request</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem}</style>
+</head>
+<body>
+<h1>Invitation request from {{.Approval.Username}}</h1>
+<p>Reason for review: {{.Approval.Reason}}. Requested at {{.Approval.CreatedAt.Format "2006-01-02 15:04 MST"}}.</p>
+{{if eq .Approval.Status "pending"}}
+<form method="post" action="/admin/decide">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">{{if eq .Decision "approve"}}Approve and invite{{else}}Deny{{end}} {{.Approval.Username}}</button>
+</form>
+{{else}}
+<p>This request has already been decided: {{.Approval.Status}}.</p>
+{{end}}
+</body>
+</html>
+`)
+
+	decidedTemplate = lazyTemplate("decided", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Invitation request {{.Status}}</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem}</style>
+</head>
+<body>
+<h1>Request from {{.Username}}: {{.Status}}</h1>
+</body>
+</html>
+`)
+
+	orgChooserTemplate = lazyTemplate("orgs", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Choose an organization</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem}li{margin:.5rem 0}</style>
+</head>
+<body>
+<h1>Which organization would you like to join?</h1>
+<ul>
+{{range .Choices}}<li><a href="{{.URL}}">{{.Org}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`)
+
+	powTemplate = lazyTemplate("pow", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Checking your browser</title>
+<style>body{font-family:system-ui,sans-serif;max-width:32rem;margin:4rem auto;padding:0 1rem;text-align:center}</style>
+</head>
+<body>
+<h1>Just a moment</h1>
+<p id="status">Your browser is doing a quick check before you sign in. No data leaves your device.</p>
+<noscript><p>This check needs JavaScript. Please enable it and reload the page.</p></noscript>
+<script>
+(async function () {
+  var challenge = {{.Challenge}}, bits = {{.Difficulty}};
+  var enc = new TextEncoder();
+  function zeros(buf) {
+    var b = new Uint8Array(buf), n = 0;
+    for (var i = 0; i < b.length; i++) {
+      if (b[i] === 0) { n += 8; continue; }
+      return n + Math.clz32(b[i]) - 24;
+    }
+    return n;
+  }
+  for (var nonce = 0; ; nonce++) {
+    var sum = await crypto.subtle.digest("SHA-256", enc.encode(challenge + ":" + nonce));
+    if (zeros(sum) >= bits) break;
+  }
+  var u = new URL(location.href);
+  u.searchParams.set("pow", challenge + "~" + nonce);
+  location.replace(u.toString());
+})().catch(function () {
+  document.getElementById("status").textContent = "The check could not run in this browser. Please try another one.";
+});
+</script>
+</body>
+</html>
+`)
+)
+
+// lazyTemplate returns a func that parses the template text on its first
+// call and then returns the parsed template.
+func lazyTemplate(name, text string) func() *template.Template {
+	return sync.OnceValue(func() *template.Template {
+		return template.Must(template.New(name).Parse(text))
+	})
+}
+
+// staticPage returns a func that renders a template without data on its
+// first call and then returns the page.
+func staticPage(tmpl func() *template.Template) func() []byte {
+	return sync.OnceValue(func() []byte {
+		var buf bytes.Buffer
+		if err := tmpl().Execute(&buf, nil); err != nil {
+			log.Printf("Failed to render %s page: %v", tmpl().Name(), err)
+		}
+		return buf.Bytes()
+	})
+}
+
+// timeoutPage is the page of requests that run out of time.
+var timeoutPage = staticPage(timeoutTemplate)
+
+// renderPage writes an HTML page with the given status.
+func renderPage(w http.ResponseWriter, status int, tmpl *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tmpl.Execute(w, data); err != nil {
+		log.Printf("Failed to render %s page: %v", tmpl.Name(), err)
+	}
+}
@@ -0,0 +1,263 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of the network throttle.
+const (
+	throttleKey     = "throttle:"
+	networkCacheKey = "network:"
+)
+
+// networkCacheTTL is how long the network of an address is remembered.
+const networkCacheTTL = time.Hour
+
+// networkInfo is what a networkLookup knows about a client address. Fields
+// it cannot tell are left zero.
+type networkInfo struct {
+	Country string `json:"country,omitempty"` // ISO 3166 code, upper case
+	ASN     int    `json:"asn,omitempty"`
+	Hosting bool   `json:"hosting,omitempty"` // A hosting or datacenter network
+}
+
+// networkLookup resolves client addresses to their network, from
+// THROTTLE_LOOKUP.
+type networkLookup interface {
+	Lookup(ctx context.Context, r *http.Request, ip string) (networkInfo, error)
+}
+
+// headerLookup reads the country set by the platform's edge, which costs no
+// request but knows nothing of networks.
+type headerLookup struct{}
+
+func (headerLookup) Lookup(ctx context.Context, r *http.Request, ip string) (networkInfo, error) {
+	for _, h := range []string{"X-Vercel-IP-Country", "CF-IPCountry", "X-Country-Code"} {
+		if c := r.Header.Get(h); c != "" {
+			return networkInfo{Country: strings.ToUpper(c)}, nil
+		}
+	}
+	return networkInfo{}, errors.New("no country header")
+}
+
+// httpLookup queries a GeoIP service. The URL has {ip} replaced, and the
+// response is a JSON object with country, asn (a number or "AS123..."), and
+// hosting fields.
+type httpLookup struct {
+	url    string
+	client *http.Client
+}
+
+func (l httpLookup) Lookup(ctx context.Context, r *http.Request, ip string) (networkInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(l.url, "{ip}", url.PathEscape(ip)), nil)
+	if err != nil {
+		return networkInfo{}, err
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return networkInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return networkInfo{}, fmt.Errorf("geoip: %s", resp.Status)
+	}
+	var body struct {
+		Country string          `json:"country"`
+		ASN     json.RawMessage `json:"asn"`
+		Hosting bool            `json:"hosting"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return networkInfo{}, err
+	}
+	info := networkInfo{Country: strings.ToUpper(body.Country), Hosting: body.Hosting}
+	asn := strings.Trim(string(body.ASN), `"`)
+	asn, _, _ = strings.Cut(strings.TrimPrefix(strings.ToUpper(asn), "AS"), " ")
+	info.ASN, _ = strconv.Atoi(asn)
+	return info, nil
+}
+
+// A throttleRule caps the sign-ups of the networks it matches.
+type throttleRule struct {
+	match  string // "asn:N", "country:CC", "hosting", or "default"
+	limit  int
+	window time.Duration
+}
+
+// throttleConfig is the optional sign-up throttle by network.
+type throttleConfig struct {
+	lookup networkLookup
+	rules  []throttleRule
+}
+
+// throttle is nil unless THROTTLE_RULES is set.
+var throttle *throttleConfig
+
+// throttleDecisions counts the throttle's decisions, by outcome and rule.
+var throttleDecisions = expvar.NewMap("throttle_decisions")
+
+// loadThrottleConfig reads THROTTLE_RULES and THROTTLE_LOOKUP.
+func loadThrottleConfig() {
+	spec := getenv("THROTTLE_RULES")
+	if spec == "" {
+		return
+	}
+	rules, err := parseThrottleRules(spec)
+	if err != nil {
+		log.Fatalf("FATAL: Invalid THROTTLE_RULES: %v", err)
+	}
+	t := &throttleConfig{rules: rules}
+	switch lookup := getenv("THROTTLE_LOOKUP"); {
+	case lookup == "" || lookup == "headers":
+		t.lookup = headerLookup{}
+	case strings.HasPrefix(lookup, "https://") || strings.HasPrefix(lookup, "http://"):
+		t.lookup = httpLookup{url: lookup, client: &http.Client{Timeout: 2 * time.Second}}
+	default:
+		log.Fatalf("FATAL: THROTTLE_LOOKUP must be headers or a GeoIP URL with {ip}, not %q.", lookup)
+	}
+	throttle = t
+}
+
+// parseThrottleRules parses THROTTLE_RULES, a comma-separated list of
+// match=limit/window entries, for example:
+//
+//	hosting=3/10m, asn:16509=1/1h, country:XX=20/1h, default=30/10m
+//
+// hosting and default rules count sign-ups per ASN, so that one hosting
+// network can be capped harder than residential traffic; the others count
+// per ASN or country as named. The most specific matching rule applies:
+// asn, then country, then hosting, then default.
+func parseThrottleRules(spec string) ([]throttleRule, error) {
+	var rules []throttleRule
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		match, value, _ := strings.Cut(entry, "=")
+		match = strings.TrimSpace(match)
+		limit, window, _ := strings.Cut(strings.TrimSpace(value), "/")
+		var rule throttleRule
+		var err error
+		rule.limit, err = strconv.Atoi(limit)
+		if err == nil {
+			rule.window, err = time.ParseDuration(window)
+		}
+		if err != nil || rule.limit < 0 || rule.window < time.Second {
+			return nil, fmt.Errorf("invalid entry %q: want match=limit/window", entry)
+		}
+		kind, arg, _ := strings.Cut(match, ":")
+		switch {
+		case match == "hosting" || match == "default":
+		case kind == "asn" && arg != "":
+			if _, err := strconv.Atoi(strings.TrimPrefix(strings.ToUpper(arg), "AS")); err != nil {
+				return nil, fmt.Errorf("invalid ASN in %q", entry)
+			}
+			match = "asn:" + strings.TrimPrefix(strings.ToUpper(arg), "AS")
+		case kind == "country" && len(arg) == 2:
+			match = "country:" + strings.ToUpper(arg)
+		default:
+			return nil, fmt.Errorf("invalid match %q: want hosting, default, asn:N, or country:CC", match)
+		}
+		rule.match = match
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ruleFor returns the rule that applies to info, and the bucket its
+// sign-ups are counted in, or nil when no rule does.
+func (t *throttleConfig) ruleFor(info networkInfo) (*throttleRule, string) {
+	asn := "asn:" + strconv.Itoa(info.ASN)
+	var best *throttleRule
+	bestRank := 0
+	for i := range t.rules {
+		rule := &t.rules[i]
+		rank := 0
+		switch {
+		case info.ASN != 0 && rule.match == asn:
+			rank = 4
+		case info.Country != "" && rule.match == "country:"+info.Country:
+			rank = 3
+		case info.Hosting && rule.match == "hosting":
+			rank = 2
+		case rule.match == "default":
+			rank = 1
+		}
+		if rank > bestRank {
+			best, bestRank = rule, rank
+		}
+	}
+	if best == nil {
+		return nil, ""
+	}
+	if bestRank >= 3 {
+		return best, best.match
+	}
+	if info.ASN == 0 {
+		// Without an ASN, hosting and default rules can only count the
+		// country as a whole.
+		return best, best.match + ":country:" + info.Country
+	}
+	return best, best.match + ":" + asn
+}
+
+// allow counts a sign-up from r against the throttle rules and reports
+// whether it may go ahead. Failed lookups and store errors let it through.
+func (t *throttleConfig) allow(ctx context.Context, r *http.Request) bool {
+	info, err := t.network(ctx, r)
+	if err != nil {
+		throttleDecisions.Add("lookup_failed", 1)
+	}
+	rule, bucket := t.ruleFor(info)
+	if rule == nil {
+		throttleDecisions.Add("allowed", 1)
+		return true
+	}
+	window := time.Now().Unix() / int64(rule.window.Seconds())
+	n, err := dataStore.Incr(ctx, fmt.Sprintf("%s%s:%d", throttleKey, bucket, window), rule.window)
+	if err != nil {
+		log.Printf("Throttle check failed, continuing: %v", err)
+		throttleDecisions.Add("allowed", 1)
+		return true
+	}
+	if n > int64(rule.limit) {
+		throttleDecisions.Add("throttled:"+rule.match, 1)
+		return false
+	}
+	throttleDecisions.Add("allowed", 1)
+	return true
+}
+
+// network looks up the network of r's client, caching the result.
+// Addresses that are not globally routable have none to look up.
+func (t *throttleConfig) network(ctx context.Context, r *http.Request) (networkInfo, error) {
+	ip := clientIP(r)
+	if !isRoutable(ip) {
+		return networkInfo{}, fmt.Errorf("%q is not a globally routable address", ip)
+	}
+	var info networkInfo
+	b, err := dataStore.Get(ctx, networkCacheKey+ip)
+	if err == nil && json.Unmarshal(b, &info) == nil {
+		return info, nil
+	}
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Printf("Failed to read the cached network of %s: %v", ip, err)
+	}
+	if info, err = t.lookup.Lookup(ctx, r, ip); err != nil {
+		return networkInfo{}, err
+	}
+	b, _ = json.Marshal(info)
+	dataStore.Set(ctx, networkCacheKey+ip, b, networkCacheTTL)
+	return info, nil
+}
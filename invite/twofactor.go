@@ -0,0 +1,159 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Store keys of the two-factor nudges.
+const (
+	memberContactKey   = "members:contact:"      // Verified address of a member who joined, by org and username
+	twoFactorNudgedKey = "members:2fa_nudged:"   // Set while a member was nudged recently, by org and username
+	twoFactorDigestKey = "members:2fa_unreached" // Set while the digest of unreachable members was sent recently
+)
+
+// memberContactTTL is how long the address of a member who joined is kept
+// for two-factor nudges.
+const memberContactTTL = 90 * 24 * time.Hour
+
+// Two-factor nudge settings, from TWO_FACTOR_NUDGE_INTERVAL,
+// TWO_FACTOR_DEADLINE, and TWO_FACTOR_NUDGE_MESSAGE.
+var (
+	twoFactorNudgeInterval time.Duration // How often a member without 2FA is nudged; 0 disables nudges
+	twoFactorDeadline      time.Time     // When the orgs start requiring 2FA; zero when not announced
+	twoFactorNudgeMessage  string
+)
+
+// defaultTwoFactorNudge is the nudge email without TWO_FACTOR_NUDGE_MESSAGE.
+const defaultTwoFactorNudge = `Hi {username},
+
+{org} will require two-factor authentication{deadline}. Members without it will be removed from the organization. Turn it on here to keep your membership:
+
+{url}
+`
+
+// loadTwoFactorNudgeConfig reads TWO_FACTOR_NUDGE_INTERVAL, the optional
+// TWO_FACTOR_DEADLINE as 2006-01-02, and TWO_FACTOR_NUDGE_MESSAGE, whose
+// {username}, {org}, and {url} are filled in, as is {deadline}: " from" the
+// date, or nothing. Nudges are emailed, so they need SMTP, and only org
+// owners can list the members without 2FA.
+func loadTwoFactorNudgeConfig() {
+	twoFactorNudgeInterval = envDuration("TWO_FACTOR_NUDGE_INTERVAL", 0)
+	twoFactorDeadline = time.Time{}
+	twoFactorNudgeMessage = getenv("TWO_FACTOR_NUDGE_MESSAGE")
+	if twoFactorNudgeMessage == "" {
+		twoFactorNudgeMessage = defaultTwoFactorNudge
+	}
+	if twoFactorNudgeInterval <= 0 {
+		twoFactorNudgeInterval = 0
+		return
+	}
+	if activeProvider.Name() != "github" {
+		log.Fatal("FATAL: TWO_FACTOR_NUDGE_INTERVAL requires the github provider.")
+	}
+	if mailer == nil {
+		log.Fatal("FATAL: SMTP_HOST must be set to send TWO_FACTOR_NUDGE_INTERVAL nudges.")
+	}
+	if s := getenv("TWO_FACTOR_DEADLINE"); s != "" {
+		d, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			log.Fatalf("FATAL: TWO_FACTOR_DEADLINE must be a date such as 2026-01-31: %v", err)
+		}
+		twoFactorDeadline = d
+	}
+}
+
+// rememberContact keeps the verified address of m, who joined, so that they
+// can be nudged about 2FA later.
+func rememberContact(ctx context.Context, m pendingMember) {
+	if twoFactorNudgeInterval == 0 || m.Email == "" {
+		return
+	}
+	if err := dataStore.Set(ctx, memberContactKey+orgOrDefault(m.Org)+":"+strings.ToLower(m.Username), []byte(m.Email), memberContactTTL); err != nil {
+		log.Printf("Failed to remember the address of %s for 2FA nudges: %v", m.Username, err)
+	}
+}
+
+// nudgeTwoFactor emails the members of each org without two-factor
+// authentication a nudge to turn it on, each once per
+// TWO_FACTOR_NUDGE_INTERVAL and at most reconcileBatch per run, and returns
+// how many it nudged and how many it has no address for. The addresses are
+// those verified by members who joined through the invite flow; the others
+// are listed to the alert notifiers instead, once per interval. Nudges stop
+// once TWO_FACTOR_DEADLINE has passed.
+func nudgeTwoFactor(ctx context.Context) (nudged, unreachable int, err error) {
+	if twoFactorNudgeInterval == 0 || !twoFactorDeadline.IsZero() && !time.Now().Before(twoFactorDeadline.AddDate(0, 0, 1)) {
+		return 0, 0, nil
+	}
+	client := adminClient()
+	var unreached []string
+	for _, org := range githubOrgs {
+		logins, err := membersWithout2FA(ctx, client, org)
+		if err != nil {
+			return nudged, unreachable, fmt.Errorf("listing the members of %s without 2FA: %w", org, err)
+		}
+		for lower, login := range logins {
+			email, err := dataStore.Get(ctx, memberContactKey+org+":"+lower)
+			if errors.Is(err, store.ErrNotFound) {
+				unreached = append(unreached, org+"/"+login)
+				continue
+			}
+			if err != nil {
+				return nudged, unreachable, err
+			}
+			if nudged >= reconcileBatch {
+				continue
+			}
+			key := twoFactorNudgedKey + org + ":" + lower
+			first, err := dataStore.SetNX(ctx, key, nil, twoFactorNudgeInterval)
+			if err != nil {
+				return nudged, unreachable, err
+			}
+			if !first {
+				continue
+			}
+			if err := mailer.send(string(email), "Turn on two-factor authentication to stay in "+org, twoFactorNudgeText(org, login)); err != nil {
+				dataStore.Delete(ctx, key)
+				return nudged, unreachable, err
+			}
+			log.Printf("Sent a 2FA nudge to %s of %s", login, org)
+			nudged++
+		}
+	}
+	unreachable = len(unreached)
+	slices.Sort(unreached)
+	if unreachable > 0 && alerts != nil {
+		if first, err := dataStore.SetNX(ctx, twoFactorDigestKey, nil, twoFactorNudgeInterval); err != nil {
+			return nudged, unreachable, err
+		} else if first {
+			text := fmt.Sprintf("%d members without two-factor authentication have no known address to nudge:\n%s", unreachable, strings.Join(unreached, "\n"))
+			for _, n := range alerts.notifiers {
+				if err := n.Notify(ctx, "Members without two-factor authentication", text); err != nil {
+					log.Printf("Failed to send the 2FA digest: %v", err)
+				}
+			}
+		}
+	}
+	return nudged, unreachable, nil
+}
+
+// twoFactorNudgeText returns the nudge email to login about org.
+func twoFactorNudgeText(org, login string) string {
+	deadline := ""
+	if !twoFactorDeadline.IsZero() {
+		deadline = " from " + twoFactorDeadline.Format("January 2, 2006")
+	}
+	return strings.NewReplacer(
+		"{username}", login,
+		"{org}", org,
+		"{deadline}", deadline,
+		"{url}", githubWebURL()+"/settings/security",
+	).Replace(twoFactorNudgeMessage)
+}
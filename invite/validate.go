@@ -0,0 +1,410 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"auto-invite/store"
+
+	"golang.org/x/oauth2"
+)
+
+// Statuses of a ConfigCheck.
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkError   = "error"
+)
+
+// ConfigCheck is one finding of ValidateConfig.
+type ConfigCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // "ok", "warning", or "error"
+	Detail string `json:"detail,omitempty"`
+}
+
+// ConfigReport is the result of ValidateConfig. It is Valid when no check
+// found an error; warnings point at settings that are likely mistakes.
+type ConfigReport struct {
+	Valid  bool          `json:"valid"`
+	Checks []ConfigCheck `json:"checks"`
+}
+
+// ValidateConfig checks the configuration in the environment without
+// applying it, so that mistakes show up before a deploy rather than as a
+// failed start. With online set it also verifies the provider credentials
+// against the provider's API.
+func ValidateConfig(ctx context.Context, online bool) *ConfigReport {
+	env, _, err := profileEnv()
+	if err != nil {
+		return &ConfigReport{Checks: []ConfigCheck{{Name: "profiles", Status: checkError, Detail: err.Error()}}}
+	}
+	return validateConfig(ctx, env, online)
+}
+
+// configValidator collects the findings of validateConfig.
+type configValidator struct {
+	getenv func(string) string
+	report ConfigReport
+}
+
+// check records a finding for name: an error for each entry of errs, a
+// warning for each entry of warnings, or else ok.
+func (v *configValidator) check(name string, errs, warnings []string) {
+	for _, e := range errs {
+		v.report.Checks = append(v.report.Checks, ConfigCheck{Name: name, Status: checkError, Detail: e})
+	}
+	for _, w := range warnings {
+		v.report.Checks = append(v.report.Checks, ConfigCheck{Name: name, Status: checkWarning, Detail: w})
+	}
+	if len(errs) == 0 && len(warnings) == 0 {
+		v.report.Checks = append(v.report.Checks, ConfigCheck{Name: name, Status: checkOK})
+	}
+}
+
+// validateConfig runs every check against the settings getenv returns.
+func validateConfig(ctx context.Context, getenv func(string) string, online bool) *ConfigReport {
+	v := &configValidator{getenv: getenv}
+	orgs := parseOrgList(getenv("GITHUB_ORG_NAME"), getenv("GITHUB_ORGS"))
+	v.checkURLs()
+	v.checkProvider(ctx, orgs, online)
+	v.checkStore(ctx, online)
+	if online {
+		v.checkRedirectPages(ctx)
+	}
+	v.checkSigningKeys()
+	v.checkValues()
+	v.checkStructured()
+	v.checkIntegrations()
+	if _, _, err := loadRulesFrom(getenv("CONFIG_FILE"), orgs, getenv); err != nil {
+		v.check("gates and rules", []string{err.Error()}, nil)
+	} else {
+		v.check("gates and rules", nil, nil)
+	}
+	v.checkTemplates()
+
+	v.report.Valid = true
+	for _, c := range v.report.Checks {
+		if c.Status == checkError {
+			v.report.Valid = false
+		}
+	}
+	return &v.report
+}
+
+// checkURLs checks that the redirect targets and webhook URLs are absolute
+// http(s) URLs.
+func (v *configValidator) checkURLs() {
+	var errs, warnings []string
+	for _, name := range []string{"SUCCESS_REDIRECT_URL", "ERROR_REDIRECT_URL"} {
+		if v.getenv(name) == "" {
+			errs = append(errs, name+" must be set")
+		}
+	}
+	for _, name := range []string{
+		"SUCCESS_REDIRECT_URL", "ERROR_REDIRECT_URL", "ORG_FULL_REDIRECT_URL", "CLOSED_REDIRECT_URL",
+		"USED_REDIRECT_URL", "CANCELLED_REDIRECT_URL", "PUBLIC_URL", "GITHUB_URL", "OIDC_ISSUER", "REPUTATION_URL",
+		"APPROVAL_SLACK_WEBHOOK", "APPROVAL_DISCORD_WEBHOOK", "WELCOME_WEBHOOK_URL",
+		"NEWSLETTER_WEBHOOK_URL", "DENYLIST_URL", "SLACK_INVITE_LINK", "KV_REST_API_URL", "POSTHOG_HOST",
+		"ALERT_SLACK_WEBHOOK", "ALERT_DISCORD_WEBHOOK",
+	} {
+		value := v.getenv(name)
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%s is not a valid URL: %v", name, err))
+		case u.Scheme != "https" && u.Scheme != "http" || u.Host == "":
+			errs = append(errs, fmt.Sprintf("%s must be an absolute http or https URL", name))
+		case u.Scheme == "http" && u.Hostname() != "localhost" && u.Hostname() != "127.0.0.1":
+			warnings = append(warnings, fmt.Sprintf("%s uses plain http", name))
+		}
+		if strings.HasSuffix(name, "_REDIRECT_URL") {
+			for _, unknown := range unknownRedirectVars(value) {
+				warnings = append(warnings, fmt.Sprintf("%s has an unknown variable %s; known are {username}, {org}, {campaign}, and {error_code}", name, unknown))
+			}
+		}
+	}
+	v.check("URLs", errs, warnings)
+}
+
+// checkProvider checks that the credentials of the provider are set and,
+// when online, that the GitHub token belongs to an owner of every org and,
+// for a fine-grained token, which of the permissions the flow uses it has.
+func (v *configValidator) checkProvider(ctx context.Context, orgs []string, online bool) {
+	var required []string
+	switch name := v.getenv("PROVIDER"); name {
+	case "", "github":
+		required = []string{"GITHUB_CLIENT_ID", "GITHUB_CLIENT_SECRET", "GITHUB_PAT"}
+		if len(orgs) == 0 {
+			required = append(required, "GITHUB_ORG_NAME")
+		}
+	case "bitbucket":
+		required = []string{"BITBUCKET_CLIENT_ID", "BITBUCKET_CLIENT_SECRET", "BITBUCKET_WORKSPACE", "BITBUCKET_GROUP", "BITBUCKET_USERNAME", "BITBUCKET_APP_PASSWORD"}
+	default:
+		v.check("provider", []string{fmt.Sprintf("unknown PROVIDER %q; expected github or bitbucket", name)}, nil)
+		return
+	}
+	var errs []string
+	for _, name := range required {
+		if v.getenv(name) == "" {
+			errs = append(errs, name+" must be set")
+		}
+	}
+	v.check("provider", errs, nil)
+	if !online || len(errs) > 0 {
+		return
+	}
+
+	if p := v.getenv("PROVIDER"); p != "" && p != "github" {
+		v.check("provider credentials", nil, []string{"online checks are only implemented for GitHub"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+	client, err := githubClientAt(v.getenv("GITHUB_URL"), oauth2.NewClient(withAPILogging(ctx), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: v.getenv("GITHUB_PAT")})))
+	if err != nil {
+		v.check("provider credentials", []string{fmt.Sprintf("GITHUB_URL: %v", err)}, nil)
+		return
+	}
+	user, _, err := client.Users.Get(ctx, "")
+	if err != nil {
+		v.check("provider credentials", []string{fmt.Sprintf("GITHUB_PAT was rejected: %v", err)}, nil)
+		return
+	}
+	errs = nil
+	for _, org := range orgs {
+		m, _, err := client.Organizations.GetOrgMembership(ctx, "", org)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Sprintf("%s cannot read its membership of %s: %v", user.GetLogin(), org, err))
+		case m.GetState() != "active" || m.GetRole() != "admin":
+			errs = append(errs, fmt.Sprintf("%s is not an owner of %s, so it cannot send invitations", user.GetLogin(), org))
+		}
+	}
+	v.check("provider credentials", errs, nil)
+	if !strings.HasPrefix(v.getenv("GITHUB_PAT"), fineGrainedPATPrefix) {
+		return
+	}
+	var warnings []string
+	errs = nil
+	for _, org := range orgs {
+		caps, err := probeCapabilities(ctx, client, org)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("could not detect the permissions of GITHUB_PAT in %s: %v", org, err))
+			continue
+		}
+		e, w := caps.problems(org)
+		errs, warnings = append(errs, e...), append(warnings, w...)
+	}
+	v.check("token permissions", errs, warnings)
+}
+
+// checkStore checks the Vercel KV settings and, when online, that the
+// database answers.
+func (v *configValidator) checkStore(ctx context.Context, online bool) {
+	kvURL, token := v.getenv("KV_REST_API_URL"), v.getenv("KV_REST_API_TOKEN")
+	switch {
+	case kvURL == "":
+		v.check("store", nil, []string{"KV_REST_API_URL is not set, so state is kept in memory, separately by every instance"})
+		return
+	case token == "":
+		v.check("store", []string{"KV_REST_API_TOKEN must be set with KV_REST_API_URL"}, nil)
+		return
+	}
+	if !online {
+		v.check("store", nil, nil)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, storeTimeout)
+	defer cancel()
+	_, err := store.NewVercelKV(kvURL, token, nil).Get(ctx, "validate")
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		v.check("store", []string{fmt.Sprintf("Vercel KV did not answer: %v", err)}, nil)
+		return
+	}
+	v.check("store", nil, nil)
+}
+
+// checkSigningKeys checks the lengths of the signing keys.
+func (v *configValidator) checkSigningKeys() {
+	var errs, warnings []string
+	switch key := v.getenv("SIGNING_KEY"); {
+	case key == "" && (v.getenv("SIGNING_KMS_KEY") != "" || v.getenv("SIGNING_KEYS") != ""):
+	case key == "":
+		warnings = append(warnings, "SIGNING_KEY is not set, so signed links, presets, SSO, and the post-invite steps are disabled")
+	case len(key) < minSigningKeyLen:
+		errs = append(errs, fmt.Sprintf("SIGNING_KEY must be at least %d bytes long", minSigningKeyLen))
+	}
+	if v.getenv("SUCCESS_PARAMS") == successParamsJWT && v.getenv("SUCCESS_TOKEN_KMS_KEY") == "" && v.getenv("SUCCESS_TOKEN_KEYS") == "" {
+		switch key := v.getenv("SUCCESS_TOKEN_KEY"); {
+		case len(key) < minSigningKeyLen:
+			errs = append(errs, fmt.Sprintf("SUCCESS_TOKEN_KEY must be at least %d bytes long when SUCCESS_PARAMS is jwt", minSigningKeyLen))
+		case key == v.getenv("SIGNING_KEY"):
+			warnings = append(warnings, "SUCCESS_TOKEN_KEY is the same as SIGNING_KEY, so the success page could sign invite links")
+		}
+	}
+	for _, name := range []string{"WEBHOOK_SIGNING_SECRET", "ABUSE_REPORT_SECRET"} {
+		if key := v.getenv(name); key != "" && len(key) < minSigningKeyLen {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d bytes long", name, minSigningKeyLen))
+		}
+	}
+	v.check("signing keys", errs, warnings)
+}
+
+// checkValues checks the settings that hold a single number, duration, or
+// boolean, all of which are registered in valueSettings.
+func (v *configValidator) checkValues() {
+	var errs []string
+	for _, name := range slices.Sorted(maps.Keys(valueSettings)) {
+		if value := v.getenv(name); value != "" {
+			if err := valueSettings[name].check(name, value); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if d, err := time.ParseDuration(v.getenv("INVITE_REMINDER_AFTER")); err == nil && d >= pendingMemberTTL {
+		errs = append(errs, fmt.Sprintf("INVITE_REMINDER_AFTER must be shorter than %v", pendingMemberTTL))
+	}
+	if value := v.getenv("TWO_FACTOR_DEADLINE"); value != "" {
+		if _, err := time.Parse(time.DateOnly, value); err != nil {
+			errs = append(errs, "TWO_FACTOR_DEADLINE must be a date such as 2026-01-31")
+		}
+	}
+	v.check("values", errs, nil)
+}
+
+// parseKMSKeys checks a ","-separated list of KMS key URIs.
+func parseKMSKeys(spec string) error {
+	for _, key := range strings.Split(spec, ",") {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		if _, err := newKMSSigner(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkStructured checks the settings with a syntax of their own.
+func (v *configValidator) checkStructured() {
+	var errs []string
+	parse := func(name string, fn func(string) error) {
+		if value := v.getenv(name); value != "" {
+			if err := fn(value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+	}
+	parse("REDIRECT_STATUS", func(s string) error { _, err := parseRedirectStatuses(s); return err })
+	parse("ROUTE_TIMEOUTS", func(s string) error { _, err := parseRouteTimeouts(s); return err })
+	parse("ADMIN_CREDENTIALS", func(s string) error { _, err := parseAdminCredentials(s); return err })
+	parse("DUAL_CONTROL_ROLES", func(s string) error { _, err := parseDualControlRoles(s); return err })
+	parse("SIGNING_KMS_KEY", parseKMSKeys)
+	parse("SUCCESS_TOKEN_KMS_KEY", parseKMSKeys)
+	parse("SIGNING_KEYS", func(s string) error { _, err := parseSigningKeys(s); return err })
+	parse("SUCCESS_TOKEN_KEYS", func(s string) error { _, err := parseSigningKeys(s); return err })
+	parse("STORE_ENCRYPTION_KEYS", func(s string) error { _, _, err := parseStoreKeys(s); return err })
+	parse("STORE_DEGRADE_MODE", func(s string) error {
+		if s != degradeFailOpen && s != degradeFailClosed {
+			return fmt.Errorf("expected %s or %s", degradeFailOpen, degradeFailClosed)
+		}
+		return nil
+	})
+	parse("SLO_OBJECTIVE", func(s string) error { _, err := parseSLOObjective(s); return err })
+	parse("THROTTLE_RULES", func(s string) error { _, err := parseThrottleRules(s); return err })
+	parse("TRUSTED_PROXIES", func(s string) error { _, err := parsePrefixes(s); return err })
+	parse("DENY_IPS", func(s string) error { _, err := parseDenylist(strings.Split(s, ",")); return err })
+	parse("SUCCESS_PARAMS", func(s string) error {
+		if s != successParamsQuery && s != successParamsJWT {
+			return fmt.Errorf("expected query or jwt")
+		}
+		return nil
+	})
+	v.check("structured settings", errs, nil)
+}
+
+// checkIntegrations checks that the optional integrations have the settings
+// they depend on.
+func (v *configValidator) checkIntegrations() {
+	var errs []string
+	set := func(name string) bool { return v.getenv(name) != "" }
+	on := func(name string) bool { b, _ := strconv.ParseBool(v.getenv(name)); return b }
+	needs := func(cond bool, msg string) {
+		if cond {
+			errs = append(errs, msg)
+		}
+	}
+	needs(set("SMTP_HOST") && !set("SMTP_FROM"), "SMTP_FROM must be set when SMTP_HOST is set")
+	needs(set("SLACK_ADMIN_TOKEN") && (!set("SLACK_TEAM_ID") || !set("SLACK_CHANNEL_IDS")), "SLACK_TEAM_ID and SLACK_CHANNEL_IDS must be set when SLACK_ADMIN_TOKEN is set")
+	needs(!set("SLACK_ADMIN_TOKEN") && set("SLACK_INVITE_LINK") && !set("SMTP_HOST"), "SMTP_HOST must be set to email SLACK_INVITE_LINK")
+	needs(set("INVITE_REMINDER_AFTER") && !set("SMTP_HOST"), "SMTP_HOST must be set to send INVITE_REMINDER_AFTER reminders")
+	needs(set("TWO_FACTOR_NUDGE_INTERVAL") && !set("SMTP_HOST"), "SMTP_HOST must be set to send TWO_FACTOR_NUDGE_INTERVAL nudges")
+	needs((set("APPROVAL_SLACK_WEBHOOK") || set("APPROVAL_DISCORD_WEBHOOK") || set("APPROVAL_EMAILS")) && !set("PUBLIC_URL"), "PUBLIC_URL must be set when approval notifications are sent")
+	needs(set("TEAM_SYNC") && !set("OIDC_ISSUER"), "OIDC_ISSUER must be set when TEAM_SYNC is set")
+	needs(on("PUBLIC_MEMBERSHIP") && !set("STORE_ENCRYPTION_KEYS"), "STORE_ENCRYPTION_KEYS must be set when PUBLIC_MEMBERSHIP is set")
+	needs(set("POW_DIFFICULTY") && v.getenv("POW_DIFFICULTY") != "0" && !set("SIGNING_KEY") && !set("SIGNING_KEYS") && !set("SIGNING_KMS_KEY"), "SIGNING_KEY or SIGNING_KMS_KEY must be set when POW_DIFFICULTY is set")
+	needs(set("GITHUB_WEBHOOK_SECRET") && v.getenv("PROVIDER") != "" && v.getenv("PROVIDER") != "github", "GITHUB_WEBHOOK_SECRET is only supported for GitHub")
+	for _, action := range strings.Split(v.getenv("ACCEPTED_ACTIONS"), ",") {
+		switch name, value, _ := strings.Cut(strings.TrimSpace(action), "="); {
+		case name == "", name == "team" && value != "":
+		case name == acceptedWelcome:
+			needs(!set("WELCOME_WEBHOOK_URL"), "ACCEPTED_ACTIONS welcome needs WELCOME_WEBHOOK_URL")
+		case name == acceptedNewsletter:
+			needs(!set("NEWSLETTER_WEBHOOK_URL"), "ACCEPTED_ACTIONS newsletter needs NEWSLETTER_WEBHOOK_URL")
+		case name == acceptedSlack:
+			needs(!set("SLACK_ADMIN_TOKEN") && !set("SLACK_INVITE_LINK"), "ACCEPTED_ACTIONS slack needs the Slack step")
+		default:
+			errs = append(errs, fmt.Sprintf("ACCEPTED_ACTIONS: invalid entry %q", strings.TrimSpace(action)))
+		}
+	}
+	v.check("integrations", errs, nil)
+}
+
+// checkTemplates renders the built-in pages with sample data, so that a
+// template that only fails at execution time is caught too.
+func (v *configValidator) checkTemplates() {
+	type choice struct{ Org, URL string }
+	opensAt := time.Now().Add(time.Hour)
+	pages := []struct {
+		tmpl *template.Template
+		data any
+	}{
+		{countdownTemplate(), struct {
+			Org        string
+			OpensAt    time.Time
+			OpensAtISO string
+		}{"acme", opensAt, opensAt.Format(time.RFC3339)}},
+		{orgChooserTemplate(), struct{ Choices []choice }{[]choice{{"acme", "/login?org=acme"}}}},
+		{powTemplate(), powChallengeResponse{Challenge: "challenge", Difficulty: 1}},
+		{timeoutTemplate(), nil},
+	}
+	var errs []string
+	for _, page := range pages {
+		if err := page.tmpl.Execute(io.Discard, page.data); err != nil {
+			errs = append(errs, fmt.Sprintf("%s page: %v", page.tmpl.Name(), err))
+		}
+	}
+	v.check("templates", errs, nil)
+}
+
+// handleValidateConfig reports on the configuration, like invitectl
+// validate-config. With ?online=true the provider credentials are verified
+// too.
+func handleValidateConfig(w http.ResponseWriter, r *http.Request) {
+	online, _ := strconv.ParseBool(r.URL.Query().Get("online"))
+	writeJSON(w, http.StatusOK, ValidateConfig(r.Context(), online))
+}
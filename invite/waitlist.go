@@ -0,0 +1,38 @@
+package invite
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Store keys used by the waitlist.
+const (
+	waitlistKey      = "waitlist"
+	waitlistEntryKey = "waitlist:user:"
+)
+
+// waitlistEntry is one user waiting for a seat.
+type waitlistEntry struct {
+	Username string    `json:"username"`
+	Reason   string    `json:"reason"`
+	AddedAt  time.Time `json:"added_at"`
+}
+
+// addToWaitlist appends username to the waitlist unless they are already on
+// it. It reports whether the user was newly added.
+func addToWaitlist(ctx context.Context, username, reason string) (bool, error) {
+	added, err := dataStore.SetNX(ctx, waitlistEntryKey+username, []byte(reason), 0)
+	if err != nil || !added {
+		return false, err
+	}
+
+	entry, err := json.Marshal(waitlistEntry{Username: username, Reason: reason, AddedAt: time.Now().UTC()})
+	if err != nil {
+		return false, err
+	}
+	if _, err := dataStore.Append(ctx, waitlistKey, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,132 @@
+package invite
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"auto-invite/store"
+)
+
+// warmupInterval is how long a warm-up report is reused. Pings in between
+// get the last report instead of calling GitHub again.
+const warmupInterval = 30 * time.Second
+
+var (
+	// instanceStarted is when this instance started, for warm-up reports.
+	instanceStarted = time.Now()
+	// initTook is how long the configuration took to load.
+	initTook time.Duration
+)
+
+// warmupStep is one step of a warm-up.
+type warmupStep struct {
+	Name   string `json:"name"`
+	Millis int64  `json:"ms"`
+	Error  string `json:"error,omitempty"`
+}
+
+// warmupReport is the response of /warmup.
+type warmupReport struct {
+	OK          bool           `json:"ok"`
+	InstanceAge string         `json:"instance_age"`
+	InitMillis  int64          `json:"init_ms"`
+	Steps       []warmupStep   `json:"steps"`
+	Cached      bool           `json:"cached,omitempty"`
+	Storage     *storageStatus `json:"storage,omitempty"` // With STORE_DEGRADE_MODE
+	at          time.Time
+}
+
+var warmup struct {
+	sync.Mutex
+	last *warmupReport
+}
+
+// handleWarmup prepares the instance for the next sign-in, for a cron or
+// uptime monitor to ping: the request has loaded the configuration by the
+// time it gets here, and the warm-up parses the pages and opens the
+// connections to the store and GitHub, which are kept alive for the
+// sign-ins that follow. It needs no credential; GitHub is called at most
+// once per warmupInterval, and only with requests that do not count against
+// the rate limit.
+func handleWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	warmup.Lock()
+	defer warmup.Unlock()
+	if last := warmup.last; last != nil && time.Since(last.at) < warmupInterval {
+		report := *last
+		report.Cached = true
+		report.Storage = storageHealth()
+		report.InstanceAge = time.Since(instanceStarted).Round(time.Second).String()
+		writeJSON(w, http.StatusOK, report)
+		return
+	}
+	report := warmUp(r.Context())
+	warmup.last = report
+	writeJSON(w, http.StatusOK, report)
+}
+
+// warmUp runs the warm-up steps.
+func warmUp(ctx context.Context) *warmupReport {
+	report := &warmupReport{OK: true, InitMillis: initTook.Milliseconds(), at: time.Now()}
+	step := func(name string, fn func() error) {
+		start := time.Now()
+		err := fn()
+		s := warmupStep{Name: name, Millis: time.Since(start).Milliseconds()}
+		if err != nil {
+			s.Error = redact(err.Error())
+			report.OK = false
+		}
+		report.Steps = append(report.Steps, s)
+	}
+	step("templates", func() error {
+		for _, tmpl := range []func() *template.Template{
+			countdownTemplate, npmFormTemplate, timeoutTemplate, decideTemplate,
+			decidedTemplate, orgChooserTemplate, powTemplate, shedTemplate, notFoundTemplate,
+			loginConfirmTemplate, embedTemplate, popupResultTemplate, joinTemplate,
+			statusFragment, waitlistFragment, membersFragment,
+		} {
+			tmpl()
+		}
+		timeoutPage()
+		shedPage()
+		notFoundPage()
+		return nil
+	})
+	step("store", func() error {
+		_, err := dataStore.Get(ctx, "warmup")
+		if errors.Is(err, store.ErrNotFound) {
+			// A store in degrade mode finds nothing while it is down.
+			return storageDown()
+		}
+		return err
+	})
+	if _, ok := activeProvider.(githubProvider); ok {
+		// Rate limit lookups are free, and open the connection that invites
+		// go over.
+		step("github_api", func() error {
+			_, _, err := adminClient().RateLimits(ctx)
+			return err
+		})
+		step("github_oauth", func() error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, oauthConf.Endpoint.TokenURL, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := apiLogClient.Do(req)
+			if err != nil {
+				return err
+			}
+			return resp.Body.Close()
+		})
+	}
+	report.InstanceAge = time.Since(instanceStarted).Round(time.Second).String()
+	report.Storage = storageHealth()
+	return report
+}
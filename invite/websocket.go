@@ -0,0 +1,211 @@
+package invite
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// wsGUID is the value RFC 6455 has servers hash the client's key with.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes of WebSocket frames.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// wsMaxMessage is the largest message accepted from clients, which have
+// nothing to say beyond control frames.
+const wsMaxMessage = 4 << 10
+
+// wsWriteTimeout bounds the write of one frame to a slow client.
+const wsWriteTimeout = 10 * time.Second
+
+// wsConn is the server side of a WebSocket connection: enough of RFC 6455
+// to push text messages and to answer pings and closes.
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	mu     sync.Mutex // Serializes writes
+	closed chan struct{}
+	once   sync.Once
+}
+
+// upgradeWebSocket switches r to the WebSocket protocol. When it fails, it
+// has answered r with the reason.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, bool) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return nil, false
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		w.Header().Set("Upgrade", "websocket")
+		writeJSONError(w, ErrBadRequest.WithMessage("Connect with a WebSocket client."))
+		return nil, false
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeJSONError(w, ErrBadRequest.WithMessage("Only WebSocket version 13 is supported."))
+		return nil, false
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		writeJSONError(w, ErrConfig.WithMessage("WebSockets are not supported by this server."))
+		return nil, false
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		writeJSONError(w, ErrConfig.Wrap(err))
+		return nil, false
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, false
+	}
+	c := &wsConn{conn: conn, rw: rw, closed: make(chan struct{})}
+	go c.readLoop()
+	return c, true
+}
+
+// headerHasToken reports whether the comma-separated header name lists
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Done is closed once the connection is closed, by either side.
+func (c *wsConn) Done() <-chan struct{} { return c.closed }
+
+// WriteText sends a text message.
+func (c *wsConn) WriteText(b []byte) error { return c.writeFrame(wsText, b) }
+
+// Ping sends a ping, which keeps proxies from closing an idle connection.
+func (c *wsConn) Ping() error { return c.writeFrame(wsPing, nil) }
+
+// Close sends a close frame with code and closes the connection.
+func (c *wsConn) Close(code uint16) {
+	payload := binary.BigEndian.AppendUint16(nil, code)
+	c.writeFrame(wsClose, payload)
+	c.shutdown()
+}
+
+func (c *wsConn) shutdown() {
+	c.once.Do(func() {
+		close(c.closed)
+		c.conn.Close()
+	})
+}
+
+// writeFrame sends one unfragmented, unmasked frame, as servers do.
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.closed:
+		return net.ErrClosed
+	default:
+	}
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	c.rw.Write(header)
+	c.rw.Write(payload)
+	return c.rw.Flush()
+}
+
+// readLoop reads the client's frames until the connection closes, answering
+// pings and closes and dropping messages.
+func (c *wsConn) readLoop() {
+	defer c.shutdown()
+	for {
+		opcode, payload, err := c.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsPing:
+			if c.writeFrame(wsPong, payload) != nil {
+				return
+			}
+		case wsClose:
+			c.writeFrame(wsClose, payload)
+			return
+		}
+	}
+}
+
+// errWSProtocol is a frame that breaks RFC 6455.
+var errWSProtocol = errors.New("websocket: protocol error")
+
+// readFrame reads one frame from the client and unmasks it.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := head[0] & 0x0F
+	if head[1]&0x80 == 0 {
+		// Clients must mask their frames.
+		return 0, nil, errWSProtocol
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.rw, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > wsMaxMessage {
+		return 0, nil, errWSProtocol
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.rw, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.rw, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
@@ -0,0 +1,187 @@
+package invite
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// popupCookie marks a sign-in started from the /embed button, which runs in
+// a popup and ends by reporting to the button instead of redirecting.
+const popupCookie = "auto_invite_popup"
+
+// popupTTL bounds how long a popup sign-in can take.
+const popupTTL = 10 * time.Minute
+
+// widgetScript is /widget.js. It puts the /embed button where the script
+// tag is, passing on its data-org, data-preset, and data-t attributes and
+// the utm_* parameters of the page, and dispatches an "auto-invite" event
+// with the result of the sign-in. With data-follow, the page then goes on to
+// the success or error page.
+const widgetScript = `(function () {
+  var script = document.currentScript;
+  if (!script) return;
+  var base = new URL(script.src).origin;
+  var query = new URLSearchParams();
+  ["org", "preset", "t"].forEach(function (name) {
+    var v = script.getAttribute("data-" + name);
+    if (v) query.set(name, v);
+  });
+  new URLSearchParams(location.search).forEach(function (v, name) {
+    if (name.indexOf("utm_") === 0) query.set(name, v);
+  });
+  var frame = document.createElement("iframe");
+  frame.src = base + "/embed" + (query.toString() ? "?" + query : "");
+  frame.title = "Join our GitHub organization";
+  frame.style.cssText = "border:0;width:280px;height:64px;overflow:hidden";
+  script.parentNode.insertBefore(frame, script.nextSibling);
+  window.addEventListener("message", function (e) {
+    if (e.origin !== base || e.source !== frame.contentWindow || !e.data || e.data.type !== "auto-invite:result") return;
+    script.dispatchEvent(new CustomEvent("auto-invite", {detail: e.data, bubbles: true}));
+    if (script.hasAttribute("data-follow") && e.data.url) location.href = e.data.url;
+  });
+})();
+`
+
+var embedTemplate = lazyTemplate("embed", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Join {{.Org}}</title>
+<style>
+body{margin:0;font-family:system-ui,sans-serif;font-size:14px}
+a{display:inline-block;padding:.6rem 1rem;border-radius:6px;background:#24292f;color:#fff;text-decoration:none;font-weight:600}
+span{margin-left:.5rem;color:#57606a}
+</style>
+</head>
+<body>
+<a id="join" href="{{.Login}}" target="_top">Join {{.Org}} on GitHub</a>{{if .Members}}<span>{{.Members}} members</span>{{end}}
+<script>
+var origins = {{.Origins}};
+document.getElementById("join").addEventListener("click", function (e) {
+  if (window.open(this.href, "auto-invite", "width=600,height=720")) e.preventDefault();
+});
+window.addEventListener("message", function (e) {
+  if (e.origin !== location.origin || !e.data || e.data.type !== "auto-invite:result") return;
+  origins.forEach(function (origin) { parent.postMessage(e.data, origin); });
+});
+</script>
+</body>
+</html>
+`)
+
+var popupResultTemplate = lazyTemplate("popup_result", `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Signing you in</title>
+</head>
+<body>
+<noscript><p><a href="{{.URL}}">Continue</a></p></noscript>
+<script>
+var result = {{.}};
+if (window.opener) {
+  window.opener.postMessage(result, location.origin);
+  window.close();
+} else {
+  location.replace(result.url);
+}
+</script>
+</body>
+</html>
+`)
+
+// popupResult is what a popup sign-in reports to the button that opened it.
+type popupResult struct {
+	Type      string `json:"type"`
+	OK        bool   `json:"ok"`
+	ErrorCode string `json:"error_code,omitempty"`
+	URL       string `json:"url"`                  // The success or error page the sign-in would have ended on
+	AcceptURL string `json:"accept_url,omitempty"` // Where the user accepts the invitation on GitHub
+}
+
+// handleWidgetJS serves the script that embeds the join button.
+func handleWidgetJS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(widgetScript))
+}
+
+// handleEmbed serves the join button with the org's member count, for
+// pages to frame. The sites of LOGIN_ORIGINS can frame it and receive the
+// results of its sign-ins.
+func handleEmbed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, http.MethodGet, http.MethodHead)
+		return
+	}
+	org := githubOrgName
+	if o := r.FormValue("org"); o != "" {
+		if org = knownOrg(o); org == "" {
+			writeJSONError(w, ErrUnknownOrg)
+			return
+		}
+	}
+	query := url.Values{"popup": {"1"}}
+	passAttribution(query, r)
+	for _, name := range []string{"org", "preset", "t"} {
+		if v := r.FormValue(name); v != "" {
+			query.Set(name, v)
+		}
+	}
+	data := struct {
+		Org, Login string
+		Members    int
+		Origins    []string
+	}{Org: org, Login: (&url.URL{Path: "/login", RawQuery: query.Encode()}).String(), Origins: append([]string{}, loginOrigins...)}
+	if counter, ok := activeProvider.(memberCounter); ok {
+		if n, err := counter.MemberCount(r.Context(), org); err != nil {
+			log.Printf("Counting the members of %s for the embed failed: %v", org, err)
+		} else {
+			data.Members = n
+		}
+	}
+	w.Header().Set("Content-Security-Policy", strings.TrimSpace("frame-ancestors 'self' "+strings.Join(loginOrigins, " ")))
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Add("Vary", "Referer")
+	renderPage(w, http.StatusOK, embedTemplate(), data)
+}
+
+// markPopupFlow remembers that the sign-in r starts runs in a popup of the
+// embedded button.
+func markPopupFlow(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("popup") != "1" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     popupCookie,
+		Value:    "1",
+		Path:     "/",
+		MaxAge:   int(popupTTL.Seconds()),
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popupFlow reports whether r ends a sign-in started in a popup.
+func popupFlow(r *http.Request) bool {
+	c, err := r.Cookie(popupCookie)
+	return err == nil && c.Value == "1"
+}
+
+// renderPopupResult ends a popup sign-in: the page reports the result to
+// the button and closes, or goes on to result.URL when the sign-in was
+// opened in the window itself.
+func renderPopupResult(w http.ResponseWriter, result popupResult) {
+	result.Type = "auto-invite:result"
+	http.SetCookie(w, &http.Cookie{Name: popupCookie, Path: "/", MaxAge: -1})
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusOK, popupResultTemplate(), result)
+}
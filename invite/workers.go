@@ -0,0 +1,140 @@
+package invite
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// workerPollInterval is how often idle workers check the invite queue.
+	workerPollInterval = 5 * time.Second
+	// workerQueueBudget bounds one turn at the queue, so that a long queue
+	// doesn't keep a worker from notifications or from shutting down.
+	workerQueueBudget = 30 * time.Second
+	// workerBacklog is how many notifications can wait for a worker before
+	// new ones run in the publishing request instead.
+	workerBacklog = 256
+)
+
+// workerConcurrency is the number of background workers, from
+// WORKER_CONCURRENCY.
+var workerConcurrency int
+
+// activePool is the running worker pool, or nil outside RunWorkers.
+var activePool atomic.Pointer[workerPool]
+
+// workerPool runs background work with bounded concurrency.
+type workerPool struct {
+	tasks chan func()
+	busy  atomic.Int64
+}
+
+// loadWorkerConfig reads WORKER_CONCURRENCY and publishes the queue metrics.
+func loadWorkerConfig() {
+	workerConcurrency = envInt("WORKER_CONCURRENCY", 4)
+	expvar.Publish("invite_queue", expvar.Func(queueMetrics))
+}
+
+// RunWorkers runs the background workers until ctx is done, and then waits
+// for the work in progress. The workers drain the invite queue and run the
+// notifiers off the request path. It is meant for long-lived processes such
+// as cmd/server; serverless deployments do the same work within requests.
+// It returns right away when WORKER_CONCURRENCY is 0.
+func RunWorkers(ctx context.Context) {
+	Init()
+	if workerConcurrency <= 0 {
+		return
+	}
+	p := &workerPool{tasks: make(chan func(), workerBacklog)}
+	activePool.Store(p)
+	go func() {
+		<-ctx.Done()
+		activePool.Store(nil)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workerConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work is one worker's loop. Sends from the queue are paced across all
+// workers by processQueue, so more workers only mean more invitations in
+// flight when GitHub is slow to answer, never a faster rate.
+func (p *workerPool) work(ctx context.Context) {
+	ticker := time.NewTicker(workerPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			p.drain()
+			return
+		case task := <-p.tasks:
+			p.busy.Add(1)
+			task()
+			p.busy.Add(-1)
+		case <-ticker.C:
+			p.busy.Add(1)
+			processQueue(ctx, time.Now().Add(workerQueueBudget))
+			p.busy.Add(-1)
+		}
+	}
+}
+
+// drain runs the tasks still waiting once the pool is stopping, so that no
+// notification is lost.
+func (p *workerPool) drain() {
+	for {
+		select {
+		case task := <-p.tasks:
+			task()
+		default:
+			return
+		}
+	}
+}
+
+// runAsync runs fn on the worker pool when there is one with room, and
+// otherwise right away. fn's context is not cancelled when the request
+// ends.
+func runAsync(ctx context.Context, fn func(context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	if p := activePool.Load(); p != nil {
+		select {
+		case p.tasks <- func() { fn(ctx) }:
+			return
+		default:
+		}
+	}
+	fn(ctx)
+}
+
+// subscribeAsync registers fn for the given event types like subscribe, but
+// runs it with runAsync, for subscribers that call out to other services.
+func subscribeAsync(fn subscriber, types ...string) {
+	subscribe(func(ctx context.Context, ev busEvent) {
+		runAsync(ctx, func(ctx context.Context) { fn(ctx, ev) })
+	}, types...)
+}
+
+// workerStats reports the pool for /debug/vars.
+func workerStats() any {
+	p := activePool.Load()
+	if p == nil {
+		return map[string]any{"running": false}
+	}
+	return map[string]any{
+		"running":       true,
+		"workers":       workerConcurrency,
+		"busy":          p.busy.Load(),
+		"tasks_waiting": len(p.tasks),
+	}
+}
@@ -0,0 +1,73 @@
+// Command invite runs the invite flow as a Netlify Function. Netlify runs Go
+// functions on AWS Lambda, so the handler is adapted to API Gateway events.
+// netlify.toml rewrites every path to the function.
+//
+// Responses are buffered, so the /admin/events stream does not work here.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+
+	"auto-invite/invite"
+)
+
+// functionPath is where Netlify serves the function.
+const functionPath = "/.netlify/functions/invite"
+
+func main() {
+	lambda.Start(handle)
+}
+
+// handle converts the Lambda event into an HTTP request, runs the handler,
+// and converts the response back.
+func handle(ctx context.Context, ev events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	body := ev.Body
+	if ev.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil
+		}
+		body = string(b)
+	}
+
+	path := strings.TrimPrefix(ev.Path, functionPath)
+	if path == "" {
+		path = "/"
+	}
+	u := &url.URL{Path: path, RawQuery: url.Values(ev.MultiValueQueryStringParameters).Encode()}
+	req, err := http.NewRequestWithContext(ctx, ev.HTTPMethod, u.String(), strings.NewReader(body))
+	if err != nil {
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest}, nil
+	}
+	for k, vs := range ev.MultiValueHeaders {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Host = req.Header.Get("Host")
+	req.RemoteAddr = ev.RequestContext.Identity.SourceIP
+
+	rec := httptest.NewRecorder()
+	invite.Handler(rec, req)
+
+	resp := events.APIGatewayProxyResponse{
+		StatusCode:        rec.Code,
+		MultiValueHeaders: rec.Result().Header,
+	}
+	if b := rec.Body.Bytes(); utf8.Valid(b) {
+		resp.Body = string(b)
+	} else {
+		resp.Body = base64.StdEncoding.EncodeToString(b)
+		resp.IsBase64Encoded = true
+	}
+	return resp, nil
+}
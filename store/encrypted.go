@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrUndecryptable is returned by an Encrypted store for values it cannot
+// decrypt: sealed with a key it does not hold, or tampered with.
+var ErrUndecryptable = errors.New("store: value cannot be decrypted")
+
+// encryptedPrefix starts every value an Encrypted store seals, followed by
+// the key ID, a colon, the nonce, and the ciphertext.
+const encryptedPrefix = "enc:v1:"
+
+// Encrypted is a Store that seals values with AES-GCM before they reach the
+// underlying store, so that the emails, tokens, and logs the flow persists
+// are unreadable without the key. Each value is bound to its key, so a
+// sealed value cannot be moved to another key.
+//
+// Values are sealed with the current key, and opened with whichever key
+// they name, so keys can be rotated by adding a new current key and keeping
+// the old ones until the values they sealed have expired or been rewritten.
+// Counters and other integers stay in the clear, since Incr needs to read
+// them, and values written before encryption was turned on are read as
+// they are.
+type Encrypted struct {
+	Store
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewEncrypted returns a store sealing the values of s with keys[current],
+// and opening them with any of keys, by key ID. Keys are 16, 24, or 32
+// bytes, for AES-128, AES-192, or AES-256.
+func NewEncrypted(s Store, keys map[string][]byte, current string) (*Encrypted, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("store: the current key %q is not among the keys", current)
+	}
+	e := &Encrypted{Store: s, current: current, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || bytes.ContainsRune([]byte(id), ':') {
+			return nil, fmt.Errorf("store: invalid key ID %q", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("store: key %q: %w", id, err)
+		}
+		if e.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// seal encrypts value for key. Integers are left as they are, for Incr.
+func (e *Encrypted) seal(key string, value []byte) ([]byte, error) {
+	if _, err := strconv.ParseInt(string(value), 10, 64); err == nil {
+		return value, nil
+	}
+	aead := e.aeads[e.current]
+	out := make([]byte, 0, len(encryptedPrefix)+len(e.current)+1+aead.NonceSize()+len(value)+aead.Overhead())
+	out = append(append(append(out, encryptedPrefix...), e.current...), ':')
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		// A repeated nonce would break GCM, so nothing is sealed without one.
+		return nil, fmt.Errorf("store: generating a nonce: %w", err)
+	}
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, value, []byte(key)), nil
+}
+
+// open decrypts a value of key. Values without the prefix are returned as
+// they are.
+func (e *Encrypted) open(key string, value []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(value, []byte(encryptedPrefix))
+	if !ok {
+		return value, nil
+	}
+	id, sealed, ok := bytes.Cut(rest, []byte(":"))
+	aead := e.aeads[string(id)]
+	if !ok || aead == nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: sealed with unknown key %q", ErrUndecryptable, id)
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(key))
+	if err != nil {
+		return nil, ErrUndecryptable
+	}
+	return plain, nil
+}
+
+// Get implements Store.
+func (e *Encrypted) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := e.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(key, value)
+}
+
+// Set implements Store.
+func (e *Encrypted) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return e.Store.Set(ctx, key, sealed, ttl)
+}
+
+// SetNX implements Store.
+func (e *Encrypted) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return false, err
+	}
+	return e.Store.SetNX(ctx, key, sealed, ttl)
+}
+
+// Append implements Store.
+func (e *Encrypted) Append(ctx context.Context, key string, value []byte) (int64, error) {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return 0, err
+	}
+	return e.Store.Append(ctx, key, sealed)
+}
+
+// Pop implements Store.
+func (e *Encrypted) Pop(ctx context.Context, key string) ([]byte, error) {
+	value, err := e.Store.Pop(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(key, value)
+}
+
+// Range implements Store.
+func (e *Encrypted) Range(ctx context.Context, key string, start, stop int64) ([][]byte, error) {
+	values, err := e.Store.Range(ctx, key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range values {
+		if values[i], err = e.open(key, value); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,53 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"auto-invite/store"
+	"auto-invite/store/storetest"
+)
+
+func newEncrypted(t *testing.T, s store.Store, current string) *store.Encrypted {
+	t.Helper()
+	keys := map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 32),
+	}
+	e, err := store.NewEncrypted(s, keys, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEncrypted(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return newEncrypted(t, store.NewMemory(), "k1") })
+}
+
+func TestEncryptedSeals(t *testing.T) {
+	ctx := context.Background()
+	mem := store.NewMemory()
+	e := newEncrypted(t, mem, "k1")
+	if err := e.Set(ctx, "a", []byte("secret"), 0); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := mem.Get(ctx, "a")
+	if bytes.Contains(raw, []byte("secret")) {
+		t.Fatalf("the underlying store holds the value in the clear: %q", raw)
+	}
+
+	// A sealed value is bound to its key.
+	mem.Set(ctx, "b", raw, 0)
+	if _, err := e.Get(ctx, "b"); !errors.Is(err, store.ErrUndecryptable) {
+		t.Fatalf("Get of a value moved to another key: got error %v, want ErrUndecryptable", err)
+	}
+
+	// Values sealed with an old key still open after rotation.
+	rotated := newEncrypted(t, mem, "k2")
+	if got, err := rotated.Get(ctx, "a"); err != nil || string(got) != "secret" {
+		t.Fatalf("Get after rotation: got %q, %v; want secret", got, err)
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store. On serverless platforms its contents only
+// live as long as the function instance, so it is best suited to local
+// development and single-instance deployments.
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	lists map[string][][]byte
+}
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{
+		items: make(map[string]memoryItem),
+		lists: make(map[string][][]byte),
+	}
+}
+
+// get returns the live item at key. The caller must hold m.mu.
+func (m *Memory) get(key string) (memoryItem, bool) {
+	item, ok := m.items[key]
+	if ok && !item.expires.IsZero() && time.Now().After(item.expires) {
+		delete(m.items, key)
+		return memoryItem{}, false
+	}
+	return item, ok
+}
+
+// Get implements Store.
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.get(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), item.value...), nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = newMemoryItem(value, ttl)
+	return nil
+}
+
+// SetNX implements Store.
+func (m *Memory) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	m.items[key] = newMemoryItem(value, ttl)
+	return true, nil
+}
+
+// Incr implements Store.
+func (m *Memory) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.get(key)
+	if !ok {
+		item = newMemoryItem([]byte("0"), ttl)
+	}
+	n, err := strconv.ParseInt(string(item.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	n++
+	item.value = strconv.AppendInt(nil, n, 10)
+	m.items[key] = item
+	return n, nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	delete(m.lists, key)
+	return nil
+}
+
+// Append implements Store.
+func (m *Memory) Append(ctx context.Context, key string, value []byte) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lists[key] = append(m.lists[key], append([]byte(nil), value...))
+	return int64(len(m.lists[key])), nil
+}
+
+// Pop implements Store.
+func (m *Memory) Pop(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.lists[key]
+	if len(list) == 0 {
+		return nil, ErrNotFound
+	}
+	m.lists[key] = list[1:]
+	return list[0], nil
+}
+
+// Range implements Store.
+func (m *Memory) Range(ctx context.Context, key string, start, stop int64) ([][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := m.lists[key]
+	n := int64(len(list))
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return nil, nil
+	}
+	out := make([][]byte, 0, stop-start+1)
+	for _, v := range list[start : stop+1] {
+		out = append(out, append([]byte(nil), v...))
+	}
+	return out, nil
+}
+
+func newMemoryItem(value []byte, ttl time.Duration) memoryItem {
+	item := memoryItem{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	return item
+}
@@ -0,0 +1,12 @@
+package store_test
+
+import (
+	"testing"
+
+	"auto-invite/store"
+	"auto-invite/store/storetest"
+)
+
+func TestMemory(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemory() })
+}
@@ -0,0 +1,45 @@
+// Package store defines the small key/value interface used for everything
+// the invite flow persists (waitlist, codes, logs), along with an in-memory
+// implementation, one backed by Vercel KV, and a wrapper encrypting the
+// values of another.
+//
+// The interface is deliberately Redis-shaped so that hosted KV services can
+// implement it directly.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by Get when the key does not exist or has expired.
+var ErrNotFound = errors.New("store: key not found")
+
+// Store is a key/value store with expiring keys and append-only lists.
+// A zero ttl means the key never expires.
+type Store interface {
+	// Get returns the value stored at key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value at key, replacing any existing value.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// SetNX stores value at key only if the key does not exist. It reports
+	// whether the value was stored.
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+	// Incr atomically increments the integer counter at key, creating it at
+	// zero first if needed, and returns the new value. The ttl is applied
+	// only when the counter is created.
+	Incr(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	// Delete removes key. Deleting a missing key is not an error.
+	Delete(ctx context.Context, key string) error
+	// Append adds value to the end of the list at key and returns the new
+	// length of the list.
+	Append(ctx context.Context, key string, value []byte) (int64, error)
+	// Pop removes and returns the first element of the list at key, or
+	// ErrNotFound if the list is empty.
+	Pop(ctx context.Context, key string) ([]byte, error)
+	// Range returns the list elements at key between start and stop
+	// inclusive. Negative indexes count from the end of the list, so
+	// Range(ctx, key, 0, -1) returns the whole list.
+	Range(ctx context.Context, key string, start, stop int64) ([][]byte, error)
+}
@@ -0,0 +1,423 @@
+// Package storetest is a conformance suite for store.Store implementations.
+// Adapters, including third-party ones, run it from a test to show that
+// they keep the semantics the invite flow relies on: expiring keys, SetNX as
+// an atomic claim (which makes OAuth codes and idempotency keys single-use),
+// atomic counters, and FIFO lists.
+//
+//	func TestConformance(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store {
+//			return redisstore.New(testRedisURL(t))
+//		})
+//	}
+//
+// Every test uses keys under a prefix of its own, so a shared backend can
+// be reused across tests without flushing it.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"auto-invite/store"
+)
+
+// Suite configures a conformance run.
+type Suite struct {
+	// NewStore returns the store under test. It is called once per test.
+	NewStore func(t *testing.T) store.Store
+	// TTL is the shortest expiry the backend honours, used by the expiry
+	// tests, which wait for twice as long. It defaults to 100ms; backends
+	// with seconds' resolution should set a second or more.
+	TTL time.Duration
+	// Concurrency is the number of goroutines of the atomicity tests. It
+	// defaults to 16.
+	Concurrency int
+}
+
+// Run runs the suite with the defaults against the stores newStore returns.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	Suite{NewStore: newStore}.Run(t)
+}
+
+// Run runs the suite.
+func (s Suite) Run(t *testing.T) {
+	if s.TTL <= 0 {
+		s.TTL = 100 * time.Millisecond
+	}
+	if s.Concurrency <= 0 {
+		s.Concurrency = 16
+	}
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st store.Store, key func(string) string)
+	}{
+		{"GetMissing", testGetMissing},
+		{"SetGet", testSetGet},
+		{"EmptyValue", testEmptyValue},
+		{"ValuesAreCopied", testValuesAreCopied},
+		{"Expiry", s.testExpiry},
+		{"SetClearsExpiry", s.testSetClearsExpiry},
+		{"SetNX", testSetNX},
+		{"SetNXAfterExpiry", s.testSetNXAfterExpiry},
+		{"SetNXIsAtomic", s.testSetNXIsAtomic},
+		{"Incr", testIncr},
+		{"IncrKeepsExpiry", s.testIncrKeepsExpiry},
+		{"IncrIsAtomic", s.testIncrIsAtomic},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"Range", testRange},
+		{"PopIsAtomic", s.testPopIsAtomic},
+	}
+	run := time.Now().UnixNano()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := s.NewStore(t)
+			prefix := fmt.Sprintf("storetest:%d:%s:", run, tt.name)
+			tt.fn(t, st, func(name string) string { return prefix + name })
+		})
+	}
+}
+
+func testGetMissing(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	if _, err := st.Get(ctx, key("missing")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get of a missing key: got error %v, want store.ErrNotFound", err)
+	}
+	if _, err := st.Pop(ctx, key("missing-list")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Pop of a missing list: got error %v, want store.ErrNotFound", err)
+	}
+}
+
+func testSetGet(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("k")
+	mustSet(t, st, k, []byte("one"), 0)
+	expectValue(t, st, k, "one")
+	mustSet(t, st, k, []byte("two"), 0)
+	expectValue(t, st, k, "two")
+	binary := []byte{0, 1, 0xff, '\n', 0}
+	mustSet(t, st, key("binary"), binary, 0)
+	got, err := st.Get(ctx, key("binary"))
+	if err != nil || !bytes.Equal(got, binary) {
+		t.Fatalf("Get of a binary value: got %q, %v; want %q", got, err, binary)
+	}
+}
+
+// testEmptyValue checks that an empty value is stored, as SetNX markers
+// with no value are.
+func testEmptyValue(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	ok, err := st.SetNX(ctx, key("marker"), nil, 0)
+	if err != nil || !ok {
+		t.Fatalf("SetNX of an empty value: got %v, %v; want true", ok, err)
+	}
+	got, err := st.Get(ctx, key("marker"))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("Get of an empty value: got %q, %v; want an empty value", got, err)
+	}
+}
+
+func testValuesAreCopied(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	value := []byte("original")
+	mustSet(t, st, key("k"), value, 0)
+	copy(value, "changed!")
+	got, err := st.Get(ctx, key("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "original" {
+		t.Fatalf("changing the value after Set changed the stored value to %q", got)
+	}
+	copy(got, "changed!")
+	expectValue(t, st, key("k"), "original")
+
+	item := []byte("item")
+	mustAppend(t, st, key("list"), item)
+	copy(item, "xxxx")
+	list, err := st.Range(ctx, key("list"), 0, -1)
+	if err != nil || len(list) != 1 || string(list[0]) != "item" {
+		t.Fatalf("changing the value after Append changed the list to %q, %v", list, err)
+	}
+}
+
+func (s Suite) testExpiry(t *testing.T, st store.Store, key func(string) string) {
+	mustSet(t, st, key("short"), []byte("v"), s.TTL)
+	mustSet(t, st, key("forever"), []byte("v"), 0)
+	expectValue(t, st, key("short"), "v")
+	time.Sleep(2 * s.TTL)
+	expectMissing(t, st, key("short"))
+	expectValue(t, st, key("forever"), "v")
+}
+
+func (s Suite) testSetClearsExpiry(t *testing.T, st store.Store, key func(string) string) {
+	mustSet(t, st, key("k"), []byte("v1"), s.TTL)
+	mustSet(t, st, key("k"), []byte("v2"), 0)
+	time.Sleep(2 * s.TTL)
+	expectValue(t, st, key("k"), "v2")
+}
+
+func testSetNX(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("claim")
+	if ok, err := st.SetNX(ctx, k, []byte("first"), 0); err != nil || !ok {
+		t.Fatalf("first SetNX: got %v, %v; want true", ok, err)
+	}
+	if ok, err := st.SetNX(ctx, k, []byte("second"), 0); err != nil || ok {
+		t.Fatalf("second SetNX: got %v, %v; want false", ok, err)
+	}
+	expectValue(t, st, k, "first")
+	if err := st.Delete(ctx, k); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := st.SetNX(ctx, k, []byte("third"), 0); err != nil || !ok {
+		t.Fatalf("SetNX after Delete: got %v, %v; want true", ok, err)
+	}
+}
+
+func (s Suite) testSetNXAfterExpiry(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("claim")
+	if ok, err := st.SetNX(ctx, k, []byte("first"), s.TTL); err != nil || !ok {
+		t.Fatalf("first SetNX: got %v, %v; want true", ok, err)
+	}
+	time.Sleep(2 * s.TTL)
+	if ok, err := st.SetNX(ctx, k, []byte("second"), 0); err != nil || !ok {
+		t.Fatalf("SetNX after the first expired: got %v, %v; want true", ok, err)
+	}
+	expectValue(t, st, k, "second")
+}
+
+// testSetNXIsAtomic checks that exactly one of concurrent claims wins.
+func (s Suite) testSetNXIsAtomic(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	var wins, failures int
+	var mu sync.Mutex
+	parallel(s.Concurrency, func(i int) {
+		ok, err := st.SetNX(ctx, key("claim"), []byte(strconv.Itoa(i)), time.Minute)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures++
+		} else if ok {
+			wins++
+		}
+	})
+	if failures > 0 || wins != 1 {
+		t.Fatalf("%d concurrent SetNX: %d won and %d failed; want exactly one to win", s.Concurrency, wins, failures)
+	}
+}
+
+func testIncr(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	for want := int64(1); want <= 3; want++ {
+		n, err := st.Incr(ctx, key("counter"), 0)
+		if err != nil || n != want {
+			t.Fatalf("Incr: got %d, %v; want %d", n, err, want)
+		}
+	}
+	expectValue(t, st, key("counter"), "3")
+	mustSet(t, st, key("preset"), []byte("41"), 0)
+	if n, err := st.Incr(ctx, key("preset"), 0); err != nil || n != 42 {
+		t.Fatalf("Incr of a counter set to 41: got %d, %v; want 42", n, err)
+	}
+}
+
+// testIncrKeepsExpiry checks that the ttl applies when the counter is
+// created and not later, so that fixed windows of rate limits end on time.
+func (s Suite) testIncrKeepsExpiry(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("window")
+	if _, err := st.Incr(ctx, k, s.TTL); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(s.TTL / 2)
+	if _, err := st.Incr(ctx, k, 10*s.TTL); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(s.TTL)
+	if n, err := st.Incr(ctx, k, 0); err != nil || n != 1 {
+		t.Fatalf("Incr after the window expired: got %d, %v; want a new counter at 1", n, err)
+	}
+}
+
+func (s Suite) testIncrIsAtomic(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	seen := make(map[int64]bool)
+	var mu sync.Mutex
+	var errs []error
+	parallel(s.Concurrency, func(int) {
+		n, err := st.Incr(ctx, key("counter"), time.Minute)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		seen[n] = true
+	})
+	if len(errs) > 0 {
+		t.Fatalf("concurrent Incr failed: %v", errs[0])
+	}
+	for n := int64(1); n <= int64(s.Concurrency); n++ {
+		if !seen[n] {
+			t.Fatalf("%d concurrent Incr did not return each of 1 to %d once: %v", s.Concurrency, s.Concurrency, seen)
+		}
+	}
+}
+
+func testDelete(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	if err := st.Delete(ctx, key("missing")); err != nil {
+		t.Fatalf("Delete of a missing key: %v", err)
+	}
+	mustSet(t, st, key("k"), []byte("v"), 0)
+	if err := st.Delete(ctx, key("k")); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing(t, st, key("k"))
+	mustAppend(t, st, key("list"), []byte("a"))
+	if err := st.Delete(ctx, key("list")); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := st.Range(ctx, key("list"), 0, -1); err != nil || len(list) != 0 {
+		t.Fatalf("Range of a deleted list: got %q, %v; want it empty", list, err)
+	}
+}
+
+func testList(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("list")
+	for i, v := range []string{"a", "b", "c"} {
+		n, err := st.Append(ctx, k, []byte(v))
+		if err != nil || n != int64(i+1) {
+			t.Fatalf("Append %q: got length %d, %v; want %d", v, n, err, i+1)
+		}
+	}
+	for _, want := range []string{"a", "b", "c"} {
+		got, err := st.Pop(ctx, k)
+		if err != nil || string(got) != want {
+			t.Fatalf("Pop: got %q, %v; want %q first in, first out", got, err, want)
+		}
+	}
+	if _, err := st.Pop(ctx, k); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Pop of an emptied list: got error %v, want store.ErrNotFound", err)
+	}
+	if n, err := st.Append(ctx, k, []byte("d")); err != nil || n != 1 {
+		t.Fatalf("Append to an emptied list: got length %d, %v; want 1", n, err)
+	}
+}
+
+func testRange(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("list")
+	for _, v := range []string{"a", "b", "c", "d", "e"} {
+		mustAppend(t, st, k, []byte(v))
+	}
+	for _, tt := range []struct {
+		start, stop int64
+		want        string
+	}{
+		{0, -1, "abcde"},
+		{1, 3, "bcd"},
+		{-2, -1, "de"},
+		{-100, 1, "ab"},
+		{3, 100, "de"},
+		{3, 1, ""},
+		{10, 20, ""},
+	} {
+		list, err := st.Range(ctx, k, tt.start, tt.stop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		for _, v := range list {
+			got = append(got, v...)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Range(%d, %d): got %q, want %q", tt.start, tt.stop, got, tt.want)
+		}
+	}
+	if list, err := st.Range(ctx, key("missing"), 0, -1); err != nil || len(list) != 0 {
+		t.Fatalf("Range of a missing list: got %q, %v; want it empty", list, err)
+	}
+}
+
+// testPopIsAtomic checks that concurrent consumers of a queue each get a
+// different element, and that none is lost.
+func (s Suite) testPopIsAtomic(t *testing.T, st store.Store, key func(string) string) {
+	ctx := context.Background()
+	k := key("queue")
+	n := 4 * s.Concurrency
+	for i := range n {
+		mustAppend(t, st, k, []byte(strconv.Itoa(i)))
+	}
+	var mu sync.Mutex
+	popped := make(map[string]int)
+	parallel(s.Concurrency, func(int) {
+		for {
+			v, err := st.Pop(ctx, k)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			popped[string(v)]++
+			mu.Unlock()
+		}
+	})
+	for i := range n {
+		if c := popped[strconv.Itoa(i)]; c != 1 {
+			t.Fatalf("element %d was popped %d times; want once", i, c)
+		}
+	}
+}
+
+// parallel runs fn(0) to fn(n-1) at once and waits for them.
+func parallel(n int, fn func(i int)) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	for i := range n {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			fn(i)
+		}()
+	}
+	start.Done()
+	done.Wait()
+}
+
+func mustSet(t *testing.T, st store.Store, key string, value []byte, ttl time.Duration) {
+	t.Helper()
+	if err := st.Set(context.Background(), key, value, ttl); err != nil {
+		t.Fatalf("Set %s: %v", key, err)
+	}
+}
+
+func mustAppend(t *testing.T, st store.Store, key string, value []byte) {
+	t.Helper()
+	if _, err := st.Append(context.Background(), key, value); err != nil {
+		t.Fatalf("Append %s: %v", key, err)
+	}
+}
+
+func expectValue(t *testing.T, st store.Store, key, want string) {
+	t.Helper()
+	got, err := st.Get(context.Background(), key)
+	if err != nil || string(got) != want {
+		t.Fatalf("Get %s: got %q, %v; want %q", key, got, err, want)
+	}
+}
+
+func expectMissing(t *testing.T, st store.Store, key string) {
+	t.Helper()
+	if got, err := st.Get(context.Background(), key); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Get %s: got %q, %v; want store.ErrNotFound", key, got, err)
+	}
+}
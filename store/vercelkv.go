@@ -0,0 +1,203 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// VercelKV is a Store backed by Vercel KV, or any Upstash Redis database,
+// through its REST API, so it works from serverless functions without a
+// Redis connection. It is safe for concurrent use, and every method is a
+// single atomic command.
+type VercelKV struct {
+	url, token string
+	client     *http.Client
+}
+
+// NewVercelKV returns a store for the database at url with token, the
+// KV_REST_API_URL and KV_REST_API_TOKEN that Vercel sets when a KV database
+// is connected to the project. A nil client means http.DefaultClient.
+func NewVercelKV(url, token string, client *http.Client) *VercelKV {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &VercelKV{url: strings.TrimSuffix(url, "/"), token: token, client: client}
+}
+
+// kvIncrScript increments a counter and sets its expiry when the increment
+// created it, in one step.
+const kvIncrScript = `local n = redis.call("INCR", KEYS[1])
+if n == 1 and tonumber(ARGV[1]) > 0 then redis.call("PEXPIRE", KEYS[1], ARGV[1]) end
+return n`
+
+// kvBinaryPrefix marks values stored base64-encoded. The REST API carries
+// commands as JSON strings, which cannot hold arbitrary bytes, so values
+// that are not valid UTF-8, or that start with the prefix, are encoded.
+const kvBinaryPrefix = "base64:"
+
+func kvEncode(value []byte) string {
+	if utf8.Valid(value) && !bytes.HasPrefix(value, []byte(kvBinaryPrefix)) {
+		return string(value)
+	}
+	return kvBinaryPrefix + base64.StdEncoding.EncodeToString(value)
+}
+
+func kvDecode(s string) ([]byte, error) {
+	if enc, ok := strings.CutPrefix(s, kvBinaryPrefix); ok {
+		return base64.StdEncoding.DecodeString(enc)
+	}
+	return []byte(s), nil
+}
+
+// kvTTL returns the PX argument of ttl, in milliseconds and at least 1.
+func kvTTL(ttl time.Duration) string {
+	return strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+}
+
+// do runs a command and decodes its result into result. String results
+// come back base64-encoded, so that values are returned byte for byte.
+func (kv *VercelKV) do(ctx context.Context, result any, args ...string) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, kv.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+kv.token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Upstash-Encoding", "base64")
+	resp, err := kv.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vercelkv: %s: %w", args[0], err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 64<<20))
+	if err != nil {
+		return fmt.Errorf("vercelkv: %s: %w", args[0], err)
+	}
+	var reply struct {
+		Result json.RawMessage `json:"result"`
+		Error  string          `json:"error"`
+	}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		return fmt.Errorf("vercelkv: %s: HTTP %d: %s", args[0], resp.StatusCode, bytes.TrimSpace(b))
+	}
+	if reply.Error != "" {
+		return fmt.Errorf("vercelkv: %s: %s", args[0], reply.Error)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("vercelkv: %s: HTTP %d", args[0], resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(reply.Result, result)
+}
+
+// kvValue decodes a base64-encoded string result; nil means the key is
+// missing.
+func kvValue(result *string) ([]byte, error) {
+	if result == nil {
+		return nil, ErrNotFound
+	}
+	raw, err := base64.StdEncoding.DecodeString(*result)
+	if err != nil {
+		return nil, fmt.Errorf("vercelkv: malformed result: %w", err)
+	}
+	return kvDecode(string(raw))
+}
+
+// Get implements Store.
+func (kv *VercelKV) Get(ctx context.Context, key string) ([]byte, error) {
+	var result *string
+	if err := kv.do(ctx, &result, "GET", key); err != nil {
+		return nil, err
+	}
+	return kvValue(result)
+}
+
+// Set implements Store.
+func (kv *VercelKV) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, kvEncode(value)}
+	if ttl > 0 {
+		args = append(args, "PX", kvTTL(ttl))
+	}
+	return kv.do(ctx, nil, args...)
+}
+
+// SetNX implements Store.
+func (kv *VercelKV) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	args := []string{"SET", key, kvEncode(value), "NX"}
+	if ttl > 0 {
+		args = append(args, "PX", kvTTL(ttl))
+	}
+	var result *string
+	if err := kv.do(ctx, &result, args...); err != nil {
+		return false, err
+	}
+	return result != nil, nil
+}
+
+// Incr implements Store.
+func (kv *VercelKV) Incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var ms int64
+	if ttl > 0 {
+		ms = max(ttl.Milliseconds(), 1)
+	}
+	var n int64
+	err := kv.do(ctx, &n, "EVAL", kvIncrScript, "1", key, strconv.FormatInt(ms, 10))
+	return n, err
+}
+
+// Delete implements Store.
+func (kv *VercelKV) Delete(ctx context.Context, key string) error {
+	return kv.do(ctx, nil, "DEL", key)
+}
+
+// Append implements Store.
+func (kv *VercelKV) Append(ctx context.Context, key string, value []byte) (int64, error) {
+	var n int64
+	err := kv.do(ctx, &n, "RPUSH", key, kvEncode(value))
+	return n, err
+}
+
+// Pop implements Store.
+func (kv *VercelKV) Pop(ctx context.Context, key string) ([]byte, error) {
+	var result *string
+	if err := kv.do(ctx, &result, "LPOP", key); err != nil {
+		return nil, err
+	}
+	return kvValue(result)
+}
+
+// Range implements Store.
+func (kv *VercelKV) Range(ctx context.Context, key string, start, stop int64) ([][]byte, error) {
+	var results []*string
+	if err := kv.do(ctx, &results, "LRANGE", key, strconv.FormatInt(start, 10), strconv.FormatInt(stop, 10)); err != nil {
+		return nil, err
+	}
+	values := make([][]byte, 0, len(results))
+	for _, result := range results {
+		v, err := kvValue(result)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,119 @@
+package store_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"auto-invite/store"
+	"auto-invite/store/storetest"
+)
+
+// fakeKV serves the commands VercelKV sends, as the Upstash REST API does
+// with Upstash-Encoding: base64, from a Memory store.
+func fakeKV(t *testing.T) *httptest.Server {
+	mem := store.NewMemory()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
+			return
+		}
+		var args []string
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil || len(args) < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "ERR malformed command"})
+			return
+		}
+		result, err := kvCommand(r.Context(), mem, args)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"result": result})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// kvCommand runs a command against mem and returns its REST result.
+func kvCommand(ctx context.Context, mem *store.Memory, args []string) (any, error) {
+	encode := func(v []byte) string { return base64.StdEncoding.EncodeToString(v) }
+	bulk := func(v []byte, err error) (any, error) {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encode(v), nil
+	}
+	key := args[1]
+	switch cmd := strings.ToUpper(args[0]); cmd {
+	case "GET":
+		return bulk(mem.Get(ctx, key))
+	case "SET":
+		var nx bool
+		var ttl time.Duration
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				ms, _ := strconv.ParseInt(args[i], 10, 64)
+				ttl = time.Duration(ms) * time.Millisecond
+			}
+		}
+		if !nx {
+			return encode([]byte("OK")), mem.Set(ctx, key, []byte(args[2]), ttl)
+		}
+		ok, err := mem.SetNX(ctx, key, []byte(args[2]), ttl)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return encode([]byte("OK")), nil
+	case "EVAL":
+		// The only script is the counter of Incr: KEYS[1], ARGV[1] in ms.
+		ms, _ := strconv.ParseInt(args[4], 10, 64)
+		return mem.Incr(ctx, args[3], time.Duration(ms)*time.Millisecond)
+	case "DEL":
+		return 1, mem.Delete(ctx, key)
+	case "RPUSH":
+		return mem.Append(ctx, key, []byte(args[2]))
+	case "LPOP":
+		return bulk(mem.Pop(ctx, key))
+	case "LRANGE":
+		start, _ := strconv.ParseInt(args[2], 10, 64)
+		stop, _ := strconv.ParseInt(args[3], 10, 64)
+		values, err := mem.Range(ctx, key, start, stop)
+		results := make([]string, len(values))
+		for i, v := range values {
+			results[i] = encode(v)
+		}
+		return results, err
+	default:
+		return nil, errors.New("ERR unknown command " + cmd)
+	}
+}
+
+func TestVercelKV(t *testing.T) {
+	srv := fakeKV(t)
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewVercelKV(srv.URL+"/", "token", srv.Client()) })
+}
+
+func TestVercelKVError(t *testing.T) {
+	srv := fakeKV(t)
+	kv := store.NewVercelKV(srv.URL, "wrong", srv.Client())
+	if _, err := kv.Get(context.Background(), "k"); err == nil || !strings.Contains(err.Error(), "Unauthorized") {
+		t.Fatalf("Get with a wrong token: got error %v, want Unauthorized", err)
+	}
+}
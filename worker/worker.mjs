@@ -0,0 +1,29 @@
+// Cloudflare Workers entry point. It starts the Go program compiled to
+// app.wasm on the first request, with the Worker's string bindings as its
+// environment, and hands every request to it.
+import "./wasm_exec.js";
+import wasm from "./app.wasm";
+
+let ready;
+
+function start(env) {
+  const go = new Go();
+  go.env = Object.fromEntries(Object.entries(env).filter(([, v]) => typeof v === "string"));
+  return WebAssembly.instantiate(wasm, go.importObject).then((instance) => {
+    go.run(instance);
+  });
+}
+
+export default {
+  async fetch(request, env) {
+    ready ??= start(env);
+    await ready;
+    const body = request.body ? new Uint8Array(await request.arrayBuffer()) : null;
+    const resp = await globalThis.goFetch(request.method, request.url, [...request.headers], body);
+    const headers = new Headers();
+    for (const [name, value] of resp.headers) headers.append(name, value);
+    // Redirects and empty statuses must not carry a body.
+    const empty = resp.status === 204 || resp.status === 304;
+    return new Response(empty ? null : resp.body, { status: resp.status, headers });
+  },
+};